@@ -11,10 +11,11 @@ import (
 
 func (a *API) AccountRoutes() http.Handler {
 	router := chi.NewRouter()
-	router.Use(Authorization)
+	router.Use(a.Authorization)
+	router.Use(a.Authenticate)
 	router.Method("POST", "/create_link_token", Handler(a.createLinkToken))
-	router.Method("POST", "/{userID}/exchange_public_token", Handler(a.exchangePublicToken))
-	router.Method("GET", "/{userID}/details", Handler(a.fetchAccountDetails))
+	router.With(OwnerOnly("userID")).Method("POST", "/{userID}/exchange_public_token", Handler(a.exchangePublicToken))
+	router.With(OwnerOrAccess("userID", model.TRANSACTION)).Method("GET", "/{userID}/details", Handler(a.fetchAccountDetails))
 
 	return router
 }
@@ -26,6 +27,7 @@ func (a *API) createLinkToken(w http.ResponseWriter, r *http.Request) *ServerRes
 	if err := decodeJSONBody(&tracingContext, r.Body, &tokenLinkRequest); err != nil {
 		return RespondWithError(nil, "Failed to decode request body", http.StatusBadRequest, &tracingContext)
 	}
+	tokenLinkRequest.UserId = principalOf(r).ID
 	token, err := a.Deps.PLAID.CreateLinkToken(tokenLinkRequest)
 	if err != nil {
 		return RespondWithError(err, "Could not create link token", http.StatusInternalServerError, &tracingContext)
@@ -6,6 +6,8 @@ import (
 	"github.com/aws/smithy-go"
 	"github.com/go-chi/chi"
 	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/helpers"
+	"github.com/isongjosiah/work/onepurse-api/money"
 	"github.com/isongjosiah/work/onepurse-api/tracing"
 	"github.com/isongjosiah/work/onepurse-api/types"
 	"github.com/lucsky/cuid"
@@ -18,37 +20,85 @@ import (
 
 func (a *API) AdminRoutes() http.Handler {
 	router := chi.NewRouter()
-	router.Use(Authorization)
-	router.Method("POST", "/create_admin", Handler(a.createAdmin))
-	router.Method("POST", "/create_currency", Handler(a.createCurrency))
+	router.Use(a.Authorization)
+	router.Use(a.Authenticate)
+	router.Use(AdminOnly)
+	router.Use(a.AuditTrail)
+	router.With(RequireAccess(model.MANAGE_PERSONEL), a.StepUp("create_admin")).Method("POST", "/create_admin", Handler(a.createAdmin))
+	router.With(RequireAccess(model.RATES)).Method("POST", "/create_currency", Handler(a.createCurrency))
+	router.With(RequireAccess(model.RATES)).Method("GET", "/currency", Handler(a.fetchCurrencies))
+	router.With(RequireAccess(model.RATES)).Method("PATCH", "/currency/{code}", Handler(a.updateCurrency))
+	router.With(RequireAccess(model.RATES), a.StepUp("currency_action")).Method("PATCH", "/currency/{code}/action", Handler(a.currencyActions))
+
+	/*ROLES*/
+	router.With(RequireAccess(model.MANAGE_PERSONEL)).Method("GET", "/roles/access", Handler(a.fetchAccesses))
+	router.With(RequireAccess(model.MANAGE_PERSONEL), a.StepUp("create_role")).Method("POST", "/roles", Handler(a.createRole))
+	router.With(RequireAccess(model.MANAGE_PERSONEL)).Method("GET", "/roles", Handler(a.fetchRoles))
+	router.With(RequireAccess(model.MANAGE_PERSONEL), a.StepUp("update_role")).Method("PATCH", "/roles/{roleID}", Handler(a.updateRole))
+	router.With(RequireAccess(model.MANAGE_PERSONEL), a.StepUp("delete_role")).Method("DELETE", "/roles/{roleID}", Handler(a.deleteRole))
+	router.With(RequireAccess(model.MANAGE_PERSONEL), a.StepUp("assign_role")).Method("PATCH", "/{adminID}/role", Handler(a.assignRole))
 
 	/*Dashboard*/
-	router.Method("GET", "/transaction/get_metrics", Handler(a.getMetrics))
-	router.Method("GET", "/transactions/get_volume", Handler(a.getTransactionVolume))
+	router.With(RequireAccess(model.DASHBOARD)).Method("GET", "/transaction/get_metrics", Handler(a.getMetrics))
+	router.With(RequireAccess(model.DASHBOARD)).Method("GET", "/transactions/get_volume", Handler(a.getTransactionVolume))
 
 	/*User*/
-	router.Method("GET", "/user", Handler(a.getAllUsers))
-	router.Method("PATCH", "/user/action", Handler(a.userActions))
-	router.Method("GET", "/user/transaction_history", Handler(a.getUserTransactionHistory))
+	router.With(RequireAccess(model.DASHBOARD)).Method("GET", "/user", Handler(a.getAllUsers))
+	router.With(RequireAccess(model.VERIFICATION), a.StepUp("user_action")).Method("PATCH", "/user/action", Handler(a.userActions))
+	router.With(RequireAccess(model.DEACTIVATE), a.StepUp("unlock_pin")).Method("PATCH", "/user/unlock_pin", Handler(a.unlockUserPin))
+	router.With(RequireAccess(model.TRANSACTION)).Method("GET", "/user/transaction_history", Handler(a.getUserTransactionHistory))
 
 	/*AGENT*/
-	router.Method("POST", "/agent", Handler(a.adminCreateAgent))
-	router.Method("GET", "/agent", Handler(a.getAllAgents))
-	router.Method("PATCH", "/agent/action", Handler(a.agentActions))
-	router.Method("GET", "/agent/transaction_history", Handler(a.getAgentTransactionHistory))
+	router.With(RequireAccess(model.MANAGE_PERSONEL)).Method("POST", "/agent", Handler(a.adminCreateAgent))
+	router.With(RequireAccess(model.DASHBOARD)).Method("GET", "/agent", Handler(a.getAllAgents))
+	router.With(RequireAccess(model.VERIFICATION), a.StepUp("agent_action")).Method("PATCH", "/agent/action", Handler(a.agentActions))
+	router.With(RequireAccess(model.TRANSACTION)).Method("GET", "/agent/transaction_history", Handler(a.getAgentTransactionHistory))
 
 	/*TRANSACTION*/
-	router.Method("GET", "/transaction", Handler(a.fetchAllTransactions))
+	router.With(RequireAccess(model.TRANSACTION)).Method("GET", "/transaction", Handler(a.fetchAllTransactions))
+	router.With(RequireAccess(model.TRANSACTION)).Method("GET", "/transaction/history", Handler(a.fetchTransactionHistory))
 
 	/*EXCHANGE RATE*/
-	router.Method("PATCH", "/exchange_rate", Handler(a.updateExchangeRate))
+	router.With(RequireAccess(model.RATES), a.StepUp("update_exchange_rate")).Method("PATCH", "/exchange_rate", Handler(a.updateExchangeRate))
+	router.With(RequireAccess(model.RATES)).Method("GET", "/exchange_rate", Handler(a.fetchExchangeRates))
+	router.With(RequireAccess(model.RATES)).Method("GET", "/exchange_rate/history", Handler(a.fetchExchangeRateHistory))
+	router.With(RequireAccess(model.RATES)).Method("POST", "/exchange_rate/fetch", Handler(a.fetchProviderRates))
+	router.With(RequireAccess(model.RATES)).Method("GET", "/exchange_rate/proposals", Handler(a.fetchRateProposals))
+	router.With(RequireAccess(model.RATES), a.StepUp("review_exchange_rate")).Method("PATCH", "/exchange_rate/proposals/{proposalID}", Handler(a.reviewRateProposal))
+
+	/*FEES AND REVENUE*/
+	router.With(RequireAccess(model.REVENUE)).Method("GET", "/fees", Handler(a.fetchFeeRules))
+	router.With(RequireAccess(model.REVENUE), a.StepUp("update_fees")).Method("POST", "/fees", Handler(a.createFeeRule))
+	router.With(RequireAccess(model.REVENUE), a.StepUp("update_fees")).Method("PATCH", "/fees/{feeID}", Handler(a.updateFeeRule))
+	router.With(RequireAccess(model.REVENUE)).Method("GET", "/revenue", Handler(a.getRevenue))
+
+	/*AGENT COMMISSIONS*/
+	router.With(RequireAccess(model.REVENUE)).Method("GET", "/commissions/rules", Handler(a.fetchCommissionRules))
+	router.With(RequireAccess(model.REVENUE), a.StepUp("update_commissions")).Method("POST", "/commissions/rules", Handler(a.createCommissionRule))
+	router.With(RequireAccess(model.REVENUE), a.StepUp("update_commissions")).Method("PATCH", "/commissions/rules/{ruleID}", Handler(a.updateCommissionRule))
+	router.With(RequireAccess(model.ADMIN_PAYMENT)).Method("GET", "/commissions/batches", Handler(a.fetchSettlementBatches))
+	router.With(RequireAccess(model.ADMIN_PAYMENT)).Method("POST", "/commissions/settle", Handler(a.runSettlement))
+	router.With(RequireAccess(model.ADMIN_PAYMENT), a.StepUp("review_commission_batch")).Method("PATCH", "/commissions/batches/{batchID}", Handler(a.reviewSettlementBatch))
+
+	/*LEDGER*/
+	router.With(RequireAccess(model.TRANSACTION)).Method("GET", "/ledger", Handler(a.getLedgerStatement))
 
 	/*ADMIN PAYMENT*/
-	router.Method("POST", "/payments", Handler(a.createAdminPayments))
-	router.Method("GET", "/payments", Handler(a.fetchAdminPayments))
+	router.With(RequireAccess(model.ADMIN_PAYMENT), a.Idempotency, a.StepUp("create_admin_payment")).Method("POST", "/payments", Handler(a.createAdminPayments))
+	router.With(RequireAccess(model.ADMIN_PAYMENT)).Method("GET", "/payments", Handler(a.fetchAdminPayments))
+
+	/*BACKGROUND JOBS*/
+	router.With(RequireAccess(model.DASHBOARD)).Method("GET", "/jobs", Handler(a.fetchJobs))
+
+	/*ACTIVITY LOGS*/
+	router.With(RequireAccess(model.ACTIVITY_LOGS)).Method("GET", "/activity_logs", Handler(a.fetchActivityLogs))
+	router.With(RequireAccess(model.ACTIVITY_LOGS)).Method("GET", "/activity_logs/verify", Handler(a.verifyActivityLogs))
 
 	/*SETTINGS*/
-	router.Method("GET", "/{ID}/security/generate_code", Handler(a.generateSecurityCode))
+	router.Method("POST", "/security/otp", Handler(a.sendAdminOTP))
+	router.Method("POST", "/security/authenticator", Handler(a.enrollAuthenticator))
+	router.Method("POST", "/security/authenticator/confirm", Handler(a.confirmAuthenticator))
+	router.With(RequireAccess(model.TWO_FACTOR_AUTH), a.StepUp("reset_authenticator")).Method("DELETE", "/{adminID}/security/authenticator", Handler(a.resetAuthenticator))
 	router.Method("PATCH", "/security/update_profile", Handler(a.updateAdminProfile))
 
 	return router
@@ -76,9 +126,15 @@ func (a *API) createAdmin(w http.ResponseWriter, r *http.Request) *ServerRespons
 	if admin.Phone == "" {
 		return RespondWithError(nil, "phone is required", http.StatusBadRequest, &tracingContext)
 	}
-	if admin.Role == nil {
-		return RespondWithError(nil, "admin role is required", http.StatusBadRequest, &tracingContext)
+	if admin.RoleID == "" {
+		return RespondWithError(nil, "role_id is required", http.StatusBadRequest, &tracingContext)
+	}
+	role, err := a.Deps.DAL.AdminDAL.FindRole(context.TODO(), admin.RoleID)
+	if err != nil {
+		return RespondWithError(err, "role does not exist", http.StatusBadRequest, &tracingContext)
 	}
+	admin.Role = role
+	admin.TOTPEnabled = false // new admins enroll their own authenticator
 
 	registration := model.CreateUserRequest{
 		Email:    admin.Email,
@@ -86,7 +142,7 @@ func (a *API) createAdmin(w http.ResponseWriter, r *http.Request) *ServerRespons
 		Phone:    admin.Phone,
 		UserName: admin.Username,
 	}
-	createResponse, err := a.Deps.AWS.Cognito.CreateUser(&registration)
+	createResponse, err := a.Deps.Identity.CreateUser(&registration)
 	if err != nil {
 		var ae smithy.APIError
 		if errors.As(err, &ae) { //TODO(JOSIAH): Verify the errors thrown
@@ -113,6 +169,7 @@ func (a *API) createAdmin(w http.ResponseWriter, r *http.Request) *ServerRespons
 	if err != nil {
 		return RespondWithError(err, "Failed to create admin", http.StatusInternalServerError, &tracingContext)
 	}
+	auditChange(r, "admin:"+admin.ID, nil, admin)
 
 	return &ServerResponse{
 		Payload:    createResponse,
@@ -121,34 +178,6 @@ func (a *API) createAdmin(w http.ResponseWriter, r *http.Request) *ServerRespons
 	}
 }
 
-// createCurrency allows an authorized admin to create currency available on the platform
-func (a *API) createCurrency(w http.ResponseWriter, r *http.Request) *ServerResponse {
-	var currency model.Currency
-	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
-
-	if err := decodeJSONBody(&tracingContext, r.Body, &currency); err != nil {
-		return RespondWithError(nil, "Failed to decode request body", http.StatusInternalServerError, &tracingContext)
-	}
-
-	if currency.Label == "" {
-		return RespondWithError(nil, "label is required", http.StatusBadRequest, &tracingContext)
-	}
-	if currency.Slug == "" {
-		return RespondWithError(nil, "slug is required", http.StatusBadRequest, &tracingContext)
-	}
-
-	err := a.Deps.DAL.CurrencyDAL.Add(context.TODO(), &currency)
-	if err != nil {
-		return RespondWithError(err, "Failed to add currency", http.StatusInternalServerError, &tracingContext)
-
-	}
-
-	response := map[string]interface{}{
-		"message": "currency successfully added",
-	}
-	return &ServerResponse{Payload: response}
-}
-
 // getMetrics allows an authorized admin fetch defined metrics on the platform
 func (a *API) getMetrics(w http.ResponseWriter, r *http.Request) *ServerResponse {
 	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
@@ -159,7 +188,8 @@ func (a *API) getMetrics(w http.ResponseWriter, r *http.Request) *ServerResponse
 	}
 
 	// Fetch TransactionVolumeMetrics
-	transactionMetric, err := a.TransactionVolumeMetrics(context.TODO(), time.Time{}, time.Time{})
+	end := periodStart(time.Now().UTC(), IntervalDay).AddDate(0, 0, 1)
+	transactionMetric, err := a.TransactionVolumeMetrics(context.TODO(), end.AddDate(0, 0, -defaultVolumeDays), end, IntervalDay)
 	if err != nil {
 		return RespondWithError(err, "unable to fetch transaction metrics", http.StatusInternalServerError, &tracingContext)
 	}
@@ -186,26 +216,12 @@ func (a *API) getMetrics(w http.ResponseWriter, r *http.Request) *ServerResponse
 func (a *API) getTransactionVolume(w http.ResponseWriter, r *http.Request) *ServerResponse {
 	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
 
-	startDate := r.URL.Query().Get("start")
-	endDate := r.URL.Query().Get("end")
-
-	var start time.Time
-	var end time.Time
-	var err error
-	if startDate != "" {
-		start, err = time.Parse("2002-01-23", startDate)
-		if err != nil {
-			return RespondWithError(err, "unable to parse start date", http.StatusBadRequest, &tracingContext)
-		}
-	}
-	if endDate != "" {
-		end, err = time.Parse("2002-01-23", endDate)
-		if err != nil {
-			return RespondWithError(err, "unable to parse end date", http.StatusBadRequest, &tracingContext)
-		}
+	start, end, interval, err := volumeRange(r)
+	if err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
 	}
 
-	metrics, err := a.TransactionVolumeMetrics(context.TODO(), start, end)
+	metrics, err := a.TransactionVolumeMetrics(context.TODO(), start, end, interval)
 	if err != nil {
 		return RespondWithError(err, "unable to fetch transaction volume metrics", http.StatusInternalServerError, &tracingContext)
 	}
@@ -215,30 +231,74 @@ func (a *API) getTransactionVolume(w http.ResponseWriter, r *http.Request) *Serv
 	}
 }
 
-// getAllUsers allows an authorized admin fetch all the users on the platform
-func (a *API) getAllUsers(w http.ResponseWriter, r *http.Request) *ServerResponse {
-	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
-	fetchType := r.URL.Query().Get("type")
+// volumeRange reads the start, end, interval and tz parameters of a transaction volume request. Dates are either
+// 2006-01-02, taken as midnight in tz with the whole end day included, or RFC 3339 times. The range defaults to the
+// defaultVolumeDays days up to today, by day in UTC
+func volumeRange(r *http.Request) (time.Time, time.Time, string, error) {
+	params := r.URL.Query()
+	loc := time.UTC
+	if tz := params.Get("tz"); tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			return time.Time{}, time.Time{}, "", errors.Errorf("unknown timezone %s", tz)
+		}
+	}
+	interval := params.Get("interval")
+	if interval == "" {
+		interval = IntervalDay
+	}
+	if !intervals[interval] {
+		return time.Time{}, time.Time{}, "", errors.New("interval must be day, week or month")
+	}
 
-	var user *[]model.User
-	var err error
-	switch fetchType {
-	case "all":
-		user, err = a.Deps.DAL.UserDAL.FindAll(context.TODO(), bson.D{})
+	end := periodStart(time.Now().In(loc), IntervalDay).AddDate(0, 0, 1)
+	if value := params.Get("end"); value != "" {
+		parsed, dateOnly, err := parseDate(value, loc)
 		if err != nil {
-			return RespondWithError(err, "unable to fetch all users", http.StatusInternalServerError, &tracingContext)
+			return time.Time{}, time.Time{}, "", errors.New("unable to parse end date, use YYYY-MM-DD or RFC 3339")
 		}
-	case types.REJECTED:
-		user, err = a.Deps.DAL.UserDAL.FindAll(context.TODO(), bson.D{{"approved", false}})
-		if err != nil {
-			return RespondWithError(err, "unable to fetch rejected users", http.StatusInternalServerError, &tracingContext)
+		end = parsed
+		if dateOnly {
+			end = end.AddDate(0, 0, 1)
 		}
-	case types.APPROVED:
-		user, err = a.Deps.DAL.UserDAL.FindAll(context.TODO(), bson.D{{"approved", true}})
+	}
+	start := end.AddDate(0, 0, -defaultVolumeDays)
+	if value := params.Get("start"); value != "" {
+		parsed, _, err := parseDate(value, loc)
 		if err != nil {
-			return RespondWithError(err, "unable to fetch approved users", http.StatusInternalServerError, &tracingContext)
+			return time.Time{}, time.Time{}, "", errors.New("unable to parse start date, use YYYY-MM-DD or RFC 3339")
 		}
-	case types.SINGLE:
+		start = parsed
+	}
+
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, "", errors.New("start date must be before end date")
+	}
+	if end.Sub(start) > maxVolumeRange {
+		return time.Time{}, time.Time{}, "", errors.New("date range cannot be longer than two years")
+	}
+	return start, end, interval, nil
+}
+
+// parseDate parses a YYYY-MM-DD date as midnight in loc, or an RFC 3339 time converted to loc. It reports whether
+// value was a date only
+func parseDate(value string, loc *time.Location) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, loc); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return t.In(loc), false, nil
+}
+
+// getAllUsers allows an authorized admin search and page through the users on the platform, or fetch one with
+// type=single. The filters are described by profileRequest
+func (a *API) getAllUsers(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+
+	if r.URL.Query().Get("type") == types.SINGLE {
 		id := r.URL.Query().Get("id")
 		suser, err := a.Deps.DAL.UserDAL.FindOne(context.TODO(), bson.D{{"_id", id}})
 		if err != nil {
@@ -248,16 +308,23 @@ func (a *API) getAllUsers(w http.ResponseWriter, r *http.Request) *ServerRespons
 			Message: "User fetched successfully",
 			Payload: suser,
 		}
+	}
 
-	default:
-		return &ServerResponse{
-			Message:    "Specified type is not supported",
-			StatusCode: http.StatusBadRequest,
-			Err:        errors.New("Specified type is not supported"),
-		}
+	filter, err := profileRequest(r)
+	if err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+	}
+	req, err := pageRequest(r)
+	if err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+	}
+	user, page, err := a.Deps.DAL.UserDAL.Search(context.TODO(), filter, req)
+	if err != nil {
+		return respondWithPageError(err, "unable to fetch users", &tracingContext)
 	}
 	return &ServerResponse{
 		Payload:    user,
+		Pagination: page,
 		Message:    "users fetched successfully",
 		StatusCode: http.StatusOK,
 	}
@@ -268,6 +335,10 @@ func (a *API) userActions(w http.ResponseWriter, r *http.Request) *ServerRespons
 	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
 	action := r.URL.Query().Get("action")
 	id := r.URL.Query().Get("id")
+	user, err := a.Deps.DAL.UserDAL.FindByID(context.TODO(), id)
+	if err != nil {
+		return RespondWithError(err, "unable to find user", http.StatusNotFound, &tracingContext)
+	}
 
 	switch action {
 	case types.APPROVE:
@@ -287,12 +358,39 @@ func (a *API) userActions(w http.ResponseWriter, r *http.Request) *ServerRespons
 			Err:        errors.New("action type is not specified"),
 		}
 	}
+	auditChange(r, "user:"+id, map[string]bool{"approved": user.Approved}, map[string]bool{"approved": action == types.APPROVE})
 
 	return &ServerResponse{
 		Message: "user updated successfully",
 	}
 }
 
+// unlockUserPin allows an authorized admin lift a transaction pin lockout before it expires
+func (a *API) unlockUserPin(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		return RespondWithError(nil, "user id is required", http.StatusBadRequest, &tracingContext)
+	}
+
+	user, err := a.Deps.DAL.UserDAL.FindByID(context.TODO(), id)
+	if err != nil {
+		return RespondWithError(err, "unable to find user", http.StatusNotFound, &tracingContext)
+	}
+
+	if err := a.resetPinAttempts(r.Context(), id); err != nil {
+		return RespondWithError(err, "unable to unlock transaction pin", http.StatusInternalServerError, &tracingContext)
+	}
+	auditChange(r, "user:"+id,
+		map[string]interface{}{"pin_attempts": user.PinAttempts, "pin_locked_until": user.PinLockedUntil},
+		map[string]interface{}{"pin_attempts": 0, "pin_locked_until": time.Time{}})
+	logrus.Infof("[PIN]: transaction pin of user %s unlocked by %s", id, actorOf(r))
+
+	return &ServerResponse{
+		Message: "transaction pin unlocked successfully",
+	}
+}
+
 // getUserTransactionHistory allows an authorized admin fetch a user transaction history
 func (a *API) getUserTransactionHistory(w http.ResponseWriter, r *http.Request) *ServerResponse {
 	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
@@ -366,7 +464,7 @@ func (a *API) adminCreateAgent(w http.ResponseWriter, r *http.Request) *ServerRe
 		Phone:    agent.Phone,
 		UserName: agent.UserName,
 	}
-	createResponse, err := a.Deps.AWS.Cognito.CreateUser(&registration)
+	createResponse, err := a.Deps.Identity.CreateUser(&registration)
 	if err != nil {
 		var ae smithy.APIError
 		if errors.As(err, &ae) { //TODO(JOSIAH): Verify the errors thrown
@@ -393,6 +491,7 @@ func (a *API) adminCreateAgent(w http.ResponseWriter, r *http.Request) *ServerRe
 	if err != nil {
 		return RespondWithError(err, "Failed to create agent", http.StatusInternalServerError, &tracingContext)
 	}
+	auditChange(r, "agent:"+agent.ID, nil, agent)
 
 	return &ServerResponse{
 		Payload: createResponse,
@@ -400,30 +499,12 @@ func (a *API) adminCreateAgent(w http.ResponseWriter, r *http.Request) *ServerRe
 	}
 }
 
-// getAllAgents allows an authorized admin fetch all the agents on the platform
+// getAllAgents allows an authorized admin search and page through the agents on the platform, or fetch one with
+// type=single. The filters are described by profileRequest, except those only users have
 func (a *API) getAllAgents(w http.ResponseWriter, r *http.Request) *ServerResponse {
 	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
-	fetchType := r.URL.Query().Get("type")
 
-	var agent *[]model.Agent
-	var err error
-	switch fetchType {
-	case "all":
-		agent, err = a.Deps.DAL.AgentDAL.FindAll(context.TODO(), bson.D{})
-		if err != nil {
-			return RespondWithError(err, "unable to fetch all users", http.StatusInternalServerError, &tracingContext)
-		}
-	case types.REJECTED:
-		agent, err = a.Deps.DAL.AgentDAL.FindAll(context.TODO(), bson.D{{"approved", false}})
-		if err != nil {
-			return RespondWithError(err, "unable to fetch rejected users", http.StatusInternalServerError, &tracingContext)
-		}
-	case types.APPROVED:
-		agent, err = a.Deps.DAL.AgentDAL.FindAll(context.TODO(), bson.D{{"approved", true}})
-		if err != nil {
-			return RespondWithError(err, "unable to fetch approved users", http.StatusInternalServerError, &tracingContext)
-		}
-	case types.SINGLE:
+	if r.URL.Query().Get("type") == types.SINGLE {
 		id := r.URL.Query().Get("id")
 		sagent, err := a.Deps.DAL.AgentDAL.FindOne(context.TODO(), bson.D{{"_id", id}})
 		if err != nil {
@@ -433,16 +514,27 @@ func (a *API) getAllAgents(w http.ResponseWriter, r *http.Request) *ServerRespon
 			Message: "agent fetched successfully",
 			Payload: sagent,
 		}
-	default:
-		return &ServerResponse{
-			Err:        errors.New("this type isn't supported"),
-			Message:    "type isn't supported",
-			StatusCode: http.StatusBadRequest,
-		}
+	}
+
+	filter, err := profileRequest(r)
+	if err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+	}
+	if filter.IDNumber != "" || filter.KYCStatus != "" || filter.Active != nil {
+		return RespondWithError(nil, "agents cannot be filtered by id_number, kyc_status or active", http.StatusBadRequest, &tracingContext)
+	}
+	req, err := pageRequest(r)
+	if err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+	}
+	agent, page, err := a.Deps.DAL.AgentDAL.Search(context.TODO(), filter, req)
+	if err != nil {
+		return respondWithPageError(err, "unable to fetch agents", &tracingContext)
 	}
 	return &ServerResponse{
-		Payload: agent,
-		Message: "agent fetched successfully",
+		Payload:    agent,
+		Pagination: page,
+		Message:    "agent fetched successfully",
 	}
 }
 
@@ -451,6 +543,10 @@ func (a *API) agentActions(w http.ResponseWriter, r *http.Request) *ServerRespon
 	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
 	action := r.URL.Query().Get("action")
 	id := r.URL.Query().Get("id")
+	agent, err := a.Deps.DAL.AgentDAL.FindOne(context.TODO(), bson.D{{"_id", id}})
+	if err != nil {
+		return RespondWithError(err, "unable to find agent", http.StatusNotFound, &tracingContext)
+	}
 
 	switch action {
 	case types.APPROVE:
@@ -463,7 +559,11 @@ func (a *API) agentActions(w http.ResponseWriter, r *http.Request) *ServerRespon
 		if err != nil {
 			return RespondWithError(err, "unable to reject user", http.StatusInternalServerError, &tracingContext)
 		}
+	default:
+		return RespondWithError(nil, "action type is not specified", http.StatusBadRequest, &tracingContext)
 	}
+	auditChange(r, "agent:"+id, map[string]bool{"approved": agent.Approved}, map[string]bool{"approved": action == types.APPROVE})
+
 	return &ServerResponse{
 		Message: "agent updated successfully",
 	}
@@ -517,65 +617,62 @@ func (a *API) getAgentTransactionHistory(w http.ResponseWriter, r *http.Request)
 	}
 }
 
-// fetchAllTransaction allows an authorized admin fetch all transactions
+// fetchAllTransaction allows an authorized admin page through the transactions of every type
 func (a *API) fetchAllTransactions(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	return a.respondWithHistory(r, func(*model.HistoryFilter) {})
+}
+
+// getLedgerStatement allows an authorized admin fetch the ledger postings and balances of a user or agent, along
+// with their wallet so the two can be checked against each other
+func (a *API) getLedgerStatement(w http.ResponseWriter, r *http.Request) *ServerResponse {
 	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
-	query := bson.D{}
+	owner := r.URL.Query().Get("owner")
+	id := r.URL.Query().Get("id")
 
-	response := make(map[string]interface{})
-	transfers, err := a.Deps.DAL.TransactionDAL.FetchTransfers(context.TODO(), query)
-	if err != nil {
-		return RespondWithError(err, "unable to fetch transfers", http.StatusInternalServerError, &tracingContext)
+	if id == "" {
+		return RespondWithError(nil, "id is required", http.StatusBadRequest, &tracingContext)
 	}
-	withdraws, err := a.Deps.DAL.TransactionDAL.FetchWithdrawals(context.TODO(), query)
-	if err != nil {
-		return RespondWithError(err, "unable to fetch withdraws", http.StatusInternalServerError, &tracingContext)
+
+	var wallet interface{}
+	switch owner {
+	case model.LedgerOwnerUser:
+		user, err := a.Deps.DAL.UserDAL.FindByID(context.TODO(), id)
+		if err != nil {
+			return RespondWithError(err, "unable to fetch user", http.StatusInternalServerError, &tracingContext)
+		}
+		wallet = user.Wallet
+	case model.LedgerOwnerAgent:
+		agent, err := a.Deps.DAL.AgentDAL.FindOne(context.TODO(), bson.D{{"_id", id}})
+		if err != nil {
+			return RespondWithError(err, "unable to fetch agent", http.StatusInternalServerError, &tracingContext)
+		}
+		wallet = agent.Wallet
+	default:
+		return RespondWithError(nil, "owner must either be user or agent", http.StatusBadRequest, &tracingContext)
 	}
-	deposits, err := a.Deps.DAL.TransactionDAL.FetchDeposits(context.TODO(), query)
+
+	req, err := pageRequest(r)
 	if err != nil {
-		return RespondWithError(err, "unable to fetch deposits", http.StatusInternalServerError, &tracingContext)
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
 	}
-	exchanges, err := a.Deps.DAL.TransactionDAL.FetchExchanges(context.TODO(), query)
+	postings, page, err := a.Deps.DAL.LedgerDAL.FetchPostingsPage(context.TODO(), bson.D{{"account.owner", owner}, {"account.owner_id", id}}, req)
 	if err != nil {
-		return RespondWithError(err, "unable to fetch exchanges", http.StatusInternalServerError, &tracingContext)
-	}
-
-	if len(*transfers) == 0 {
-		transfers = &[]model.Transfer{}
+		return respondWithPageError(err, "unable to fetch ledger postings", &tracingContext)
 	}
-	if len(*withdraws) == 0 {
-		withdraws = &[]model.Withdrawal{}
-	}
-	if len(*deposits) == 0 {
-		deposits = &[]model.Deposit{}
-	}
-	if len(*exchanges) == 0 {
-		exchanges = &[]model.Exchange{}
-	}
-
-	response[types.TRANSFER] = transfers
-	response[types.WITHDRAW] = withdraws
-	response[types.DEPOSIT] = deposits
-	response[types.EXCHANGE] = exchanges
-
-	return &ServerResponse{
-		Payload: response,
-		Message: "all transactions fetched successfully",
+	balances, err := a.Deps.DAL.LedgerDAL.Balances(context.TODO(), owner, id)
+	if err != nil {
+		return RespondWithError(err, "unable to fetch ledger balances", http.StatusInternalServerError, &tracingContext)
 	}
-}
-
-//updateExchangeRate allows an authorized admin update the exchange rate
-func (a *API) updateExchangeRate(w http.ResponseWriter, r *http.Request) *ServerResponse {
-	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
-	var exchangeRate *model.Rate
 
-	if err := decodeJSONBody(&tracingContext, r.Body, &exchangeRate); err != nil {
-		logrus.Errorf(err.Error())
-		return RespondWithError(err, "failed to decode request body", http.StatusBadRequest, &tracingContext)
+	response := map[string]interface{}{
+		"wallet":   wallet,
+		"balances": balances,
+		"postings": postings,
 	}
-
 	return &ServerResponse{
-		Payload: nil,
+		Payload:    response,
+		Pagination: page,
+		Message:    "ledger statement fetched successfully",
 	}
 }
 
@@ -589,12 +686,31 @@ func (a *API) createAdminPayments(w http.ResponseWriter, r *http.Request) *Serve
 		return RespondWithError(err, "failed to decode request body", http.StatusBadRequest, &tracingContext)
 	}
 
-	err := a.Deps.DAL.TransactionDAL.CreateAdminPayment(context.TODO(), &payment)
+	if payment.BaseCurrency == "" || payment.BaseAmount <= money.Zero {
+		return RespondWithError(nil, "base amount and currency is required", http.StatusBadRequest, &tracingContext)
+	}
+	// admin payments are not held to the transaction limits, and can settle balances in a disabled currency
+	currency, resp := a.currencyOf(payment.BaseCurrency, true, &tracingContext)
+	if resp != nil {
+		return resp
+	}
+	payment.BaseCurrency = currency.Code
+	if err := helpers.CheckAmount(payment.BaseAmount, payment.BaseCurrency); err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+	}
+
+	payment.ID = cuid.New()
+	payment.CreatedAt = time.Now()
+	err := a.postWithUpdate(context.TODO(), adminPaymentEntry(&payment), func(ctx context.Context) error {
+		return a.Deps.DAL.TransactionDAL.CreateAdminPayment(ctx, &payment)
+	})
 	if err != nil {
 		return RespondWithError(err, "failed to create admin payment", http.StatusInternalServerError, &tracingContext)
 	}
+	auditChange(r, "admin_payment:"+payment.ID, nil, payment)
 	return &ServerResponse{
-		Message: "unable to create admin payment",
+		Payload: payment,
+		Message: "admin payment created successfully",
 	}
 }
 
@@ -604,16 +720,18 @@ func (a *API) fetchAdminPayments(w http.ResponseWriter, r *http.Request) *Server
 	id := r.URL.Query().Get("id")
 
 	if id == "" {
-		payments, err := a.Deps.DAL.TransactionDAL.FetchAdminPayments(context.TODO(), bson.D{})
+		req, err := pageRequest(r)
 		if err != nil {
-			return RespondWithError(err, "unable to fetch admin payments", http.StatusInternalServerError, &tracingContext)
+			return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
 		}
-		if len(*payments) == 0 {
-			payments = &[]model.AdminPayment{}
+		payments, page, err := a.Deps.DAL.TransactionDAL.FetchAdminPaymentsPage(context.TODO(), bson.D{}, req)
+		if err != nil {
+			return respondWithPageError(err, "unable to fetch admin payments", &tracingContext)
 		}
 		return &ServerResponse{
-			Payload: payments,
-			Message: "admin payments fetched successfully",
+			Payload:    payments,
+			Pagination: page,
+			Message:    "admin payments fetched successfully",
 		}
 	} else {
 		payment, err := a.Deps.DAL.TransactionDAL.GetAdminPayment(context.TODO(), bson.D{{"_id", id}})
@@ -627,14 +745,28 @@ func (a *API) fetchAdminPayments(w http.ResponseWriter, r *http.Request) *Server
 	}
 }
 
-// generateSecurityCode allows an authorized admin generate a security code for sensitive actions
-func (a *API) generateSecurityCode(w http.ResponseWriter, r *http.Request) *ServerResponse {
+//fetchJobs returns the state of background jobs, optionally filtered by status, kind or handler
+func (a *API) fetchJobs(w http.ResponseWriter, r *http.Request) *ServerResponse {
 	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
-	id := chi.URLParam(r, "ID")
-	fmt.Println("admin", id)
 
+	query := bson.D{}
+	for _, param := range []string{"status", "kind", "handler"} {
+		if value := r.URL.Query().Get(param); value != "" {
+			query = append(query, bson.E{Key: param, Value: value})
+		}
+	}
+
+	req, err := pageRequest(r)
+	if err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+	}
+	jobs, page, err := a.Deps.DAL.JobDAL.FetchPage(context.TODO(), query, req)
+	if err != nil {
+		return respondWithPageError(err, "unable to fetch jobs", &tracingContext)
+	}
 	return &ServerResponse{
-		Payload: tracingContext,
+		Payload:    jobs,
+		Pagination: page,
 	}
 }
 
@@ -2,14 +2,19 @@ package api
 
 import (
 	"github.com/go-chi/chi"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
 	"net/http"
 )
 
 func (a *API) AgentRoutes() http.Handler {
 	router := chi.NewRouter()
-	router.Use(Authorization)
-	router.Method("POST", "/create", Handler(a.createAgent))
-	router.Method("GET", "/{agentID}/account", Handler(a.getAccountInfo))
+	router.Use(a.Authorization)
+	router.Use(a.Authenticate)
+	router.With(RequireAccess(model.MANAGE_PERSONEL)).Method("POST", "/create", Handler(a.createAgent))
+	router.With(OwnerOrAccess("agentID", model.DASHBOARD)).Method("GET", "/{agentID}/account", Handler(a.getAccountInfo))
+	router.With(OwnerOrAccess("agentID", model.TRANSACTION)).Method("GET", "/{agentID}/history", Handler(a.getAgentHistory))
+	router.With(OwnerOrAccess("agentID", model.REVENUE)).Method("GET", "/{agentID}/earnings", Handler(a.getAgentEarnings))
+	router.With(OwnerOnly("agentID")).Method("GET", "/{agentID}/notifications", Handler(a.getAgentNotifications))
 	return router
 }
 
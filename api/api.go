@@ -37,9 +37,10 @@ func (a *API) SetupServerHandler() http.Handler {
 		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders: []string{
 			"Accept", "Authorization", "Content-Type",
-			"X-CSRF-Token", config.HeaderRequestID, config.HeaderRequestSource,
+			"X-CSRF-Token", config.HeaderRequestID, config.HeaderRequestSource, HeaderIdempotencyKey, HeaderTransactionPin,
+			HeaderSecurityCode, HeaderOTP,
 		},
-		ExposedHeaders:   []string{"Link"},
+		ExposedHeaders:   []string{"Link", HeaderIdempotencyReplayed},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
@@ -53,6 +54,8 @@ func (a *API) SetupServerHandler() http.Handler {
 	mux.Mount("/admin", a.AdminRoutes())
 	mux.Mount("/media", a.MediaRoutes())
 	mux.Mount("/agent", a.AgentRoutes())
+	mux.Mount("/quote", a.QuoteRoutes())
+	mux.Mount("/currency", a.CurrencyRoutes())
 
 	return mux
 }
@@ -95,11 +98,15 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		})
 	} else {
 
-		responseBytes, marshalErr = json.Marshal(map[string]interface{}{
+		body := map[string]interface{}{
 			"message":     response.Message,
 			"payload":     response.Payload,
 			"status_code": response.StatusCode,
-		})
+		}
+		if response.Pagination != nil {
+			body["pagination"] = response.Pagination
+		}
+		responseBytes, marshalErr = json.Marshal(body)
 	}
 
 	if marshalErr != nil {
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/isongjosiah/work/onepurse-api/common"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/tracing"
+	"github.com/lucsky/cuid"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ContextKeyAudit holds the auditRecord of a request that AuditTrail will log
+const ContextKeyAudit = common.ContextKey("audit")
+
+// auditRecord collects what a handler changed so AuditTrail can log it once the handler is done
+type auditRecord struct {
+	target  string
+	changes []model.AuditChange
+}
+
+// AuditTrail adds an entry to the audit log for every request that is not a read, with the response status and
+// any change the handler recorded with auditChange. It must run after Authenticate
+func (a *API) AuditTrail(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		record := &auditRecord{}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		r = r.WithContext(context.WithValue(r.Context(), ContextKeyAudit, record))
+		next.ServeHTTP(ww, r)
+
+		action := r.Method + " " + r.URL.Path
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			action = r.Method + " " + rctx.RoutePattern()
+		}
+		target := record.target
+		if target == "" {
+			target = requestTarget(r)
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		a.appendAudit(r, action, target, status, record.changes)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// requestTarget describes what a request acts on from its URL parameters and id query, for handlers that do not
+// record a target themselves
+func requestTarget(r *http.Request) string {
+	var parts []string
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		for i, key := range rctx.URLParams.Keys {
+			if key != "*" {
+				parts = append(parts, key+":"+rctx.URLParams.Values[i])
+			}
+		}
+	}
+	if id := r.URL.Query().Get("id"); id != "" {
+		parts = append(parts, "id:"+id)
+	}
+	return strings.Join(parts, ",")
+}
+
+// auditChange records the fields that changed on target between before and after, compared by their JSON encoding.
+// Either can be nil when the target was created or deleted. Fields hidden from JSON are never logged
+func auditChange(r *http.Request, target string, before, after interface{}) {
+	record, ok := r.Context().Value(ContextKeyAudit).(*auditRecord)
+	if !ok {
+		return
+	}
+	record.target = target
+
+	beforeFields, afterFields := jsonFields(before), jsonFields(after)
+	var fields []string
+	for field := range beforeFields {
+		fields = append(fields, field)
+	}
+	for field := range afterFields {
+		if _, ok := beforeFields[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		b, a := beforeFields[field], afterFields[field]
+		if !bytes.Equal(b, a) {
+			record.changes = append(record.changes, model.AuditChange{Field: field, Before: string(b), After: string(a)})
+		}
+	}
+}
+
+// jsonFields returns the top level fields of the JSON encoding of v
+func jsonFields(v interface{}) map[string]json.RawMessage {
+	fields := make(map[string]json.RawMessage)
+	if v == nil {
+		return fields
+	}
+	content, err := json.Marshal(v)
+	if err != nil {
+		logrus.Errorf("[Audit]: unable to encode %T: %s", v, err.Error())
+		return fields
+	}
+	_ = json.Unmarshal(content, &fields)
+	return fields
+}
+
+// audit records an action taken by the caller of r that is not tied to the response, e.g. a failed step-up
+func (a *API) audit(r *http.Request, action, target string) {
+	a.appendAudit(r, action, target, 0, nil)
+}
+
+// appendAudit adds an entry for the caller of r to the audit log. Failing to add it is logged but does not fail the
+// request
+func (a *API) appendAudit(r *http.Request, action, target string, status int, changes []model.AuditChange) {
+	entry := &model.AuditLog{
+		ID:        cuid.New(),
+		Actor:     actorOf(r),
+		Action:    action,
+		Target:    target,
+		Status:    status,
+		Changes:   changes,
+		IP:        clientIP(r),
+		CreatedAt: time.Now(),
+	}
+	if p := principalOf(r); p != nil {
+		entry.ActorID = p.ID
+		if p.Role != nil {
+			entry.Role = p.Role.Slug
+		}
+	}
+	if tracingContext, ok := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context); ok {
+		entry.RequestID = tracingContext.RequestID
+	}
+	if err := a.Deps.DAL.AuditDAL.Append(context.Background(), entry); err != nil {
+		logrus.Errorf("[Audit]: unable to record %s by %s: %s", action, entry.Actor, err.Error())
+	}
+}
+
+// clientIP is the address of the caller, which RealIP takes from X-Forwarded-For or X-Real-IP when present
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// fetchActivityLogs allows an authorized admin page through the audit log, newest first. It can be filtered by
+// actor_id, action, target and a from/to time range
+func (a *API) fetchActivityLogs(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	params := r.URL.Query()
+
+	query := bson.D{}
+	for _, param := range []string{"actor_id", "action", "target"} {
+		if value := params.Get(param); value != "" {
+			query = append(query, bson.E{Key: param, Value: value})
+		}
+	}
+	createdAt := bson.D{}
+	for param, operator := range map[string]string{"from": "$gte", "to": "$lte"} {
+		if value := params.Get(param); value != "" {
+			at, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return RespondWithError(err, param+" must be an RFC 3339 time", http.StatusBadRequest, &tracingContext)
+			}
+			createdAt = append(createdAt, bson.E{Key: operator, Value: at})
+		}
+	}
+	if len(createdAt) > 0 {
+		query = append(query, bson.E{Key: "created_at", Value: createdAt})
+	}
+
+	req, err := pageRequest(r)
+	if err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+	}
+
+	logs, page, err := a.Deps.DAL.AuditDAL.Fetch(context.TODO(), query, req)
+	if err != nil {
+		return respondWithPageError(err, "unable to fetch activity logs", &tracingContext)
+	}
+	return &ServerResponse{
+		Payload:    logs,
+		Pagination: page,
+	}
+}
+
+// verifyActivityLogs allows an authorized admin check that no audit log entry was changed or removed
+func (a *API) verifyActivityLogs(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+
+	broken, checked, err := a.Deps.DAL.AuditDAL.Verify(context.TODO())
+	if err != nil {
+		return RespondWithError(err, "unable to verify activity logs", http.StatusInternalServerError, &tracingContext)
+	}
+	response := map[string]interface{}{
+		"intact":  broken == nil,
+		"checked": checked,
+	}
+	if broken != nil {
+		logrus.Errorf("[Audit]: chain is broken at entry %d (%s)", broken.Seq, broken.ID)
+		response["broken_at"] = broken.Seq
+	}
+	return &ServerResponse{
+		Payload: response,
+	}
+}
@@ -25,10 +25,28 @@ func (a *API) AuthRoutes(router *chi.Mux) http.Handler {
 	router.Method("POST", "/reset_password", Handler(a.resetPassword))
 	router.Method("POST", "/confirm_password", Handler(a.confirmPassword))
 	router.Method("POST", "/resend_code", Handler(a.resendCode))
+	if a.Deps.LocalKeys != nil {
+		router.Get("/.well-known/jwks.json", a.serveJWKS)
+	}
 
 	return router
 }
 
+// serveJWKS publishes the public keys of locally signed tokens in the format cognito does
+func (a *API) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	set, err := a.Deps.LocalKeys.Fetch(r.Context())
+	if err != nil {
+		writeErrorResponse(w, http.StatusServiceUnavailable, "signing keys are unavailable")
+		return
+	}
+	body, err := json.Marshal(set)
+	if err != nil {
+		writeErrorResponse(w, http.StatusInternalServerError, "unable to encode signing keys")
+		return
+	}
+	WriteJSONResponse(w, http.StatusOK, body)
+}
+
 func (a *API) login(w http.ResponseWriter, r *http.Request) *ServerResponse {
 	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
 	action := r.URL.Query().Get("action")
@@ -51,7 +69,7 @@ func (a *API) login(w http.ResponseWriter, r *http.Request) *ServerResponse {
 			return RespondWithError(nil, "Session is a required field", http.StatusBadRequest, &tracingContext)
 		}
 
-		authResponse, err := a.Deps.AWS.Cognito.InvitedUserChangePassword(&login)
+		authResponse, err := a.Deps.Identity.InvitedUserChangePassword(&login)
 		if err != nil {
 			var ae smithy.APIError
 			if errors.As(err, &ae) {
@@ -88,7 +106,7 @@ func (a *API) login(w http.ResponseWriter, r *http.Request) *ServerResponse {
 			return RespondWithError(nil, "Password is a required field", http.StatusBadRequest, &tracingContext)
 		}
 
-		authResponse, err := a.Deps.AWS.Cognito.Login(&login)
+		authResponse, err := a.Deps.Identity.Login(&login)
 		if err != nil {
 			var ae smithy.APIError
 			if errors.As(err, &ae) {
@@ -145,7 +163,7 @@ func (a *API) login(w http.ResponseWriter, r *http.Request) *ServerResponse {
 			return RespondWithError(nil, "Password is a required field", http.StatusBadRequest, &tracingContext)
 		}
 
-		authResponse, err := a.Deps.AWS.Cognito.Login(&login)
+		authResponse, err := a.Deps.Identity.Login(&login)
 		if err != nil {
 			var ae smithy.APIError
 			if errors.As(err, &ae) {
@@ -202,7 +220,7 @@ func (a *API) login(w http.ResponseWriter, r *http.Request) *ServerResponse {
 			return RespondWithError(nil, "Password is a required field", http.StatusBadRequest, &tracingContext)
 		}
 
-		authResponse, err := a.Deps.AWS.Cognito.Login(&login)
+		authResponse, err := a.Deps.Identity.Login(&login)
 		if err != nil {
 			var ae smithy.APIError
 			if errors.As(err, &ae) {
@@ -262,7 +280,7 @@ func (a *API) refreshAccessToken(w http.ResponseWriter, r *http.Request) *Server
 		return RespondWithError(nil, "email is a required fields", http.StatusBadRequest, &tracingContext)
 	}
 
-	authResponse, err := a.Deps.AWS.Cognito.RefreshAccessToken(&refresh)
+	authResponse, err := a.Deps.Identity.RefreshAccessToken(&refresh)
 	if err != nil {
 		var ae smithy.APIError
 		if errors.As(err, &ae) {
@@ -314,7 +332,7 @@ func (a *API) signUp(w http.ResponseWriter, r *http.Request) *ServerResponse {
 		return RespondWithError(nil, "Password is a required field", http.StatusBadRequest, &tracingContext)
 	}
 
-	signupResponse, err := a.Deps.AWS.Cognito.SignUp(&registration)
+	signupResponse, err := a.Deps.Identity.SignUp(&registration)
 	if err != nil {
 		var ae smithy.APIError
 		if errors.As(err, &ae) {
@@ -387,7 +405,7 @@ func (a *API) confirmSignUp(w http.ResponseWriter, r *http.Request) *ServerRespo
 		return RespondWithError(nil, "Code is a required field", http.StatusBadRequest, &tracingContext)
 	}
 
-	status, err := a.Deps.AWS.Cognito.ConfirmSignUp(&verification)
+	status, err := a.Deps.Identity.ConfirmSignUp(&verification)
 	if err != nil {
 		var ae smithy.APIError
 		if errors.As(err, &ae) {
@@ -423,7 +441,7 @@ func (a *API) resendCode(w http.ResponseWriter, r *http.Request) *ServerResponse
 		return RespondWithError(nil, "Email is a required field", http.StatusBadRequest, &tracingContext)
 	}
 
-	_, err := a.Deps.AWS.Cognito.ResendCode(email.Email)
+	err := a.Deps.Identity.ResendCode(email.Email)
 
 	if err != nil {
 		var ae smithy.APIError
@@ -461,7 +479,7 @@ func (a *API) resetPassword(w http.ResponseWriter, r *http.Request) *ServerRespo
 		return RespondWithError(nil, "Email is a required field", http.StatusBadRequest, &tracingContext)
 	}
 
-	_, err := a.Deps.AWS.Cognito.ForgetPassword(email.Email)
+	err := a.Deps.Identity.ForgetPassword(email.Email)
 	if err != nil {
 		var ae smithy.APIError
 		if errors.As(err, &ae) {
@@ -492,7 +510,7 @@ func (a *API) confirmPassword(w http.ResponseWriter, r *http.Request) *ServerRes
 		return RespondWithError(nil, "Failed to decode request body", http.StatusInternalServerError, &tracingContext)
 	}
 
-	status, err := a.Deps.AWS.Cognito.ConfirmForgotPassword(&password)
+	status, err := a.Deps.Identity.ConfirmForgotPassword(&password)
 	if err != nil {
 		var ae smithy.APIError
 		if errors.As(err, &ae) {
@@ -0,0 +1,394 @@
+package api
+
+import (
+	"context"
+	"github.com/go-chi/chi"
+	"github.com/isongjosiah/work/onepurse-api/dal"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"github.com/isongjosiah/work/onepurse-api/tracing"
+	"github.com/isongjosiah/work/onepurse-api/txstate"
+	"github.com/isongjosiah/work/onepurse-api/types"
+	"github.com/lucsky/cuid"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// commissionTransactionTypes are the transactions agents earn commissions on
+var commissionTransactionTypes = map[string]bool{
+	types.TRANSFER: true,
+	types.EXCHANGE: true,
+	types.DEPOSIT:  true,
+}
+
+// commissionOn returns the commission an agent earns by moving a transaction to state to, nil unless the transaction
+// is being completed by an agent whose commission schedule pays on it
+func (a *API) commissionOn(ctx context.Context, to, transactionType, transactionID, agentID, currency string, amount money.Amount) (*model.Commission, error) {
+	if to != txstate.Completed || agentID == "" {
+		return nil, nil
+	}
+	rule, err := a.Deps.DAL.CommissionDAL.MatchRule(ctx, agentID, transactionType, currency)
+	if err == dal.ErrCommissionRuleNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch commission rule")
+	}
+	commission, err := rule.Commission(amount, currency)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to price commission")
+	}
+	if commission <= money.Zero {
+		return nil, nil
+	}
+	return &model.Commission{
+		ID:              cuid.New(),
+		AgentID:         agentID,
+		TransactionID:   transactionID,
+		TransactionType: transactionType,
+		Currency:        currency,
+		BaseAmount:      amount,
+		Amount:          commission,
+		RuleID:          rule.ID,
+		Status:          model.CommissionAccrued,
+		CreatedAt:       time.Now(),
+	}, nil
+}
+
+// getAgentEarnings fetches the commissions an agent earned with their totals per currency. It takes the from, to,
+// type, currency and status filters of the transaction history, status being accrued, batched or paid
+func (a *API) getAgentEarnings(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	agentID := chi.URLParam(r, "agentID")
+	filter, err := historyRequest(r)
+	if err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+	}
+	req, err := pageRequest(r)
+	if err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+	}
+
+	statement := model.EarningsStatement{AgentID: agentID}
+	query := bson.D{{"agent_id", agentID}}
+	created := bson.D{}
+	if !filter.From.IsZero() {
+		statement.From = &filter.From
+		created = append(created, bson.E{Key: "$gte", Value: filter.From})
+	}
+	if !filter.To.IsZero() {
+		statement.To = &filter.To
+		created = append(created, bson.E{Key: "$lt", Value: filter.To})
+	}
+	if len(created) > 0 {
+		query = append(query, bson.E{Key: "created_at", Value: created})
+	}
+	if len(filter.Types) > 0 {
+		query = append(query, bson.E{Key: "transaction_type", Value: bson.D{{"$in", filter.Types}}})
+	}
+	if filter.Currency != "" {
+		query = append(query, bson.E{Key: "currency", Value: strings.ToUpper(filter.Currency)})
+	}
+
+	statement.Totals, err = a.Deps.DAL.CommissionDAL.Earnings(context.TODO(), query)
+	if err != nil {
+		return RespondWithError(err, "unable to fetch earnings", http.StatusInternalServerError, &tracingContext)
+	}
+	if len(filter.Statuses) > 0 {
+		query = append(query, bson.E{Key: "status", Value: bson.D{{"$in", filter.Statuses}}})
+	}
+	commissions, page, err := a.Deps.DAL.CommissionDAL.FetchCommissions(context.TODO(), query, req)
+	if err != nil {
+		return respondWithPageError(err, "unable to fetch earnings", &tracingContext)
+	}
+	statement.Commissions = commissions
+	return &ServerResponse{
+		Payload:    statement,
+		Pagination: page,
+	}
+}
+
+// fetchCommissionRules allows an authorized admin fetch the commission schedule, optionally of a single agent_id, with
+// the rules of every agent, or transaction_type
+func (a *API) fetchCommissionRules(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	query := bson.D{}
+	if value := r.URL.Query().Get("agent_id"); value != "" {
+		query = append(query, bson.E{Key: "agent_id", Value: bson.D{{"$in", bson.A{value, ""}}}})
+	}
+	if value := r.URL.Query().Get("transaction_type"); value != "" {
+		query = append(query, bson.E{Key: "transaction_type", Value: value})
+	}
+	rules, err := a.Deps.DAL.CommissionDAL.FindRules(context.TODO(), query)
+	if err != nil {
+		return RespondWithError(err, "unable to fetch commission schedule", http.StatusInternalServerError, &tracingContext)
+	}
+	return &ServerResponse{
+		Payload: rules,
+	}
+}
+
+// createCommissionRule allows an authorized admin add a rule to the commission schedule, of an agent or of every
+// agent. It is created disabled unless enabled is set
+func (a *API) createCommissionRule(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	var rule model.CommissionRule
+	if err := decodeJSONBody(&tracingContext, r.Body, &rule); err != nil {
+		return RespondWithError(err, "failed to decode request body", http.StatusBadRequest, &tracingContext)
+	}
+	if !commissionTransactionTypes[rule.TransactionType] {
+		return RespondWithError(nil, "transaction type must be transfer, exchange or deposit", http.StatusBadRequest, &tracingContext)
+	}
+	if rule.AgentID != "" {
+		if _, err := a.Deps.DAL.AgentDAL.FindOne(context.TODO(), bson.D{{"_id", rule.AgentID}}); err != nil {
+			return RespondWithError(err, "unable to find agent", http.StatusBadRequest, &tracingContext)
+		}
+	}
+	if rule.Currency != "" {
+		currency, resp := a.currencyOf(rule.Currency, true, &tracingContext)
+		if resp != nil {
+			return resp
+		}
+		rule.Currency = currency.Code
+	}
+	if err := rule.Validate(); err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+	}
+	rule.ID = cuid.New()
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = rule.CreatedAt
+
+	err := a.Deps.DAL.CommissionDAL.CreateRule(context.TODO(), &rule)
+	if err == dal.ErrCommissionRuleExists {
+		return RespondWithError(err, err.Error(), http.StatusConflict, &tracingContext)
+	}
+	if err != nil {
+		return RespondWithError(err, "unable to add commission rule", http.StatusInternalServerError, &tracingContext)
+	}
+	auditChange(r, "commission_rule:"+rule.ID, nil, rule)
+	return &ServerResponse{
+		Payload:    rule,
+		Message:    "commission rule added successfully",
+		StatusCode: http.StatusCreated,
+	}
+}
+
+// commissionRuleUpdate is the part of a commission rule that can change. The agent, transaction type and currency
+// identify the rule, so a rule for others is added instead
+type commissionRuleUpdate struct {
+	Flat            *money.Amount `json:"flat"`
+	Percent         *money.Rate   `json:"percent"`
+	MinAmount       *money.Amount `json:"min_amount"`
+	MaxAmount       *money.Amount `json:"max_amount"`
+	Enabled         *bool         `json:"enabled"`
+	AgentID         *string       `json:"agent_id"`
+	TransactionType *string       `json:"transaction_type"`
+	Currency        *string       `json:"currency"`
+}
+
+// updateCommissionRule allows an authorized admin change how a rule of the commission schedule prices commissions,
+// or enable and disable it. Commissions already accrued keep their amount
+func (a *API) updateCommissionRule(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	var update commissionRuleUpdate
+	if err := decodeJSONBody(&tracingContext, r.Body, &update); err != nil {
+		return RespondWithError(err, "failed to decode request body", http.StatusBadRequest, &tracingContext)
+	}
+	before, err := a.Deps.DAL.CommissionDAL.FindRule(context.TODO(), chi.URLParam(r, "ruleID"))
+	if err == dal.ErrCommissionRuleNotFound {
+		return RespondWithError(err, err.Error(), http.StatusNotFound, &tracingContext)
+	}
+	if err != nil {
+		return RespondWithError(err, "unable to fetch commission rule", http.StatusInternalServerError, &tracingContext)
+	}
+	if (update.AgentID != nil && *update.AgentID != before.AgentID) ||
+		(update.TransactionType != nil && *update.TransactionType != before.TransactionType) ||
+		(update.Currency != nil && !strings.EqualFold(*update.Currency, before.Currency)) {
+		return RespondWithError(nil, "the agent, transaction type and currency of a commission rule cannot change, add a rule for them instead", http.StatusBadRequest, &tracingContext)
+	}
+
+	after := *before
+	if update.Flat != nil {
+		after.Flat = *update.Flat
+	}
+	if update.Percent != nil {
+		after.Percent = *update.Percent
+	}
+	if update.MinAmount != nil {
+		after.MinAmount = *update.MinAmount
+	}
+	if update.MaxAmount != nil {
+		after.MaxAmount = *update.MaxAmount
+	}
+	if update.Enabled != nil {
+		after.Enabled = *update.Enabled
+	}
+	if err := after.Validate(); err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+	}
+
+	updated, err := a.Deps.DAL.CommissionDAL.UpdateRule(context.TODO(), before.ID, bson.D{{"$set", bson.D{
+		{"flat", after.Flat},
+		{"percent", after.Percent},
+		{"min_amount", after.MinAmount},
+		{"max_amount", after.MaxAmount},
+		{"enabled", after.Enabled},
+		{"updated_at", time.Now()},
+	}}})
+	if err != nil {
+		return RespondWithError(err, "unable to update commission rule", http.StatusInternalServerError, &tracingContext)
+	}
+	auditChange(r, "commission_rule:"+before.ID, before, updated)
+	return &ServerResponse{
+		Payload: updated,
+		Message: "commission rule updated successfully",
+	}
+}
+
+// settle batches the commissions accrued up to now for an admin to approve
+func (a *API) settle(ctx context.Context, createdBy string) (*model.SettlementBatch, error) {
+	now := time.Now()
+	batch := &model.SettlementBatch{
+		ID:        cuid.New(),
+		Cutoff:    now,
+		Status:    model.SettlementPending,
+		CreatedBy: createdBy,
+		CreatedAt: now,
+	}
+	if err := a.Deps.DAL.CommissionDAL.Settle(ctx, batch); err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// SettleCommissions batches the commissions accrued since the last settlement run for an admin to approve
+func (a *API) SettleCommissions(ctx context.Context, job *model.Job) error {
+	batch, err := a.settle(ctx, jobSettleCommissions)
+	if err == dal.ErrNothingToSettle {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	logrus.Infof("[Commission]: settlement batch %s created with %d payouts", batch.ID, len(batch.Payouts))
+	return nil
+}
+
+// runSettlement allows an authorized admin batch the accrued commissions now instead of waiting for the next
+// scheduled settlement run
+func (a *API) runSettlement(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	batch, err := a.settle(r.Context(), principalOf(r).String())
+	if err == dal.ErrNothingToSettle {
+		return RespondWithError(err, err.Error(), http.StatusConflict, &tracingContext)
+	}
+	if err != nil {
+		return RespondWithError(err, "unable to settle commissions", http.StatusInternalServerError, &tracingContext)
+	}
+	return &ServerResponse{
+		Payload:    batch,
+		Message:    "settlement batch created successfully",
+		StatusCode: http.StatusCreated,
+	}
+}
+
+// fetchSettlementBatches allows an authorized admin page through the settlement batches waiting for approval, or with
+// status the batches approved or rejected
+func (a *API) fetchSettlementBatches(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = model.SettlementPending
+	}
+	req, err := pageRequest(r)
+	if err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+	}
+
+	batches, page, err := a.Deps.DAL.CommissionDAL.FetchBatches(context.TODO(), bson.D{{"status", status}}, req)
+	if err != nil {
+		return respondWithPageError(err, "unable to fetch settlement batches", &tracingContext)
+	}
+	return &ServerResponse{
+		Payload:    batches,
+		Pagination: page,
+	}
+}
+
+// reviewSettlementBatch allows an authorized admin approve a settlement batch, paying the agents in it into their
+// wallets, or reject it, returning its commissions to the next settlement run. The admin who ran a settlement cannot
+// approve its batch
+func (a *API) reviewSettlementBatch(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	batchID := chi.URLParam(r, "batchID")
+	action := r.URL.Query().Get("action")
+	var review struct {
+		Note string `json:"note"`
+	}
+	if r.ContentLength > 0 {
+		if err := decodeJSONBody(&tracingContext, r.Body, &review); err != nil {
+			return RespondWithError(err, "failed to decode request body", http.StatusBadRequest, &tracingContext)
+		}
+	}
+
+	var status string
+	switch action {
+	case types.APPROVE:
+		status = model.SettlementApproved
+	case types.REJECT:
+		status = model.SettlementRejected
+	default:
+		return RespondWithError(nil, "action must be approve or reject", http.StatusBadRequest, &tracingContext)
+	}
+
+	ctx := context.Background()
+	ses, err := a.Deps.DAL.Client.StartSession()
+	if err != nil {
+		logrus.Errorf("[Mongo]: unable to create a session: %s", err.Error())
+		return RespondWithError(err, "Something went wrong. Please Try again", http.StatusInternalServerError, &tracingContext)
+	}
+	defer ses.EndSession(ctx)
+
+	now := time.Now()
+	result, err := ses.WithTransaction(ctx, func(sesCtx mongo.SessionContext) (interface{}, error) {
+		batch, err := a.Deps.DAL.CommissionDAL.ReviewBatch(sesCtx, batchID, status, principalOf(r).String(), review.Note, now)
+		if err != nil {
+			return nil, err
+		}
+		paid := status == model.SettlementApproved
+		if err := a.Deps.DAL.CommissionDAL.CloseBatch(sesCtx, batch.ID, paid, now); err != nil {
+			return nil, err
+		}
+		if paid {
+			if err := a.Deps.DAL.LedgerDAL.Post(sesCtx, settlementEntry(batch)); err != nil {
+				return nil, err
+			}
+		}
+		return batch, nil
+	})
+	switch errors.Cause(err) {
+	case nil:
+	case dal.ErrAgentCurrency:
+		return RespondWithError(err, err.Error(), http.StatusConflict, &tracingContext)
+	case dal.ErrSettlementBatchNotFound:
+		return RespondWithError(err, err.Error(), http.StatusNotFound, &tracingContext)
+	case dal.ErrSettlementBatchReviewed:
+		return RespondWithError(err, err.Error(), http.StatusConflict, &tracingContext)
+	case dal.ErrSettlementBatchOwnApproval:
+		return RespondWithError(err, err.Error(), http.StatusForbidden, &tracingContext)
+	default:
+		return RespondWithError(err, "unable to review settlement batch", http.StatusInternalServerError, &tracingContext)
+	}
+
+	batch := result.(*model.SettlementBatch)
+	auditChange(r, "settlement_batch:"+batch.ID, map[string]string{"status": model.SettlementPending}, map[string]string{"status": status})
+	return &ServerResponse{
+		Payload: batch,
+		Message: "settlement batch " + status,
+	}
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-chi/chi"
+	"github.com/isongjosiah/work/onepurse-api/dal"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"github.com/isongjosiah/work/onepurse-api/tracing"
+	"github.com/isongjosiah/work/onepurse-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CurrencyRoutes lists the currencies customers can hold wallets in and transact
+func (a *API) CurrencyRoutes() http.Handler {
+	router := chi.NewRouter()
+	router.Use(a.Authorization)
+	router.Method("GET", "/", Handler(a.getCurrencies))
+	return router
+}
+
+// currencyOf returns the registered currency of a code, or the response refusing a request in it. Only enabled
+// currencies are accepted unless disabled is set
+func (a *API) currencyOf(code string, disabled bool, tracingContext *tracing.Context) (*model.Currency, *ServerResponse) {
+	if code == "" {
+		return nil, RespondWithError(nil, "currency is required", http.StatusBadRequest, tracingContext)
+	}
+	currency, err := a.Deps.DAL.CurrencyDAL.Find(context.TODO(), strings.ToUpper(code))
+	if err == dal.ErrCurrencyNotFound {
+		return nil, RespondWithError(err, fmt.Sprintf("%s is not a supported currency", code), http.StatusBadRequest, tracingContext)
+	}
+	if err != nil {
+		return nil, RespondWithError(err, "unable to fetch currency", http.StatusInternalServerError, tracingContext)
+	}
+	if !currency.Enabled && !disabled {
+		return nil, RespondWithError(nil, fmt.Sprintf("%s is currently disabled", currency.Code), http.StatusBadRequest, tracingContext)
+	}
+	return currency, nil
+}
+
+// transactionCurrency returns the enabled currency of a transaction amount, or the response refusing the amount
+func (a *API) transactionCurrency(code string, amount money.Amount, tracingContext *tracing.Context) (*model.Currency, *ServerResponse) {
+	currency, resp := a.currencyOf(code, false, tracingContext)
+	if resp != nil {
+		return nil, resp
+	}
+	if err := currency.CheckAmount(amount); err != nil {
+		return nil, RespondWithError(err, err.Error(), http.StatusBadRequest, tracingContext)
+	}
+	return currency, nil
+}
+
+// getCurrencies fetches the enabled currencies
+func (a *API) getCurrencies(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	currencies, err := a.Deps.DAL.CurrencyDAL.FindAll(context.TODO(), bson.D{{"enabled", true}})
+	if err != nil {
+		return RespondWithError(err, "unable to fetch currencies", http.StatusInternalServerError, &tracingContext)
+	}
+	return &ServerResponse{
+		Payload: currencies,
+	}
+}
+
+// fetchCurrencies allows an authorized admin fetch every currency of the registry, enabled or not
+func (a *API) fetchCurrencies(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	currencies, err := a.Deps.DAL.CurrencyDAL.FindAll(context.TODO(), bson.D{})
+	if err != nil {
+		return RespondWithError(err, "unable to fetch currencies", http.StatusInternalServerError, &tracingContext)
+	}
+	return &ServerResponse{
+		Payload: currencies,
+	}
+}
+
+// createCurrency allows an authorized admin to register a currency. It is created disabled unless enabled is set, so
+// it can be set up before customers see it
+func (a *API) createCurrency(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	var currency model.Currency
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+
+	if err := decodeJSONBody(&tracingContext, r.Body, &currency); err != nil {
+		return RespondWithError(err, "Failed to decode request body", http.StatusBadRequest, &tracingContext)
+	}
+	currency.Code = strings.ToUpper(currency.Code)
+	if err := currency.Validate(); err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+	}
+	currency.CreatedAt = time.Now()
+	currency.UpdatedAt = currency.CreatedAt
+
+	err := a.Deps.DAL.CurrencyDAL.Add(context.TODO(), &currency)
+	if err == dal.ErrCurrencyExists {
+		return RespondWithError(err, err.Error(), http.StatusConflict, &tracingContext)
+	}
+	if err != nil {
+		return RespondWithError(err, "Failed to add currency", http.StatusInternalServerError, &tracingContext)
+	}
+	auditChange(r, "currency:"+currency.Code, nil, currency)
+	return &ServerResponse{
+		Payload:    currency,
+		Message:    "currency successfully added",
+		StatusCode: http.StatusCreated,
+	}
+}
+
+// currencyUpdate is the part of a currency that can change once it is registered. The type and minor units are fixed
+// as amounts are already stored in them
+type currencyUpdate struct {
+	Label              *string       `json:"label"`
+	Symbol             *string       `json:"symbol"`
+	Icon               *string       `json:"icon"`
+	MinAmount          *money.Amount `json:"min_amount"`
+	MaxAmount          *money.Amount `json:"max_amount"`
+	PaymentChannels    *[]string     `json:"payment_channels"`
+	BlockchainNetworks *[]string     `json:"blockchain_networks"`
+	Type               *string       `json:"type"`
+	MinorUnits         *int          `json:"minor_units"`
+}
+
+// updateCurrency allows an authorized admin change the label, symbol, icon, limits, payment channels and blockchain
+// networks of a currency
+func (a *API) updateCurrency(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	var update currencyUpdate
+	if err := decodeJSONBody(&tracingContext, r.Body, &update); err != nil {
+		return RespondWithError(err, "Failed to decode request body", http.StatusBadRequest, &tracingContext)
+	}
+	before, resp := a.currencyOf(chi.URLParam(r, "code"), true, &tracingContext)
+	if resp != nil {
+		return resp
+	}
+	if (update.Type != nil && *update.Type != before.Type) || (update.MinorUnits != nil && *update.MinorUnits != before.MinorUnits) {
+		return RespondWithError(nil, "the type and minor units of a currency cannot change", http.StatusBadRequest, &tracingContext)
+	}
+
+	after := *before
+	if update.Label != nil {
+		after.Label = *update.Label
+	}
+	if update.Symbol != nil {
+		after.Symbol = *update.Symbol
+	}
+	if update.Icon != nil {
+		after.Icon = *update.Icon
+	}
+	if update.MinAmount != nil {
+		after.MinAmount = *update.MinAmount
+	}
+	if update.MaxAmount != nil {
+		after.MaxAmount = *update.MaxAmount
+	}
+	if update.PaymentChannels != nil {
+		after.PaymentChannels = *update.PaymentChannels
+	}
+	if update.BlockchainNetworks != nil {
+		after.BlockchainNetworks = *update.BlockchainNetworks
+	}
+	if err := after.Validate(); err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+	}
+
+	updated, err := a.Deps.DAL.CurrencyDAL.Update(context.TODO(), before.Code, bson.D{{"$set", bson.D{
+		{"label", after.Label},
+		{"symbol", after.Symbol},
+		{"icon", after.Icon},
+		{"min_amount", after.MinAmount},
+		{"max_amount", after.MaxAmount},
+		{"payment_channels", after.PaymentChannels},
+		{"blockchain_networks", after.BlockchainNetworks},
+		{"updated_at", time.Now()},
+	}}})
+	if err != nil {
+		return RespondWithError(err, "unable to update currency", http.StatusInternalServerError, &tracingContext)
+	}
+	auditChange(r, "currency:"+before.Code, before, updated)
+	return &ServerResponse{
+		Payload: updated,
+		Message: "currency updated successfully",
+	}
+}
+
+// currencyActions allows an authorized admin enable or disable a currency. Wallets, transactions and quotes in a
+// disabled currency are refused, while those already created carry on
+func (a *API) currencyActions(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	action := r.URL.Query().Get("action")
+	currency, resp := a.currencyOf(chi.URLParam(r, "code"), true, &tracingContext)
+	if resp != nil {
+		return resp
+	}
+
+	var enabled bool
+	switch action {
+	case types.ACTIVATE:
+		enabled = true
+	case types.DEACTIVATE:
+		enabled = false
+	default:
+		return RespondWithError(nil, "this action does not exist", http.StatusBadRequest, &tracingContext)
+	}
+
+	updated, err := a.Deps.DAL.CurrencyDAL.Update(context.TODO(), currency.Code, bson.D{{"$set", bson.D{
+		{"enabled", enabled},
+		{"updated_at", time.Now()},
+	}}})
+	if err != nil {
+		return RespondWithError(err, "unable to update currency", http.StatusInternalServerError, &tracingContext)
+	}
+	auditChange(r, "currency:"+currency.Code, map[string]bool{"enabled": currency.Enabled}, map[string]bool{"enabled": enabled})
+	return &ServerResponse{
+		Payload: updated,
+		Message: "currency updated successfully",
+	}
+}
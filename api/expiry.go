@@ -0,0 +1,109 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/txstate"
+	"github.com/isongjosiah/work/onepurse-api/types"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"time"
+)
+
+// expiryActor is recorded in the history of transactions moved by the expiry job
+var expiryActor = transitionActor{Name: "expiry-job", Party: txstate.System}
+
+const expiryReason = "not matched or paid before the deadline"
+
+// enteredStateAt is when a transaction moved to its current status, the time of the last change of its history. A
+// transaction created before history was recorded is measured from when it was created
+var enteredStateAt = bson.D{{"$ifNull", bson.A{bson.D{{"$arrayElemAt", bson.A{"$history.at", -1}}}, "$created_at"}}}
+
+// ExpireTransactions moves transfers, exchanges and deposits that were not matched or paid within TransactionExpiry
+// of entering their current state to the expired state. Funds held for them are released and both parties are notified
+func (a *API) ExpireTransactions(ctx context.Context, job *model.Job) error {
+	deadline := time.Now().Add(-a.Config.TransactionExpiry)
+	query := func(transactionType string) bson.D {
+		return bson.D{
+			{"status", bson.D{{"$in", txstate.Sources(transactionType, txstate.Expired)}}},
+			{"$expr", bson.D{{"$lt", bson.A{enteredStateAt, deadline}}}},
+		}
+	}
+
+	transfers, err := a.Deps.DAL.TransactionDAL.FetchTransfers(ctx, query(types.TRANSFER))
+	if err != nil {
+		logrus.Errorf("[Expiry]: unable to fetch expired transfers: %s", err.Error())
+	} else {
+		for i := range *transfers {
+			t := &(*transfers)[i]
+			if err := a.transitionTransfer(ctx, t, txstate.Expired, expiryActor, expiryReason, nil); err != nil {
+				logrus.Errorf("[Expiry]: unable to expire transfer %s: %s", t.ID, err.Error())
+				continue
+			}
+			a.notifyExpired(ctx, types.TRANSFER, t, t.BaseCurrency, t.BaseAmount.String(), t.UserID, t.AgentID, "")
+		}
+	}
+
+	exchanges, err := a.Deps.DAL.TransactionDAL.FetchExchanges(ctx, query(types.EXCHANGE))
+	if err != nil {
+		logrus.Errorf("[Expiry]: unable to fetch expired exchanges: %s", err.Error())
+	} else {
+		for i := range *exchanges {
+			e := &(*exchanges)[i]
+			if err := a.transitionExchange(ctx, e, txstate.Expired, expiryActor, expiryReason, nil); err != nil {
+				logrus.Errorf("[Expiry]: unable to expire exchange %s: %s", e.ID, err.Error())
+				continue
+			}
+			a.notifyExpired(ctx, types.EXCHANGE, e, e.BaseCurrency, e.BaseAmount.String(), e.UserID, e.AgentID, e.MatchedUserID)
+		}
+	}
+
+	deposits, err := a.Deps.DAL.TransactionDAL.FetchDeposits(ctx, query(types.DEPOSIT))
+	if err != nil {
+		logrus.Errorf("[Expiry]: unable to fetch expired deposits: %s", err.Error())
+	} else {
+		for i := range *deposits {
+			d := &(*deposits)[i]
+			if err := a.transitionDeposit(ctx, d, txstate.Expired, expiryActor, expiryReason, nil); err != nil {
+				logrus.Errorf("[Expiry]: unable to expire deposit %s: %s", d.ID, err.Error())
+				continue
+			}
+			a.notifyExpired(ctx, types.DEPOSIT, d, d.BaseCurrency, d.BaseAmount.String(), d.UserID, d.AgentID, "")
+		}
+	}
+	return nil
+}
+
+// notifyExpired tells the user who created a transaction, and the agent or user it was matched to, that it expired.
+// A failed notification is logged and does not undo the expiry
+func (a *API) notifyExpired(ctx context.Context, transactionType string, transaction interface{}, currency, amount, userID, agentID, matchedUserID string) {
+	message := fmt.Sprintf("your %s %s %s has expired and any funds held for it have been released", currency, amount, transactionType)
+
+	userIDs := []string{userID}
+	if matchedUserID != "" {
+		userIDs = append(userIDs, matchedUserID)
+	}
+	for _, id := range userIDs {
+		user, err := a.Deps.DAL.UserDAL.FindByID(ctx, id)
+		if err != nil {
+			logrus.Errorf("[Expiry]: unable to find user %s to notify: %s", id, err.Error())
+			continue
+		}
+		if err := a.CreateNotification(ctx, user.ID, types.TRANSACTION_EXPIRED, message, transactionType, user.DeviceToken, transaction); err != nil {
+			logrus.Errorf("[Expiry]: unable to notify user %s: %s", user.ID, err.Error())
+		}
+	}
+
+	if agentID == "" {
+		return
+	}
+	agent, err := a.Deps.DAL.AgentDAL.FindOne(ctx, bson.D{{"_id", agentID}})
+	if err != nil {
+		logrus.Errorf("[Expiry]: unable to find agent %s to notify: %s", agentID, err.Error())
+		return
+	}
+	if err := a.CreateNotification(ctx, agent.ID, types.TRANSACTION_EXPIRED, message, transactionType, agent.DeviceToken, transaction); err != nil {
+		logrus.Errorf("[Expiry]: unable to notify agent %s: %s", agent.ID, err.Error())
+	}
+}
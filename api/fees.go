@@ -0,0 +1,280 @@
+package api
+
+import (
+	"context"
+	"github.com/go-chi/chi"
+	"github.com/isongjosiah/work/onepurse-api/dal"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"github.com/isongjosiah/work/onepurse-api/tracing"
+	"github.com/isongjosiah/work/onepurse-api/types"
+	"github.com/lucsky/cuid"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// feeTransactionTypes are the transactions fees are charged on
+var feeTransactionTypes = map[string]bool{
+	types.TRANSFER: true,
+	types.EXCHANGE: true,
+	types.WITHDRAW: true,
+}
+
+// feeOf returns the fee of the schedule on an amount of currency converted to toCurrency by a transaction of a type,
+// and the ID of the rule that set it. There is no fee when no enabled rule matches
+func (a *API) feeOf(ctx context.Context, transactionType, currency, toCurrency string, amount money.Amount) (money.Amount, string, error) {
+	rule, err := a.Deps.DAL.FeeDAL.Match(ctx, transactionType, currency, toCurrency)
+	if err == dal.ErrFeeRuleNotFound {
+		return money.Zero, "", nil
+	}
+	if err != nil {
+		return money.Zero, "", err
+	}
+	fee, err := rule.Fee(amount, currency)
+	if err != nil {
+		return money.Zero, "", errors.Wrap(err, "unable to price fee")
+	}
+	return fee, rule.ID, nil
+}
+
+// fetchFeeRules allows an authorized admin fetch the fee schedule, optionally of a single transaction_type or currency
+func (a *API) fetchFeeRules(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	query := bson.D{}
+	if value := r.URL.Query().Get("transaction_type"); value != "" {
+		query = append(query, bson.E{Key: "transaction_type", Value: value})
+	}
+	if value := r.URL.Query().Get("currency"); value != "" {
+		query = append(query, bson.E{Key: "currency", Value: strings.ToUpper(value)})
+	}
+	rules, err := a.Deps.DAL.FeeDAL.FindAll(context.TODO(), query)
+	if err != nil {
+		return RespondWithError(err, "unable to fetch fee schedule", http.StatusInternalServerError, &tracingContext)
+	}
+	return &ServerResponse{
+		Payload: rules,
+	}
+}
+
+// createFeeRule allows an authorized admin add a rule to the fee schedule. It is created disabled unless enabled is
+// set, so it can be checked before it is charged
+func (a *API) createFeeRule(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	var rule model.FeeRule
+	if err := decodeJSONBody(&tracingContext, r.Body, &rule); err != nil {
+		return RespondWithError(err, "failed to decode request body", http.StatusBadRequest, &tracingContext)
+	}
+	if !feeTransactionTypes[rule.TransactionType] {
+		return RespondWithError(nil, "transaction type must be transfer, exchange or withdraw", http.StatusBadRequest, &tracingContext)
+	}
+	if rule.TransactionType == types.WITHDRAW && rule.ToCurrency != "" {
+		return RespondWithError(nil, "withdrawals are not converted, leave to_currency empty", http.StatusBadRequest, &tracingContext)
+	}
+	// rules can be set up for disabled currencies ahead of enabling them
+	for _, code := range []*string{&rule.Currency, &rule.ToCurrency} {
+		if *code == "" {
+			continue
+		}
+		currency, resp := a.currencyOf(*code, true, &tracingContext)
+		if resp != nil {
+			return resp
+		}
+		*code = currency.Code
+	}
+	if err := rule.Validate(); err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+	}
+	rule.ID = cuid.New()
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = rule.CreatedAt
+
+	err := a.Deps.DAL.FeeDAL.Create(context.TODO(), &rule)
+	if err == dal.ErrFeeRuleExists {
+		return RespondWithError(err, err.Error(), http.StatusConflict, &tracingContext)
+	}
+	if err != nil {
+		return RespondWithError(err, "unable to add fee rule", http.StatusInternalServerError, &tracingContext)
+	}
+	auditChange(r, "fee_rule:"+rule.ID, nil, rule)
+	return &ServerResponse{
+		Payload:    rule,
+		Message:    "fee rule added successfully",
+		StatusCode: http.StatusCreated,
+	}
+}
+
+// feeRuleUpdate is the part of a fee rule that can change. The transaction type and currencies identify the rule, so
+// a rule for others is added instead
+type feeRuleUpdate struct {
+	Kind            *string          `json:"kind"`
+	Flat            *money.Amount    `json:"flat"`
+	Percent         *money.Rate      `json:"percent"`
+	Tiers           *[]model.FeeTier `json:"tiers"`
+	MinFee          *money.Amount    `json:"min_fee"`
+	MaxFee          *money.Amount    `json:"max_fee"`
+	Enabled         *bool            `json:"enabled"`
+	TransactionType *string          `json:"transaction_type"`
+	Currency        *string          `json:"currency"`
+	ToCurrency      *string          `json:"to_currency"`
+}
+
+// updateFeeRule allows an authorized admin change how a rule of the fee schedule prices fees, or enable and disable
+// it. Quotes already given keep the fee they were given with
+func (a *API) updateFeeRule(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	var update feeRuleUpdate
+	if err := decodeJSONBody(&tracingContext, r.Body, &update); err != nil {
+		return RespondWithError(err, "failed to decode request body", http.StatusBadRequest, &tracingContext)
+	}
+	before, err := a.Deps.DAL.FeeDAL.Find(context.TODO(), chi.URLParam(r, "feeID"))
+	if err == dal.ErrFeeRuleNotFound {
+		return RespondWithError(err, err.Error(), http.StatusNotFound, &tracingContext)
+	}
+	if err != nil {
+		return RespondWithError(err, "unable to fetch fee rule", http.StatusInternalServerError, &tracingContext)
+	}
+	if (update.TransactionType != nil && *update.TransactionType != before.TransactionType) ||
+		(update.Currency != nil && !strings.EqualFold(*update.Currency, before.Currency)) ||
+		(update.ToCurrency != nil && !strings.EqualFold(*update.ToCurrency, before.ToCurrency)) {
+		return RespondWithError(nil, "the transaction type and currencies of a fee rule cannot change, add a rule for them instead", http.StatusBadRequest, &tracingContext)
+	}
+
+	after := *before
+	if update.Kind != nil {
+		// pricing of the previous kind does not carry over
+		after.Kind, after.Flat, after.Percent, after.Tiers = *update.Kind, money.Zero, money.Rate{}, nil
+	}
+	if update.Flat != nil {
+		after.Flat = *update.Flat
+	}
+	if update.Percent != nil {
+		after.Percent = *update.Percent
+	}
+	if update.Tiers != nil {
+		after.Tiers = *update.Tiers
+	}
+	if update.MinFee != nil {
+		after.MinFee = *update.MinFee
+	}
+	if update.MaxFee != nil {
+		after.MaxFee = *update.MaxFee
+	}
+	if update.Enabled != nil {
+		after.Enabled = *update.Enabled
+	}
+	if err := after.Validate(); err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+	}
+
+	updated, err := a.Deps.DAL.FeeDAL.Update(context.TODO(), before.ID, bson.D{{"$set", bson.D{
+		{"kind", after.Kind},
+		{"flat", after.Flat},
+		{"percent", after.Percent},
+		{"tiers", after.Tiers},
+		{"min_fee", after.MinFee},
+		{"max_fee", after.MaxFee},
+		{"enabled", after.Enabled},
+		{"updated_at", time.Now()},
+	}}})
+	if err != nil {
+		return RespondWithError(err, "unable to update fee rule", http.StatusInternalServerError, &tracingContext)
+	}
+	auditChange(r, "fee_rule:"+before.ID, before, updated)
+	return &ServerResponse{
+		Payload: updated,
+		Message: "fee rule updated successfully",
+	}
+}
+
+// getRevenue allows an authorized admin fetch the fees the platform earned, totalled per currency and transaction type
+// and bucketed by interval. It takes the parameters of the transaction volume report, and optionally a single
+// currency or transaction_type
+func (a *API) getRevenue(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	start, end, interval, err := volumeRange(r)
+	if err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+	}
+	query := bson.D{}
+	if value := r.URL.Query().Get("currency"); value != "" {
+		query = append(query, bson.E{Key: "account.currency", Value: strings.ToUpper(value)})
+	}
+	if value := r.URL.Query().Get("transaction_type"); value != "" {
+		query = append(query, bson.E{Key: "transaction_type", Value: value})
+	}
+
+	report, err := a.RevenueReport(context.TODO(), start, end, interval, query)
+	if err != nil {
+		return RespondWithError(err, "unable to fetch revenue", http.StatusInternalServerError, &tracingContext)
+	}
+	return &ServerResponse{
+		Payload: report,
+	}
+}
+
+// RevenueReport totals the fees paid into the platform revenue accounts from start up to end, bucketed by interval in
+// the location of start. Fees are totalled by the hour, so timezones offset by part of an hour are bucketed to the
+// hour
+func (a *API) RevenueReport(ctx context.Context, start, end time.Time, interval string, query bson.D) (*model.RevenueReport, error) {
+	if !intervals[interval] {
+		return nil, errors.Errorf("unknown interval %s", interval)
+	}
+	loc := start.Location()
+
+	report := &model.RevenueReport{
+		Interval: interval,
+		Timezone: loc.String(),
+		Start:    start,
+		End:      end,
+		Values:   make(map[string]money.Amount),
+		Types:    make(map[string]map[string]money.Amount),
+	}
+	buckets := make(map[string]*model.RevenueBucket)
+	for at := periodStart(start, interval); at.Before(end); at = nextPeriod(at, interval) {
+		report.Buckets = append(report.Buckets, model.RevenueBucket{
+			Period: periodOf(at, interval),
+			Start:  at,
+			Values: make(map[string]money.Amount),
+			Types:  make(map[string]map[string]money.Amount),
+		})
+	}
+	for i := range report.Buckets {
+		buckets[report.Buckets[i].Period] = &report.Buckets[i]
+	}
+
+	rollups, err := a.Deps.DAL.LedgerDAL.RevenueHours(ctx, start, end, query)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch revenue")
+	}
+	for _, rollup := range rollups {
+		if err := addRevenue(report.Values, report.Types, rollup); err != nil {
+			return nil, err
+		}
+		bucket, ok := buckets[periodOf(rollup.Hour.In(loc), interval)]
+		if !ok {
+			continue
+		}
+		bucket.Count += rollup.Count
+		if err := addRevenue(bucket.Values, bucket.Types, rollup); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// addRevenue adds the revenue of a rollup to totals by currency and to byType by transaction type and currency
+func addRevenue(totals map[string]money.Amount, byType map[string]map[string]money.Amount, rollup model.RevenueRollup) error {
+	if err := money.AddTo(totals, rollup.Currency, rollup.Amount); err != nil {
+		return errors.Wrap(err, "unable to total revenue")
+	}
+	if byType[rollup.TransactionType] == nil {
+		byType[rollup.TransactionType] = make(map[string]money.Amount)
+	}
+	if err := money.AddTo(byType[rollup.TransactionType], rollup.Currency, rollup.Amount); err != nil {
+		return errors.Wrap(err, "unable to total revenue")
+	}
+	return nil
+}
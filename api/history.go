@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"github.com/go-chi/chi"
+	"github.com/isongjosiah/work/onepurse-api/dal"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/tracing"
+	"github.com/pkg/errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// historyRequest reads the filters of a transaction history request. type and status take comma separated lists,
+// currency and counterparty a single value, and from and to a YYYY-MM-DD date in UTC, with the whole to day included,
+// or an RFC 3339 time
+func historyRequest(r *http.Request) (model.HistoryFilter, error) {
+	params := r.URL.Query()
+	filter := model.HistoryFilter{
+		Statuses:       splitList(params.Get("status")),
+		Currency:       params.Get("currency"),
+		CounterpartyID: params.Get("counterparty"),
+	}
+
+	for _, transactionType := range splitList(params.Get("type")) {
+		known := false
+		for _, t := range dal.TransactionTypes {
+			known = known || t == transactionType
+		}
+		if !known {
+			return filter, errors.Errorf("unknown transaction type %s", transactionType)
+		}
+		filter.Types = append(filter.Types, transactionType)
+	}
+
+	if value := params.Get("from"); value != "" {
+		from, _, err := parseDate(value, time.UTC)
+		if err != nil {
+			return filter, errors.New("unable to parse from date, use YYYY-MM-DD or RFC 3339")
+		}
+		filter.From = from
+	}
+	if value := params.Get("to"); value != "" {
+		to, dateOnly, err := parseDate(value, time.UTC)
+		if err != nil {
+			return filter, errors.New("unable to parse to date, use YYYY-MM-DD or RFC 3339")
+		}
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+		filter.To = to
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return filter, errors.New("from date must be before to date")
+	}
+	return filter, nil
+}
+
+// splitList splits a comma separated parameter, ignoring empty values
+func splitList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// respondWithHistory fetches the page of history requested by r, narrowed by scope
+func (a *API) respondWithHistory(r *http.Request, scope func(filter *model.HistoryFilter)) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	filter, err := historyRequest(r)
+	if err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+	}
+	req, err := pageRequest(r)
+	if err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+	}
+	scope(&filter)
+
+	transactions, page, err := a.Deps.DAL.TransactionDAL.FetchHistory(context.TODO(), filter, req)
+	if err != nil {
+		return respondWithPageError(err, "unable to fetch transaction history", &tracingContext)
+	}
+	return &ServerResponse{
+		Payload:    transactions,
+		Pagination: page,
+	}
+}
+
+// getUserHistory fetches the transactions a user created or was matched to, of every type
+func (a *API) getUserHistory(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	userID := chi.URLParam(r, "userID")
+	return a.respondWithHistory(r, func(filter *model.HistoryFilter) {
+		filter.ParticipantID = userID
+	})
+}
+
+// getAgentHistory fetches the transactions an agent was matched to, of every type
+func (a *API) getAgentHistory(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	agentID := chi.URLParam(r, "agentID")
+	return a.respondWithHistory(r, func(filter *model.HistoryFilter) {
+		filter.AgentID = agentID
+	})
+}
+
+// fetchTransactionHistory allows an authorized admin fetch the transactions of every type on the platform, optionally
+// of a single user_id or agent_id
+func (a *API) fetchTransactionHistory(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	params := r.URL.Query()
+	return a.respondWithHistory(r, func(filter *model.HistoryFilter) {
+		filter.ParticipantID = params.Get("user_id")
+		filter.AgentID = params.Get("agent_id")
+	})
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	HeaderIdempotencyKey      = "Idempotency-Key"
+	HeaderIdempotencyReplayed = "Idempotent-Replayed"
+	idempotencyKeyTTL         = 24 * time.Hour
+	maxIdempotencyKeyLength   = 255
+)
+
+// recordingWriter keeps a copy of the response written by a handler so it can be stored for replays
+type recordingWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// Idempotency makes a money moving route safe to retry. The response to the first request carrying an
+// Idempotency-Key is stored, retries with the same key and payload get the stored response back, and reusing a key
+// for a different payload is rejected. Requests without the header are handled as usual. It must run before StepUp,
+// so a retry is replayed without a new security code and a refused code does not use up the key
+func (a *API) Idempotency(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(HeaderIdempotencyKey)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeErrorResponse(w, http.StatusBadRequest, "Idempotency-Key is too long")
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, "unable to read request body")
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		scope := idempotencyScope(r)
+		now := time.Now()
+		record := &model.IdempotencyRecord{
+			ID:          sha256Hex(scope, key),
+			Scope:       scope,
+			Key:         key,
+			RequestHash: sha256Hex(r.Method, r.URL.Path, r.URL.RawQuery, string(body)),
+			State:       model.IdempotencyInProgress,
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(idempotencyKeyTTL),
+		}
+
+		existing, err := a.Deps.DAL.IdempotencyDAL.Reserve(r.Context(), record)
+		if err != nil {
+			writeErrorResponse(w, http.StatusInternalServerError, "unable to process Idempotency-Key. Please try again")
+			return
+		}
+		if existing != nil {
+			switch {
+			case existing.RequestHash != record.RequestHash:
+				writeErrorResponse(w, http.StatusConflict, "Idempotency-Key has already been used for a different request")
+			case existing.State != model.IdempotencyCompleted:
+				writeErrorResponse(w, http.StatusConflict, "a request with this Idempotency-Key is still being processed")
+			default:
+				w.Header().Set(HeaderIdempotencyReplayed, "true")
+				WriteJSONResponse(w, existing.StatusCode, existing.Body)
+			}
+			return
+		}
+
+		recorder := &recordingWriter{ResponseWriter: w}
+		defer func() {
+			// a request that failed on our side, or panicked, may be retried with the same key, as may one refused
+			// before it was handled, such as one with a missing or spent security code
+			if p := recover(); p != nil {
+				a.releaseIdempotencyKey(record.ID)
+				panic(p)
+			}
+			if recorder.statusCode == 0 || recorder.statusCode >= http.StatusInternalServerError ||
+				recorder.statusCode == http.StatusUnauthorized || recorder.statusCode == http.StatusForbidden {
+				a.releaseIdempotencyKey(record.ID)
+				return
+			}
+			if err := a.Deps.DAL.IdempotencyDAL.Complete(context.Background(), record.ID, recorder.statusCode, recorder.body.Bytes()); err != nil {
+				logrus.Errorf("[Idempotency]: unable to store response for key %s: %s", key, err.Error())
+			}
+		}()
+		next.ServeHTTP(recorder, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+func (a *API) releaseIdempotencyKey(ID string) {
+	if err := a.Deps.DAL.IdempotencyDAL.Release(context.Background(), ID); err != nil {
+		logrus.Errorf("[Idempotency]: unable to release key %s: %s", ID, err.Error())
+	}
+}
+
+// idempotencyScope identifies the caller an Idempotency-Key belongs to, so two callers can use the same key
+func idempotencyScope(r *http.Request) string {
+	if p := principalOf(r); p != nil {
+		return p.String()
+	}
+	return "token:" + sha256Hex(r.Header.Get("Authorization"))
+}
+
+func sha256Hex(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
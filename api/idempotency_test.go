@@ -0,0 +1,140 @@
+package api
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/dal"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/deps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memoryIdempotencyDAL keeps idempotency records in memory
+type memoryIdempotencyDAL struct {
+	mu      sync.Mutex
+	records map[string]model.IdempotencyRecord
+}
+
+func (m *memoryIdempotencyDAL) Reserve(ctx context.Context, record *model.IdempotencyRecord) (*model.IdempotencyRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if existing, ok := m.records[record.ID]; ok {
+		return &existing, nil
+	}
+	m.records[record.ID] = *record
+	return nil, nil
+}
+
+func (m *memoryIdempotencyDAL) Complete(ctx context.Context, ID string, statusCode int, body []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record := m.records[ID]
+	record.State, record.StatusCode, record.Body = model.IdempotencyCompleted, statusCode, body
+	m.records[ID] = record
+	return nil
+}
+
+func (m *memoryIdempotencyDAL) Release(ctx context.Context, ID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, ID)
+	return nil
+}
+
+func newIdempotencyAPI() *API {
+	return &API{Deps: &deps.Dependencies{DAL: &dal.DAL{
+		IdempotencyDAL: &memoryIdempotencyDAL{records: make(map[string]model.IdempotencyRecord)},
+	}}}
+}
+
+func idempotentRequest(handler http.Handler, key, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/payments", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer token")
+	r.Header.Set(HeaderIdempotencyKey, key)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	calls := 0
+	handler := newIdempotencyAPI().Idempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		WriteJSONResponse(w, http.StatusCreated, []byte(`{"payment":1}`))
+	}))
+
+	first := idempotentRequest(handler, "key", `{"amount":"10"}`)
+	retry := idempotentRequest(handler, "key", `{"amount":"10"}`)
+	if calls != 1 {
+		t.Fatalf("handler ran %d times, want 1", calls)
+	}
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() {
+		t.Errorf("retry = %d %s, want %d %s", retry.Code, retry.Body, first.Code, first.Body)
+	}
+	if retry.Header().Get(HeaderIdempotencyReplayed) != "true" {
+		t.Errorf("retry is not marked as replayed")
+	}
+
+	other := idempotentRequest(handler, "other", `{"amount":"10"}`)
+	if calls != 2 || other.Header().Get(HeaderIdempotencyReplayed) != "" {
+		t.Errorf("a request with another key was replayed")
+	}
+}
+
+func TestIdempotencyDifferentRequest(t *testing.T) {
+	calls := 0
+	handler := newIdempotencyAPI().Idempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		WriteJSONResponse(w, http.StatusCreated, []byte(`{}`))
+	}))
+
+	idempotentRequest(handler, "key", `{"amount":"10"}`)
+	reused := idempotentRequest(handler, "key", `{"amount":"20"}`)
+	if reused.Code != http.StatusConflict {
+		t.Errorf("reusing a key for another request = %d, want %d", reused.Code, http.StatusConflict)
+	}
+	if calls != 1 {
+		t.Errorf("handler ran %d times, want 1", calls)
+	}
+}
+
+func TestIdempotencyInFlight(t *testing.T) {
+	var handler http.Handler
+	var concurrent *httptest.ResponseRecorder
+	handler = newIdempotencyAPI().Idempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if concurrent == nil {
+			// a retry arriving while the first request is still being handled
+			concurrent = idempotentRequest(handler, "key", `{"amount":"10"}`)
+		}
+		WriteJSONResponse(w, http.StatusCreated, []byte(`{}`))
+	}))
+
+	first := idempotentRequest(handler, "key", `{"amount":"10"}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first request = %d, want %d", first.Code, http.StatusCreated)
+	}
+	if concurrent.Code != http.StatusConflict {
+		t.Errorf("request with an in flight key = %d, want %d", concurrent.Code, http.StatusConflict)
+	}
+}
+
+func TestIdempotencyReleasesRefusedRequests(t *testing.T) {
+	statuses := []int{http.StatusForbidden, http.StatusInternalServerError, http.StatusCreated}
+	calls := 0
+	handler := newIdempotencyAPI().Idempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteJSONResponse(w, statuses[calls], []byte(`{}`))
+		calls++
+	}))
+
+	for _, want := range statuses {
+		if got := idempotentRequest(handler, "key", `{"amount":"10"}`); got.Code != want {
+			t.Fatalf("request %d = %d, want %d", calls, got.Code, want)
+		}
+	}
+	if replay := idempotentRequest(handler, "key", `{"amount":"10"}`); replay.Code != http.StatusCreated || calls != len(statuses) {
+		t.Errorf("replay = %d after %d calls, want the stored %d", replay.Code, calls, http.StatusCreated)
+	}
+}
@@ -0,0 +1,32 @@
+package api
+
+import "context"
+
+// Background jobs run by the scheduler
+const (
+	jobExpireTransactions = "expire-transactions"
+	jobRollupMetrics      = "rollup-metrics"
+	jobFetchRates         = "fetch-rates"
+	jobSettleCommissions  = "settle-commissions"
+)
+
+// RegisterJobs registers every background job of the API with the scheduler. It must be called before the scheduler
+// is started
+func (a *API) RegisterJobs(ctx context.Context) error {
+	s := a.Deps.Scheduler
+	if err := s.Cron(ctx, jobExpireTransactions, "@every "+a.Config.ExpiryInterval.String(), a.ExpireTransactions); err != nil {
+		return err
+	}
+	if err := s.Cron(ctx, jobRollupMetrics, "@every "+a.Config.RollupInterval.String(), a.RollupMetrics); err != nil {
+		return err
+	}
+	if err := s.Cron(ctx, jobSettleCommissions, "@every "+a.Config.CommissionSettlementInterval.String(), a.SettleCommissions); err != nil {
+		return err
+	}
+	if a.Deps.RateFeed != nil {
+		if err := s.Cron(ctx, jobFetchRates, "@every "+a.Config.RateFetchInterval.String(), a.FetchRates); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"github.com/isongjosiah/work/onepurse-api/types"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Journal entries for every money movement on the platform. Handlers build the entry for a movement and post it
+// through the LedgerDAL, which is the only place wallet balances are changed
+
+// transfer moves amount from one ledger account to another
+func transfer(from, to model.LedgerAccount, amount money.Amount) []model.Posting {
+	return []model.Posting{
+		{Account: from, Direction: model.Debit, Amount: amount},
+		{Account: to, Direction: model.Credit, Amount: amount},
+	}
+}
+
+// pendingOf returns the pending bucket of a wallet account
+func pendingOf(account model.LedgerAccount) model.LedgerAccount {
+	account.Bucket = model.LedgerBucketPending
+	return account
+}
+
+// holdEntry moves amount from the available balance of a wallet into its pending balance
+func holdEntry(transactionID, transactionType string, account model.LedgerAccount, amount money.Amount) *model.JournalEntry {
+	return &model.JournalEntry{
+		TransactionID:   transactionID,
+		TransactionType: transactionType,
+		Description:     fmt.Sprintf("hold %s %v for %s", account.Currency, amount, transactionType),
+		Postings:        transfer(account, pendingOf(account), amount),
+	}
+}
+
+// releaseEntry moves amount held in the pending balance of a wallet back to its available balance
+func releaseEntry(transactionID, transactionType string, account model.LedgerAccount, amount money.Amount) *model.JournalEntry {
+	return &model.JournalEntry{
+		TransactionID:   transactionID,
+		TransactionType: transactionType,
+		Description:     fmt.Sprintf("release %s %v held for %s", account.Currency, amount, transactionType),
+		Postings:        transfer(pendingOf(account), account, amount),
+	}
+}
+
+// feePostings pays a fee held in the pending balance of a wallet into the platform revenue account. There are none
+// when no fee was charged
+func feePostings(pending model.LedgerAccount, fee money.Amount) []model.Posting {
+	if fee <= money.Zero {
+		return nil
+	}
+	return transfer(pending, model.RevenueAccount(pending.Currency), fee)
+}
+
+func onePursePaymentEntry(t *model.OnePurseTransaction) *model.JournalEntry {
+	return &model.JournalEntry{
+		TransactionID:   t.ID,
+		TransactionType: types.ONE_PURSE_TRANSACTION,
+		Description:     fmt.Sprintf("%s paid %s %v to %s", t.FromUser.ID, t.Currency, t.Amount, t.ToUser.ID),
+		Postings: transfer(
+			model.UserWalletAccount(t.FromUser.ID, t.Currency, model.LedgerBucketAvailable),
+			model.UserWalletAccount(t.ToUser.ID, t.Currency, model.LedgerBucketAvailable),
+			t.Amount,
+		),
+	}
+}
+
+// transferCompletionEntry pays the user's held funds to the agent and the fee to the platform, and releases the
+// agent's hold
+func transferCompletionEntry(t *model.Transfer) *model.JournalEntry {
+	user := model.UserWalletAccount(t.UserID, t.BaseCurrency, model.LedgerBucketPending)
+	agent := model.AgentWalletAccount(t.AgentID, t.BaseCurrency, model.LedgerBucketAvailable)
+	postings := transfer(user, agent, t.BaseAmount)
+	postings = append(postings, feePostings(user, t.Fee)...)
+	postings = append(postings, transfer(pendingOf(agent), agent, t.BaseAmount)...)
+	return &model.JournalEntry{
+		TransactionID:   t.ID,
+		TransactionType: types.TRANSFER,
+		Description:     fmt.Sprintf("transfer of %s %v completed by agent %s", t.BaseCurrency, t.BaseAmount, t.AgentID),
+		Postings:        postings,
+	}
+}
+
+// transferCancellationEntry releases the user's held funds and fee and, when matched, the agent's held funds
+func transferCancellationEntry(t *model.Transfer) (*model.JournalEntry, error) {
+	held, err := t.BaseAmount.Add(t.Fee)
+	if err != nil {
+		return nil, err
+	}
+	user := model.UserWalletAccount(t.UserID, t.BaseCurrency, model.LedgerBucketAvailable)
+	postings := transfer(pendingOf(user), user, held)
+	if t.AgentID != "" {
+		agent := model.AgentWalletAccount(t.AgentID, t.BaseCurrency, model.LedgerBucketAvailable)
+		postings = append(postings, transfer(pendingOf(agent), agent, t.BaseAmount)...)
+	}
+	return &model.JournalEntry{
+		TransactionID:   t.ID,
+		TransactionType: types.TRANSFER,
+		Description:     fmt.Sprintf("transfer of %s %v cancelled", t.BaseCurrency, t.BaseAmount),
+		Postings:        postings,
+	}, nil
+}
+
+// exchangePeerAccount returns the wallet account of whoever the exchange was matched to
+func exchangePeerAccount(e *model.Exchange, currency string) model.LedgerAccount {
+	if e.MatchedUserID != "" {
+		return model.UserWalletAccount(e.MatchedUserID, currency, model.LedgerBucketAvailable)
+	}
+	return model.AgentWalletAccount(e.AgentID, currency, model.LedgerBucketAvailable)
+}
+
+// exchangeCompletionEntry swaps the user's held base currency for the peer's held exchange currency, and pays the fee
+// and, out of the peer's hold, the margin of the rate to the platform. An agent only holds the currency of their
+// wallet, so the platform's fx account buys the base currency of an exchange an agent fills and pays the agent back
+// what they held, the value of the base amount at the peer rate, in their own currency
+func exchangeCompletionEntry(e *model.Exchange) (*model.JournalEntry, error) {
+	user := model.UserWalletAccount(e.UserID, e.BaseCurrency, model.LedgerBucketPending)
+	peer := pendingOf(exchangePeerAccount(e, e.ExchangeCurrency))
+	base := model.PlatformAccount(model.LedgerFX, e.BaseCurrency)
+	if e.MatchedUserID != "" {
+		base = exchangePeerAccount(e, e.BaseCurrency)
+	}
+	postings := transfer(user, base, e.BaseAmount)
+	postings = append(postings, feePostings(user, e.Fee)...)
+	postings = append(postings, transfer(
+		peer,
+		model.UserWalletAccount(e.UserID, e.ExchangeCurrency, model.LedgerBucketAvailable),
+		e.ExchangeAmount,
+	)...)
+	postings = append(postings, feePostings(peer, e.Margin)...)
+	if e.MatchedUserID == "" {
+		held, err := e.ExchangeAmount.Add(e.Margin)
+		if err != nil {
+			return nil, err
+		}
+		postings = append(postings, transfer(model.PlatformAccount(model.LedgerFX, e.ExchangeCurrency), exchangePeerAccount(e, e.ExchangeCurrency), held)...)
+	}
+	return &model.JournalEntry{
+		TransactionID:   e.ID,
+		TransactionType: types.EXCHANGE,
+		Description:     fmt.Sprintf("exchange of %s %v for %s %v completed", e.BaseCurrency, e.BaseAmount, e.ExchangeCurrency, e.ExchangeAmount),
+		Postings:        postings,
+	}, nil
+}
+
+// exchangeCancellationEntry releases the user's held funds and fee and, when matched, the peer's held funds and margin
+func exchangeCancellationEntry(e *model.Exchange) (*model.JournalEntry, error) {
+	held, err := e.BaseAmount.Add(e.Fee)
+	if err != nil {
+		return nil, err
+	}
+	user := model.UserWalletAccount(e.UserID, e.BaseCurrency, model.LedgerBucketAvailable)
+	postings := transfer(pendingOf(user), user, held)
+	if e.AgentID != "" || e.MatchedUserID != "" {
+		peerHeld, err := e.ExchangeAmount.Add(e.Margin)
+		if err != nil {
+			return nil, err
+		}
+		peer := exchangePeerAccount(e, e.ExchangeCurrency)
+		postings = append(postings, transfer(pendingOf(peer), peer, peerHeld)...)
+	}
+	return &model.JournalEntry{
+		TransactionID:   e.ID,
+		TransactionType: types.EXCHANGE,
+		Description:     fmt.Sprintf("exchange of %s %v cancelled", e.BaseCurrency, e.BaseAmount),
+		Postings:        postings,
+	}, nil
+}
+
+// depositCompletionEntry credits the user with the amount the agent received off platform
+func depositCompletionEntry(d *model.Deposit) *model.JournalEntry {
+	return &model.JournalEntry{
+		TransactionID:   d.ID,
+		TransactionType: types.DEPOSIT,
+		Description:     fmt.Sprintf("deposit of %s %v through agent %s", d.BaseCurrency, d.BaseAmount, d.AgentID),
+		Postings: transfer(
+			model.AgentWalletAccount(d.AgentID, d.BaseCurrency, model.LedgerBucketAvailable),
+			model.UserWalletAccount(d.UserID, d.BaseCurrency, model.LedgerBucketAvailable),
+			d.BaseAmount,
+		),
+	}
+}
+
+// withdrawalCompletionEntry settles the user's held funds to their bank account and pays the fee to the platform
+func withdrawalCompletionEntry(w *model.Withdrawal) *model.JournalEntry {
+	user := model.UserWalletAccount(w.UserID, w.BaseCurrency, model.LedgerBucketPending)
+	postings := transfer(user, model.ExternalAccount(types.WITHDRAW, w.BaseCurrency), w.BaseAmount)
+	return &model.JournalEntry{
+		TransactionID:   w.ID,
+		TransactionType: types.WITHDRAW,
+		Description:     fmt.Sprintf("withdrawal of %s %v", w.BaseCurrency, w.BaseAmount),
+		Postings:        append(postings, feePostings(user, w.Fee)...),
+	}
+}
+
+func adminPaymentEntry(p *model.AdminPayment) *model.JournalEntry {
+	return &model.JournalEntry{
+		TransactionID:   p.ID,
+		TransactionType: types.ADMIN_PAYMENT,
+		Description:     fmt.Sprintf("%s payment of %s %v to %s", p.Category, p.BaseCurrency, p.BaseAmount, p.RecipientName),
+		Postings: transfer(
+			model.PlatformAccount("operating", p.BaseCurrency),
+			model.ExternalAccount(types.ADMIN_PAYMENT, p.BaseCurrency),
+			p.BaseAmount,
+		),
+	}
+}
+
+// commissionEntry charges the commission an agent earned on a transaction to the platform, owed to the agent until
+// it is settled
+func commissionEntry(c *model.Commission) *model.JournalEntry {
+	return &model.JournalEntry{
+		TransactionID:   c.TransactionID,
+		TransactionType: c.TransactionType,
+		Description:     fmt.Sprintf("commission of %s %v earned by agent %s", c.Currency, c.Amount, c.AgentID),
+		Postings: transfer(
+			model.PlatformAccount(model.LedgerCommissionExpense, c.Currency),
+			model.PlatformAccount(model.LedgerCommissionPayable, c.Currency),
+			c.Amount,
+		),
+	}
+}
+
+// settlementEntry pays the agents of an approved settlement batch the commissions owed to them into their wallets.
+// Settlement only batches commissions in the currency of the agent's wallet, and the ledger rejects any other
+func settlementEntry(b *model.SettlementBatch) *model.JournalEntry {
+	var postings []model.Posting
+	for _, payout := range b.Payouts {
+		postings = append(postings, transfer(
+			model.PlatformAccount(model.LedgerCommissionPayable, payout.Currency),
+			model.AgentWalletAccount(payout.AgentID, payout.Currency, model.LedgerBucketAvailable),
+			payout.Amount,
+		)...)
+	}
+	return &model.JournalEntry{
+		TransactionID:   b.ID,
+		TransactionType: types.COMMISSION_SETTLEMENT,
+		Description:     fmt.Sprintf("settlement of %d agent commission payouts", len(b.Payouts)),
+		Postings:        postings,
+	}
+}
+
+// postWithUpdate posts entry, when there is one, and applies update in the same mongo transaction so a transaction
+// record never changes state without its ledger entry. When ctx is already part of a mongo session, both run in it
+func (a *API) postWithUpdate(ctx context.Context, entry *model.JournalEntry, update func(ctx context.Context) error) error {
+	if entry == nil {
+		return update(ctx)
+	}
+	if mongo.SessionFromContext(ctx) != nil {
+		if err := a.Deps.DAL.LedgerDAL.Post(ctx, entry); err != nil {
+			return err
+		}
+		return update(ctx)
+	}
+
+	ses, err := a.Deps.DAL.Client.StartSession()
+	if err != nil {
+		return errors.Wrap(err, "unable to create a session")
+	}
+	defer ses.EndSession(ctx)
+
+	_, err = ses.WithTransaction(ctx, func(sesCtx mongo.SessionContext) (interface{}, error) {
+		if err := a.Deps.DAL.LedgerDAL.Post(sesCtx, entry); err != nil {
+			return nil, err
+		}
+		return nil, update(sesCtx)
+	})
+	return err
+}
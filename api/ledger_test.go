@@ -0,0 +1,89 @@
+package api
+
+import (
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"testing"
+)
+
+// balances returns the net credit of each account of an entry
+func balances(entry *model.JournalEntry) map[string]money.Amount {
+	net := make(map[string]money.Amount)
+	for _, p := range entry.Postings {
+		if p.Direction == model.Debit {
+			net[p.Account.Code()] -= p.Amount
+		} else {
+			net[p.Account.Code()] += p.Amount
+		}
+	}
+	return net
+}
+
+func TestExchangeCompletionEntry(t *testing.T) {
+	exchange := model.Exchange{
+		ID:               "exchange",
+		UserID:           "user",
+		BaseCurrency:     "NGN",
+		BaseAmount:       money.New(41525),
+		Fee:              money.New(100),
+		ExchangeCurrency: "USD",
+		ExchangeAmount:   money.New(99),
+		Margin:           money.New(1),
+	}
+	var (
+		userBase      = model.UserWalletAccount("user", "NGN", model.LedgerBucketPending).Code()
+		userPaid      = model.UserWalletAccount("user", "USD", model.LedgerBucketAvailable).Code()
+		baseRevenue   = model.RevenueAccount("NGN").Code()
+		marginRevenue = model.RevenueAccount("USD").Code()
+		fxBase        = model.PlatformAccount(model.LedgerFX, "NGN").Code()
+		fxPaid        = model.PlatformAccount(model.LedgerFX, "USD").Code()
+		agentHeld     = model.AgentWalletAccount("agent", "USD", model.LedgerBucketPending).Code()
+		agentWallet   = model.AgentWalletAccount("agent", "USD", model.LedgerBucketAvailable).Code()
+		peerBase      = model.UserWalletAccount("peer", "NGN", model.LedgerBucketAvailable).Code()
+		peerHeld      = model.UserWalletAccount("peer", "USD", model.LedgerBucketPending).Code()
+	)
+
+	tests := []struct {
+		name  string
+		agent string
+		peer  string
+		want  map[string]money.Amount
+	}{
+		{name: "agent", agent: "agent", want: map[string]money.Amount{
+			userBase:      money.New(-41625),
+			fxBase:        money.New(41525),
+			baseRevenue:   money.New(100),
+			agentHeld:     money.New(-100),
+			userPaid:      money.New(99),
+			marginRevenue: money.New(1),
+			fxPaid:        money.New(-100),
+			agentWallet:   money.New(100),
+		}},
+		{name: "peer", peer: "peer", want: map[string]money.Amount{
+			userBase:      money.New(-41625),
+			peerBase:      money.New(41525),
+			baseRevenue:   money.New(100),
+			peerHeld:      money.New(-100),
+			userPaid:      money.New(99),
+			marginRevenue: money.New(1),
+		}},
+	}
+	for _, tt := range tests {
+		e := exchange
+		e.AgentID, e.MatchedUserID = tt.agent, tt.peer
+		entry, err := exchangeCompletionEntry(&e)
+		if err != nil {
+			t.Errorf("%s: exchangeCompletionEntry() error = %v", tt.name, err)
+			continue
+		}
+		got := balances(entry)
+		for code, want := range tt.want {
+			if got[code] != want {
+				t.Errorf("%s: %s moved %s, want %s", tt.name, code, got[code], want)
+			}
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: entry moved %d accounts, want %d: %v", tt.name, len(got), len(tt.want), got)
+		}
+	}
+}
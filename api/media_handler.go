@@ -5,13 +5,14 @@ import (
 	"fmt"
 	"github.com/aws/smithy-go"
 	"github.com/go-chi/chi"
+	"github.com/isongjosiah/work/onepurse-api/services"
 	"github.com/isongjosiah/work/onepurse-api/tracing"
 	"net/http"
 )
 
 func (a *API) MediaRoutes() http.Handler {
 	router := chi.NewRouter()
-	router.Use(Authorization)
+	router.Use(a.Authorization)
 	router.Method("POST", "/upload_media", Handler(a.uploadMedia))
 	return router
 }
@@ -31,6 +32,9 @@ func (a *API) uploadMedia(w http.ResponseWriter, r *http.Request) *ServerRespons
 		path = fmt.Sprintf("%s/%s", folder, header.Filename)
 	}
 	location, s3err := a.Deps.AWS.S3.Upload(path, file)
+	if errors.Is(s3err, services.ErrAWSUnavailable) {
+		return RespondWithError(s3err, "File uploads are not available", http.StatusServiceUnavailable, &tracingContext)
+	}
 	if s3err != nil {
 		fmt.Println(s3err)
 		var ae smithy.APIError
@@ -2,12 +2,11 @@ package api
 
 import (
 	"context"
-	"fmt"
+	"github.com/isongjosiah/work/onepurse-api/auth"
 	"github.com/isongjosiah/work/onepurse-api/common"
 	"github.com/isongjosiah/work/onepurse-api/config"
 	"github.com/isongjosiah/work/onepurse-api/tracing"
-	"github.com/lestrrat-go/jwx/jwk"
-	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"net/http"
 	"strings"
@@ -15,6 +14,9 @@ import (
 
 const ContextKeyRequestSource = common.ContextKey("header-request-source")
 
+// ContextKeyToken holds the validated jwt.Token of the caller
+const ContextKeyToken = common.ContextKey("jwt-token")
+
 func RequestTracing(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -41,39 +43,33 @@ func RequestTracing(next http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
-func Authorization(next http.Handler) http.Handler {
-	cfg := config.New() //TODO: Find a way to skip this, makes no sense doing this twice, and probably expand on this middleware
-
+// Authorization verifies the bearer token of a request and puts it in the request context
+func (a *API) Authorization(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		url := fmt.Sprintf("https://cognito-idp.%s.amazonaws.com/%s/.well-known/jwks.json", cfg.AWSRegion, cfg.CognitoUserPoolID)
-		keySet, err := jwk.Fetch(context.Background(), url)
-		if err != nil {
-			logrus.Fatalf("failed to get JWKS from provided resource: %s", err.Error())
-			writeErrorResponse(w, http.StatusUnauthorized, "Not authorized")
-			return
-		}
-
-		if r.Header.Get("Authorization") == "" {
+		header := r.Header.Get("Authorization")
+		if len(header) < len("Bearer ") || !strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
 			writeErrorResponse(w, http.StatusUnauthorized, "Not authorized")
 			return
 		}
-
-		awsJwt := strings.Split(r.Header.Get("Authorization"), " ")[1]
+		awsJwt := strings.TrimSpace(header[len("Bearer "):])
 		if awsJwt == "" {
 			writeErrorResponse(w, http.StatusUnauthorized, "Not authorized")
 			return
 		}
-		_, err = jwt.ParseString(
-			awsJwt,
-			jwt.WithKeySet(keySet),
-			jwt.WithValidate(true),
-		)
+
+		token, err := a.Deps.Verifier.Verify(r.Context(), awsJwt)
 		if err != nil {
+			if errors.Is(err, auth.ErrKeysUnavailable) {
+				logrus.Errorf("unable to verify token: %s", err.Error())
+				writeErrorResponse(w, http.StatusServiceUnavailable, "Unable to verify credentials, please try again later")
+				return
+			}
 			logrus.Warnf("error parsing token %s", err.Error())
 			writeErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
 			return
 		}
-		next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), ContextKeyToken, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 
 	return http.HandlerFunc(fn)
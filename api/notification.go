@@ -0,0 +1,47 @@
+package api
+
+import (
+	"context"
+	"github.com/go-chi/chi"
+	"github.com/isongjosiah/work/onepurse-api/tracing"
+	"go.mongodb.org/mongo-driver/bson"
+	"net/http"
+)
+
+// respondWithNotifications fetches the page of notifications sent to ownerID requested by r, only read or unread ones
+// with read=true or read=false
+func (a *API) respondWithNotifications(r *http.Request, ownerID string) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	query := bson.D{{"user_id", ownerID}}
+	read, err := boolParam(r, "read")
+	if err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+	}
+	if read != nil {
+		query = append(query, bson.E{Key: "read", Value: *read})
+	}
+	req, err := pageRequest(r)
+	if err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+	}
+
+	notifications, page, err := a.Deps.DAL.NotificationDAL.FetchUserNotifications(context.TODO(), query, req)
+	if err != nil {
+		return respondWithPageError(err, "unable to fetch notifications", &tracingContext)
+	}
+	return &ServerResponse{
+		Payload:    notifications,
+		Pagination: page,
+		Message:    "notifications fetched successfully",
+	}
+}
+
+// getUserNotifications fetches the notifications sent to a user, newest first
+func (a *API) getUserNotifications(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	return a.respondWithNotifications(r, chi.URLParam(r, "userID"))
+}
+
+// getAgentNotifications fetches the notifications sent to an agent, newest first
+func (a *API) getAgentNotifications(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	return a.respondWithNotifications(r, chi.URLParam(r, "agentID"))
+}
@@ -0,0 +1,204 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"github.com/isongjosiah/work/onepurse-api/dal"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/tracing"
+	"github.com/lucsky/cuid"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"math"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const otpDigits = 6
+
+// HeaderOTP carries a one time code on requests that require one, such as withdrawals
+const HeaderOTP = "X-OTP"
+
+// otpPurposes lists the purposes codes can be sent for, with how each is described in the message sent with the code
+var otpPurposes = map[string]string{
+	model.OTPPurposeChangePin:   "changing your transaction pin",
+	model.OTPPurposeWithdraw:    "your withdrawal",
+	model.OTPPurposeNewDevice:   "adding a new device",
+	model.OTPPurposeAdminAction: "your admin action",
+}
+
+var (
+	errOTPNotFound = errors.New("no active otp. Please request a new one")
+	errOTPInvalid  = errors.New("invalid otp")
+	errOTPExpired  = errors.New("otp has expired. Please request a new one")
+	errOTPAttempts = errors.New("too many incorrect otp attempts. Please request a new one")
+)
+
+// otpThrottledError is returned when a code is requested too soon after the last one
+type otpThrottledError struct {
+	retryAfter time.Duration
+}
+
+func (e otpThrottledError) Error() string {
+	return fmt.Sprintf("too many otp requests. Please try again in %s", e.retryAfter.Round(time.Second))
+}
+
+// sendOTP creates a challenge for purpose and sends its code to destination over channel. Any earlier code for the
+// same purpose stops working
+func (a *API) sendOTP(ctx context.Context, ownerID, purpose, channel, destination string) (*model.OTPChallenge, error) {
+	description, ok := otpPurposes[purpose]
+	if !ok {
+		return nil, errors.Errorf("unknown otp purpose %s", purpose)
+	}
+	if err := a.throttleOTP(ctx, ownerID, purpose); err != nil {
+		return nil, err
+	}
+
+	code, err := newOTPCode()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to generate otp")
+	}
+	now := time.Now()
+	challenge := &model.OTPChallenge{
+		ID:          cuid.New(),
+		OwnerID:     ownerID,
+		Purpose:     purpose,
+		Channel:     channel,
+		Destination: destination,
+		MaxAttempts: a.Config.OTPMaxAttempts,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(a.Config.OTPExpiry),
+	}
+	challenge.CodeHash = a.hashOTPCode(challenge.ID, code)
+	if err := a.Deps.DAL.OTPDAL.Create(ctx, challenge); err != nil {
+		return nil, errors.Wrap(err, "unable to save otp")
+	}
+
+	minutes := int(math.Ceil(a.Config.OTPExpiry.Minutes()))
+	message := fmt.Sprintf("Your OnePurse code for %s is %s. It expires in %d minutes. Do not share it with anyone.", description, code, minutes)
+	switch channel {
+	case model.OTPChannelSMS:
+		err = a.Deps.TWILIO.SendMessage(destination, message)
+	case model.OTPChannelEmail:
+		err = a.Deps.EMAIL.SendEmail(destination, "Your OnePurse code", message)
+	default:
+		err = errors.Errorf("unknown otp channel %s", channel)
+	}
+	if err != nil {
+		// a code that never arrived should not be usable
+		if _, consumeErr := a.Deps.DAL.OTPDAL.Consume(ctx, challenge.ID); consumeErr != nil {
+			logrus.Errorf("[OTP]: unable to discard undelivered otp %s: %s", challenge.ID, consumeErr.Error())
+		}
+		return nil, errors.Wrap(err, "unable to send otp")
+	}
+	return challenge, nil
+}
+
+// throttleOTP refuses to send a code when one was sent for the same purpose within OTPResendInterval, or when
+// OTPMaxPerHour codes were sent in the last hour
+func (a *API) throttleOTP(ctx context.Context, ownerID, purpose string) error {
+	latest, err := a.Deps.DAL.OTPDAL.FindLatest(ctx, ownerID, purpose)
+	if err != nil && err != dal.ErrOTPNotFound {
+		return err
+	}
+	if latest != nil {
+		if wait := a.Config.OTPResendInterval - time.Since(latest.CreatedAt); wait > 0 {
+			return otpThrottledError{retryAfter: wait}
+		}
+	}
+
+	sent, err := a.Deps.DAL.OTPDAL.CountSince(ctx, ownerID, purpose, time.Now().Add(-time.Hour))
+	if err != nil {
+		return err
+	}
+	if sent >= int64(a.Config.OTPMaxPerHour) {
+		return otpThrottledError{retryAfter: time.Hour}
+	}
+	return nil
+}
+
+// verifyOTP checks code against the latest challenge sent to ownerID for purpose and uses the challenge up when the
+// code matches. Every code entered is claimed as an attempt on the challenge before it is compared, so no more than
+// MaxAttempts codes are ever checked, even by concurrent requests
+func (a *API) verifyOTP(ctx context.Context, ownerID, purpose, code string) error {
+	challenge, err := a.Deps.DAL.OTPDAL.FindLatest(ctx, ownerID, purpose)
+	if err != nil {
+		if err == dal.ErrOTPNotFound {
+			return errOTPNotFound
+		}
+		return err
+	}
+	if challenge.Consumed {
+		return errOTPNotFound
+	}
+	if time.Now().After(challenge.ExpiresAt) {
+		return errOTPExpired
+	}
+
+	challenge, err = a.Deps.DAL.OTPDAL.ClaimAttempt(ctx, challenge.ID)
+	switch err {
+	case nil:
+	case dal.ErrOTPNotFound:
+		return errOTPNotFound
+	case dal.ErrOTPAttempts:
+		return errOTPAttempts
+	default:
+		return err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(a.hashOTPCode(challenge.ID, code)), []byte(challenge.CodeHash)) != 1 {
+		if challenge.Attempts >= challenge.MaxAttempts {
+			return errOTPAttempts
+		}
+		return errOTPInvalid
+	}
+
+	consumed, err := a.Deps.DAL.OTPDAL.Consume(ctx, challenge.ID)
+	if err != nil {
+		return err
+	}
+	if !consumed {
+		return errOTPNotFound
+	}
+	return nil
+}
+
+// respondWithOTPError maps errors from sendOTP and verifyOTP to a response
+func respondWithOTPError(w http.ResponseWriter, err error, tracingContext *tracing.Context) *ServerResponse {
+	var throttled otpThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(throttled.retryAfter.Seconds()))))
+		return RespondWithError(err, throttled.Error(), http.StatusTooManyRequests, tracingContext)
+	case err == errOTPAttempts:
+		return RespondWithError(nil, err.Error(), http.StatusTooManyRequests, tracingContext)
+	case err == errOTPNotFound, err == errOTPInvalid, err == errOTPExpired:
+		return RespondWithError(nil, err.Error(), http.StatusBadRequest, tracingContext)
+	default:
+		return RespondWithError(err, "unable to process otp. Please try again", http.StatusInternalServerError, tracingContext)
+	}
+}
+
+// newOTPCode returns a random numeric code
+func newOTPCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(math.Pow10(otpDigits))))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", otpDigits, n.Int64()), nil
+}
+
+// hashOTPCode hashes a code with the ID of its challenge so equal codes on different challenges do not share a hash.
+// The hash is keyed with OTPSecret, as codes are short enough to be brute forced from an unkeyed hash
+func (a *API) hashOTPCode(challengeID, code string) string {
+	mac := hmac.New(sha256.New, []byte(a.Config.OTPSecret))
+	mac.Write([]byte(challengeID + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
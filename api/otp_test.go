@@ -0,0 +1,201 @@
+package api
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/config"
+	"github.com/isongjosiah/work/onepurse-api/dal"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/deps"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryOTPDAL keeps otp challenges in memory with the claim and consume rules of the mongo DAL
+type memoryOTPDAL struct {
+	mu         sync.Mutex
+	challenges []*model.OTPChallenge
+}
+
+func (m *memoryOTPDAL) Create(ctx context.Context, challenge *model.OTPChallenge) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range m.challenges {
+		if c.OwnerID == challenge.OwnerID && c.Purpose == challenge.Purpose {
+			c.Consumed = true
+		}
+	}
+	stored := *challenge
+	m.challenges = append(m.challenges, &stored)
+	return nil
+}
+
+func (m *memoryOTPDAL) FindLatest(ctx context.Context, ownerID, purpose string) (*model.OTPChallenge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.challenges) - 1; i >= 0; i-- {
+		if c := m.challenges[i]; c.OwnerID == ownerID && c.Purpose == purpose {
+			found := *c
+			return &found, nil
+		}
+	}
+	return nil, dal.ErrOTPNotFound
+}
+
+func (m *memoryOTPDAL) CountSince(ctx context.Context, ownerID, purpose string, since time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var count int64
+	for _, c := range m.challenges {
+		if c.OwnerID == ownerID && c.Purpose == purpose && !c.CreatedAt.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *memoryOTPDAL) find(ID string) *model.OTPChallenge {
+	for _, c := range m.challenges {
+		if c.ID == ID {
+			return c
+		}
+	}
+	return nil
+}
+
+func (m *memoryOTPDAL) ClaimAttempt(ctx context.Context, ID string) (*model.OTPChallenge, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := m.find(ID)
+	switch {
+	case c == nil || c.Consumed:
+		return nil, dal.ErrOTPNotFound
+	case c.Attempts >= c.MaxAttempts:
+		return nil, dal.ErrOTPAttempts
+	}
+	c.Attempts++
+	claimed := *c
+	return &claimed, nil
+}
+
+func (m *memoryOTPDAL) Consume(ctx context.Context, ID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := m.find(ID)
+	if c == nil || c.Consumed || c.Attempts > c.MaxAttempts {
+		return false, nil
+	}
+	c.Consumed = true
+	return true, nil
+}
+
+// recordingEmail keeps the last email it was asked to send
+type recordingEmail struct {
+	body string
+}
+
+func (e *recordingEmail) SendEmail(to, subject, body string) error {
+	e.body = body
+	return nil
+}
+
+var otpCode = regexp.MustCompile(`\b\d{6}\b`)
+
+func newOTPAPI(secret string) (*API, *memoryOTPDAL, *recordingEmail) {
+	otps, email := &memoryOTPDAL{}, &recordingEmail{}
+	a := &API{
+		Config: &config.Config{OTPSecret: secret, OTPExpiry: 5 * time.Minute, OTPMaxAttempts: 3, OTPMaxPerHour: 5},
+		Deps:   &deps.Dependencies{DAL: &dal.DAL{OTPDAL: otps}, EMAIL: email},
+	}
+	return a, otps, email
+}
+
+// sendTestOTP sends a code for purpose and returns it as the user would read it
+func sendTestOTP(t *testing.T, a *API, email *recordingEmail, purpose string) (*model.OTPChallenge, string) {
+	t.Helper()
+	challenge, err := a.sendOTP(context.Background(), "user", purpose, model.OTPChannelEmail, "user@onepurse.test")
+	if err != nil {
+		t.Fatalf("sendOTP(%s) error = %v", purpose, err)
+	}
+	code := otpCode.FindString(email.body)
+	if code == "" {
+		t.Fatalf("no code in %q", email.body)
+	}
+	return challenge, code
+}
+
+func TestVerifyOTP(t *testing.T) {
+	ctx := context.Background()
+	for _, purpose := range []string{model.OTPPurposeChangePin, model.OTPPurposeWithdraw, model.OTPPurposeNewDevice, model.OTPPurposeAdminAction} {
+		a, _, email := newOTPAPI("secret")
+		_, code := sendTestOTP(t, a, email, purpose)
+		if err := a.verifyOTP(ctx, "user", purpose, code); err != nil {
+			t.Errorf("verifyOTP(%s) of the sent code error = %v", purpose, err)
+		}
+		if err := a.verifyOTP(ctx, "user", purpose, code); err != errOTPNotFound {
+			t.Errorf("verifyOTP(%s) of a used code error = %v, want %v", purpose, err, errOTPNotFound)
+		}
+	}
+
+	a, _, email := newOTPAPI("secret")
+	_, code := sendTestOTP(t, a, email, model.OTPPurposeWithdraw)
+	if err := a.verifyOTP(ctx, "user", model.OTPPurposeNewDevice, code); err != errOTPNotFound {
+		t.Errorf("verifyOTP of a code sent for another purpose error = %v, want %v", err, errOTPNotFound)
+	}
+	if _, err := a.sendOTP(ctx, "user", "unknown", model.OTPChannelEmail, "user@onepurse.test"); err == nil {
+		t.Errorf("sendOTP of an unknown purpose succeeded")
+	}
+}
+
+func TestVerifyOTPAttempts(t *testing.T) {
+	ctx := context.Background()
+	a, _, email := newOTPAPI("secret")
+	_, code := sendTestOTP(t, a, email, model.OTPPurposeWithdraw)
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+
+	for attempt := 1; attempt < a.Config.OTPMaxAttempts; attempt++ {
+		if err := a.verifyOTP(ctx, "user", model.OTPPurposeWithdraw, wrong); err != errOTPInvalid {
+			t.Fatalf("wrong code %d error = %v, want %v", attempt, err, errOTPInvalid)
+		}
+	}
+	// the last attempt a challenge allows can still use the right code
+	if err := a.verifyOTP(ctx, "user", model.OTPPurposeWithdraw, code); err != nil {
+		t.Errorf("right code on the last attempt error = %v", err)
+	}
+
+	a, _, email = newOTPAPI("secret")
+	_, code = sendTestOTP(t, a, email, model.OTPPurposeWithdraw)
+	for attempt := 1; attempt <= a.Config.OTPMaxAttempts; attempt++ {
+		err := a.verifyOTP(ctx, "user", model.OTPPurposeWithdraw, wrong)
+		if attempt == a.Config.OTPMaxAttempts && err != errOTPAttempts {
+			t.Errorf("last wrong code error = %v, want %v", err, errOTPAttempts)
+		}
+	}
+	if err := a.verifyOTP(ctx, "user", model.OTPPurposeWithdraw, code); err != errOTPAttempts {
+		t.Errorf("right code after the attempts ran out error = %v, want %v", err, errOTPAttempts)
+	}
+}
+
+func TestVerifyOTPExpired(t *testing.T) {
+	a, otps, email := newOTPAPI("secret")
+	challenge, code := sendTestOTP(t, a, email, model.OTPPurposeChangePin)
+	otps.find(challenge.ID).ExpiresAt = time.Now().Add(-time.Second)
+	if err := a.verifyOTP(context.Background(), "user", model.OTPPurposeChangePin, code); err != errOTPExpired {
+		t.Errorf("verifyOTP of an expired code error = %v, want %v", err, errOTPExpired)
+	}
+}
+
+func TestHashOTPCodeIsKeyed(t *testing.T) {
+	a, _, _ := newOTPAPI("secret")
+	other, _, _ := newOTPAPI("other secret")
+	if a.hashOTPCode("challenge", "123456") == other.hashOTPCode("challenge", "123456") {
+		t.Errorf("codes hash the same under different secrets")
+	}
+	if a.hashOTPCode("challenge", "123456") == a.hashOTPCode("other challenge", "123456") {
+		t.Errorf("a code hashes the same on different challenges")
+	}
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"github.com/isongjosiah/work/onepurse-api/dal"
+	"github.com/isongjosiah/work/onepurse-api/tracing"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+)
+
+// pageRequest reads the page a list request asks for: limit, the cursor returned with the previous page, sort and
+// whether to count the total with count=true
+func pageRequest(r *http.Request) (dal.PageRequest, error) {
+	params := r.URL.Query()
+	req := dal.PageRequest{
+		Cursor:     params.Get("cursor"),
+		Sort:       params.Get("sort"),
+		CountTotal: params.Get("count") == "true",
+	}
+	if value := params.Get("limit"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n <= 0 {
+			return req, errors.New("limit must be a positive number")
+		}
+		req.Limit = n
+	}
+	return req, nil
+}
+
+// respondWithPageError responds to an error fetching a page, with a bad request when the page request was invalid
+func respondWithPageError(err error, message string, tracingContext *tracing.Context) *ServerResponse {
+	if dal.IsInvalidPage(err) {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, tracingContext)
+	}
+	return RespondWithError(err, message, http.StatusInternalServerError, tracingContext)
+}
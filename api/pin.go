@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/isongjosiah/work/onepurse-api/dal"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/helpers"
+	"github.com/isongjosiah/work/onepurse-api/tracing"
+	"github.com/isongjosiah/work/onepurse-api/types"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"net/http"
+	"time"
+)
+
+// HeaderTransactionPin carries the user's transaction pin on requests that move money out of a wallet
+const HeaderTransactionPin = "X-Transaction-PIN"
+
+// checkTransactionPin verifies the transaction pin sent with a debit. Every attempt is claimed against the user before
+// the pin is compared, and debits are locked for PinLockout once PinMaxAttempts wrong pins are entered. It returns nil
+// when the pin is correct
+func (a *API) checkTransactionPin(r *http.Request, user *model.User) *ServerResponse {
+	ctx := r.Context()
+	tracingContext := ctx.Value(tracing.ContextKeyTracing).(tracing.Context)
+
+	if user.TransactionPassword == "" {
+		return RespondWithError(nil, "create a transaction pin before making this transaction", http.StatusForbidden, &tracingContext)
+	}
+	pin := r.Header.Get(HeaderTransactionPin)
+	if pin == "" {
+		return RespondWithError(nil, "transaction pin is required", http.StatusBadRequest, &tracingContext)
+	}
+
+	// the attempt is claimed first so parallel requests cannot each compare a pin before any failure is counted
+	updated, err := a.Deps.DAL.UserDAL.ClaimPinAttempt(ctx, user.ID, a.Config.PinMaxAttempts, time.Now())
+	if err == dal.ErrPinLocked {
+		message := "transaction pin is locked, please try again later"
+		if current, err := a.Deps.DAL.UserDAL.FindByID(ctx, user.ID); err == nil && time.Now().Before(current.PinLockedUntil) {
+			message = fmt.Sprintf("transaction pin is locked until %s", current.PinLockedUntil.Format(time.RFC3339))
+		}
+		return RespondWithError(err, message, http.StatusLocked, &tracingContext)
+	}
+	if err != nil {
+		return RespondWithError(err, "unable to verify transaction pin. Please try again", http.StatusInternalServerError, &tracingContext)
+	}
+
+	if helpers.CheckPasswordHash(pin, updated.TransactionPassword) {
+		if err := a.resetPinAttempts(ctx, user.ID); err != nil {
+			logrus.Errorf("[PIN]: unable to reset pin attempts of user %s: %s", user.ID, err.Error())
+		}
+		return nil
+	}
+
+	remaining := a.Config.PinMaxAttempts - updated.PinAttempts
+	if remaining > 0 {
+		message := fmt.Sprintf("incorrect transaction pin. %d attempts remaining", remaining)
+		return RespondWithError(nil, message, http.StatusForbidden, &tracingContext)
+	}
+
+	lockedUntil := time.Now().Add(a.Config.PinLockout)
+	err = a.Deps.DAL.UserDAL.UpdateUser(ctx, user.ID, bson.D{{"$set", bson.D{{"pin_attempts", 0}, {"pin_locked_until", lockedUntil}}}})
+	if err != nil {
+		return RespondWithError(err, "unable to verify transaction pin. Please try again", http.StatusInternalServerError, &tracingContext)
+	}
+	logrus.Warnf("[PIN]: transaction pin of user %s locked until %s", user.ID, lockedUntil.Format(time.RFC3339))
+
+	message := fmt.Sprintf("Your transaction pin was entered incorrectly %d times. Transactions from your wallet are paused until %s", a.Config.PinMaxAttempts, lockedUntil.Format(time.RFC1123))
+	if err := a.CreateNotification(ctx, user.ID, types.TRANSACTION_PIN_LOCKED, message, types.TRANSACTION_PIN, user.DeviceToken, nil); err != nil {
+		logrus.Errorf("[PIN]: unable to notify user %s of pin lockout: %s", user.ID, err.Error())
+	}
+
+	return RespondWithError(nil, "incorrect transaction pin. Transactions are locked, please try again later", http.StatusLocked, &tracingContext)
+}
+
+// resetPinAttempts clears the wrong pin count and any lockout on the user
+func (a *API) resetPinAttempts(ctx context.Context, userID string) error {
+	return a.Deps.DAL.UserDAL.UpdateUser(ctx, userID, bson.D{{"$set", bson.D{{"pin_attempts", 0}, {"pin_locked_until", time.Time{}}}}})
+}
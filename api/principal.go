@@ -0,0 +1,217 @@
+package api
+
+import (
+	"context"
+	"github.com/go-chi/chi"
+	"github.com/isongjosiah/work/onepurse-api/common"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"net/http"
+)
+
+// ContextKeyPrincipal holds the *Principal making the request
+const ContextKeyPrincipal = common.ContextKey("principal")
+
+// Principal kinds, which are also the names of the cognito groups that mark an account as that kind
+const (
+	PrincipalUser  = "user"
+	PrincipalAgent = "agent"
+	PrincipalAdmin = "admin"
+)
+
+// Principal is the caller of a request as identified by their token
+type Principal struct {
+	Subject  string      // cognito sub
+	Username string      // cognito username, which is the email for accounts that sign up
+	Email    string      // only present in id tokens
+	Groups   []string    // cognito groups
+	Kind     string      // user, agent or admin
+	ID       string      // _id of the user, agent or admin record of the caller
+	Role     *model.Role // role currently assigned to an admin
+}
+
+// String identifies the principal in transaction histories and logs
+func (p *Principal) String() string {
+	return p.Kind + ":" + p.ID
+}
+
+// Owns reports whether the principal is the user or agent with one of ids. Admins never own a resource
+func (p *Principal) Owns(ids ...string) bool {
+	if p == nil || p.Kind == PrincipalAdmin {
+		return false
+	}
+	for _, id := range ids {
+		if id != "" && id == p.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccess reports whether the principal owns one of ids or is an admin whose role grants access
+func (p *Principal) CanAccess(access string, ids ...string) bool {
+	if p == nil {
+		return false
+	}
+	if p.Kind == PrincipalAdmin {
+		return p.Role.HasAccess(access)
+	}
+	return p.Owns(ids...)
+}
+
+// principalOf returns the principal of a request that went through Authenticate
+func principalOf(r *http.Request) *Principal {
+	p, _ := r.Context().Value(ContextKeyPrincipal).(*Principal)
+	return p
+}
+
+func stringClaim(token jwt.Token, name string) string {
+	value, ok := token.Get(name)
+	if !ok {
+		return ""
+	}
+	s, _ := value.(string)
+	return s
+}
+
+// principalFromToken reads the identity claims of a cognito access or id token
+func principalFromToken(token jwt.Token) *Principal {
+	p := &Principal{
+		Subject:  token.Subject(),
+		Username: stringClaim(token, "username"),
+		Email:    stringClaim(token, "email"),
+	}
+	if p.Username == "" {
+		p.Username = stringClaim(token, "cognito:username")
+	}
+	if groups, ok := token.Get("cognito:groups"); ok {
+		if list, ok := groups.([]interface{}); ok {
+			for _, group := range list {
+				if name, ok := group.(string); ok {
+					p.Groups = append(p.Groups, name)
+				}
+			}
+		}
+	}
+	for _, group := range p.Groups {
+		switch group {
+		case PrincipalUser, PrincipalAgent, PrincipalAdmin:
+			p.Kind = group
+		}
+	}
+	return p
+}
+
+// Authenticate puts the Principal of the caller in the request context. It must run after Authorization
+func (a *API) Authenticate(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		token, ok := r.Context().Value(ContextKeyToken).(jwt.Token)
+		if !ok {
+			writeErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		p := principalFromToken(token)
+		if p.Username == "" && p.Email == "" {
+			writeErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		if err := a.resolvePrincipal(r.Context(), p); err != nil {
+			logrus.Warnf("unable to resolve the account of %s: %s", p.Username, err.Error())
+			writeErrorResponse(w, http.StatusForbidden, "Forbidden")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), ContextKeyPrincipal, p)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+	return http.HandlerFunc(fn)
+}
+
+// resolvePrincipal finds the record of the principal. Accounts without a kind group are looked up as a user, then an
+// agent, then an admin
+func (a *API) resolvePrincipal(ctx context.Context, p *Principal) error {
+	names := []string{p.Username}
+	if p.Email != "" && p.Email != p.Username {
+		names = append(names, p.Email)
+	}
+	query := bson.D{{"$or", []bson.M{{"username": bson.M{"$in": names}}, {"email": bson.M{"$in": names}}}}}
+
+	kinds := []string{PrincipalUser, PrincipalAgent, PrincipalAdmin}
+	if p.Kind != "" {
+		kinds = []string{p.Kind}
+	}
+
+	var err error
+	for _, kind := range kinds {
+		switch kind {
+		case PrincipalUser:
+			var user *model.User
+			if user, err = a.Deps.DAL.UserDAL.FindOne(ctx, query); err == nil {
+				p.Kind, p.ID = kind, user.ID
+				return nil
+			}
+		case PrincipalAgent:
+			var agent *model.Agent
+			if agent, err = a.Deps.DAL.AgentDAL.FindOne(ctx, query); err == nil {
+				p.Kind, p.ID = kind, agent.ID
+				return nil
+			}
+		case PrincipalAdmin:
+			var admin *model.Admin
+			if admin, err = a.Deps.DAL.AdminDAL.FindAdmin(ctx, query); err == nil {
+				p.Kind, p.ID = kind, admin.ID
+				p.Role = a.adminRole(ctx, admin)
+				return nil
+			}
+		}
+	}
+	return err
+}
+
+// adminRole loads the role currently assigned to admin
+func (a *API) adminRole(ctx context.Context, admin *model.Admin) *model.Role {
+	// admins created before roles were stored separately only carry a copy of their role
+	roleID := admin.RoleID
+	if roleID == "" && admin.Role != nil {
+		roleID = admin.Role.Slug
+	}
+	if roleID == "" {
+		return nil
+	}
+	role, err := a.Deps.DAL.AdminDAL.FindRole(ctx, roleID)
+	if err != nil {
+		logrus.Warnf("unable to load role %s of admin %s: %s", roleID, admin.ID, err.Error())
+	}
+	return role
+}
+
+// OwnerOnly only lets the user or agent named by the param URL parameter through
+func OwnerOnly(param string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if !principalOf(r).Owns(chi.URLParam(r, param)) {
+				writeErrorResponse(w, http.StatusForbidden, "Forbidden")
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// OwnerOrAccess lets the user or agent named by the param URL parameter through, as well as admins whose role grants
+// access
+func OwnerOrAccess(param, access string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if !principalOf(r).CanAccess(access, chi.URLParam(r, param)) {
+				writeErrorResponse(w, http.StatusForbidden, "Forbidden")
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
@@ -0,0 +1,401 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-chi/chi"
+	"github.com/isongjosiah/work/onepurse-api/dal"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"github.com/isongjosiah/work/onepurse-api/tracing"
+	"github.com/isongjosiah/work/onepurse-api/types"
+	"github.com/lucsky/cuid"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// QuoteRoutes lets users lock the price of a conversion before creating the transfer or exchange that uses it
+func (a *API) QuoteRoutes() http.Handler {
+	router := chi.NewRouter()
+	router.Use(a.Authorization)
+	router.Use(a.Authenticate)
+	router.Method("POST", "/", Handler(a.createQuote))
+	return router
+}
+
+// rateFor returns the version of the rate in effect at a time that prices converting from one currency to another,
+// whichever way round the pair was set
+func (a *API) rateFor(ctx context.Context, from, to string, at time.Time) (*model.ExchangeRate, error) {
+	rate, err := a.Deps.DAL.RateDAL.Current(ctx, from, to, at)
+	if err != dal.ErrRateNotFound {
+		return rate, err
+	}
+	return a.Deps.DAL.RateDAL.Current(ctx, to, from, at)
+}
+
+// createQuote prices converting an amount of one currency to another at the rate in effect, with the fee of the
+// schedule for the transaction type, and locks the price and fee for the user for QUOTE_TTL
+func (a *API) createQuote(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	principal := principalOf(r)
+	if principal == nil || principal.Kind != PrincipalUser {
+		return RespondWithError(nil, "only users can request quotes", http.StatusForbidden, &tracingContext)
+	}
+
+	var request model.QuoteRequest
+	if err := decodeJSONBody(&tracingContext, r.Body, &request); err != nil {
+		return RespondWithError(err, "failed to decode request body", http.StatusBadRequest, &tracingContext)
+	}
+	if !feeTransactionTypes[request.TransactionType] {
+		return RespondWithError(nil, "transaction type must be transfer, exchange or withdraw", http.StatusBadRequest, &tracingContext)
+	}
+	if request.TransactionType == types.WITHDRAW {
+		if request.ToCurrency != "" && !strings.EqualFold(request.ToCurrency, request.FromCurrency) {
+			return RespondWithError(nil, "withdrawals are not converted, leave to_currency empty", http.StatusBadRequest, &tracingContext)
+		}
+		request.ToCurrency = request.FromCurrency
+	}
+	fromCurrency, resp := a.transactionCurrency(request.FromCurrency, request.Amount, &tracingContext)
+	if resp != nil {
+		return resp
+	}
+	toCurrency, resp := a.currencyOf(request.ToCurrency, false, &tracingContext)
+	if resp != nil {
+		return resp
+	}
+	from, to := fromCurrency.Code, toCurrency.Code
+
+	now := time.Now()
+	quote := model.Quote{
+		ID:              cuid.New(),
+		UserID:          principal.ID,
+		TransactionType: request.TransactionType,
+		FromCurrency:    from,
+		FromAmount:      request.Amount,
+		ToCurrency:      to,
+		ToAmount:        request.Amount,
+		Rate:            money.MustParseRate("1"),
+		MidRate:         money.MustParseRate("1"),
+		ExpiresAt:       now.Add(a.Config.QuoteTTL),
+		CreatedAt:       now,
+	}
+	if from != to {
+		rate, err := a.rateFor(context.TODO(), from, to, now)
+		if err == dal.ErrRateNotFound {
+			return RespondWithError(err, err.Error(), http.StatusUnprocessableEntity, &tracingContext)
+		}
+		if err != nil {
+			return RespondWithError(err, "unable to fetch exchange rate", http.StatusInternalServerError, &tracingContext)
+		}
+		quote.Rate, quote.MidRate, err = rate.Convert(from, to)
+		if err != nil {
+			return RespondWithError(err, "unable to price conversion", http.StatusInternalServerError, &tracingContext)
+		}
+		quote.RateID = rate.ID
+		quote.ToAmount, err = quote.Rate.Convert(request.Amount, to)
+		if err != nil {
+			return RespondWithError(err, "amount is too large to convert", http.StatusBadRequest, &tracingContext)
+		}
+		if quote.ToAmount <= money.Zero {
+			return RespondWithError(nil, "amount is too small to convert", http.StatusBadRequest, &tracingContext)
+		}
+		if quote.TransactionType == types.EXCHANGE {
+			peerRate, err := rate.PeerRate(from, to)
+			if err != nil {
+				return RespondWithError(err, "unable to price conversion", http.StatusInternalServerError, &tracingContext)
+			}
+			peerAmount, err := peerRate.Convert(request.Amount, to)
+			if err == nil {
+				quote.Margin, err = peerAmount.Sub(quote.ToAmount)
+			}
+			if err != nil {
+				return RespondWithError(err, "amount is too large to convert", http.StatusBadRequest, &tracingContext)
+			}
+		}
+	}
+
+	fee, ruleID, err := a.feeOf(context.TODO(), quote.TransactionType, from, to, quote.FromAmount)
+	if err != nil {
+		return RespondWithError(err, "unable to price fee", http.StatusInternalServerError, &tracingContext)
+	}
+	total, err := quote.FromAmount.Add(fee)
+	if err != nil {
+		return RespondWithError(err, "amount is too large to charge", http.StatusBadRequest, &tracingContext)
+	}
+	quote.Fee, quote.FeeRuleID, quote.Total = fee, ruleID, total
+
+	if err := a.Deps.DAL.QuoteDAL.Create(context.TODO(), &quote); err != nil {
+		return RespondWithError(err, "unable to create quote", http.StatusInternalServerError, &tracingContext)
+	}
+	return &ServerResponse{
+		Payload:    quote,
+		Message:    "quote created successfully",
+		StatusCode: http.StatusCreated,
+	}
+}
+
+// quoteOf returns the quote a user asked to create a transaction of a type with, or the response refusing it. The
+// quote is only used once QuoteDAL.Use succeeds
+func (a *API) quoteOf(quoteID, userID, transactionType string, tracingContext *tracing.Context) (*model.Quote, *ServerResponse) {
+	if quoteID == "" {
+		return nil, RespondWithError(nil, "quote_id is required, request a quote first", http.StatusBadRequest, tracingContext)
+	}
+	quote, err := a.Deps.DAL.QuoteDAL.Find(context.TODO(), quoteID, userID)
+	if err != nil {
+		return nil, respondWithQuoteError(err, tracingContext)
+	}
+	if quote.TransactionType != transactionType {
+		message := fmt.Sprintf("quote was given for a %s, request a quote for a %s", quote.TransactionType, transactionType)
+		return nil, RespondWithError(nil, message, http.StatusBadRequest, tracingContext)
+	}
+	if quote.UsedAt != nil {
+		return nil, respondWithQuoteError(dal.ErrQuoteUsed, tracingContext)
+	}
+	if !time.Now().Before(quote.ExpiresAt) {
+		return nil, respondWithQuoteError(dal.ErrQuoteExpired, tracingContext)
+	}
+	return quote, nil
+}
+
+// respondWithQuoteError refuses a transaction whose quote cannot be used
+func respondWithQuoteError(err error, tracingContext *tracing.Context) *ServerResponse {
+	switch errors.Cause(err) {
+	case dal.ErrQuoteNotFound:
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, tracingContext)
+	case dal.ErrQuoteExpired, dal.ErrQuoteUsed:
+		return RespondWithError(err, err.Error(), http.StatusConflict, tracingContext)
+	}
+	return RespondWithError(err, "unable to fetch quote", http.StatusInternalServerError, tracingContext)
+}
+
+// isQuoteError reports whether err is a quote that could not be used
+func isQuoteError(err error) bool {
+	switch errors.Cause(err) {
+	case dal.ErrQuoteNotFound, dal.ErrQuoteExpired, dal.ErrQuoteUsed:
+		return true
+	}
+	return false
+}
+
+// updateExchangeRate allows an authorized admin set a new version of the rate of a currency pair, taking effect now
+// or at effective_at
+func (a *API) updateExchangeRate(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	var rate model.ExchangeRate
+	if err := decodeJSONBody(&tracingContext, r.Body, &rate); err != nil {
+		return RespondWithError(err, "failed to decode request body", http.StatusBadRequest, &tracingContext)
+	}
+	// disabled currencies can still be priced, for the transactions already in them
+	for _, code := range []*string{&rate.Base, &rate.Quote} {
+		currency, resp := a.currencyOf(*code, true, &tracingContext)
+		if resp != nil {
+			return resp
+		}
+		*code = currency.Code
+	}
+	if err := rate.Validate(); err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+	}
+
+	now := time.Now()
+	if rate.EffectiveAt.IsZero() {
+		rate.EffectiveAt = now
+	}
+	if rate.EffectiveAt.Before(now.Add(-time.Minute)) {
+		return RespondWithError(nil, "effective_at cannot be in the past", http.StatusBadRequest, &tracingContext)
+	}
+	rate.Source = principalOf(r).String()
+	rate.CreatedAt = now
+
+	previous, err := a.Deps.DAL.RateDAL.Current(context.TODO(), rate.Base, rate.Quote, now)
+	if err != nil && err != dal.ErrRateNotFound {
+		return RespondWithError(err, "unable to fetch exchange rate", http.StatusInternalServerError, &tracingContext)
+	}
+	if err := a.Deps.DAL.RateDAL.Add(context.TODO(), &rate); err != nil {
+		return RespondWithError(err, "unable to update exchange rate", http.StatusInternalServerError, &tracingContext)
+	}
+	var before interface{}
+	if previous != nil {
+		before = previous
+	}
+	auditChange(r, "exchange_rate:"+rate.Pair(), before, rate)
+	return &ServerResponse{
+		Payload: rate,
+		Message: "exchange rate updated successfully",
+	}
+}
+
+// fetchExchangeRates allows an authorized admin fetch the rate of every currency pair in effect now
+func (a *API) fetchExchangeRates(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	rates, err := a.Deps.DAL.RateDAL.FetchCurrent(context.TODO(), time.Now())
+	if err != nil {
+		return RespondWithError(err, "unable to fetch exchange rates", http.StatusInternalServerError, &tracingContext)
+	}
+	return &ServerResponse{
+		Payload: rates,
+	}
+}
+
+// fetchExchangeRateHistory allows an authorized admin page through the versions of the rates, optionally of a
+// single pair with base and quote, including versions that have not taken effect yet
+func (a *API) fetchExchangeRateHistory(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	query := bson.D{}
+	for _, param := range []string{"base", "quote"} {
+		if value := r.URL.Query().Get(param); value != "" {
+			query = append(query, bson.E{Key: param, Value: strings.ToUpper(value)})
+		}
+	}
+	req, err := pageRequest(r)
+	if err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+	}
+
+	rates, page, err := a.Deps.DAL.RateDAL.FetchHistory(context.TODO(), query, req)
+	if err != nil {
+		return respondWithPageError(err, "unable to fetch exchange rate history", &tracingContext)
+	}
+	return &ServerResponse{
+		Payload:    rates,
+		Pagination: page,
+	}
+}
+
+// FetchRates adds the latest rates of the rate provider, leaving outliers for an admin to approve
+func (a *API) FetchRates(ctx context.Context, job *model.Job) error {
+	result, err := a.Deps.RateFeed.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("[Rates]: fetched from %s: %d applied, %d proposed, %d unchanged, %d invalid",
+		a.Deps.RateFeed.Provider(), result.Applied, result.Proposed, result.Unchanged, result.Invalid)
+	return nil
+}
+
+// fetchProviderRates allows an authorized admin fetch the rates of the rate provider now instead of waiting for the
+// next scheduled fetch
+func (a *API) fetchProviderRates(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	if a.Deps.RateFeed == nil {
+		return RespondWithError(nil, "no rate provider is configured", http.StatusConflict, &tracingContext)
+	}
+	result, err := a.Deps.RateFeed.Fetch(r.Context())
+	if err != nil {
+		return RespondWithError(err, "unable to fetch rates from the rate provider", http.StatusBadGateway, &tracingContext)
+	}
+	return &ServerResponse{
+		Payload: result,
+		Message: "rates fetched from " + a.Deps.RateFeed.Provider(),
+	}
+}
+
+// fetchRateProposals allows an authorized admin page through the fetched rates waiting for approval, or with status
+// the proposals reviewed or superseded
+func (a *API) fetchRateProposals(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = model.RateProposalPending
+	}
+	req, err := pageRequest(r)
+	if err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+	}
+
+	proposals, page, err := a.Deps.DAL.RateProposalDAL.Fetch(context.TODO(), bson.D{{"status", status}}, req)
+	if err != nil {
+		return respondWithPageError(err, "unable to fetch rate proposals", &tracingContext)
+	}
+	return &ServerResponse{
+		Payload:    proposals,
+		Pagination: page,
+	}
+}
+
+// reviewRateProposal allows an authorized admin approve a fetched rate, adding it as the rate in effect, or reject
+// it. The spreads and margin of the rate in effect when approved carry over
+func (a *API) reviewRateProposal(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	proposalID := chi.URLParam(r, "proposalID")
+	action := r.URL.Query().Get("action")
+	var review struct {
+		Note string `json:"note"`
+	}
+	if r.ContentLength > 0 {
+		if err := decodeJSONBody(&tracingContext, r.Body, &review); err != nil {
+			return RespondWithError(err, "failed to decode request body", http.StatusBadRequest, &tracingContext)
+		}
+	}
+
+	var status string
+	switch action {
+	case types.APPROVE:
+		status = model.RateProposalApproved
+	case types.REJECT:
+		status = model.RateProposalRejected
+	default:
+		return RespondWithError(nil, "action must be approve or reject", http.StatusBadRequest, &tracingContext)
+	}
+
+	ctx := context.Background()
+	ses, err := a.Deps.DAL.Client.StartSession()
+	if err != nil {
+		logrus.Errorf("[Mongo]: unable to create a session: %s", err.Error())
+		return RespondWithError(err, "Something went wrong. Please Try again", http.StatusInternalServerError, &tracingContext)
+	}
+	defer ses.EndSession(ctx)
+
+	now := time.Now()
+	var previous *model.ExchangeRate
+	result, err := ses.WithTransaction(ctx, func(sesCtx mongo.SessionContext) (interface{}, error) {
+		proposal, err := a.Deps.DAL.RateProposalDAL.Review(sesCtx, proposalID, status, principalOf(r).String(), review.Note, now)
+		if err != nil || status == model.RateProposalRejected {
+			return proposal, err
+		}
+
+		rate := proposal.Rate
+		previous, err = a.Deps.DAL.RateDAL.Current(sesCtx, rate.Base, rate.Quote, now)
+		if err != nil && err != dal.ErrRateNotFound {
+			return nil, err
+		}
+		if previous != nil {
+			rate.BuySpread, rate.SellSpread, rate.Margin = previous.BuySpread, previous.SellSpread, previous.Margin
+		}
+		rate.EffectiveAt, rate.CreatedAt = now, now
+		if err := a.Deps.DAL.RateDAL.Add(sesCtx, &rate); err != nil {
+			return nil, err
+		}
+		proposal.Rate = rate
+		return proposal, nil
+	})
+	switch err {
+	case nil:
+	case dal.ErrRateProposalNotFound:
+		return RespondWithError(err, err.Error(), http.StatusNotFound, &tracingContext)
+	case dal.ErrRateProposalReviewed:
+		return RespondWithError(err, err.Error(), http.StatusConflict, &tracingContext)
+	default:
+		return RespondWithError(err, "unable to review rate proposal", http.StatusInternalServerError, &tracingContext)
+	}
+
+	proposal := result.(*model.RateProposal)
+	if status == model.RateProposalApproved {
+		var before interface{}
+		if previous != nil {
+			before = previous
+		}
+		auditChange(r, "exchange_rate:"+proposal.Rate.Pair(), before, proposal.Rate)
+	} else {
+		auditChange(r, "rate_proposal:"+proposal.ID, map[string]string{"status": model.RateProposalPending}, map[string]string{"status": status})
+	}
+	return &ServerResponse{
+		Payload: proposal,
+		Message: "rate proposal " + status,
+	}
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/http"
+)
+
+// AdminOnly only lets admins through. It must run after Authenticate
+func AdminOnly(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if p := principalOf(r); p == nil || p.Kind != PrincipalAdmin {
+			writeErrorResponse(w, http.StatusForbidden, "Forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(fn)
+}
+
+// RequireAccess only lets admins whose role grants access through. It must run after Authenticate
+func RequireAccess(access string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if p := principalOf(r); p == nil || p.Kind != PrincipalAdmin || !p.Role.HasAccess(access) {
+				writeErrorResponse(w, http.StatusForbidden, "You do not have the "+access+" access")
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
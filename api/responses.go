@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/isongjosiah/work/onepurse-api/common"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
 	"github.com/isongjosiah/work/onepurse-api/tracing"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -24,6 +25,7 @@ type ServerResponse struct {
 	Context     context.Context    `json:"context"`
 	ContentType common.ContentType `json:"content_type"`
 	Payload     interface{}        `json:"payload"`
+	Pagination  *model.Page        `json:"pagination"` // set by list endpoints
 }
 
 type ErrorResponse struct {
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"github.com/go-chi/chi"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/tracing"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"net/http"
+	"strings"
+)
+
+// roleRequest is the body for creating and updating roles. Access holds access names e.g. rates
+type roleRequest struct {
+	Name   string   `json:"name"`
+	Slug   string   `json:"slug"`
+	Access []string `json:"access"`
+}
+
+// accesses resolves the requested access names to their definitions
+func (req *roleRequest) accesses() ([]model.Access, error) {
+	if len(req.Access) == 0 {
+		return nil, errors.New("access is required")
+	}
+	var accesses []model.Access
+	seen := make(map[string]bool)
+	for _, name := range req.Access {
+		access := model.FindAccess(name)
+		if access == nil {
+			return nil, errors.Errorf("%s is not a valid access", name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			accesses = append(accesses, *access)
+		}
+	}
+	return accesses, nil
+}
+
+// fetchAccesses lists every access that can be granted to a role
+func (a *API) fetchAccesses(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	return &ServerResponse{
+		Payload: model.Accesses,
+	}
+}
+
+// createRole allows an authorized admin define a new role
+func (a *API) createRole(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	var req roleRequest
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+
+	if err := decodeJSONBody(&tracingContext, r.Body, &req); err != nil {
+		return RespondWithError(err, "Failed to decode request body", http.StatusBadRequest, &tracingContext)
+	}
+	if req.Name == "" {
+		return RespondWithError(nil, "name is required", http.StatusBadRequest, &tracingContext)
+	}
+	slug := req.Slug
+	if slug == "" {
+		slug = strings.Join(strings.Fields(strings.ToLower(req.Name)), "-")
+	}
+	accesses, err := req.accesses()
+	if err != nil {
+		return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+	}
+
+	role := &model.Role{
+		ID:     slug,
+		Name:   req.Name,
+		Slug:   slug,
+		Access: accesses,
+	}
+	if err := a.Deps.DAL.AdminDAL.AddRole(context.TODO(), role); err != nil {
+		return RespondWithError(err, "unable to create role", http.StatusBadRequest, &tracingContext)
+	}
+	auditChange(r, "role:"+role.ID, nil, role)
+	return &ServerResponse{
+		Payload:    role,
+		Message:    "role created successfully",
+		StatusCode: http.StatusCreated,
+	}
+}
+
+// fetchRoles allows an authorized admin fetch all roles
+func (a *API) fetchRoles(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+
+	roles, err := a.Deps.DAL.AdminDAL.FindRoles(context.TODO(), bson.D{})
+	if err != nil {
+		return RespondWithError(err, "unable to fetch roles", http.StatusInternalServerError, &tracingContext)
+	}
+	return &ServerResponse{
+		Payload: roles,
+	}
+}
+
+// updateRole allows an authorized admin rename a role or change its access. Admins assigned the role pick up the
+// change on their next request
+func (a *API) updateRole(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	var req roleRequest
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	roleID := chi.URLParam(r, "roleID")
+
+	if roleID == model.SUPER_ADMIN {
+		return RespondWithError(nil, "the super admin role cannot be changed", http.StatusForbidden, &tracingContext)
+	}
+	if err := decodeJSONBody(&tracingContext, r.Body, &req); err != nil {
+		return RespondWithError(err, "Failed to decode request body", http.StatusBadRequest, &tracingContext)
+	}
+	before, err := a.Deps.DAL.AdminDAL.FindRole(context.TODO(), roleID)
+	if err != nil {
+		return RespondWithError(err, "role does not exist", http.StatusNotFound, &tracingContext)
+	}
+
+	set := bson.D{}
+	if req.Name != "" {
+		set = append(set, bson.E{Key: "name", Value: req.Name})
+	}
+	if req.Access != nil {
+		accesses, err := req.accesses()
+		if err != nil {
+			return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+		}
+		set = append(set, bson.E{Key: "access", Value: accesses})
+	}
+	if len(set) == 0 {
+		return RespondWithError(nil, "name or access is required", http.StatusBadRequest, &tracingContext)
+	}
+
+	if err := a.Deps.DAL.AdminDAL.UpdateRole(context.TODO(), roleID, bson.D{{"$set", set}}); err != nil {
+		return RespondWithError(err, "unable to update role", http.StatusBadRequest, &tracingContext)
+	}
+	role, err := a.Deps.DAL.AdminDAL.FindRole(context.TODO(), roleID)
+	if err != nil {
+		return RespondWithError(err, "unable to fetch role", http.StatusInternalServerError, &tracingContext)
+	}
+	auditChange(r, "role:"+roleID, before, role)
+	return &ServerResponse{
+		Payload: role,
+		Message: "role updated successfully",
+	}
+}
+
+// deleteRole allows an authorized admin delete a role that is not assigned to any admin
+func (a *API) deleteRole(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	roleID := chi.URLParam(r, "roleID")
+
+	if roleID == model.SUPER_ADMIN {
+		return RespondWithError(nil, "the super admin role cannot be deleted", http.StatusForbidden, &tracingContext)
+	}
+	role, err := a.Deps.DAL.AdminDAL.FindRole(context.TODO(), roleID)
+	if err != nil {
+		return RespondWithError(err, "role does not exist", http.StatusNotFound, &tracingContext)
+	}
+	admins, err := a.Deps.DAL.AdminDAL.FindAdmins(context.TODO(), bson.D{{"role_id", roleID}})
+	if err != nil {
+		return RespondWithError(err, "unable to fetch admins", http.StatusInternalServerError, &tracingContext)
+	}
+	if len(*admins) > 0 {
+		return RespondWithError(nil, "role is assigned to admins, assign them another role first", http.StatusConflict, &tracingContext)
+	}
+
+	if err := a.Deps.DAL.AdminDAL.DeleteRole(context.TODO(), roleID); err != nil {
+		return RespondWithError(err, "unable to delete role", http.StatusBadRequest, &tracingContext)
+	}
+	auditChange(r, "role:"+roleID, role, nil)
+	return &ServerResponse{
+		Message: "role deleted successfully",
+	}
+}
+
+// assignRole allows an authorized admin change the role of an admin
+func (a *API) assignRole(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	var req struct {
+		RoleID string `json:"role_id"`
+	}
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	adminID := chi.URLParam(r, "adminID")
+
+	if err := decodeJSONBody(&tracingContext, r.Body, &req); err != nil {
+		return RespondWithError(err, "Failed to decode request body", http.StatusBadRequest, &tracingContext)
+	}
+	if req.RoleID == "" {
+		return RespondWithError(nil, "role_id is required", http.StatusBadRequest, &tracingContext)
+	}
+	if principalOf(r).ID == adminID {
+		return RespondWithError(nil, "you cannot change your own role", http.StatusForbidden, &tracingContext)
+	}
+
+	role, err := a.Deps.DAL.AdminDAL.FindRole(context.TODO(), req.RoleID)
+	if err != nil {
+		return RespondWithError(err, "role does not exist", http.StatusBadRequest, &tracingContext)
+	}
+	admin, err := a.Deps.DAL.AdminDAL.FindAdmin(context.TODO(), bson.D{{"_id", adminID}})
+	if err != nil {
+		return RespondWithError(err, "admin does not exist", http.StatusNotFound, &tracingContext)
+	}
+
+	update := bson.D{{"$set", bson.D{{"role_id", role.ID}, {"role", role}}}}
+	if err := a.Deps.DAL.AdminDAL.UpdateAdmin(context.TODO(), adminID, update); err != nil {
+		return RespondWithError(err, "unable to assign role", http.StatusInternalServerError, &tracingContext)
+	}
+	auditChange(r, "admin:"+adminID, map[string]string{"role_id": admin.RoleID}, map[string]string{"role_id": role.ID})
+	return &ServerResponse{
+		Message: "role assigned successfully",
+	}
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/dal"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"sort"
+	"time"
+)
+
+// RollupMetrics rolls up the days with transactions created or updated since the job last ran into the hourly, daily
+// and total metrics the dashboard reads. The first run rolls up every transaction
+func (a *API) RollupMetrics(ctx context.Context, job *model.Job) error {
+	var since time.Time
+	if !job.LastRunAt.IsZero() {
+		since = job.LastRunAt.Add(-a.Config.RollupOverlap)
+	}
+	return a.rollupSince(ctx, since)
+}
+
+// BackfillMetrics rebuilds the metrics rollups of every day with a transaction
+func (a *API) BackfillMetrics(ctx context.Context) error {
+	return a.rollupSince(ctx, time.Time{})
+}
+
+// rollupSince rolls up every day with transactions created or updated since since, then the totals
+func (a *API) rollupSince(ctx context.Context, since time.Time) error {
+	changed := make(map[time.Time]bool)
+	for _, transactionType := range dal.TransactionTypes {
+		days, err := a.Deps.DAL.TransactionDAL.ChangedDays(ctx, transactionType, since)
+		if err != nil {
+			return errors.Wrapf(err, "unable to find changed %s days", transactionType)
+		}
+		for _, day := range days {
+			changed[day] = true
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	days := make([]time.Time, 0, len(changed))
+	for day := range changed {
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	for _, day := range days {
+		if err := a.rollupDay(ctx, day); err != nil {
+			return errors.Wrapf(err, "unable to roll up %s", day.Format("2006-01-02"))
+		}
+	}
+
+	totals, err := a.Deps.DAL.RollupDAL.Sum(ctx, bson.D{{"granularity", model.RollupDay}})
+	if err != nil {
+		return errors.Wrap(err, "unable to sum daily rollups")
+	}
+	for i := range totals {
+		totals[i].Granularity = model.RollupTotal
+	}
+	if err := a.Deps.DAL.RollupDAL.Replace(ctx, bson.D{{"granularity", model.RollupTotal}}, totals); err != nil {
+		return errors.Wrap(err, "unable to save total rollups")
+	}
+	logrus.Infof("[Metrics]: rolled up %d days", len(days))
+	return nil
+}
+
+// rollupDay recomputes the hourly rollups of a UTC day from its transactions, and the daily rollup from those
+func (a *API) rollupDay(ctx context.Context, day time.Time) error {
+	end := day.AddDate(0, 0, 1)
+	var hours []model.MetricsRollup
+	for _, transactionType := range dal.TransactionTypes {
+		rollups, err := a.Deps.DAL.TransactionDAL.RollupHours(ctx, transactionType, day, end)
+		if err != nil {
+			return errors.Wrapf(err, "unable to roll up %s", transactionType)
+		}
+		hours = append(hours, rollups...)
+	}
+
+	if err := a.Deps.DAL.RollupDAL.Replace(ctx, rollupScope(model.RollupHour, day, end), hours); err != nil {
+		return err
+	}
+	days, err := combineRollups(model.RollupDay, day, hours)
+	if err != nil {
+		return err
+	}
+	return a.Deps.DAL.RollupDAL.Replace(ctx, rollupScope(model.RollupDay, day, end), days)
+}
+
+// rollupScope matches the rollups of a granularity for the periods starting from start up to end
+func rollupScope(granularity string, start, end time.Time) bson.D {
+	return bson.D{{"granularity", granularity}, {"period_start", bson.D{{"$gte", start}, {"$lt", end}}}}
+}
+
+// combineRollups adds up rollups per type, currency and status into rollups of granularity starting at periodStart
+func combineRollups(granularity string, periodStart time.Time, rollups []model.MetricsRollup) ([]model.MetricsRollup, error) {
+	combined := make(map[string]*model.MetricsRollup)
+	var keys []string
+	for _, rollup := range rollups {
+		r := model.MetricsRollup{
+			Granularity: granularity,
+			PeriodStart: periodStart,
+			Type:        rollup.Type,
+			Currency:    rollup.Currency,
+			Status:      rollup.Status,
+		}
+		key := r.Key()
+		if _, ok := combined[key]; !ok {
+			combined[key] = &r
+			keys = append(keys, key)
+		}
+		volume, err := combined[key].Volume.Add(rollup.Volume)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to total %s rollups", key)
+		}
+		combined[key].Count += rollup.Count
+		combined[key].Volume = volume
+	}
+
+	result := make([]model.MetricsRollup, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, *combined[key])
+	}
+	return result, nil
+}
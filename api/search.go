@@ -0,0 +1,87 @@
+package api
+
+import (
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/types"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// profileRequest reads the filters of a user or agent search: q for words of the name, username, email or phone,
+// name, username and email matching the start ignoring case, phone, id_number, kyc_status, active, approved,
+// currency, and from and to creation dates like historyRequest. type=approved or type=rejected is kept as a shorthand
+// for approved
+func profileRequest(r *http.Request) (model.ProfileFilter, error) {
+	params := r.URL.Query()
+	filter := model.ProfileFilter{
+		Text:     params.Get("q"),
+		Name:     params.Get("name"),
+		Username: params.Get("username"),
+		Email:    params.Get("email"),
+		Phone:    params.Get("phone"),
+		IDNumber: params.Get("id_number"),
+		Currency: params.Get("currency"),
+	}
+
+	switch kycStatus := params.Get("kyc_status"); kycStatus {
+	case "", model.KYCVerified, model.KYCPending, model.KYCNone:
+		filter.KYCStatus = kycStatus
+	default:
+		return filter, errors.Errorf("kyc_status must be one of %s, %s or %s", model.KYCVerified, model.KYCPending, model.KYCNone)
+	}
+	var err error
+	if filter.Active, err = boolParam(r, "active"); err != nil {
+		return filter, err
+	}
+	if filter.Approved, err = boolParam(r, "approved"); err != nil {
+		return filter, err
+	}
+	switch params.Get("type") {
+	case "", "all":
+	case types.APPROVED, types.REJECTED:
+		approved := params.Get("type") == types.APPROVED
+		if filter.Approved != nil && *filter.Approved != approved {
+			return filter, errors.New("type and approved disagree")
+		}
+		filter.Approved = &approved
+	default:
+		return filter, errors.New("Specified type is not supported")
+	}
+
+	if value := params.Get("from"); value != "" {
+		from, _, err := parseDate(value, time.UTC)
+		if err != nil {
+			return filter, errors.New("unable to parse from date, use YYYY-MM-DD or RFC 3339")
+		}
+		filter.From = from
+	}
+	if value := params.Get("to"); value != "" {
+		to, dateOnly, err := parseDate(value, time.UTC)
+		if err != nil {
+			return filter, errors.New("unable to parse to date, use YYYY-MM-DD or RFC 3339")
+		}
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+		filter.To = to
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return filter, errors.New("from date must be before to date")
+	}
+	return filter, nil
+}
+
+// boolParam reads an optional true or false parameter, nil when it is not set
+func boolParam(r *http.Request, param string) (*bool, error) {
+	value := r.URL.Query().Get(param)
+	if value == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, errors.Errorf("%s must be true or false", param)
+	}
+	return &b, nil
+}
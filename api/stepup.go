@@ -0,0 +1,259 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"github.com/go-chi/chi"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/tracing"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"go.mongodb.org/mongo-driver/bson"
+	"image/png"
+	"net/http"
+	"time"
+)
+
+// HeaderSecurityCode carries a code from the admin's authenticator on sensitive admin routes
+const HeaderSecurityCode = "X-Security-Code"
+
+const (
+	totpIssuer = "OnePurse"
+	totpPeriod = 30
+	totpQRSize = 256
+)
+
+var totpOpts = totp.ValidateOpts{
+	Period:    totpPeriod,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1, // the only algorithm most authenticator apps support
+}
+
+// matchTOTP returns the time step of code when it is valid for secret at now, allowing a step of clock drift either way
+func matchTOTP(secret, code string, now time.Time) (int64, bool) {
+	for _, drift := range []int64{0, -1, 1} {
+		at := now.Add(time.Duration(drift*totpPeriod) * time.Second)
+		expected, err := totp.GenerateCodeCustom(secret, at, totpOpts)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return at.Unix() / totpPeriod, true
+		}
+	}
+	return 0, false
+}
+
+// StepUp requires a code from the admin's authenticator in the X-Security-Code header. A code is accepted once, so
+// every sensitive request needs a fresh one. Attempts are recorded in the audit log against action. It must run after
+// AdminOnly
+func (a *API) StepUp(action string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			code := r.Header.Get(HeaderSecurityCode)
+			if code == "" {
+				writeErrorResponse(w, http.StatusForbidden, "a security code is required for this action")
+				return
+			}
+			p := principalOf(r)
+			admin, err := a.Deps.DAL.AdminDAL.FindAdmin(r.Context(), bson.D{{"_id", p.ID}})
+			if err != nil {
+				writeErrorResponse(w, http.StatusInternalServerError, "unable to verify security code. Please try again")
+				return
+			}
+			if !admin.TOTPEnabled || admin.TOTPSecret == "" {
+				writeErrorResponse(w, http.StatusForbidden, "enroll an authenticator before performing this action")
+				return
+			}
+
+			step, ok := matchTOTP(admin.TOTPSecret, code, time.Now())
+			if !ok {
+				a.audit(r, "step_up.failed", action)
+				writeErrorResponse(w, http.StatusForbidden, "invalid security code")
+				return
+			}
+			accepted, err := a.Deps.DAL.AdminDAL.AcceptTOTPStep(r.Context(), admin.ID, step)
+			if err != nil {
+				writeErrorResponse(w, http.StatusInternalServerError, "unable to verify security code. Please try again")
+				return
+			}
+			if !accepted {
+				a.audit(r, "step_up.failed", action)
+				writeErrorResponse(w, http.StatusForbidden, "security code has already been used. Wait for a new one")
+				return
+			}
+
+			a.audit(r, "step_up.verified", action)
+			next.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+// sendAdminOTP sends the calling admin a code for a sensitive admin action, by email unless they only have a phone
+// number
+func (a *API) sendAdminOTP(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	admin, err := a.Deps.DAL.AdminDAL.FindAdmin(r.Context(), bson.D{{"_id", principalOf(r).ID}})
+	if err != nil {
+		return RespondWithError(err, "unable to find admin", http.StatusInternalServerError, &tracingContext)
+	}
+
+	var channel, destination string
+	switch {
+	case admin.Email != "":
+		channel, destination = model.OTPChannelEmail, admin.Email
+	case admin.Phone != "":
+		channel, destination = model.OTPChannelSMS, admin.Phone
+	default:
+		return RespondWithError(nil, "add an email or phone number to your profile first", http.StatusBadRequest, &tracingContext)
+	}
+	challenge, err := a.sendOTP(r.Context(), admin.ID, model.OTPPurposeAdminAction, channel, destination)
+	if err != nil {
+		return respondWithOTPError(w, err, &tracingContext)
+	}
+
+	response := map[string]interface{}{
+		"message":    fmt.Sprintf("token sent to %s", destination),
+		"expires_at": challenge.ExpiresAt,
+	}
+	return &ServerResponse{
+		Payload: response,
+	}
+}
+
+// enrollAuthenticator starts enrolling an authenticator for the calling admin. The secret is returned with a QR code
+// to scan, and only takes effect once confirmAuthenticator receives a code from it. An admin action otp is required in
+// the X-OTP header
+func (a *API) enrollAuthenticator(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	admin, err := a.Deps.DAL.AdminDAL.FindAdmin(context.TODO(), bson.D{{"_id", principalOf(r).ID}})
+	if err != nil {
+		return RespondWithError(err, "unable to find admin", http.StatusInternalServerError, &tracingContext)
+	}
+	if admin.TOTPEnabled {
+		return RespondWithError(nil, "an authenticator is already enrolled. Ask an admin with the 2fa access to reset it", http.StatusConflict, &tracingContext)
+	}
+	// the authenticator guards every other sensitive action, so enrolling one needs a code sent to the admin
+	code := r.Header.Get(HeaderOTP)
+	if code == "" {
+		return RespondWithError(nil, "an otp is required to enroll an authenticator", http.StatusBadRequest, &tracingContext)
+	}
+	if err := a.verifyOTP(r.Context(), admin.ID, model.OTPPurposeAdminAction, code); err != nil {
+		return respondWithOTPError(w, err, &tracingContext)
+	}
+
+	account := admin.Email
+	if account == "" {
+		account = admin.Username
+	}
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: account,
+		Period:      totpPeriod,
+		Digits:      totpOpts.Digits,
+		Algorithm:   totpOpts.Algorithm,
+	})
+	if err != nil {
+		return RespondWithError(err, "unable to generate authenticator secret", http.StatusInternalServerError, &tracingContext)
+	}
+	img, err := key.Image(totpQRSize, totpQRSize)
+	if err != nil {
+		return RespondWithError(err, "unable to generate authenticator qr code", http.StatusInternalServerError, &tracingContext)
+	}
+	var qr bytes.Buffer
+	if err := png.Encode(&qr, img); err != nil {
+		return RespondWithError(err, "unable to generate authenticator qr code", http.StatusInternalServerError, &tracingContext)
+	}
+
+	err = a.Deps.DAL.AdminDAL.UpdateAdmin(context.TODO(), admin.ID, bson.D{{"$set", bson.D{{"totp_pending_secret", key.Secret()}}}})
+	if err != nil {
+		return RespondWithError(err, "unable to save authenticator secret", http.StatusInternalServerError, &tracingContext)
+	}
+
+	response := map[string]interface{}{
+		"secret":  key.Secret(),
+		"url":     key.URL(),
+		"qr_code": "data:image/png;base64," + base64.StdEncoding.EncodeToString(qr.Bytes()),
+	}
+	return &ServerResponse{
+		Payload: response,
+	}
+}
+
+// confirmAuthenticator finishes enrolling an authenticator once the admin sends a code from it
+func (a *API) confirmAuthenticator(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	var request struct {
+		Code string `json:"code"`
+	}
+	if err := decodeJSONBody(&tracingContext, r.Body, &request); err != nil {
+		return RespondWithError(err, "Failed to decode request body", http.StatusBadRequest, &tracingContext)
+	}
+	if request.Code == "" {
+		return RespondWithError(nil, "code is required", http.StatusBadRequest, &tracingContext)
+	}
+
+	admin, err := a.Deps.DAL.AdminDAL.FindAdmin(context.TODO(), bson.D{{"_id", principalOf(r).ID}})
+	if err != nil {
+		return RespondWithError(err, "unable to find admin", http.StatusInternalServerError, &tracingContext)
+	}
+	if admin.TOTPEnabled {
+		return RespondWithError(nil, "an authenticator is already enrolled", http.StatusConflict, &tracingContext)
+	}
+	if admin.TOTPPendingSecret == "" {
+		return RespondWithError(nil, "start enrolling an authenticator first", http.StatusBadRequest, &tracingContext)
+	}
+	step, ok := matchTOTP(admin.TOTPPendingSecret, request.Code, time.Now())
+	if !ok {
+		return RespondWithError(nil, "invalid code", http.StatusBadRequest, &tracingContext)
+	}
+
+	err = a.Deps.DAL.AdminDAL.UpdateAdmin(context.TODO(), admin.ID, bson.D{{"$set", bson.D{
+		{"totp_enabled", true},
+		{"totp_secret", admin.TOTPPendingSecret},
+		{"totp_pending_secret", ""},
+		{"totp_last_step", step},
+	}}})
+	if err != nil {
+		return RespondWithError(err, "unable to enroll authenticator", http.StatusInternalServerError, &tracingContext)
+	}
+	auditChange(r, "admin:"+admin.ID, map[string]bool{"totp_enabled": false}, map[string]bool{"totp_enabled": true})
+
+	return &ServerResponse{
+		Message: "authenticator enrolled successfully",
+	}
+}
+
+// resetAuthenticator removes the authenticator of another admin, e.g. when their device is lost, so they can enroll
+// a new one
+func (a *API) resetAuthenticator(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	adminID := chi.URLParam(r, "adminID")
+	if adminID == principalOf(r).ID {
+		return RespondWithError(nil, "you cannot reset your own authenticator", http.StatusForbidden, &tracingContext)
+	}
+
+	admin, err := a.Deps.DAL.AdminDAL.FindAdmin(context.TODO(), bson.D{{"_id", adminID}})
+	if err != nil {
+		return RespondWithError(err, "admin does not exist", http.StatusNotFound, &tracingContext)
+	}
+
+	err = a.Deps.DAL.AdminDAL.UpdateAdmin(context.TODO(), adminID, bson.D{{"$set", bson.D{
+		{"totp_enabled", false},
+		{"totp_secret", ""},
+		{"totp_pending_secret", ""},
+		{"totp_last_step", int64(0)},
+	}}})
+	if err != nil {
+		return RespondWithError(err, "unable to reset authenticator", http.StatusBadRequest, &tracingContext)
+	}
+	auditChange(r, "admin:"+adminID, map[string]bool{"totp_enabled": admin.TOTPEnabled}, map[string]bool{"totp_enabled": false})
+
+	return &ServerResponse{
+		Message: "authenticator reset successfully",
+	}
+}
@@ -0,0 +1,202 @@
+package api
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/tracing"
+	"github.com/isongjosiah/work/onepurse-api/txstate"
+	"github.com/isongjosiah/work/onepurse-api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"net/http"
+	"time"
+)
+
+// transitionRequest is the body of a transaction update. A client can only move a transaction to a new status and
+// provide the receipts and reason that come with that status, never set arbitrary fields
+type transitionRequest struct {
+	Status       string `json:"status"`
+	Reason       string `json:"reason"`
+	UserReceipt  string `json:"user_receipt"`
+	AgentReceipt string `json:"agent_receipt"`
+}
+
+// fields returns the transaction fields a transition request sets alongside the status
+func (t transitionRequest) fields() bson.D {
+	fields := bson.D{}
+	if t.Status == txstate.Paid {
+		if t.UserReceipt != "" {
+			fields = append(fields, bson.E{Key: "user_receipt", Value: t.UserReceipt})
+		}
+		if t.AgentReceipt != "" {
+			fields = append(fields, bson.E{Key: "agent_receipt", Value: t.AgentReceipt})
+		}
+	}
+	return fields
+}
+
+// actorOf identifies who is making a request for the transition history of a transaction
+func actorOf(r *http.Request) string {
+	if p := principalOf(r); p != nil {
+		return p.String()
+	}
+	return r.Context().Value(tracing.ContextKeyTracing).(tracing.Context).RequestSource
+}
+
+// transitionActor is who moves a transaction: their name, recorded in its history, and the txstate party they act as
+type transitionActor struct {
+	Name  string
+	Party string
+}
+
+// partyOf returns the actor of a request moving a transaction created by owner and matched to one of counterparties.
+// Callers that are not a party to it, and admins without transaction access, act as no party and can make no move
+func partyOf(r *http.Request, owner string, counterparties ...string) transitionActor {
+	actor := transitionActor{Name: actorOf(r)}
+	p := principalOf(r)
+	switch {
+	case p == nil:
+	case p.Kind == PrincipalAdmin:
+		if p.Role.HasAccess(model.TRANSACTION) {
+			actor.Party = txstate.Admin
+		}
+	case p.Owns(owner):
+		actor.Party = txstate.Owner
+	case p.Owns(counterparties...):
+		actor.Party = txstate.Counterparty
+	}
+	return actor
+}
+
+// matchActor returns the actor matching a transaction to an agent or peer user. Matching is done by the API on behalf
+// of the owner, who callers must check made the request
+func matchActor(r *http.Request) transitionActor {
+	return transitionActor{Name: actorOf(r), Party: txstate.System}
+}
+
+// createdHistory starts the history of a newly created transaction
+func createdHistory(actor string, at time.Time) []model.StatusChange {
+	return []model.StatusChange{{To: txstate.Created, Actor: actor, At: at}}
+}
+
+// transition moves a transaction from one status to another, when actor is a party that can make the move. The
+// ledger entry of the transition, if any, and the commission the agent earned by it, if any, are recorded in the same
+// mongo transaction as the status change
+func (a *API) transition(ctx context.Context, transactionType, transactionID, from, to string, actor transitionActor, reason string, entry *model.JournalEntry, commission *model.Commission, fields bson.D) error {
+	if err := txstate.Check(transactionType, from, to); err != nil {
+		return err
+	}
+	if err := txstate.Authorize(transactionType, from, to, actor.Party); err != nil {
+		return err
+	}
+
+	change := model.StatusChange{From: from, To: to, Actor: actor.Name, Reason: reason, At: time.Now()}
+	return a.postWithUpdate(ctx, entry, func(ctx context.Context) error {
+		if err := a.Deps.DAL.TransactionDAL.TransitionStatus(ctx, transactionType, transactionID, change, fields); err != nil {
+			return err
+		}
+		if commission == nil {
+			return nil
+		}
+		if err := a.Deps.DAL.CommissionDAL.Accrue(ctx, commission); err != nil {
+			return err
+		}
+		return a.Deps.DAL.LedgerDAL.Post(ctx, commissionEntry(commission))
+	})
+}
+
+func (a *API) transitionTransfer(ctx context.Context, t *model.Transfer, to string, actor transitionActor, reason string, fields bson.D) error {
+	commission, err := a.commissionOn(ctx, to, types.TRANSFER, t.ID, t.AgentID, t.BaseCurrency, t.BaseAmount)
+	if err != nil {
+		return err
+	}
+	entry, err := transferEntry(t, to)
+	if err != nil {
+		return err
+	}
+	return a.transition(ctx, types.TRANSFER, t.ID, t.Status, to, actor, reason, entry, commission, fields)
+}
+
+func (a *API) transitionWithdrawal(ctx context.Context, w *model.Withdrawal, to string, actor transitionActor, reason string, fields bson.D) error {
+	entry, err := withdrawalEntry(w, to)
+	if err != nil {
+		return err
+	}
+	return a.transition(ctx, types.WITHDRAW, w.ID, w.Status, to, actor, reason, entry, nil, fields)
+}
+
+func (a *API) transitionDeposit(ctx context.Context, d *model.Deposit, to string, actor transitionActor, reason string, fields bson.D) error {
+	commission, err := a.commissionOn(ctx, to, types.DEPOSIT, d.ID, d.AgentID, d.BaseCurrency, d.BaseAmount)
+	if err != nil {
+		return err
+	}
+	return a.transition(ctx, types.DEPOSIT, d.ID, d.Status, to, actor, reason, depositEntry(d, to), commission, fields)
+}
+
+// transitionExchange moves an exchange to state to. An agent filling an exchange trades its exchange currency, the
+// currency of their wallet, so that is the side their commission is earned on
+func (a *API) transitionExchange(ctx context.Context, e *model.Exchange, to string, actor transitionActor, reason string, fields bson.D) error {
+	commission, err := a.commissionOn(ctx, to, types.EXCHANGE, e.ID, e.AgentID, e.ExchangeCurrency, e.ExchangeAmount)
+	if err != nil {
+		return err
+	}
+	entry, err := exchangeEntry(e, to)
+	if err != nil {
+		return err
+	}
+	return a.transition(ctx, types.EXCHANGE, e.ID, e.Status, to, actor, reason, entry, commission, fields)
+}
+
+// transferEntry returns the ledger entry for a transfer entering state to. The agent must already be set on t when
+// it is being matched
+func transferEntry(t *model.Transfer, to string) (*model.JournalEntry, error) {
+	switch to {
+	case txstate.Matched:
+		account := model.AgentWalletAccount(t.AgentID, t.BaseCurrency, model.LedgerBucketAvailable)
+		return holdEntry(t.ID, types.TRANSFER, account, t.BaseAmount), nil
+	case txstate.Completed:
+		return transferCompletionEntry(t), nil
+	case txstate.Cancelled, txstate.Expired:
+		return transferCancellationEntry(t)
+	}
+	return nil, nil
+}
+
+func withdrawalEntry(w *model.Withdrawal, to string) (*model.JournalEntry, error) {
+	switch to {
+	case txstate.Completed:
+		return withdrawalCompletionEntry(w), nil
+	case txstate.Cancelled, txstate.Expired:
+		held, err := w.BaseAmount.Add(w.Fee)
+		if err != nil {
+			return nil, err
+		}
+		account := model.UserWalletAccount(w.UserID, w.BaseCurrency, model.LedgerBucketAvailable)
+		return releaseEntry(w.ID, types.WITHDRAW, account, held), nil
+	}
+	return nil, nil
+}
+
+func depositEntry(d *model.Deposit, to string) *model.JournalEntry {
+	if to == txstate.Completed {
+		return depositCompletionEntry(d)
+	}
+	return nil
+}
+
+// exchangeEntry returns the ledger entry for an exchange entering state to. The agent or matched user must already be
+// set on e when it is being matched
+func exchangeEntry(e *model.Exchange, to string) (*model.JournalEntry, error) {
+	switch to {
+	case txstate.Matched:
+		held, err := e.ExchangeAmount.Add(e.Margin)
+		if err != nil {
+			return nil, err
+		}
+		return holdEntry(e.ID, types.EXCHANGE, exchangePeerAccount(e, e.ExchangeCurrency), held), nil
+	case txstate.Completed:
+		return exchangeCompletionEntry(e)
+	case txstate.Cancelled, txstate.Expired:
+		return exchangeCancellationEntry(e)
+	}
+	return nil, nil
+}
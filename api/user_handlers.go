@@ -3,11 +3,14 @@ package api
 import (
 	"context"
 	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/smithy-go"
 	"github.com/go-chi/chi"
 	"github.com/isongjosiah/work/onepurse-api/dal/model"
 	"github.com/isongjosiah/work/onepurse-api/helpers"
+	"github.com/isongjosiah/work/onepurse-api/money"
 	"github.com/isongjosiah/work/onepurse-api/tracing"
+	"github.com/isongjosiah/work/onepurse-api/txstate"
 	"github.com/isongjosiah/work/onepurse-api/types"
 	"github.com/lucsky/cuid"
 	"github.com/pkg/errors"
@@ -21,29 +24,35 @@ import (
 
 func (a *API) UserRoutes() http.Handler {
 	router := chi.NewRouter()
-	router.Use(Authorization)
+	router.Use(a.Authorization)
+	router.Use(a.Authenticate)
 
 	// Profile Routes
 	router.Method("POST", "/change_password", Handler(a.changePassword))
-	router.Method("PATCH", "/{userID}/username", Handler(a.updateUserName))
-	router.Method("PATCH", "/{userID}/transaction_password", Handler(a.transactionPasswordActions))
-	router.Method("PATCH", "/{userID}/update_kyc_information", Handler(a.updateKYCInformation))
-	router.Method("PATCH", "/{userID}/profile", Handler(a.updateProfile))
+	router.With(OwnerOnly("userID")).Method("PATCH", "/{userID}/username", Handler(a.updateUserName))
+	router.With(OwnerOnly("userID")).Method("PATCH", "/{userID}/transaction_password", Handler(a.transactionPasswordActions))
+	router.With(OwnerOnly("userID")).Method("PATCH", "/{userID}/update_kyc_information", Handler(a.updateKYCInformation))
+	router.With(OwnerOnly("userID")).Method("PATCH", "/{userID}/profile", Handler(a.updateProfile))
+	router.With(OwnerOnly("userID")).Method("PATCH", "/{userID}/device", Handler(a.registerDevice))
 
 	// Transaction Routes
-	router.Method("POST", "/{userID}/transaction", Handler(a.createTransaction))
-	router.Method("PATCH", "/transaction/{transactionID}", Handler(a.updateTransaction))
-	router.Method("GET", "/{userID}/transaction", Handler(a.getTransaction))
+	router.With(OwnerOnly("userID"), a.Idempotency).Method("POST", "/{userID}/transaction", Handler(a.createTransaction))
+	router.With(a.Idempotency).Method("PATCH", "/transaction/{transactionID}", Handler(a.updateTransaction))
+	router.With(OwnerOrAccess("userID", model.TRANSACTION)).Method("GET", "/{userID}/transaction", Handler(a.getTransaction))
+	router.With(OwnerOrAccess("userID", model.TRANSACTION)).Method("GET", "/{userID}/history", Handler(a.getUserHistory))
 	router.Method("GET", "/transaction/{transactionID}/get_peer", Handler(a.getAgentForTransaction))
 
 	// OTP Token Routes
-	router.Method("GET", "/{userID}/otp", Handler(a.generateOTPToken))
-	router.Method("POST", "/{userID}/otp", Handler(a.validateOTPToken))
+	router.With(OwnerOnly("userID")).Method("GET", "/{userID}/otp", Handler(a.generateOTPToken))
+	router.With(OwnerOnly("userID")).Method("POST", "/{userID}/otp", Handler(a.validateOTPToken))
 
 	// Wallet Routes
-	router.Method("POST", "/{userID}/wallet", Handler(a.createWallet))
-	router.Method("PATCH", "/{userID}/wallet", Handler(a.updateWallet))
-	router.Method("GET", "/{userID}/wallet", Handler(a.getWalletTransaction))
+	router.With(OwnerOnly("userID")).Method("POST", "/{userID}/wallet", Handler(a.createWallet))
+	router.With(OwnerOnly("userID")).Method("PATCH", "/{userID}/wallet", Handler(a.updateWallet))
+	router.With(OwnerOrAccess("userID", model.TRANSACTION)).Method("GET", "/{userID}/wallet", Handler(a.getWalletTransaction))
+
+	// Notification Routes
+	router.With(OwnerOnly("userID")).Method("GET", "/{userID}/notifications", Handler(a.getUserNotifications))
 	return router
 }
 
@@ -70,7 +79,7 @@ func (a *API) changePassword(w http.ResponseWriter, r *http.Request) *ServerResp
 		return RespondWithError(nil, "Proposed password is required", http.StatusBadRequest, &tracingContext)
 	}
 
-	status, err := a.Deps.AWS.Cognito.ChangePassword(&password)
+	status, err := a.Deps.Identity.ChangePassword(&password)
 	if err != nil {
 		var ae smithy.APIError
 		if errors.As(err, &ae) {
@@ -136,7 +145,7 @@ func (a *API) transactionPasswordActions(w http.ResponseWriter, r *http.Request)
 
 	case "update":
 		var request model.ChangeTransactionPasswordRequest
-		if err := decodeJSONBody(&tracingContext, r.Body, &tracingContext); err != nil {
+		if err := decodeJSONBody(&tracingContext, r.Body, &request); err != nil {
 			return RespondWithError(err, "failed to decode request body", http.StatusInternalServerError, &tracingContext)
 		}
 		if request.OTP == "" {
@@ -146,9 +155,8 @@ func (a *API) transactionPasswordActions(w http.ResponseWriter, r *http.Request)
 			return RespondWithError(nil, "new password is required", http.StatusBadRequest, &tracingContext)
 		}
 
-		valid := helpers.ValidateOTPCode(userID, request.OTP)
-		if !valid {
-			return RespondWithError(nil, "Invalid Token", http.StatusBadRequest, &tracingContext)
+		if err := a.verifyOTP(r.Context(), userID, model.OTPPurposeChangePin, request.OTP); err != nil {
+			return respondWithOTPError(w, err, &tracingContext)
 		}
 		hashPassword, err := helpers.HashPassword(request.ProposedPassword)
 		if err != nil {
@@ -156,9 +164,12 @@ func (a *API) transactionPasswordActions(w http.ResponseWriter, r *http.Request)
 		}
 
 		// update utemp transaction password
-		err = a.Deps.DAL.UserDAL.UpdateUser(context.TODO(), userID, bson.D{{"$set", bson.D{{
-			"transaction_password", hashPassword,
-		}}}})
+		// a new pin also lifts any lockout from wrong attempts on the old one
+		err = a.Deps.DAL.UserDAL.UpdateUser(context.TODO(), userID, bson.D{{"$set", bson.D{
+			{"transaction_password", hashPassword},
+			{"pin_attempts", 0},
+			{"pin_locked_until", time.Time{}},
+		}}})
 		if err != nil {
 			return RespondWithError(err, "unable to update utemp. Please try again", http.StatusInternalServerError, &tracingContext)
 		}
@@ -191,7 +202,7 @@ func (a *API) updateUserName(w http.ResponseWriter, r *http.Request) *ServerResp
 		return RespondWithError(nil, "preferred_username is required", http.StatusBadRequest, &tracingContext)
 	}
 
-	err := a.Deps.AWS.Cognito.UpdateUsername(&param)
+	err := a.Deps.Identity.UpdateUsername(&param)
 	if err != nil {
 		var ae smithy.APIError
 		if errors.As(err, &ae) {
@@ -298,8 +309,63 @@ func (a *API) updateProfile(w http.ResponseWriter, r *http.Request) *ServerRespo
 	}
 }
 
+// registerDevice sets the device the user's push notifications are sent to. Replacing a device the user already
+// registered needs a new device otp, so a stolen session cannot redirect notifications such as pin lockouts
+func (a *API) registerDevice(w http.ResponseWriter, r *http.Request) *ServerResponse {
+	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	userID := chi.URLParam(r, "userID")
+	var request struct {
+		DeviceToken string `json:"device_token"`
+	}
+	if err := decodeJSONBody(&tracingContext, r.Body, &request); err != nil {
+		return RespondWithError(err, "failed to decode request body", http.StatusBadRequest, &tracingContext)
+	}
+	if request.DeviceToken == "" {
+		return RespondWithError(nil, "device_token is required", http.StatusBadRequest, &tracingContext)
+	}
+	user, err := a.Deps.DAL.UserDAL.FindByID(r.Context(), userID)
+	if err != nil {
+		return RespondWithError(err, "could not find user", http.StatusBadRequest, &tracingContext)
+	}
+
+	endpoint, err := a.Deps.AWS.SNS.CreatePlatformEndpoint(request.DeviceToken)
+	if err != nil {
+		return RespondWithError(err, "unable to register device", http.StatusInternalServerError, &tracingContext)
+	}
+	endpointArn := aws.ToString(endpoint.EndpointArn)
+	if user.DeviceToken == endpointArn {
+		return &ServerResponse{Payload: map[string]interface{}{"message": "device is already registered"}}
+	}
+	if user.DeviceToken != "" {
+		code := r.Header.Get(HeaderOTP)
+		if code == "" {
+			return RespondWithError(nil, "an otp is required to add a new device", http.StatusBadRequest, &tracingContext)
+		}
+		if err := a.verifyOTP(r.Context(), userID, model.OTPPurposeNewDevice, code); err != nil {
+			return respondWithOTPError(w, err, &tracingContext)
+		}
+	}
+
+	err = a.Deps.DAL.UserDAL.UpdateUser(r.Context(), userID, bson.D{{"$set", bson.D{{"device_token", endpointArn}}}})
+	if err != nil {
+		return RespondWithError(err, "failed to register device", http.StatusInternalServerError, &tracingContext)
+	}
+
+	response := map[string]interface{}{
+		"message": "device registered successfully",
+	}
+	return &ServerResponse{
+		Payload: response,
+	}
+}
+
 // Transaction
 
+// onePurseParty is the part of a user a one purse transaction records about its sender and receiver
+func onePurseParty(u *model.User) *model.User {
+	return &model.User{ID: u.ID, FullName: u.FullName, UserName: u.UserName}
+}
+
 func (a *API) createTransaction(w http.ResponseWriter, r *http.Request) *ServerResponse {
 	ctx := context.Background()
 	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
@@ -323,25 +389,59 @@ func (a *API) createTransaction(w http.ResponseWriter, r *http.Request) *ServerR
 		if err := decodeJSONBody(&tracingContext, r.Body, &transfer); err != nil {
 			return RespondWithError(nil, "Failed to decode request body", http.StatusInternalServerError, &tracingContext)
 		}
-		if transfer.BaseCurrency == "" || transfer.ConvCurrency == "" {
-			return RespondWithError(nil, "base and conversion currency are required", http.StatusBadRequest, &tracingContext)
+		// the amounts are those of the quote, not the client's
+		quote, resp := a.quoteOf(transfer.QuoteID, user.ID, types.TRANSFER, &tracingContext)
+		if resp != nil {
+			return resp
+		}
+		transfer.BaseCurrency, transfer.BaseAmount, transfer.Fee = quote.FromCurrency, quote.FromAmount, quote.Fee
+		transfer.ConvCurrency, transfer.AmountSent = quote.ToCurrency, quote.ToAmount
+		if _, resp := a.transactionCurrency(transfer.BaseCurrency, transfer.BaseAmount, &tracingContext); resp != nil {
+			return resp
 		}
-		if transfer.BaseAmount == 0 || transfer.AmountSent == 0 {
-			return RespondWithError(nil, "base and converted amount are required", http.StatusBadRequest, &tracingContext)
+		convCurrency, resp := a.transactionCurrency(transfer.ConvCurrency, transfer.AmountSent, &tracingContext)
+		if resp != nil {
+			return resp
 		}
 		if transfer.PaymentChannel == "" {
 			return RespondWithError(nil, "payment channel in use is required", http.StatusBadRequest, &tracingContext)
 		}
-		pass := helpers.DoSufficientFundsCheck(user, transfer.BaseAmount, transfer.BaseCurrency)
+		if err := convCurrency.CheckPaymentChannel(transfer.PaymentChannel); err != nil {
+			return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+		}
+		total, err := transfer.BaseAmount.Add(transfer.Fee)
+		if err != nil {
+			return RespondWithError(err, "Transfer amount is too large", http.StatusBadRequest, &tracingContext)
+		}
+		if resp := a.checkTransactionPin(r, user); resp != nil {
+			return resp
+		}
+		pass := helpers.DoSufficientFundsCheck(user, total, transfer.BaseCurrency)
 		if !pass {
 			return RespondWithError(nil, "Insufficient Funds to initiate transfer. Please Top-up Wallet and try again", http.StatusBadRequest, &tracingContext)
 		}
-		transfer.Status = "created"
+		transfer.Status = txstate.Created
 		transfer.ID = cuid.New()
 		transfer.CreatedAt = time.Now()
 		transfer.UserID = user.ID
+		transfer.History = createdHistory(user.ID, transfer.CreatedAt)
+
+		_, err = ses.WithTransaction(ctx, func(sesCtx mongo.SessionContext) (interface{}, error) {
+			if err := a.Deps.DAL.QuoteDAL.Use(sesCtx, quote.ID, user.ID, transfer.ID, time.Now()); err != nil {
+				return nil, err
+			}
+			err := a.Deps.DAL.TransactionDAL.CreateTransfer(sesCtx, &transfer)
+			if err != nil {
+				return nil, err
+			}
 
-		err := a.Deps.DAL.TransactionDAL.CreateTransfer(context.TODO(), &transfer)
+			// hold the amount being transferred and the fee until the transfer is completed or cancelled
+			account := model.UserWalletAccount(user.ID, transfer.BaseCurrency, model.LedgerBucketAvailable)
+			return nil, a.Deps.DAL.LedgerDAL.Post(sesCtx, holdEntry(transfer.ID, types.TRANSFER, account, total))
+		})
+		if isQuoteError(err) {
+			return respondWithQuoteError(err, &tracingContext)
+		}
 		if err != nil {
 			return RespondWithError(err, "Failed to initiate transfer. Please try again", http.StatusInternalServerError, &tracingContext)
 		}
@@ -358,12 +458,26 @@ func (a *API) createTransaction(w http.ResponseWriter, r *http.Request) *ServerR
 			return RespondWithError(nil, "Failed to decode request body", http.StatusInternalServerError, &tracingContext)
 		}
 
-		if transaction.FromUser == nil || transaction.ToUser == nil {
-			return RespondWithError(nil, "sender and receiver is required", http.StatusBadRequest, &tracingContext)
+		// the caller is always the sender, and the other party is loaded rather than taken from the client
+		if transaction.ToUser == nil || transaction.ToUser.ID == "" {
+			return RespondWithError(nil, "receiver is required", http.StatusBadRequest, &tracingContext)
 		}
-		if transaction.Currency == "" || transaction.Amount == 0 {
+		if transaction.ToUser.ID == user.ID {
+			return RespondWithError(nil, "you cannot pay or request money from yourself", http.StatusBadRequest, &tracingContext)
+		}
+		recipient, err := a.Deps.DAL.UserDAL.FindByID(context.TODO(), transaction.ToUser.ID)
+		if err != nil {
+			return RespondWithError(err, "unable to find receiver", http.StatusBadRequest, &tracingContext)
+		}
+		transaction.FromUser, transaction.ToUser = onePurseParty(user), onePurseParty(recipient)
+		if transaction.Currency == "" || transaction.Amount <= money.Zero {
 			return RespondWithError(nil, "transaction amount and currency is required", http.StatusBadRequest, &tracingContext)
 		}
+		currency, resp := a.transactionCurrency(transaction.Currency, transaction.Amount, &tracingContext)
+		if resp != nil {
+			return resp
+		}
+		transaction.Currency = currency.Code
 		if transaction.Type == "" {
 			return RespondWithError(nil, "transaction type must be specified", http.StatusBadRequest, &tracingContext)
 		}
@@ -382,7 +496,7 @@ func (a *API) createTransaction(w http.ResponseWriter, r *http.Request) *ServerR
 
 				// Create a Notification
 				message := fmt.Sprintf("%s requested for %s %v from you", transaction.FromUser.UserName, transaction.Currency, transaction.Amount)
-				err := a.CreateNotification(sesCtx, transaction.ToUser.ID, types.PAYMENT_REQUEST, message, types.ONE_PURSE_TRANSACTION, transaction.ToUser.DeviceToken, transaction)
+				err := a.CreateNotification(sesCtx, recipient.ID, types.PAYMENT_REQUEST, message, types.ONE_PURSE_TRANSACTION, recipient.DeviceToken, transaction)
 				if err != nil {
 					return nil, errors.Wrap(err, "unable to send notification")
 				}
@@ -400,6 +514,10 @@ func (a *API) createTransaction(w http.ResponseWriter, r *http.Request) *ServerR
 				Payload: response,
 			}
 		} else if transaction.Type == types.PAY {
+			if resp := a.checkTransactionPin(r, user); resp != nil {
+				return resp
+			}
+
 			// check that sender has enough in wallet
 			pass := helpers.DoSufficientFundsCheck(user, transaction.Amount, transaction.Currency)
 			if !pass {
@@ -407,7 +525,7 @@ func (a *API) createTransaction(w http.ResponseWriter, r *http.Request) *ServerR
 			}
 			_, err := ses.WithTransaction(ctx, func(sesCtx mongo.SessionContext) (interface{}, error) {
 				// check that user has a wallet for currency being sent, and create if not
-				pass := helpers.DoUserWalletCheck(transaction.ToUser, transaction.Currency)
+				pass := helpers.DoUserWalletCheck(recipient, transaction.Currency)
 				if !pass {
 					// create wallet for user
 					wallet := &model.Wallet{
@@ -425,27 +543,15 @@ func (a *API) createTransaction(w http.ResponseWriter, r *http.Request) *ServerR
 
 				// create Notification
 				message := fmt.Sprintf("%s just sent %s %v to you", transaction.FromUser.UserName, transaction.Currency, transaction.Amount)
-				err := a.CreateNotification(sesCtx, transaction.ToUser.ID, types.PAYMENT_RECEIVED, message, types.ONE_PURSE_TRANSACTION, transaction.ToUser.DeviceToken, transaction)
+				err := a.CreateNotification(sesCtx, recipient.ID, types.PAYMENT_RECEIVED, message, types.ONE_PURSE_TRANSACTION, recipient.DeviceToken, transaction)
 				if err != nil {
 					return nil, err
 				}
 
-				// remove amount from sender's wallet
-				err = a.Deps.DAL.UserDAL.UpdateUser(sesCtx, transaction.FromUser.ID, bson.D{{"$inc",
-					bson.D{{
-						fmt.Sprintf("wallet.%s.available_balance", transaction.Currency), -transaction.Amount,
-					}}}})
+				// move amount from sender's wallet to receiver's wallet
+				err = a.Deps.DAL.LedgerDAL.Post(sesCtx, onePursePaymentEntry(&transaction))
 				if err != nil {
-					return nil, errors.New("unable to update sender's wallet")
-				}
-
-				//add amount to receivers wallet
-				err = a.Deps.DAL.UserDAL.UpdateUser(sesCtx, transaction.ToUser.ID, bson.D{{"$inc",
-					bson.D{{
-						fmt.Sprintf("wallet.%s.available_balance", transaction.Currency), transaction.Amount,
-					}}}})
-				if err != nil {
-					return nil, errors.New("unable to update recipient's wallet")
+					return nil, errors.Wrap(err, "unable to update wallets")
 				}
 
 				err = a.Deps.DAL.TransactionDAL.CreateOnePurseTransaction(sesCtx, &transaction)
@@ -473,25 +579,83 @@ func (a *API) createTransaction(w http.ResponseWriter, r *http.Request) *ServerR
 		if err := decodeJSONBody(&tracingContext, r.Body, &withdrawal); err != nil {
 			return RespondWithError(nil, "Failed to decode request body", http.StatusBadRequest, &tracingContext)
 		}
+		// a quote locks the fee of the amount quoted, without one the fee is that of the schedule now
+		var quote *model.Quote
+		if withdrawal.QuoteID != "" {
+			q, resp := a.quoteOf(withdrawal.QuoteID, user.ID, types.WITHDRAW, &tracingContext)
+			if resp != nil {
+				return resp
+			}
+			quote = q
+			withdrawal.BaseCurrency, withdrawal.BaseAmount = quote.FromCurrency, quote.FromAmount
+		}
 		if withdrawal.BaseCurrency == "" {
 			return RespondWithError(nil, "withdrawal currency is required", http.StatusBadRequest, &tracingContext)
 		}
-		if withdrawal.BaseAmount == 0 {
-			return RespondWithError(nil, "Withdrawal amount cannot equal 0", http.StatusBadRequest, &tracingContext)
+		if withdrawal.BaseAmount <= money.Zero {
+			return RespondWithError(nil, "Withdrawal amount must be greater than 0", http.StatusBadRequest, &tracingContext)
 		}
+		currency, resp := a.transactionCurrency(withdrawal.BaseCurrency, withdrawal.BaseAmount, &tracingContext)
+		if resp != nil {
+			return resp
+		}
+		withdrawal.BaseCurrency = currency.Code
 		if withdrawal.UserAccount == nil {
 			return RespondWithError(nil, "Destination account is required", http.StatusBadRequest, &tracingContext)
 		}
+		if quote != nil {
+			withdrawal.Fee = quote.Fee
+		} else {
+			fee, _, err := a.feeOf(context.TODO(), types.WITHDRAW, withdrawal.BaseCurrency, withdrawal.BaseCurrency, withdrawal.BaseAmount)
+			if err != nil {
+				return RespondWithError(err, "Unable to price withdrawal fee. Please try again", http.StatusInternalServerError, &tracingContext)
+			}
+			withdrawal.Fee = fee
+		}
+		total, err := withdrawal.BaseAmount.Add(withdrawal.Fee)
+		if err != nil {
+			return RespondWithError(err, "Withdrawal amount is too large", http.StatusBadRequest, &tracingContext)
+		}
 
-		pass := helpers.DoSufficientFundsCheck(user, withdrawal.BaseAmount, withdrawal.BaseCurrency)
+		if resp := a.checkTransactionPin(r, user); resp != nil {
+			return resp
+		}
+		pass := helpers.DoSufficientFundsCheck(user, total, withdrawal.BaseCurrency)
 		if !pass {
 			return RespondWithError(nil, "Insufficient funds to withdraw from", http.StatusBadRequest, &tracingContext)
 		}
+		// money leaving the platform also needs a code sent to the user's phone or email
+		code := r.Header.Get(HeaderOTP)
+		if code == "" {
+			return RespondWithError(nil, "an otp for your withdrawal is required", http.StatusBadRequest, &tracingContext)
+		}
+		if err := a.verifyOTP(r.Context(), user.ID, model.OTPPurposeWithdraw, code); err != nil {
+			return respondWithOTPError(w, err, &tracingContext)
+		}
 
 		withdrawal.CreatedAt = time.Now()
 		withdrawal.ID = cuid.New()
-		withdrawal.Status = "created"
-		err := a.Deps.DAL.TransactionDAL.CreateWithdrawal(context.TODO(), &withdrawal)
+		withdrawal.Status = txstate.Created
+		withdrawal.UserID = user.ID
+		withdrawal.History = createdHistory(user.ID, withdrawal.CreatedAt)
+		_, err = ses.WithTransaction(ctx, func(sesCtx mongo.SessionContext) (interface{}, error) {
+			if quote != nil {
+				if err := a.Deps.DAL.QuoteDAL.Use(sesCtx, quote.ID, user.ID, withdrawal.ID, time.Now()); err != nil {
+					return nil, err
+				}
+			}
+			err := a.Deps.DAL.TransactionDAL.CreateWithdrawal(sesCtx, &withdrawal)
+			if err != nil {
+				return nil, err
+			}
+
+			// hold the amount being withdrawn and the fee until it is settled to the user's account
+			account := model.UserWalletAccount(user.ID, withdrawal.BaseCurrency, model.LedgerBucketAvailable)
+			return nil, a.Deps.DAL.LedgerDAL.Post(sesCtx, holdEntry(withdrawal.ID, types.WITHDRAW, account, total))
+		})
+		if isQuoteError(err) {
+			return respondWithQuoteError(err, &tracingContext)
+		}
 		if err != nil {
 			return RespondWithError(err, "Failed to initiate withdrawal. Please try again", http.StatusBadRequest, &tracingContext)
 		}
@@ -510,16 +674,25 @@ func (a *API) createTransaction(w http.ResponseWriter, r *http.Request) *ServerR
 		if deposit.BaseCurrency == "" {
 			return RespondWithError(nil, "deposit currency is required", http.StatusBadRequest, &tracingContext)
 		}
-		if deposit.BaseAmount == 0 {
-			return RespondWithError(nil, "deposit amount is required", http.StatusBadRequest, &tracingContext)
+		if deposit.BaseAmount <= money.Zero {
+			return RespondWithError(nil, "deposit amount must be greater than 0", http.StatusBadRequest, &tracingContext)
+		}
+		currency, resp := a.transactionCurrency(deposit.BaseCurrency, deposit.BaseAmount, &tracingContext)
+		if resp != nil {
+			return resp
 		}
+		deposit.BaseCurrency = currency.Code
 		if deposit.PaymentChannel == "" {
 			return RespondWithError(nil, "payment channel is required", http.StatusBadRequest, &tracingContext)
 		}
-		deposit.Status = "created"
+		if err := currency.CheckPaymentChannel(deposit.PaymentChannel); err != nil {
+			return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+		}
+		deposit.Status = txstate.Created
 		deposit.ID = cuid.New()
 		deposit.CreatedAt = time.Now()
 		deposit.UserID = user.ID
+		deposit.History = createdHistory(user.ID, deposit.CreatedAt)
 
 		err := a.Deps.DAL.TransactionDAL.CreateDeposit(context.TODO(), &deposit)
 		if err != nil {
@@ -537,11 +710,20 @@ func (a *API) createTransaction(w http.ResponseWriter, r *http.Request) *ServerR
 		if err := decodeJSONBody(&tracingContext, r.Body, &exchange); err != nil {
 			return RespondWithError(nil, "Failed to decode request body", http.StatusBadRequest, &tracingContext)
 		}
-		if exchange.BaseAmount == 0 || exchange.BaseCurrency == "" {
-			return RespondWithError(nil, "base amount and currency is required", http.StatusBadRequest, &tracingContext)
+		// the amounts are those of the quote, not the client's
+		quote, resp := a.quoteOf(exchange.QuoteID, user.ID, types.EXCHANGE, &tracingContext)
+		if resp != nil {
+			return resp
+		}
+		exchange.BaseCurrency, exchange.BaseAmount, exchange.Fee = quote.FromCurrency, quote.FromAmount, quote.Fee
+		exchange.ExchangeCurrency, exchange.ExchangeAmount, exchange.Margin = quote.ToCurrency, quote.ToAmount, quote.Margin
+		baseCurrency, resp := a.transactionCurrency(exchange.BaseCurrency, exchange.BaseAmount, &tracingContext)
+		if resp != nil {
+			return resp
 		}
-		if exchange.ExchangeAmount == 0 || exchange.ExchangeCurrency == "" {
-			return RespondWithError(nil, "exchange amount and currency is required", http.StatusBadRequest, &tracingContext)
+		exchangeCurrency, resp := a.transactionCurrency(exchange.ExchangeCurrency, exchange.ExchangeAmount, &tracingContext)
+		if resp != nil {
+			return resp
 		}
 		if exchange.IsCryptoExchange == false && exchange.PaymentChannel == "" {
 			return RespondWithError(nil, "agent account and payment channel is required for fiat exchange", http.StatusBadRequest, &tracingContext)
@@ -549,17 +731,52 @@ func (a *API) createTransaction(w http.ResponseWriter, r *http.Request) *ServerR
 		if exchange.IsCryptoExchange == true && (exchange.BlockchainChannel == "" || exchange.CryptoWalletAddress == "") {
 			return RespondWithError(nil, "blockchain channel and crypto wallet address is required for crypto exchange", http.StatusBadRequest, &tracingContext)
 		}
+		if exchange.IsCryptoExchange {
+			// the crypto side of the exchange is sent over the blockchain channel
+			crypto := exchangeCurrency
+			if !crypto.IsCrypto() {
+				crypto = baseCurrency
+			}
+			if err := crypto.CheckBlockchainNetwork(exchange.BlockchainChannel); err != nil {
+				return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+			}
+		} else if err := exchangeCurrency.CheckPaymentChannel(exchange.PaymentChannel); err != nil {
+			return RespondWithError(err, err.Error(), http.StatusBadRequest, &tracingContext)
+		}
 
-		pass := helpers.DoSufficientFundsCheck(user, exchange.BaseAmount, exchange.BaseCurrency)
+		total, err := exchange.BaseAmount.Add(exchange.Fee)
+		if err != nil {
+			return RespondWithError(err, "Exchange amount is too large", http.StatusBadRequest, &tracingContext)
+		}
+		if resp := a.checkTransactionPin(r, user); resp != nil {
+			return resp
+		}
+		pass := helpers.DoSufficientFundsCheck(user, total, exchange.BaseCurrency)
 		if !pass {
 			return RespondWithError(nil, "insufficient funds to transfer from. Top-up Wallet", http.StatusBadRequest, &tracingContext)
 		}
 
 		exchange.CreatedAt = time.Now()
 		exchange.ID = cuid.New()
-		exchange.Status = "initiated"
+		exchange.Status = txstate.Created
 		exchange.UserID = user.ID
-		err := a.Deps.DAL.TransactionDAL.CreateExchange(context.TODO(), &exchange)
+		exchange.History = createdHistory(user.ID, exchange.CreatedAt)
+		_, err = ses.WithTransaction(ctx, func(sesCtx mongo.SessionContext) (interface{}, error) {
+			if err := a.Deps.DAL.QuoteDAL.Use(sesCtx, quote.ID, user.ID, exchange.ID, time.Now()); err != nil {
+				return nil, err
+			}
+			err := a.Deps.DAL.TransactionDAL.CreateExchange(sesCtx, &exchange)
+			if err != nil {
+				return nil, err
+			}
+
+			// hold the amount being exchanged and the fee until the exchange is completed or cancelled
+			account := model.UserWalletAccount(user.ID, exchange.BaseCurrency, model.LedgerBucketAvailable)
+			return nil, a.Deps.DAL.LedgerDAL.Post(sesCtx, holdEntry(exchange.ID, types.EXCHANGE, account, total))
+		})
+		if isQuoteError(err) {
+			return respondWithQuoteError(err, &tracingContext)
+		}
 		if err != nil {
 			return RespondWithError(err, "Failed to initiate transaction. Please try again", http.StatusBadRequest, &tracingContext)
 		}
@@ -580,30 +797,36 @@ func (a *API) updateTransaction(w http.ResponseWriter, r *http.Request) *ServerR
 	transactionType := r.URL.Query().Get("transaction-type")
 	transactionId := chi.URLParam(r, "transactionID")
 
+	var update transitionRequest
+	if err := decodeJSONBody(&tracingContext, r.Body, &update); err != nil {
+		return RespondWithError(nil, "Failed to decode request body", http.StatusBadRequest, &tracingContext)
+	}
+	if update.Status == "" {
+		return RespondWithError(nil, "status is required", http.StatusBadRequest, &tracingContext)
+	}
+	if txstate.SystemOnly(transactionType, update.Status) {
+		return RespondWithError(nil, fmt.Sprintf("a transaction cannot be moved to %s by request", update.Status), http.StatusForbidden, &tracingContext)
+	}
+	principal := principalOf(r)
+
 	switch transactionType {
 	case types.TRANSFER:
-		var transfer model.Transfer
-
-		if err := decodeJSONBody(&tracingContext, r.Body, &transfer); err != nil {
-			return RespondWithError(nil, "Failed to decode request body", http.StatusInternalServerError, &tracingContext)
-		}
-
-		doc, err := helpers.MarshalStructToBSONDoc(transfer)
-		if err != nil {
-			return RespondWithError(err, "Failed to marshal to bson document", http.StatusInternalServerError, &tracingContext)
-		}
-
-		tempTransfer, err := a.Deps.DAL.TransactionDAL.GetTransferByID(context.TODO(), transactionId)
+		transfer, err := a.Deps.DAL.TransactionDAL.GetTransferByID(context.TODO(), transactionId)
 		if err != nil {
 			return RespondWithError(err, "error fetching transfer information", http.StatusBadRequest, &tracingContext)
 		}
-		if tempTransfer.Status == "completed" {
-			// No update should be undertaken
-			return RespondWithError(nil, "cannot update a completed transaction", http.StatusForbidden, &tracingContext)
+		if !principal.CanAccess(model.TRANSACTION, transfer.UserID, transfer.AgentID) {
+			return RespondWithError(nil, "you are not a party to this transaction", http.StatusForbidden, &tracingContext)
+		}
+		if err := txstate.Check(types.TRANSFER, transfer.Status, update.Status); err != nil {
+			return RespondWithError(err, err.Error(), http.StatusConflict, &tracingContext)
+		}
+		actor := partyOf(r, transfer.UserID, transfer.AgentID)
+		if err := txstate.Authorize(types.TRANSFER, transfer.Status, update.Status, actor.Party); err != nil {
+			return RespondWithError(err, err.Error(), http.StatusForbidden, &tracingContext)
 		}
 
-		transfer.UpdatedAt = time.Now()
-		err = a.Deps.DAL.TransactionDAL.UpdateTransfer(context.TODO(), transactionId, doc)
+		err = a.transitionTransfer(context.TODO(), transfer, update.Status, actor, update.Reason, update.fields())
 		if err != nil {
 			return RespondWithError(err, "unable to update transfer information", http.StatusInternalServerError, &tracingContext)
 		}
@@ -615,28 +838,22 @@ func (a *API) updateTransaction(w http.ResponseWriter, r *http.Request) *ServerR
 			Payload: response,
 		}
 	case types.WITHDRAW:
-		var withdrawal model.Withdrawal
-
-		if err := decodeJSONBody(&tracingContext, r.Body, &withdrawal); err != nil {
-			return RespondWithError(nil, "Failed to decode request body", http.StatusInternalServerError, &tracingContext)
-		}
-
-		doc, err := helpers.MarshalStructToBSONDoc(withdrawal)
-		if err != nil {
-			return RespondWithError(err, "Failed to marshal to bson document", http.StatusInternalServerError, &tracingContext)
-		}
-
-		tempWithdrawal, err := a.Deps.DAL.TransactionDAL.GetWithdrawalByID(context.TODO(), transactionId)
+		withdrawal, err := a.Deps.DAL.TransactionDAL.GetWithdrawalByID(context.TODO(), transactionId)
 		if err != nil {
 			return RespondWithError(err, "error fetching withdrawal information", http.StatusBadRequest, &tracingContext)
 		}
-		if tempWithdrawal.Status == "completed" {
-			return RespondWithError(nil, "cannot update a completed transaction", http.StatusForbidden, &tracingContext)
-
+		if !principal.CanAccess(model.TRANSACTION, withdrawal.UserID) {
+			return RespondWithError(nil, "you are not a party to this transaction", http.StatusForbidden, &tracingContext)
+		}
+		if err := txstate.Check(types.WITHDRAW, withdrawal.Status, update.Status); err != nil {
+			return RespondWithError(err, err.Error(), http.StatusConflict, &tracingContext)
+		}
+		actor := partyOf(r, withdrawal.UserID)
+		if err := txstate.Authorize(types.WITHDRAW, withdrawal.Status, update.Status, actor.Party); err != nil {
+			return RespondWithError(err, err.Error(), http.StatusForbidden, &tracingContext)
 		}
 
-		withdrawal.UpdatedAt = time.Now()
-		err = a.Deps.DAL.TransactionDAL.UpdateWithdrawal(context.TODO(), transactionId, doc)
+		err = a.transitionWithdrawal(context.TODO(), withdrawal, update.Status, actor, update.Reason, update.fields())
 		if err != nil {
 			return RespondWithError(err, "unable to update withdrawal information", http.StatusInternalServerError, &tracingContext)
 		}
@@ -648,26 +865,22 @@ func (a *API) updateTransaction(w http.ResponseWriter, r *http.Request) *ServerR
 			Payload: response,
 		}
 	case types.DEPOSIT:
-		var deposit model.Deposit
-		if err := decodeJSONBody(&tracingContext, r.Body, &deposit); err != nil {
-			return RespondWithError(nil, "Failed to decode request body", http.StatusInternalServerError, &tracingContext)
-		}
-
-		doc, err := helpers.MarshalStructToBSONDoc(deposit)
-		if err != nil {
-			return RespondWithError(err, "Failed to marshal to bson document", http.StatusInternalServerError, &tracingContext)
-		}
-
-		tempDeposit, err := a.Deps.DAL.TransactionDAL.GetDepositByID(context.TODO(), transactionId)
+		deposit, err := a.Deps.DAL.TransactionDAL.GetDepositByID(context.TODO(), transactionId)
 		if err != nil {
 			return RespondWithError(err, "error fetching deposit information", http.StatusBadRequest, &tracingContext)
 		}
-		if tempDeposit.Status == "completed" {
-			return RespondWithError(nil, "cannot update a completed transaction", http.StatusForbidden, &tracingContext)
+		if !principal.CanAccess(model.TRANSACTION, deposit.UserID, deposit.AgentID) {
+			return RespondWithError(nil, "you are not a party to this transaction", http.StatusForbidden, &tracingContext)
+		}
+		if err := txstate.Check(types.DEPOSIT, deposit.Status, update.Status); err != nil {
+			return RespondWithError(err, err.Error(), http.StatusConflict, &tracingContext)
+		}
+		actor := partyOf(r, deposit.UserID, deposit.AgentID)
+		if err := txstate.Authorize(types.DEPOSIT, deposit.Status, update.Status, actor.Party); err != nil {
+			return RespondWithError(err, err.Error(), http.StatusForbidden, &tracingContext)
 		}
 
-		deposit.UpdatedAt = time.Now()
-		err = a.Deps.DAL.TransactionDAL.UpdateDeposit(context.TODO(), transactionId, doc)
+		err = a.transitionDeposit(context.TODO(), deposit, update.Status, actor, update.Reason, update.fields())
 		if err != nil {
 			return RespondWithError(err, "unable to update deposit information", http.StatusInternalServerError, &tracingContext)
 		}
@@ -679,26 +892,29 @@ func (a *API) updateTransaction(w http.ResponseWriter, r *http.Request) *ServerR
 			Payload: response,
 		}
 	case types.EXCHANGE:
-		var exchange model.Exchange
-
-		if err := decodeJSONBody(&tracingContext, r.Body, &exchange); err != nil {
-			return RespondWithError(nil, "Failed to decode request body", http.StatusInternalServerError, &tracingContext)
-		}
-
-		doc, err := helpers.MarshalStructToBSONDoc(exchange)
-		if err != nil {
-			return RespondWithError(err, "Failed to marshal to bson document", http.StatusInternalServerError, &tracingContext)
-		}
-		tempExchange, err := a.Deps.DAL.TransactionDAL.GetExchangeByID(context.TODO(), transactionId)
+		exchange, err := a.Deps.DAL.TransactionDAL.GetExchangeByID(context.TODO(), transactionId)
 		if err != nil {
 			return RespondWithError(err, "error fetching exchange information", http.StatusForbidden, &tracingContext)
 		}
-		if tempExchange.Status == "completed" {
-			return RespondWithError(nil, "cannot update a completed transaction", http.StatusForbidden, &tracingContext)
+		if !principal.CanAccess(model.TRANSACTION, exchange.UserID, exchange.AgentID, exchange.MatchedUserID) {
+			return RespondWithError(nil, "you are not a party to this transaction", http.StatusForbidden, &tracingContext)
+		}
+		if err := txstate.Check(types.EXCHANGE, exchange.Status, update.Status); err != nil {
+			return RespondWithError(err, err.Error(), http.StatusConflict, &tracingContext)
+		}
+		actor := partyOf(r, exchange.UserID, exchange.AgentID, exchange.MatchedUserID)
+		if err := txstate.Authorize(types.EXCHANGE, exchange.Status, update.Status, actor.Party); err != nil {
+			return RespondWithError(err, err.Error(), http.StatusForbidden, &tracingContext)
 		}
 
-		exchange.UpdatedAt = time.Now()
-		err = a.Deps.DAL.TransactionDAL.UpdateExchange(context.TODO(), transactionId, doc)
+		fields := update.fields()
+		switch update.Status {
+		case txstate.Cancelled:
+			fields = append(fields, bson.E{Key: "reason_for_cancelling", Value: update.Reason})
+		case txstate.Disputed:
+			fields = append(fields, bson.E{Key: "reason_for_dispute", Value: update.Reason})
+		}
+		err = a.transitionExchange(context.TODO(), exchange, update.Status, actor, update.Reason, fields)
 		if err != nil {
 			return RespondWithError(err, "unable to update exchange information", http.StatusInternalServerError, &tracingContext)
 		}
@@ -805,6 +1021,12 @@ func (a *API) getAgentForTransaction(w http.ResponseWriter, r *http.Request) *Se
 		if err != nil {
 			return RespondWithError(err, "could not fetch exchange information", http.StatusInternalServerError, &tracingContext)
 		}
+		if !principalOf(r).Owns(transfer.UserID) {
+			return RespondWithError(nil, "you are not a party to this transaction", http.StatusForbidden, &tracingContext)
+		}
+		if err := txstate.Check(types.TRANSFER, transfer.Status, txstate.Matched); err != nil {
+			return RespondWithError(err, err.Error(), http.StatusConflict, &tracingContext)
+		}
 		user, err := a.Deps.DAL.UserDAL.FindByID(context.TODO(), transfer.UserID)
 		if err != nil {
 			return RespondWithError(err, "could not fetch user information", http.StatusInternalServerError, &tracingContext)
@@ -817,13 +1039,8 @@ func (a *API) getAgentForTransaction(w http.ResponseWriter, r *http.Request) *Se
 			if err != nil {
 				return nil, err
 			}
-			err = a.Deps.DAL.AgentDAL.Update(sesCtx, agent.ID, bson.D{{"$inc", bson.D{
-				{"wallet.available_balance", -transfer.BaseAmount},
-				{"wallet.pending_balance", transfer.BaseAmount}}}})
-			if err != nil {
-				return nil, err
-			}
-			err = a.Deps.DAL.TransactionDAL.UpdateTransfer(sesCtx, transactionId, bson.D{{"agent_account", agent}})
+			transfer.AgentID = agent.ID
+			err = a.transitionTransfer(sesCtx, transfer, txstate.Matched, matchActor(r), "", bson.D{{"agent_id", agent.ID}})
 			if err != nil {
 				return nil, err
 			}
@@ -849,10 +1066,23 @@ func (a *API) getAgentForTransaction(w http.ResponseWriter, r *http.Request) *Se
 		if err != nil {
 			return RespondWithError(err, "could not fetch exchange information", http.StatusInternalServerError, &tracingContext)
 		}
+		if !principalOf(r).Owns(exchange.UserID) {
+			return RespondWithError(nil, "you are not a party to this transaction", http.StatusForbidden, &tracingContext)
+		}
+		if err := txstate.Check(types.EXCHANGE, exchange.Status, txstate.Matched); err != nil {
+			return RespondWithError(err, err.Error(), http.StatusConflict, &tracingContext)
+		}
 
 		if exchange.BaseCurrency == "USD" {
-			query := bson.D{{"$gte", bson.D{{
-				"wallet.USD.available_balance", exchange.BaseAmount}}}}
+			// the peer holds the exchange currency and the margin when matched, and cannot be the user themselves
+			held, err := exchange.ExchangeAmount.Add(exchange.Margin)
+			if err != nil {
+				return RespondWithError(err, "exchange amount is too large", http.StatusBadRequest, &tracingContext)
+			}
+			query := bson.D{
+				{"_id", bson.D{{"$ne", exchange.UserID}}},
+				{fmt.Sprintf("wallet.%s.available_balance", exchange.ExchangeCurrency), bson.D{{"$gte", held}}},
+			}
 
 			// start transaction
 			result, err := ses.WithTransaction(ctx, func(sesCtx mongo.SessionContext) (interface{}, error) {
@@ -861,9 +1091,8 @@ func (a *API) getAgentForTransaction(w http.ResponseWriter, r *http.Request) *Se
 					return nil, err
 				}
 
-				err = a.Deps.DAL.UserDAL.UpdateUser(sesCtx, user.ID, bson.D{{"$inc", bson.D{{
-					fmt.Sprintf("wallet.%s.available_balance", exchange.ExchangeCurrency), -exchange.ExchangeAmount,
-				}, {fmt.Sprintf("wallet.%s.pending_balance", exchange.ExchangeCurrency), exchange.ExchangeAmount}}}})
+				exchange.MatchedUserID = user.ID
+				err = a.transitionExchange(sesCtx, exchange, txstate.Matched, matchActor(r), "", bson.D{{"matched_user_id", user.ID}})
 				if err != nil {
 					return nil, err
 				}
@@ -883,9 +1112,16 @@ func (a *API) getAgentForTransaction(w http.ResponseWriter, r *http.Request) *Se
 				Payload: result,
 			}
 		} else {
-			query := bson.D{{"$gte", bson.D{{
-				fmt.Sprintf("wallet.available_balance"), exchange.BaseAmount}}},
-				{"wallet.currency", exchange.BaseCurrency}}
+			// the agent holds the exchange currency and the margin, and the platform's fx account takes the base
+			// currency, so the agent must trade the exchange currency
+			held, err := exchange.ExchangeAmount.Add(exchange.Margin)
+			if err != nil {
+				return RespondWithError(err, "exchange amount is too large", http.StatusBadRequest, &tracingContext)
+			}
+			query := bson.D{
+				{"wallet.currency", exchange.ExchangeCurrency},
+				{"wallet.available_balance", bson.D{{"$gte", held}}},
+			}
 
 			//start transaction
 			result, err := ses.WithTransaction(ctx, func(sesCtx mongo.SessionContext) (interface{}, error) {
@@ -894,10 +1130,8 @@ func (a *API) getAgentForTransaction(w http.ResponseWriter, r *http.Request) *Se
 					return nil, err
 				}
 
-				err = a.Deps.DAL.UserDAL.UpdateUser(sesCtx, agent.ID, bson.D{{"$inc", bson.D{
-					{fmt.Sprintf("wallet.%s.available_balance", exchange.ExchangeCurrency), -exchange.ExchangeAmount},
-					{fmt.Sprintf("wallet.%s.pending_balance", exchange.ExchangeCurrency), exchange.ExchangeAmount},
-				}}})
+				exchange.AgentID = agent.ID
+				err = a.transitionExchange(sesCtx, exchange, txstate.Matched, matchActor(r), "", bson.D{{"agent_id", agent.ID}})
 				if err != nil {
 					return nil, err
 				}
@@ -925,6 +1159,12 @@ func (a *API) getAgentForTransaction(w http.ResponseWriter, r *http.Request) *Se
 		if err != nil {
 			return RespondWithError(err, "could not fetch deposit information", http.StatusInternalServerError, &tracingContext)
 		}
+		if !principalOf(r).Owns(deposit.UserID) {
+			return RespondWithError(nil, "you are not a party to this transaction", http.StatusForbidden, &tracingContext)
+		}
+		if err := txstate.Check(types.DEPOSIT, deposit.Status, txstate.Matched); err != nil {
+			return RespondWithError(err, err.Error(), http.StatusConflict, &tracingContext)
+		}
 		query := bson.D{{"$gte", bson.D{{
 			fmt.Sprintf("wallet.available_balance"), deposit.BaseAmount}}},
 			{"wallet.currency", deposit.BaseCurrency}}
@@ -932,6 +1172,11 @@ func (a *API) getAgentForTransaction(w http.ResponseWriter, r *http.Request) *Se
 		if err != nil {
 			return RespondWithError(err, "unable to find an agent for your transaction right now", http.StatusInternalServerError, &tracingContext)
 		}
+		deposit.AgentID = agent.ID
+		err = a.transitionDeposit(context.TODO(), deposit, txstate.Matched, matchActor(r), "", bson.D{{"agent_id", agent.ID}})
+		if err != nil {
+			return RespondWithError(err, "unable to assign agent to your transaction", http.StatusInternalServerError, &tracingContext)
+		}
 		return &ServerResponse{
 			Payload: agent,
 		}
@@ -944,35 +1189,47 @@ func (a *API) getAgentForTransaction(w http.ResponseWriter, r *http.Request) *Se
 
 // OTP Token Routes
 
+// generateOTPToken sends the user a one time code for the purpose in the query, by sms unless the channel is email
 func (a *API) generateOTPToken(w http.ResponseWriter, r *http.Request) *ServerResponse {
 	userID := chi.URLParam(r, "userID")
 	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	purpose := r.URL.Query().Get("purpose")
+	if purpose == "" {
+		purpose = model.OTPPurposeChangePin
+	}
+	if _, ok := otpPurposes[purpose]; !ok || purpose == model.OTPPurposeAdminAction {
+		return RespondWithError(nil, "otp purpose is not supported", http.StatusBadRequest, &tracingContext)
+	}
 	user, err := a.Deps.DAL.UserDAL.FindByID(context.TODO(), userID)
 	if err != nil {
 		return RespondWithError(err, "could not find user", http.StatusBadRequest, &tracingContext)
 	}
 
-	token, err := helpers.CreateOTPCode(userID)
-	if err != nil {
-		return RespondWithError(err, "unable to generate otp token", http.StatusInternalServerError, &tracingContext)
-	}
-	err = a.Deps.DAL.UserDAL.UpdateUser(context.TODO(), userID, bson.D{{"$set", bson.D{{"otp_token", token}}}})
-	if err != nil {
-		return RespondWithError(err, "unable to update user token", http.StatusInternalServerError, &tracingContext)
+	channel := r.URL.Query().Get("channel")
+	var destination string
+	switch channel {
+	case "", model.OTPChannelSMS:
+		if user.PhoneNumber == "" {
+			return RespondWithError(nil, "Please update phone number on profile screen", http.StatusBadRequest, &tracingContext)
+		}
+		channel, destination = model.OTPChannelSMS, user.PhoneNumber
+	case model.OTPChannelEmail:
+		if user.Email == "" {
+			return RespondWithError(nil, "Please update email on profile screen", http.StatusBadRequest, &tracingContext)
+		}
+		destination = user.Email
+	default:
+		return RespondWithError(nil, "otp channel is not supported", http.StatusBadRequest, &tracingContext)
 	}
 
-	// Send OTP token to user
-	if user.PhoneNumber == "" {
-		return RespondWithError(nil, "Please update phone number on profile screen", http.StatusBadRequest, &tracingContext)
-	}
-	msg := fmt.Sprintf("Here is your OTP for changing your transaction password: %s. It expires in %s", token, time.Now().Add(30*time.Second))
-	err = a.Deps.TWILIO.SendMessage(user.PhoneNumber, msg)
+	challenge, err := a.sendOTP(r.Context(), userID, purpose, channel, destination)
 	if err != nil {
-		return RespondWithError(err, "unable to send otp to user. Please try again", http.StatusInternalServerError, &tracingContext)
+		return respondWithOTPError(w, err, &tracingContext)
 	}
 
 	response := map[string]interface{}{
-		"message": fmt.Sprintf("token sent to %s", user.PhoneNumber),
+		"message":    fmt.Sprintf("token sent to %s", destination),
+		"expires_at": challenge.ExpiresAt,
 	}
 
 	return &ServerResponse{
@@ -980,18 +1237,22 @@ func (a *API) generateOTPToken(w http.ResponseWriter, r *http.Request) *ServerRe
 	}
 }
 
+// validateOTPToken checks a code sent for the purpose in the query. A valid code is used up
 func (a *API) validateOTPToken(w http.ResponseWriter, r *http.Request) *ServerResponse {
 	userID := chi.URLParam(r, "userID")
 	passcode := r.URL.Query().Get("passcode")
+	purpose := r.URL.Query().Get("purpose")
 	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
+	if passcode == "" || purpose == "" {
+		return RespondWithError(nil, "passcode and purpose are required", http.StatusBadRequest, &tracingContext)
+	}
 	_, err := a.Deps.DAL.UserDAL.FindByID(context.TODO(), userID)
 	if err != nil {
 		return RespondWithError(err, "could not find user", http.StatusBadRequest, &tracingContext)
 	}
 
-	valid := helpers.ValidateOTPCode(userID, passcode)
-	if !valid {
-		return RespondWithError(nil, "invalid otp", http.StatusBadRequest, &tracingContext)
+	if err := a.verifyOTP(r.Context(), userID, purpose, passcode); err != nil {
+		return respondWithOTPError(w, err, &tracingContext)
 	}
 
 	response := map[string]interface{}{
@@ -1006,17 +1267,21 @@ func (a *API) validateOTPToken(w http.ResponseWriter, r *http.Request) *ServerRe
 
 func (a *API) createWallet(w http.ResponseWriter, r *http.Request) *ServerResponse {
 	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
-	walletType := r.URL.Query().Get("wallet-type")
 	userId := chi.URLParam(r, "userID")
 
-	if walletType == "" {
-		return RespondWithError(nil, "wallet type is required", http.StatusBadRequest, &tracingContext)
+	currency, resp := a.currencyOf(r.URL.Query().Get("wallet-type"), false, &tracingContext)
+	if resp != nil {
+		return resp
 	}
+	walletType := currency.Code
 
 	user, err := a.Deps.DAL.UserDAL.FindByID(context.TODO(), userId)
 	if err != nil {
 		return RespondWithError(err, "unable to fetch user", http.StatusInternalServerError, &tracingContext)
 	}
+	if helpers.DoUserWalletCheck(user, walletType) {
+		return RespondWithError(nil, fmt.Sprintf("you already have a %s wallet", walletType), http.StatusConflict, &tracingContext)
+	}
 
 	wallet := model.Wallet{
 		Currency:         walletType,
@@ -1063,13 +1328,15 @@ func (a *API) createWallet(w http.ResponseWriter, r *http.Request) *ServerRespon
 
 func (a *API) updateWallet(w http.ResponseWriter, r *http.Request) *ServerResponse {
 	tracingContext := r.Context().Value(tracing.ContextKeyTracing).(tracing.Context)
-	walletType := r.URL.Query().Get("wallet-type")
 	action := r.URL.Query().Get("action")
 	userId := chi.URLParam(r, "userID")
 
-	if walletType == "" {
-		return RespondWithError(nil, "wallet type is required", http.StatusBadRequest, &tracingContext)
+	// wallets in a disabled currency can be deactivated but not activated again
+	currency, resp := a.currencyOf(r.URL.Query().Get("wallet-type"), action == types.DEACTIVATE, &tracingContext)
+	if resp != nil {
+		return resp
 	}
+	walletType := currency.Code
 
 	switch action {
 	case types.DEACTIVATE:
@@ -2,9 +2,14 @@ package api
 
 import (
 	"context"
+	"fmt"
 	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"github.com/isongjosiah/work/onepurse-api/txstate"
+	"github.com/isongjosiah/work/onepurse-api/types"
 	"github.com/lucsky/cuid"
 	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
 	"time"
 )
 
@@ -34,7 +39,8 @@ func (a *API) CreateNotification(ctx context.Context, userID, title, message, in
 	return nil
 }
 
-// GetNumberMetrics fetches all the information required for the NumberMetrics struct
+// GetNumberMetrics fetches all the information required for the NumberMetrics struct. Transactions are counted from
+// the total rollups
 func (a *API) GetNumberMetrics(ctx context.Context) (*model.NumberMetrics, error) {
 	numUser, err := a.Deps.DAL.UserDAL.Count(ctx)
 	if err != nil {
@@ -44,25 +50,189 @@ func (a *API) GetNumberMetrics(ctx context.Context) (*model.NumberMetrics, error
 	if err != nil {
 		return nil, err
 	}
-	numTransaction, err := a.Deps.DAL.TransactionDAL.CountAll(ctx)
+	totals, err := a.Deps.DAL.RollupDAL.Fetch(ctx, bson.D{{"granularity", model.RollupTotal}})
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "unable to fetch total rollups")
+	}
+	var numTransaction int64
+	for _, total := range totals {
+		numTransaction += total.Count
 	}
 
 	metrics := model.NumberMetrics{
 		NumberOfUser:        numUser,
 		NumberOfAgent:       numAgent,
-		NumberOfTransaction: numTransaction,
+		NumberOfTransaction: int32(numTransaction),
 	}
 	return &metrics, nil
 }
 
-// GetCurrencyMetrics fetches all the information required for the CurrencyMetrics struct
+// Intervals the transaction volume can be bucketed by
+const (
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+
+	defaultVolumeDays = 30
+	maxVolumeRange    = 2 * 366 * 24 * time.Hour
+)
+
+var intervals = map[string]bool{
+	IntervalDay:   true,
+	IntervalWeek:  true,
+	IntervalMonth: true,
+}
+
+// settled reports whether the funds of the transactions in a rollup have moved. One purse payments settle as they are
+// made, everything else once completed
+func settled(rollup model.MetricsRollup) bool {
+	if rollup.Type == types.ONE_PURSE_TRANSACTION {
+		return rollup.Status == types.PAY
+	}
+	return rollup.Status == txstate.Completed
+}
+
+// settledQuery matches the rollups settled reports true for
+func settledQuery() bson.E {
+	return bson.E{Key: "$or", Value: bson.A{
+		bson.D{{"type", types.ONE_PURSE_TRANSACTION}, {"status", types.PAY}},
+		bson.D{{"type", bson.D{{"$ne", types.ONE_PURSE_TRANSACTION}}}, {"status", txstate.Completed}},
+	}}
+}
+
+// GetCurrencyMetrics fetches all the information required for the CurrencyMetrics struct from the total rollups
 func (a *API) GetCurrencyMetrics(ctx context.Context) (*model.CurrencyMetrics, error) {
-	return nil, nil
+	totals, err := a.Deps.DAL.RollupDAL.Fetch(ctx, bson.D{{"granularity", model.RollupTotal}})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch total rollups")
+	}
+
+	metrics := &model.CurrencyMetrics{
+		InApp:      newCurrencyVolume(),
+		Deposit:    newCurrencyVolume(),
+		Withdrawal: newCurrencyVolume(),
+		Canceled:   newCurrencyVolume(),
+	}
+	for _, total := range totals {
+		var err error
+		switch {
+		case settled(total) && total.Type == types.DEPOSIT:
+			err = addCurrencyVolume(metrics.Deposit, total)
+		case settled(total) && total.Type == types.WITHDRAW:
+			err = addCurrencyVolume(metrics.Withdrawal, total)
+		case settled(total):
+			err = addCurrencyVolume(metrics.InApp, total)
+		case total.Type != types.ONE_PURSE_TRANSACTION && (total.Status == txstate.Cancelled || total.Status == txstate.Expired):
+			err = addCurrencyVolume(metrics.Canceled, total)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to total currency volume")
+		}
+	}
+	return metrics, nil
+}
+
+func newCurrencyVolume() model.CurrencyVolume {
+	return model.CurrencyVolume{
+		Fiat:   make(map[string]money.Amount),
+		Crypto: make(map[string]money.Amount),
+	}
+}
+
+// addCurrencyVolume adds the volume of rollup to the fiat or crypto side of volume
+func addCurrencyVolume(volume model.CurrencyVolume, rollup model.MetricsRollup) error {
+	if money.IsCrypto(rollup.Currency) {
+		return money.AddTo(volume.Crypto, rollup.Currency, rollup.Volume)
+	}
+	return money.AddTo(volume.Fiat, rollup.Currency, rollup.Volume)
 }
 
-// TransactionVolumeMetrics fetches all the information required for the transactionMetrics struct
-func (a *API) TransactionVolumeMetrics(ctx context.Context, start, end time.Time) (*model.TransactionVolumeMetrics, error) {
-	return nil, nil
+// TransactionVolumeMetrics fetches all the information required for the transactionMetrics struct. Settled
+// transactions created from start up to end are bucketed by interval in the location of start. Daily rollups are read
+// when the range is whole UTC days and hourly ones otherwise, so timezones offset by part of an hour are bucketed to
+// the hour
+func (a *API) TransactionVolumeMetrics(ctx context.Context, start, end time.Time, interval string) (*model.TransactionVolumeMetrics, error) {
+	if !intervals[interval] {
+		return nil, errors.Errorf("unknown interval %s", interval)
+	}
+	loc := start.Location()
+
+	metrics := &model.TransactionVolumeMetrics{
+		Interval: interval,
+		Timezone: loc.String(),
+		Start:    start,
+		End:      end,
+		Values:   make(map[string]money.Amount),
+	}
+	buckets := make(map[string]*model.VolumeBucket)
+	for at := periodStart(start, interval); at.Before(end); at = nextPeriod(at, interval) {
+		metrics.Buckets = append(metrics.Buckets, model.VolumeBucket{
+			Period: periodOf(at, interval),
+			Start:  at,
+			Values: make(map[string]money.Amount),
+		})
+	}
+	for i := range metrics.Buckets {
+		buckets[metrics.Buckets[i].Period] = &metrics.Buckets[i]
+	}
+
+	granularity := model.RollupHour
+	if loc == time.UTC && start.Equal(periodStart(start, IntervalDay)) && end.Equal(periodStart(end, IntervalDay)) {
+		granularity = model.RollupDay
+	}
+	query := append(rollupScope(granularity, start, end), settledQuery())
+	rollups, err := a.Deps.DAL.RollupDAL.Fetch(ctx, query)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch transaction rollups")
+	}
+	for _, rollup := range rollups {
+		if err := money.AddTo(metrics.Values, rollup.Currency, rollup.Volume); err != nil {
+			return nil, errors.Wrap(err, "unable to total transaction volume")
+		}
+		bucket, ok := buckets[periodOf(rollup.PeriodStart.In(loc), interval)]
+		if !ok {
+			continue
+		}
+		bucket.Count += rollup.Count
+		if err := money.AddTo(bucket.Values, rollup.Currency, rollup.Volume); err != nil {
+			return nil, errors.Wrap(err, "unable to total transaction volume")
+		}
+	}
+	return metrics, nil
+}
+
+// periodStart returns the start of the interval t falls in, in the location of t. Weeks start on Monday
+func periodStart(t time.Time, interval string) time.Time {
+	year, month, day := t.Date()
+	switch interval {
+	case IntervalWeek:
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(year, month, day-offset, 0, 0, 0, 0, t.Location())
+	case IntervalMonth:
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// nextPeriod returns the start of the interval after the one starting at t
+func nextPeriod(t time.Time, interval string) time.Time {
+	switch interval {
+	case IntervalWeek:
+		return t.AddDate(0, 0, 7)
+	case IntervalMonth:
+		return t.AddDate(0, 1, 0)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+// periodOf labels the interval t falls in, e.g. 2021-12-01, 2021-W48 or 2021-12
+func periodOf(t time.Time, interval string) string {
+	switch interval {
+	case IntervalWeek:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%04d-W%02d", year, week)
+	case IntervalMonth:
+		return t.Format("2006-01")
+	}
+	return t.Format("2006-01-02")
 }
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"context"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRefreshInterval = time.Hour
+	// minRefreshInterval stops tokens with unknown key ids from making us hammer the key source
+	minRefreshInterval = 30 * time.Second
+)
+
+// KeyCache keeps the keys of a KeySource in memory. It refreshes them in the background and when a token names a key
+// it has not seen, which is how rotated keys are picked up
+type KeyCache struct {
+	source          KeySource
+	refreshInterval time.Duration
+
+	mu          sync.RWMutex
+	set         jwk.Set
+	lastAttempt time.Time
+	refreshing  sync.Mutex
+}
+
+// NewKeyCache returns a cache over source refreshed every refreshInterval
+func NewKeyCache(source KeySource, refreshInterval time.Duration) *KeyCache {
+	if refreshInterval <= 0 {
+		refreshInterval = defaultRefreshInterval
+	}
+	return &KeyCache{source: source, refreshInterval: refreshInterval}
+}
+
+// Run refreshes the keys every refresh interval until ctx is cancelled
+func (c *KeyCache) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Refresh(ctx); err != nil {
+				// the keys we have stay in use until a refresh succeeds
+				logrus.Errorf("[Auth]: unable to refresh JWKS: %s", err.Error())
+			}
+		}
+	}
+}
+
+// Refresh fetches the keys now
+func (c *KeyCache) Refresh(ctx context.Context) error {
+	return c.refresh(ctx, true)
+}
+
+// Key returns the key with the given id. ErrKeysUnavailable is returned when no keys could ever be fetched
+func (c *KeyCache) Key(ctx context.Context, kid string) (jwk.Key, error) {
+	if key, ok := c.lookup(kid); ok {
+		return key, nil
+	}
+	err := c.refresh(ctx, false)
+	if key, ok := c.lookup(kid); ok {
+		return key, nil
+	}
+	if !c.loaded() {
+		if err == nil {
+			err = ErrKeysUnavailable
+		}
+		return nil, &keysUnavailableError{err}
+	}
+	return nil, ErrUnknownKey
+}
+
+func (c *KeyCache) lookup(kid string) (jwk.Key, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.set == nil {
+		return nil, false
+	}
+	return c.set.LookupKeyID(kid)
+}
+
+func (c *KeyCache) loaded() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.set != nil
+}
+
+// refresh fetches the keys. Unless forced, it does nothing if keys were fetched in the last minRefreshInterval
+func (c *KeyCache) refresh(ctx context.Context, force bool) error {
+	c.refreshing.Lock()
+	defer c.refreshing.Unlock()
+
+	c.mu.RLock()
+	recent := time.Since(c.lastAttempt) < minRefreshInterval
+	c.mu.RUnlock()
+	if recent && !force {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	set, err := c.source.Fetch(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastAttempt = time.Now()
+	if err != nil {
+		return err
+	}
+	c.set = set
+	logrus.Infof("[Auth]: loaded %d signing keys", set.Len())
+	return nil
+}
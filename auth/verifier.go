@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"context"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jws"
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// Token uses, as found in the token_use claim of Cognito tokens
+const (
+	TokenUseAccess = "access"
+	TokenUseID     = "id"
+)
+
+const clockSkew = time.Minute
+
+var (
+	// ErrInvalidToken means the token cannot be trusted, the caller should get a 401
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrUnknownKey means the token is signed by a key the key source does not publish
+	ErrUnknownKey = errors.Wrap(ErrInvalidToken, "token signed by an unknown key")
+	// ErrKeysUnavailable means tokens cannot be verified at the moment, the caller should get a 503
+	ErrKeysUnavailable = errors.New("signing keys are unavailable")
+)
+
+type keysUnavailableError struct {
+	cause error
+}
+
+func (e *keysUnavailableError) Error() string {
+	return ErrKeysUnavailable.Error() + ": " + e.cause.Error()
+}
+
+func (e *keysUnavailableError) Unwrap() error {
+	return ErrKeysUnavailable
+}
+
+// allowedAlgorithms keeps tokens from choosing a weaker or symmetric algorithm than the keys are meant for
+var allowedAlgorithms = map[jwa.SignatureAlgorithm]bool{
+	jwa.RS256: true,
+	jwa.RS384: true,
+	jwa.RS512: true,
+	jwa.ES256: true,
+	jwa.ES384: true,
+	jwa.ES512: true,
+}
+
+// Verifier checks the signature and claims of tokens
+type Verifier struct {
+	Keys      *KeyCache
+	Issuer    string   // expected iss claim
+	ClientID  string   // expected aud claim of id tokens and client_id claim of access tokens
+	TokenUses []string // accepted token_use claims
+}
+
+// NewVerifier returns a verifier accepting access and id tokens issued by issuer to clientID
+func NewVerifier(keys *KeyCache, issuer, clientID string) *Verifier {
+	return &Verifier{
+		Keys:      keys,
+		Issuer:    issuer,
+		ClientID:  clientID,
+		TokenUses: []string{TokenUseAccess, TokenUseID},
+	}
+}
+
+// Verify parses a compact serialized token and checks it. Errors wrap ErrInvalidToken or ErrKeysUnavailable
+func (v *Verifier) Verify(ctx context.Context, raw string) (jwt.Token, error) {
+	msg, err := jws.ParseString(raw)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidToken, err.Error())
+	}
+	if len(msg.Signatures()) != 1 {
+		return nil, errors.Wrap(ErrInvalidToken, "token must have exactly one signature")
+	}
+	headers := msg.Signatures()[0].ProtectedHeaders()
+	alg := headers.Algorithm()
+	if !allowedAlgorithms[alg] {
+		return nil, errors.Wrapf(ErrInvalidToken, "algorithm %s is not allowed", alg)
+	}
+
+	key, err := v.Keys.Key(ctx, headers.KeyID())
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.ParseString(raw,
+		jwt.WithVerify(alg, key),
+		jwt.WithValidate(true),
+		jwt.WithAcceptableSkew(clockSkew),
+		jwt.WithIssuer(v.Issuer),
+	)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidToken, err.Error())
+	}
+	if err := v.checkUse(token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// checkUse checks the token_use claim, and the claim naming the client the token was issued to for that use
+func (v *Verifier) checkUse(token jwt.Token) error {
+	use, _ := token.Get("token_use")
+	accepted := false
+	for _, u := range v.TokenUses {
+		if use == u {
+			accepted = true
+		}
+	}
+	if !accepted {
+		return errors.Wrapf(ErrInvalidToken, "token_use %v is not accepted", use)
+	}
+
+	switch use {
+	case TokenUseAccess:
+		if clientID, _ := token.Get("client_id"); clientID != v.ClientID {
+			return errors.Wrap(ErrInvalidToken, "token was issued to another client")
+		}
+	case TokenUseID:
+		for _, aud := range token.Audience() {
+			if aud == v.ClientID {
+				return nil
+			}
+		}
+		return errors.Wrap(ErrInvalidToken, "token was issued to another audience")
+	}
+	return nil
+}
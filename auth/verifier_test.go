@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"context"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/pkg/errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://issuer.test"
+	testClientID = "client"
+)
+
+// staticKeySource publishes a fixed JWKS and counts how often it is fetched
+type staticKeySource struct {
+	mu      sync.Mutex
+	set     jwk.Set
+	err     error
+	fetches int
+}
+
+func (s *staticKeySource) Fetch(ctx context.Context) (jwk.Set, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fetches++
+	return s.set, s.err
+}
+
+func (s *staticKeySource) publish(keys ...*LocalKeys) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set, s.err = jwk.NewSet(), nil
+	for _, k := range keys {
+		key, _ := k.public.Get(0)
+		s.set.Add(key)
+	}
+}
+
+func (s *staticKeySource) fetchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+func newTestKeys(t *testing.T) *LocalKeys {
+	t.Helper()
+	keys, err := NewLocalKeys()
+	if err != nil {
+		t.Fatalf("NewLocalKeys() error = %v", err)
+	}
+	return keys
+}
+
+// signedToken signs a token for the test client expiring in expiresIn, with claims overriding the defaults
+func signedToken(t *testing.T, keys *LocalKeys, use string, expiresIn time.Duration, claims map[string]interface{}) string {
+	t.Helper()
+	token := jwt.New()
+	defaults := map[string]interface{}{
+		jwt.IssuerKey:     testIssuer,
+		jwt.SubjectKey:    "user",
+		jwt.IssuedAtKey:   time.Now().Add(-time.Minute),
+		jwt.ExpirationKey: time.Now().Add(expiresIn),
+		"token_use":       use,
+	}
+	if use == TokenUseID {
+		defaults[jwt.AudienceKey] = testClientID
+	} else {
+		defaults["client_id"] = testClientID
+	}
+	for name, value := range claims {
+		defaults[name] = value
+	}
+	for name, value := range defaults {
+		if err := token.Set(name, value); err != nil {
+			t.Fatalf("unable to set %s: %v", name, err)
+		}
+	}
+	signed, err := keys.Sign(token)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	return signed
+}
+
+func TestVerify(t *testing.T) {
+	keys := newTestKeys(t)
+	source := &staticKeySource{}
+	source.publish(keys)
+	verifier := NewVerifier(NewKeyCache(source, time.Hour), testIssuer, testClientID)
+
+	tests := []struct {
+		name      string
+		use       string
+		expiresIn time.Duration
+		claims    map[string]interface{}
+		wantErr   bool
+	}{
+		{name: "access token", use: TokenUseAccess, expiresIn: time.Hour},
+		{name: "id token", use: TokenUseID, expiresIn: time.Hour},
+		{name: "expired within the clock skew", use: TokenUseAccess, expiresIn: -clockSkew / 2},
+		{name: "expired", use: TokenUseAccess, expiresIn: -2 * clockSkew, wantErr: true},
+		{name: "other issuer", use: TokenUseAccess, expiresIn: time.Hour, claims: map[string]interface{}{jwt.IssuerKey: "https://other.test"}, wantErr: true},
+		{name: "id token for another audience", use: TokenUseID, expiresIn: time.Hour, claims: map[string]interface{}{jwt.AudienceKey: "other"}, wantErr: true},
+		{name: "access token for another client", use: TokenUseAccess, expiresIn: time.Hour, claims: map[string]interface{}{"client_id": "other"}, wantErr: true},
+		{name: "access token naming the client as audience only", use: TokenUseAccess, expiresIn: time.Hour, claims: map[string]interface{}{"client_id": "", jwt.AudienceKey: testClientID}, wantErr: true},
+		{name: "refresh token use", use: "refresh", expiresIn: time.Hour, wantErr: true},
+		{name: "no token use", use: "", expiresIn: time.Hour, wantErr: true},
+	}
+	for _, tt := range tests {
+		token, err := verifier.Verify(context.Background(), signedToken(t, keys, tt.use, tt.expiresIn, tt.claims))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Verify() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil && !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: Verify() error = %v, want it to wrap %v", tt.name, err, ErrInvalidToken)
+		}
+		if err == nil && token.Subject() != "user" {
+			t.Errorf("%s: Verify() subject = %s, want user", tt.name, token.Subject())
+		}
+	}
+
+	if _, err := verifier.Verify(context.Background(), "not a token"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify() of garbage error = %v, want %v", err, ErrInvalidToken)
+	}
+	// a token signed by a key the source does not publish, but naming the key id of one it does
+	forged := newTestKeys(t)
+	kid, _ := keys.private.Get(jwk.KeyIDKey)
+	if err := forged.private.Set(jwk.KeyIDKey, kid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.Verify(context.Background(), signedToken(t, forged, TokenUseAccess, time.Hour, nil)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Verify() of a forged token error = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+func TestKeyCacheRefetchesUnknownKeys(t *testing.T) {
+	current, rotated := newTestKeys(t), newTestKeys(t)
+	source := &staticKeySource{}
+	source.publish(current)
+	cache := NewKeyCache(source, time.Hour)
+	verifier := NewVerifier(cache, testIssuer, testClientID)
+
+	if _, err := verifier.Verify(context.Background(), signedToken(t, current, TokenUseAccess, time.Hour, nil)); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if source.fetchCount() != 1 {
+		t.Fatalf("source fetched %d times, want 1", source.fetchCount())
+	}
+
+	// the key is rotated after the cache last refreshed
+	source.publish(current, rotated)
+	cache.lastAttempt = time.Now().Add(-minRefreshInterval)
+	if _, err := verifier.Verify(context.Background(), signedToken(t, rotated, TokenUseAccess, time.Hour, nil)); err != nil {
+		t.Fatalf("Verify() of a token signed by a rotated key error = %v", err)
+	}
+	if source.fetchCount() != 2 {
+		t.Errorf("source fetched %d times, want a refetch for the unknown key", source.fetchCount())
+	}
+	if _, err := verifier.Verify(context.Background(), signedToken(t, current, TokenUseAccess, time.Hour, nil)); err != nil || source.fetchCount() != 2 {
+		t.Errorf("Verify() of a known key = %v after %d fetches, want it verified from the cache", err, source.fetchCount())
+	}
+}
+
+func TestKeyCacheRefetchRateLimit(t *testing.T) {
+	keys, unknown := newTestKeys(t), newTestKeys(t)
+	source := &staticKeySource{}
+	source.publish(keys)
+	cache := NewKeyCache(source, time.Hour)
+	verifier := NewVerifier(cache, testIssuer, testClientID)
+	if err := cache.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+
+	token := signedToken(t, unknown, TokenUseAccess, time.Hour, nil)
+	for i := 0; i < 5; i++ {
+		if _, err := verifier.Verify(context.Background(), token); !errors.Is(err, ErrUnknownKey) {
+			t.Fatalf("Verify() of an unknown key error = %v, want %v", err, ErrUnknownKey)
+		}
+	}
+	if source.fetchCount() != 1 {
+		t.Errorf("source fetched %d times within the rate limit, want 1", source.fetchCount())
+	}
+
+	cache.lastAttempt = time.Now().Add(-minRefreshInterval)
+	if _, err := verifier.Verify(context.Background(), token); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Verify() of an unknown key error = %v, want %v", err, ErrUnknownKey)
+	}
+	if source.fetchCount() != 2 {
+		t.Errorf("source fetched %d times once the rate limit passed, want 2", source.fetchCount())
+	}
+
+	// forced refreshes, like the background one, are not rate limited
+	if err := cache.Refresh(context.Background()); err != nil || source.fetchCount() != 3 {
+		t.Errorf("Refresh() = %v after %d fetches, want 3", err, source.fetchCount())
+	}
+}
+
+func TestKeyCacheUnavailable(t *testing.T) {
+	keys := newTestKeys(t)
+	source := &staticKeySource{err: errors.New("connection refused")}
+	cache := NewKeyCache(source, time.Hour)
+	verifier := NewVerifier(cache, testIssuer, testClientID)
+
+	token := signedToken(t, keys, TokenUseAccess, time.Hour, nil)
+	if _, err := verifier.Verify(context.Background(), token); !errors.Is(err, ErrKeysUnavailable) || errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Verify() without keys error = %v, want %v", err, ErrKeysUnavailable)
+	}
+
+	// the keys come back once the source does, after the rate limit
+	source.publish(keys)
+	if _, err := verifier.Verify(context.Background(), token); !errors.Is(err, ErrKeysUnavailable) {
+		t.Errorf("Verify() within the rate limit error = %v, want %v", err, ErrKeysUnavailable)
+	}
+	cache.lastAttempt = time.Now().Add(-minRefreshInterval)
+	if _, err := verifier.Verify(context.Background(), token); err != nil {
+		t.Errorf("Verify() once the source recovered error = %v", err)
+	}
+}
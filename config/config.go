@@ -1,10 +1,12 @@
 package config
 
 import (
+	"fmt"
 	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/caarlos0/env.v2"
 	"log"
+	"strings"
 	"time"
 )
 
@@ -32,13 +34,13 @@ const (
 
 type Config struct {
 	ServiceName               string
-	AWSRegion                 string `env:"AWS_REGION" required:"true"`
-	S3Bucket                  string `env:"S3_BUCKET" required:"true"`
+	AWSRegion                 string `env:"AWS_REGION"` // required with the cognito identity provider, uploads and push notifications are unavailable without it
+	S3Bucket                  string `env:"S3_BUCKET"`
 	Port                      int    `env:"PORT" required:"true"`
-	CognitoUserPoolID         string `env:"COGNITO_USER_POOL_ID" required:"true"`
-	CognitoAppClientID        string `env:"COGNITO_APP_CLIENT_ID" required:"true"`
-	CognitoAppClientSecret    string `env:"COGNITO_APP_CLIENT_SECRET" required:"true"`
-	SNSPlatformApplicationArn string `env:"SNS_PLATFORM_APPLICATION_ARN" required:"true"`
+	CognitoUserPoolID         string `env:"COGNITO_USER_POOL_ID"`      // required with the cognito identity provider
+	CognitoAppClientID        string `env:"COGNITO_APP_CLIENT_ID"`     // required with the cognito identity provider
+	CognitoAppClientSecret    string `env:"COGNITO_APP_CLIENT_SECRET"` // required with the cognito identity provider
+	SNSPlatformApplicationArn string `env:"SNS_PLATFORM_APPLICATION_ARN"`
 	PlaidClientId             string `env:"PLAID_CLIENT_ID" required:"true"`
 	PlaidClientName           string `env:"PLAID_CLIENT_NAME" required:"true"`
 	PlaidSecret               string `env:"PLAID_SECRET" required:"true"`
@@ -52,9 +54,72 @@ type Config struct {
 	OkraToken                 string `env:"OKRA_TOKEN" required:"true"`
 	MongoURI                  string `env:"MONGO_URI" required:"true"` // TODO: set up a database properly before production deployment
 	Environment               string `env:"ENVIRONMENT" envDefault:"development"`
+	MigrateOnStartup          bool   `env:"MIGRATE_ON_STARTUP" envDefault:"true"` // apply pending schema migrations on startup, otherwise run the migrate command
+	BackfillMetrics           bool   `env:"BACKFILL_METRICS" envDefault:"false"`  // rebuild the dashboard metrics rollups from every transaction on startup
 	Debug                     bool
+
+	// Background jobs
+	SchedulerPollInterval time.Duration `env:"SCHEDULER_POLL_INTERVAL" envDefault:"10s"` // how often the scheduler looks for due jobs
+	JobLease              time.Duration `env:"JOB_LEASE" envDefault:"5m"`                // how long a job stays leased to a replica without being renewed
+	TransactionExpiry     time.Duration `env:"TRANSACTION_EXPIRY" envDefault:"30m"`      // how long a transaction can wait in a state to be matched or paid
+	ExpiryInterval        time.Duration `env:"EXPIRY_INTERVAL" envDefault:"1m"`          // how often the expiry job looks for expired transactions
+	RollupInterval        time.Duration `env:"ROLLUP_INTERVAL" envDefault:"5m"`          // how often transactions are rolled up into the dashboard metrics
+	RollupOverlap         time.Duration `env:"ROLLUP_OVERLAP" envDefault:"5m"`           // how far before its last run the rollup job looks for changes again, to catch slow writes
+
+	// Identity
+	IdentityProvider    string        `env:"IDENTITY_PROVIDER" envDefault:"cognito"` // cognito or local
+	AuthKeySource       string        `env:"AUTH_KEY_SOURCE" envDefault:"cognito"`   // cognito, file or local. Always local with the local identity provider
+	JWKSFile            string        `env:"JWKS_FILE"`                              // JWKS read when AUTH_KEY_SOURCE is file
+	JWKSRefreshInterval time.Duration `env:"JWKS_REFRESH_INTERVAL" envDefault:"1h"`  // how often signing keys are refetched
+	TokenIssuer         string        `env:"TOKEN_ISSUER"`                           // expected iss claim, defaults to the cognito user pool or this API
+
+	// Exchange rates
+	QuoteTTL          time.Duration `env:"QUOTE_TTL" envDefault:"30s"`          // how long the price of a quote is locked for
+	RateProvider      string        `env:"RATE_PROVIDER"`                       // fixture, or empty to only set rates by hand
+	RateFixture       string        `env:"RATE_FIXTURE"`                        // file or http(s) URL the fixture provider reads rates from
+	RateFetchInterval time.Duration `env:"RATE_FETCH_INTERVAL" envDefault:"5m"` // how often rates are fetched from the provider
+	RateMaxMove       string        `env:"RATE_MAX_MOVE" envDefault:"5"`        // percent a fetched rate can move from the rate in effect before an admin has to approve it
+
+	// Agent commissions
+	CommissionSettlementInterval time.Duration `env:"COMMISSION_SETTLEMENT_INTERVAL" envDefault:"24h"` // how often accrued commissions are batched for an admin to approve
+
+	// Transaction PIN
+	PinMaxAttempts int           `env:"PIN_MAX_ATTEMPTS" envDefault:"5"` // wrong pins allowed before debits are locked
+	PinLockout     time.Duration `env:"PIN_LOCKOUT" envDefault:"30m"`    // how long debits stay locked after too many wrong pins
+
+	// OTP
+	OTPSecret         string        `env:"OTP_SECRET"`                          // key the hashes of one time codes are computed with
+	OTPExpiry         time.Duration `env:"OTP_EXPIRY" envDefault:"5m"`          // how long a one time code can be used
+	OTPMaxAttempts    int           `env:"OTP_MAX_ATTEMPTS" envDefault:"5"`     // wrong codes allowed before a code stops working
+	OTPResendInterval time.Duration `env:"OTP_RESEND_INTERVAL" envDefault:"1m"` // minimum wait before another code is sent for the same purpose
+	OTPMaxPerHour     int           `env:"OTP_MAX_PER_HOUR" envDefault:"5"`     // codes that can be sent for the same purpose in an hour
+
+	// Email
+	SMTPHost     string `env:"SMTP_HOST"` // emails are logged instead of sent when empty
+	SMTPPort     int    `env:"SMTP_PORT" envDefault:"587"`
+	SMTPUsername string `env:"SMTP_USERNAME"`
+	SMTPPassword string `env:"SMTP_PASSWORD"`
+	EmailFrom    string `env:"EMAIL_FROM" envDefault:"hello@onepurse.co"`
 }
 
+// Identity providers users can sign up and log in with
+const (
+	IdentityProviderCognito = "cognito"
+	IdentityProviderLocal   = "local" // accounts kept in mongo, for development and testing without AWS
+)
+
+// Key sources tokens can be verified against
+const (
+	KeySourceCognito = "cognito"
+	KeySourceFile    = "file"
+	KeySourceLocal   = "local" // keys generated at startup, only tokens signed by this process verify
+)
+
+// Rate providers exchange rates can be fetched from
+const (
+	RateProviderFixture = "fixture" // rates read from a file or a local stand-in server, for development and testing offline
+)
+
 // New returns a pointer to a config struct
 func New() *Config {
 	var cfg Config
@@ -68,6 +133,41 @@ func New() *Config {
 	if err := env.Parse(&cfg); err != nil {
 		log.Fatal(err.Error())
 	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err.Error())
+	}
 
 	return &cfg
 }
+
+// Validate checks that the secrets and the settings the identity provider depends on are set. AWS and cognito
+// settings are only required with the cognito provider, so the API can run offline with the local one
+func (c *Config) Validate() error {
+	if c.OTPSecret == "" {
+		return fmt.Errorf("OTP_SECRET must be set")
+	}
+
+	switch c.IdentityProvider {
+	case IdentityProviderLocal:
+		return nil
+	case IdentityProviderCognito:
+	default:
+		return fmt.Errorf("unknown IDENTITY_PROVIDER %s", c.IdentityProvider)
+	}
+
+	var missing []string
+	for _, setting := range []struct{ name, value string }{
+		{"AWS_REGION", c.AWSRegion},
+		{"COGNITO_USER_POOL_ID", c.CognitoUserPoolID},
+		{"COGNITO_APP_CLIENT_ID", c.CognitoAppClientID},
+		{"COGNITO_APP_CLIENT_SECRET", c.CognitoAppClientSecret},
+	} {
+		if setting.value == "" {
+			missing = append(missing, setting.name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s must be set with the cognito identity provider", strings.Join(missing, ", "))
+	}
+	return nil
+}
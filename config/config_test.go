@@ -0,0 +1,41 @@
+package config
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	cognito := Config{
+		OTPSecret:              "secret",
+		IdentityProvider:       IdentityProviderCognito,
+		AWSRegion:              "eu-west-1",
+		CognitoUserPoolID:      "eu-west-1_pool",
+		CognitoAppClientID:     "client",
+		CognitoAppClientSecret: "secret",
+	}
+	withoutPool := cognito
+	withoutPool.CognitoUserPoolID = ""
+	withoutAWS := cognito
+	withoutAWS.AWSRegion = ""
+	unknown := cognito
+	unknown.IdentityProvider = "okta"
+	withoutOTPSecret := cognito
+	withoutOTPSecret.OTPSecret = ""
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{name: "cognito", cfg: cognito},
+		{name: "cognito without a user pool", cfg: withoutPool, wantErr: true},
+		{name: "cognito without aws", cfg: withoutAWS, wantErr: true},
+		{name: "local without aws or cognito", cfg: Config{OTPSecret: "secret", IdentityProvider: IdentityProviderLocal}},
+		{name: "local without an otp secret", cfg: Config{IdentityProvider: IdentityProviderLocal}, wantErr: true},
+		{name: "without an otp secret", cfg: withoutOTPSecret, wantErr: true},
+		{name: "unknown provider", cfg: unknown, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
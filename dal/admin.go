@@ -8,16 +8,21 @@ import (
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
-	"log"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type IAdminDAL interface {
 	AddRole(ctx context.Context, role *model.Role) error
+	FindRole(ctx context.Context, ID string) (*model.Role, error)
+	FindRoles(ctx context.Context, query bson.D) (*[]model.Role, error)
+	UpdateRole(ctx context.Context, ID string, updateParam bson.D) error
 	DeleteRole(ctx context.Context, ID string) error
+	EnsureSuperAdminRole(ctx context.Context) error
 	AddAdmin(ctx context.Context, admin *model.Admin) error
 	FindAdmin(ctx context.Context, query bson.D) (*model.Admin, error)
 	FindAdmins(ctx context.Context, query bson.D) (*[]model.Admin, error)
 	UpdateAdmin(ctx context.Context, ID string, updateParam bson.D) error
+	AcceptTOTPStep(ctx context.Context, ID string, step int64) (bool, error)
 	DeleteAdmin(ctx context.Context, ID string) error
 }
 
@@ -37,7 +42,7 @@ func NewAdminDAL(db *mongo.Database) *AdminDAL {
 
 // AddRole ...
 func (a AdminDAL) AddRole(ctx context.Context, role *model.Role) error {
-	_, err := a.AdminCollection.InsertOne(ctx, role)
+	_, err := a.RoleCollection.InsertOne(ctx, role)
 	if err != nil {
 		if mongo.IsDuplicateKeyError(err) {
 			return errors.New("roles already exists")
@@ -47,6 +52,60 @@ func (a AdminDAL) AddRole(ctx context.Context, role *model.Role) error {
 	return nil
 }
 
+// FindRole ...
+func (a AdminDAL) FindRole(ctx context.Context, ID string) (*model.Role, error) {
+	var role model.Role
+	err := a.RoleCollection.FindOne(ctx, bson.D{{"_id", ID}}).Decode(&role)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("role record does not exist")
+		}
+		return nil, err
+	}
+	return &role, nil
+}
+
+// FindRoles ...
+func (a AdminDAL) FindRoles(ctx context.Context, query bson.D) (*[]model.Role, error) {
+	var roles []model.Role
+
+	cursor, err := a.RoleCollection.Find(ctx, query)
+	if err != nil {
+		logrus.Errorf("[Mongo]: error fetching roles: %s", err.Error())
+		return nil, err
+	}
+	if err = cursor.All(ctx, &roles); err != nil {
+		logrus.Errorf("[Mongo]: error decoding role results: %s", err.Error())
+		return nil, err
+	}
+	return &roles, nil
+}
+
+// UpdateRole ...
+func (a AdminDAL) UpdateRole(ctx context.Context, ID string, updateParam bson.D) error {
+	result, err := a.RoleCollection.UpdateByID(ctx, ID, updateParam)
+	if err != nil {
+		logrus.Errorf("[Mongo]: error updating role %s: %s", ID, err.Error())
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("role record does not exist")
+	}
+	return nil
+}
+
+// EnsureSuperAdminRole creates the super admin role, or brings its access up to date with the defined accesses
+func (a AdminDAL) EnsureSuperAdminRole(ctx context.Context) error {
+	role := model.SuperAdminRole()
+	update := bson.D{{"$set", bson.D{{"name", role.Name}, {"slug", role.Slug}, {"access", role.Access}}}}
+	_, err := a.RoleCollection.UpdateByID(ctx, role.ID, update, options.Update().SetUpsert(true))
+	if err != nil {
+		logrus.Errorf("[Mongo]: error creating super admin role: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
 // DeleteRole ...
 func (a AdminDAL) DeleteRole(ctx context.Context, ID string) error {
 	var role model.Role
@@ -111,16 +170,28 @@ func (a AdminDAL) FindAdmins(ctx context.Context, query bson.D) (*[]model.Admin,
 func (a AdminDAL) UpdateAdmin(ctx context.Context, ID string, updateParam bson.D) error {
 	result, err := a.AdminCollection.UpdateByID(ctx, ID, updateParam)
 	if err != nil {
-		log.Fatalf("[Mongo]: error update ageint %s: %s", ID, err.Error())
+		logrus.Errorf("[Mongo]: error updating admin %s: %s", ID, err.Error())
 		return err
 	}
 	if result.MatchedCount == 0 {
-		logrus.Fatalf("[Mongo]: error updating admin %s: admin record not found", err.Error())
-		return errors.New("agent record not found")
+		logrus.Errorf("[Mongo]: error updating admin %s: admin record not found", ID)
+		return errors.New("admin record not found")
 	}
 	return nil
 }
 
+// AcceptTOTPStep records step as the time step of the last authenticator code the admin used. It reports false when
+// a code from the same or a later step was already used
+func (a AdminDAL) AcceptTOTPStep(ctx context.Context, ID string, step int64) (bool, error) {
+	filter := bson.D{{"_id", ID}, {"totp_last_step", bson.D{{"$lt", step}}}}
+	result, err := a.AdminCollection.UpdateOne(ctx, filter, bson.D{{"$set", bson.D{{"totp_last_step", step}}}})
+	if err != nil {
+		logrus.Errorf("[Mongo]: error recording authenticator code of admin %s: %s", ID, err.Error())
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
 // DeleteAdmin ...
 func (a AdminDAL) DeleteAdmin(ctx context.Context, ID string) error {
 	var admin model.Admin
@@ -8,11 +8,14 @@ import (
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type IAgentDAL interface {
 	Add(ctx context.Context, agent *model.Agent) error
 	FindAll(ctx context.Context, query bson.D) (*[]model.Agent, error)
+	FindPage(ctx context.Context, query bson.D, req PageRequest) ([]model.Agent, *model.Page, error)
+	Search(ctx context.Context, filter model.ProfileFilter, req PageRequest) ([]model.Agent, *model.Page, error)
 	FindOne(ctx context.Context, query bson.D) (*model.Agent, error)
 	Update(ctx context.Context, agentID string, updateParam bson.D) error
 	Count(ctx context.Context) (int32, error)
@@ -30,6 +33,20 @@ func NewAgentDAL(db *mongo.Database) *AgentDAL {
 	}
 }
 
+// agentIndexes back the searches of the admin console, including a text index over names and contact details
+var agentIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{"full_name", 1}}},
+	{Keys: bson.D{{"username", 1}}},
+	{Keys: bson.D{{"email", 1}}},
+	{Keys: bson.D{{"phone", 1}}},
+	{Keys: bson.D{{"approved", 1}, {"_id", -1}}},
+	{Keys: bson.D{{"wallet.currency", 1}}},
+	{
+		Keys:    bson.D{{"full_name", "text"}, {"username", "text"}, {"email", "text"}, {"phone", "text"}},
+		Options: options.Index().SetName("agent_search"),
+	},
+}
+
 func (a AgentDAL) Add(ctx context.Context, agent *model.Agent) error {
 	_, err := a.Collection.InsertOne(ctx, agent)
 	if err != nil {
@@ -88,9 +105,28 @@ func (a AgentDAL) Update(ctx context.Context, agentID string, updateParam bson.D
 }
 
 func (a AgentDAL) Count(ctx context.Context) (int32, error) {
-	num, err := a.Collection.CountDocuments(ctx, bson.D{})
+	num, err := a.Collection.EstimatedDocumentCount(ctx)
 	if err != nil {
 		return 0, err
 	}
 	return int32(num), err
 }
+
+// agentSortKeys are the keys agents can be listed by. Agents have no creation time, but their ids start with it so
+// they are newest first by default
+var agentSortKeys = []string{"-_id", "full_name", "username"}
+
+// FindPage returns the page req asks for of the agents matching query
+func (a AgentDAL) FindPage(ctx context.Context, query bson.D, req PageRequest) ([]model.Agent, *model.Page, error) {
+	var agents []model.Agent
+	page, err := paginate(ctx, a.Collection, query, req, agentSortKeys, &agents)
+	if err != nil {
+		return nil, nil, err
+	}
+	return agents, page, nil
+}
+
+// Search returns the page req asks for of the agents matching filter
+func (a AgentDAL) Search(ctx context.Context, filter model.ProfileFilter, req PageRequest) ([]model.Agent, *model.Page, error) {
+	return a.FindPage(ctx, profileQuery(agentProfileFields, filter), req)
+}
@@ -0,0 +1,108 @@
+package dal
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// auditAppendRetries is how many times an entry is retried when another replica appends at the same position
+const auditAppendRetries = 5
+
+type IAuditDAL interface {
+	Append(ctx context.Context, entry *model.AuditLog) error
+	Fetch(ctx context.Context, query bson.D, req PageRequest) ([]model.AuditLog, *model.Page, error)
+	Verify(ctx context.Context) (*model.AuditLog, int64, error)
+}
+
+type AuditDAL struct {
+	DB              *mongo.Database
+	AuditCollection *mongo.Collection
+}
+
+func NewAuditDAL(db *mongo.Database) *AuditDAL {
+	return &AuditDAL{
+		DB:              db,
+		AuditCollection: db.Collection("audit-log"),
+	}
+}
+
+// auditIndexes make positions in the chain unique and index the fields the log is filtered by
+var auditIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{"seq", -1}}, Options: options.Index().SetUnique(true)},
+	{Keys: bson.D{{"actor_id", 1}, {"seq", -1}}},
+	{Keys: bson.D{{"target", 1}, {"seq", -1}}},
+	{Keys: bson.D{{"action", 1}, {"seq", -1}}},
+}
+
+// Append adds entry to the end of the chain, setting its position and hashes. Entries are never updated or removed
+func (a AuditDAL) Append(ctx context.Context, entry *model.AuditLog) error {
+	// mongo keeps milliseconds, so the time is truncated before hashing for the hash to match once read back
+	entry.CreatedAt = entry.CreatedAt.UTC().Truncate(time.Millisecond)
+
+	for attempt := 0; attempt < auditAppendRetries; attempt++ {
+		var last model.AuditLog
+		err := a.AuditCollection.FindOne(ctx, bson.D{}, options.FindOne().SetSort(bson.D{{"seq", -1}})).Decode(&last)
+		if err != nil && err != mongo.ErrNoDocuments {
+			logrus.Errorf("[Mongo]: error fetching the last audit log: %s", err.Error())
+			return err
+		}
+		entry.Seq = last.Seq + 1
+		entry.PrevHash = last.Hash
+		entry.Hash = entry.ComputeHash()
+
+		_, err = a.AuditCollection.InsertOne(ctx, entry)
+		if err == nil {
+			return nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			logrus.Errorf("[Mongo]: error appending audit log %s: %s", entry.Action, err.Error())
+			return err
+		}
+	}
+	return errors.Errorf("unable to append audit log %s after %d attempts", entry.Action, auditAppendRetries)
+}
+
+// auditSortKeys are the keys the log can be listed by, newest first by default
+var auditSortKeys = []string{"-seq"}
+
+// Fetch returns the page req asks for of the entries matching query
+func (a AuditDAL) Fetch(ctx context.Context, query bson.D, req PageRequest) ([]model.AuditLog, *model.Page, error) {
+	var logs []model.AuditLog
+	page, err := paginate(ctx, a.AuditCollection, query, req, auditSortKeys, &logs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return logs, page, nil
+}
+
+// Verify walks the chain from the start and returns the first entry whose hash or link to the previous entry does
+// not match, or nil when the chain is intact. It also returns how many entries were checked
+func (a AuditDAL) Verify(ctx context.Context) (*model.AuditLog, int64, error) {
+	cursor, err := a.AuditCollection.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{"seq", 1}}))
+	if err != nil {
+		logrus.Errorf("[Mongo]: error fetching audit logs: %s", err.Error())
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var checked int64
+	var prev model.AuditLog
+	for cursor.Next(ctx) {
+		var entry model.AuditLog
+		if err := cursor.Decode(&entry); err != nil {
+			return nil, checked, err
+		}
+		checked++
+		if entry.Seq != prev.Seq+1 || entry.PrevHash != prev.Hash || entry.Hash != entry.ComputeHash() {
+			return &entry, checked, nil
+		}
+		prev = entry
+	}
+	return nil, checked, cursor.Err()
+}
@@ -0,0 +1,82 @@
+package dal
+
+import (
+	"context"
+	"fmt"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+	"time"
+)
+
+func TestAuditVerify(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		tamper  func(a *AuditDAL) error
+		wantSeq int64 // 0 when the chain is intact
+	}{
+		{name: "intact", tamper: func(a *AuditDAL) error { return nil }},
+		{name: "edited entry", wantSeq: 3, tamper: func(a *AuditDAL) error {
+			_, err := a.AuditCollection.UpdateOne(ctx, bson.D{{"seq", 3}}, bson.D{{"$set", bson.D{{"target", "user:other"}}}})
+			return err
+		}},
+		{name: "edited entry with its hash recomputed", wantSeq: 4, tamper: func(a *AuditDAL) error {
+			var entry model.AuditLog
+			if err := a.AuditCollection.FindOne(ctx, bson.D{{"seq", 3}}).Decode(&entry); err != nil {
+				return err
+			}
+			entry.Target = "user:other"
+			_, err := a.AuditCollection.UpdateOne(ctx, bson.D{{"seq", 3}}, bson.D{{"$set", bson.D{
+				{"target", entry.Target},
+				{"hash", entry.ComputeHash()},
+			}}})
+			return err
+		}},
+		{name: "removed entry", wantSeq: 4, tamper: func(a *AuditDAL) error {
+			_, err := a.AuditCollection.DeleteOne(ctx, bson.D{{"seq", 3}})
+			return err
+		}},
+		{name: "edited first entry", wantSeq: 1, tamper: func(a *AuditDAL) error {
+			_, err := a.AuditCollection.UpdateOne(ctx, bson.D{{"seq", 1}}, bson.D{{"$set", bson.D{{"status", 200}}}})
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		a := NewAuditDAL(testDatabase(t))
+		created := time.Now()
+		for i := 1; i <= 5; i++ {
+			entry := &model.AuditLog{
+				ID:        fmt.Sprintf("entry-%d", i),
+				ActorID:   "admin",
+				Actor:     "admin:admin",
+				Action:    "PATCH /admin/user/action",
+				Target:    fmt.Sprintf("user:%d", i),
+				Status:    204,
+				Changes:   []model.AuditChange{{Field: "status", Before: `"active"`, After: `"suspended"`}},
+				CreatedAt: created.Add(time.Duration(i) * time.Second),
+			}
+			if err := a.Append(ctx, entry); err != nil {
+				t.Fatalf("%s: Append error = %v", tt.name, err)
+			}
+			if entry.Seq != int64(i) {
+				t.Fatalf("%s: appended entry %d at %d", tt.name, i, entry.Seq)
+			}
+		}
+		if err := tt.tamper(a); err != nil {
+			t.Fatalf("%s: unable to tamper with the log: %v", tt.name, err)
+		}
+
+		broken, checked, err := a.Verify(ctx)
+		if err != nil {
+			t.Errorf("%s: Verify error = %v", tt.name, err)
+			continue
+		}
+		switch {
+		case tt.wantSeq == 0 && (broken != nil || checked != 5):
+			t.Errorf("%s: Verify = %+v after %d entries, want an intact chain of 5", tt.name, broken, checked)
+		case tt.wantSeq != 0 && (broken == nil || broken.Seq != tt.wantSeq):
+			t.Errorf("%s: Verify = %+v, want the break at %d", tt.name, broken, tt.wantSeq)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package dal
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+var (
+	// ErrCommissionRuleNotFound is returned when there is no commission rule with an ID, or none matching a transaction
+	ErrCommissionRuleNotFound = errors.New("commission rule not found")
+	// ErrCommissionRuleExists is returned when a second rule is added for the same agent, transaction type and currency
+	ErrCommissionRuleExists = errors.New("a commission rule already exists for this agent, transaction type and currency, update it instead")
+	// ErrNothingToSettle is returned when a settlement run finds no accrued commission
+	ErrNothingToSettle = errors.New("there are no accrued commissions to settle")
+	// ErrSettlementBatchNotFound is returned when there is no settlement batch with an ID
+	ErrSettlementBatchNotFound = errors.New("settlement batch not found")
+	// ErrSettlementBatchReviewed is returned when a settlement batch that is no longer pending is reviewed
+	ErrSettlementBatchReviewed = errors.New("settlement batch has already been reviewed")
+	// ErrSettlementBatchOwnApproval is returned when the admin who ran a settlement tries to approve its batch
+	ErrSettlementBatchOwnApproval = errors.New("a settlement batch must be approved by an admin other than the one who ran the settlement")
+)
+
+// ICommissionDAL is the commission schedule, the commissions agents earned and the batches they are settled in
+type ICommissionDAL interface {
+	CreateRule(ctx context.Context, rule *model.CommissionRule) error
+	FindRule(ctx context.Context, ID string) (*model.CommissionRule, error)
+	FindRules(ctx context.Context, query bson.D) ([]model.CommissionRule, error)
+	UpdateRule(ctx context.Context, ID string, update bson.D) (*model.CommissionRule, error)
+	MatchRule(ctx context.Context, agentID, transactionType, currency string) (*model.CommissionRule, error)
+	Accrue(ctx context.Context, commission *model.Commission) error
+	FetchCommissions(ctx context.Context, query bson.D, req PageRequest) ([]model.Commission, *model.Page, error)
+	Earnings(ctx context.Context, query bson.D) (map[string]model.EarningsTotal, error)
+	Settle(ctx context.Context, batch *model.SettlementBatch) error
+	FetchBatches(ctx context.Context, query bson.D, req PageRequest) ([]model.SettlementBatch, *model.Page, error)
+	ReviewBatch(ctx context.Context, ID, status, reviewer, note string, at time.Time) (*model.SettlementBatch, error)
+	CloseBatch(ctx context.Context, ID string, paid bool, at time.Time) error
+}
+
+type CommissionDAL struct {
+	DB                   *mongo.Database
+	RuleCollection       *mongo.Collection
+	CommissionCollection *mongo.Collection
+	BatchCollection      *mongo.Collection
+	AgentCollection      *mongo.Collection
+}
+
+func NewCommissionDAL(db *mongo.Database) *CommissionDAL {
+	return &CommissionDAL{
+		DB:                   db,
+		RuleCollection:       db.Collection("commission-rule"),
+		CommissionCollection: db.Collection("commission"),
+		BatchCollection:      db.Collection("settlement-batch"),
+		AgentCollection:      db.Collection("agent"),
+	}
+}
+
+// commissionIndexes keep a single rule per agent, transaction type and currency and a single commission per
+// transaction, and list commissions by agent and for settlement
+var commissionIndexes = map[string][]mongo.IndexModel{
+	"commission-rule": {
+		{Keys: bson.D{{"agent_id", 1}, {"transaction_type", 1}, {"currency", 1}}, Options: options.Index().SetUnique(true)},
+	},
+	"commission": {
+		{Keys: bson.D{{"transaction_id", 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{"agent_id", 1}, {"created_at", -1}}},
+		{Keys: bson.D{{"status", 1}, {"created_at", 1}}},
+		{Keys: bson.D{{"batch_id", 1}}},
+	},
+	"settlement-batch": {
+		{Keys: bson.D{{"status", 1}, {"created_at", -1}}},
+	},
+}
+
+func (c CommissionDAL) CreateRule(ctx context.Context, rule *model.CommissionRule) error {
+	_, err := c.RuleCollection.InsertOne(ctx, rule)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrCommissionRuleExists
+		}
+		logrus.Errorf("[Mongo]: error creating commission rule %s: %s", rule.ID, err.Error())
+		return err
+	}
+	return nil
+}
+
+// FindRule returns the commission rule with an ID
+func (c CommissionDAL) FindRule(ctx context.Context, ID string) (*model.CommissionRule, error) {
+	var rule model.CommissionRule
+	err := c.RuleCollection.FindOne(ctx, bson.D{{"_id", ID}}).Decode(&rule)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrCommissionRuleNotFound
+		}
+		logrus.Errorf("[Mongo]: error fetching commission rule %s: %s", ID, err.Error())
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// FindRules returns the commission rules matching query by agent, transaction type and currency
+func (c CommissionDAL) FindRules(ctx context.Context, query bson.D) ([]model.CommissionRule, error) {
+	opts := options.Find().SetSort(bson.D{{"agent_id", 1}, {"transaction_type", 1}, {"currency", 1}})
+	cursor, err := c.RuleCollection.Find(ctx, query, opts)
+	if err != nil {
+		logrus.Errorf("[Mongo]: error fetching commission rules: %s", err.Error())
+		return nil, err
+	}
+	rules := []model.CommissionRule{}
+	if err = cursor.All(ctx, &rules); err != nil {
+		logrus.Errorf("[Mongo]: error parsing mongo document to commission rule model: %s", err.Error())
+		return nil, err
+	}
+	return rules, nil
+}
+
+// UpdateRule applies update to the commission rule with an ID and returns it updated
+func (c CommissionDAL) UpdateRule(ctx context.Context, ID string, update bson.D) (*model.CommissionRule, error) {
+	var rule model.CommissionRule
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := c.RuleCollection.FindOneAndUpdate(ctx, bson.D{{"_id", ID}}, update, opts).Decode(&rule)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrCommissionRuleNotFound
+		}
+		logrus.Errorf("[Mongo]: error updating commission rule %s: %s", ID, err.Error())
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// MatchRule returns the most specific enabled rule of an agent completing a transaction of a type in currency
+func (c CommissionDAL) MatchRule(ctx context.Context, agentID, transactionType, currency string) (*model.CommissionRule, error) {
+	rules, err := c.FindRules(ctx, bson.D{
+		{"agent_id", bson.D{{"$in", bson.A{agentID, ""}}}},
+		{"transaction_type", transactionType},
+		{"currency", bson.D{{"$in", bson.A{currency, ""}}}},
+		{"enabled", true},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var match *model.CommissionRule
+	for i, rule := range rules {
+		if rule.Matches(agentID, transactionType, currency) && (match == nil || rule.Specificity() > match.Specificity()) {
+			match = &rules[i]
+		}
+	}
+	if match == nil {
+		return nil, ErrCommissionRuleNotFound
+	}
+	return match, nil
+}
+
+// Accrue records a commission an agent earned
+func (c CommissionDAL) Accrue(ctx context.Context, commission *model.Commission) error {
+	_, err := c.CommissionCollection.InsertOne(ctx, commission)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return errors.Errorf("commission of transaction %s has already been accrued", commission.TransactionID)
+		}
+		logrus.Errorf("[Mongo]: error accruing commission %s: %s", commission.ID, err.Error())
+		return err
+	}
+	return nil
+}
+
+// commissionSortKeys are the keys commissions can be listed by, latest first by default
+var commissionSortKeys = []string{"-created_at", "amount"}
+
+// FetchCommissions returns the page req asks for of the commissions matching query
+func (c CommissionDAL) FetchCommissions(ctx context.Context, query bson.D, req PageRequest) ([]model.Commission, *model.Page, error) {
+	var commissions []model.Commission
+	page, err := paginate(ctx, c.CommissionCollection, query, req, commissionSortKeys, &commissions)
+	if err != nil {
+		return nil, nil, err
+	}
+	return commissions, page, nil
+}
+
+// Earnings totals the commissions matching query per currency, by status
+func (c CommissionDAL) Earnings(ctx context.Context, query bson.D) (map[string]model.EarningsTotal, error) {
+	sumOf := func(status string) bson.D {
+		return bson.D{{"$sum", bson.D{{"$cond", bson.A{bson.D{{"$eq", bson.A{"$status", status}}}, "$amount", 0}}}}}
+	}
+	pipeline := mongo.Pipeline{
+		{{"$match", query}},
+		{{"$group", bson.D{
+			{"_id", "$currency"},
+			{"accrued", sumOf(model.CommissionAccrued)},
+			{"batched", sumOf(model.CommissionBatched)},
+			{"paid", sumOf(model.CommissionPaid)},
+			{"count", bson.D{{"$sum", 1}}},
+		}}},
+	}
+	var rows []struct {
+		Currency            string `bson:"_id"`
+		model.EarningsTotal `bson:",inline"`
+	}
+	cursor, err := c.CommissionCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		logrus.Errorf("[Mongo]: error aggregating earnings: %s", err.Error())
+		return nil, err
+	}
+	if err = cursor.All(ctx, &rows); err != nil {
+		logrus.Errorf("[Mongo]: error decoding earnings: %s", err.Error())
+		return nil, err
+	}
+	totals := make(map[string]model.EarningsTotal, len(rows))
+	for _, row := range rows {
+		totals[row.Currency] = row.EarningsTotal
+	}
+	return totals, nil
+}
+
+// Settle puts the commissions accrued before the cutoff of batch in it, totalled per agent and currency, and records
+// it. Agents are paid into their single wallet, so commissions in any other currency are left accrued. When ctx is not already part of a mongo session, it runs in its own transaction so no commission is left out of
+// the totals or batched twice
+func (c CommissionDAL) Settle(ctx context.Context, batch *model.SettlementBatch) error {
+	if mongo.SessionFromContext(ctx) != nil {
+		return c.settle(ctx, batch)
+	}
+
+	ses, err := c.DB.Client().StartSession()
+	if err != nil {
+		logrus.Errorf("[Mongo]: unable to create a session: %s", err.Error())
+		return err
+	}
+	defer ses.EndSession(ctx)
+
+	_, err = ses.WithTransaction(ctx, func(sesCtx mongo.SessionContext) (interface{}, error) {
+		return nil, c.settle(sesCtx, batch)
+	})
+	return err
+}
+
+func (c CommissionDAL) settle(ctx context.Context, batch *model.SettlementBatch) error {
+	accrued := bson.D{{"status", model.CommissionAccrued}, {"created_at", bson.D{{"$lt", batch.Cutoff}}}}
+	pipeline := mongo.Pipeline{
+		{{"$match", accrued}},
+		{{"$group", bson.D{
+			{"_id", bson.D{{"agent_id", "$agent_id"}, {"currency", "$currency"}}},
+			{"amount", bson.D{{"$sum", "$amount"}}},
+			{"count", bson.D{{"$sum", 1}}},
+		}}},
+		{{"$lookup", bson.D{
+			{"from", c.AgentCollection.Name()},
+			{"localField", "_id.agent_id"},
+			{"foreignField", "_id"},
+			{"as", "agent"},
+		}}},
+		{{"$match", bson.D{{"$expr", bson.D{{"$in", bson.A{"$_id.currency", "$agent.wallet.currency"}}}}}}},
+		{{"$sort", bson.D{{"_id.agent_id", 1}, {"_id.currency", 1}}}},
+	}
+	var rows []struct {
+		ID struct {
+			AgentID  string `bson:"agent_id"`
+			Currency string `bson:"currency"`
+		} `bson:"_id"`
+		Amount money.Amount `bson:"amount"`
+		Count  int64        `bson:"count"`
+	}
+	cursor, err := c.CommissionCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		logrus.Errorf("[Mongo]: error aggregating accrued commissions: %s", err.Error())
+		return err
+	}
+	if err = cursor.All(ctx, &rows); err != nil {
+		logrus.Errorf("[Mongo]: error decoding accrued commissions: %s", err.Error())
+		return err
+	}
+	if len(rows) == 0 {
+		return ErrNothingToSettle
+	}
+
+	batch.Payouts = make([]model.AgentPayout, 0, len(rows))
+	batch.Totals = make(map[string]money.Amount)
+	payouts := bson.A{}
+	for _, row := range rows {
+		payouts = append(payouts, bson.D{{"agent_id", row.ID.AgentID}, {"currency", row.ID.Currency}})
+		batch.Payouts = append(batch.Payouts, model.AgentPayout{
+			AgentID:  row.ID.AgentID,
+			Currency: row.ID.Currency,
+			Amount:   row.Amount,
+			Count:    row.Count,
+		})
+		if err := money.AddTo(batch.Totals, row.ID.Currency, row.Amount); err != nil {
+			return err
+		}
+	}
+	if _, err := c.BatchCollection.InsertOne(ctx, batch); err != nil {
+		logrus.Errorf("[Mongo]: error creating settlement batch %s: %s", batch.ID, err.Error())
+		return err
+	}
+	batched := append(accrued, bson.E{Key: "$or", Value: payouts})
+	update := bson.D{{"$set", bson.D{{"status", model.CommissionBatched}, {"batch_id", batch.ID}}}}
+	if _, err := c.CommissionCollection.UpdateMany(ctx, batched, update); err != nil {
+		logrus.Errorf("[Mongo]: error batching commissions for settlement batch %s: %s", batch.ID, err.Error())
+		return err
+	}
+	return nil
+}
+
+// settlementBatchSortKeys are the keys settlement batches can be listed by, latest first by default
+var settlementBatchSortKeys = []string{"-created_at"}
+
+// FetchBatches returns the page req asks for of the settlement batches matching query
+func (c CommissionDAL) FetchBatches(ctx context.Context, query bson.D, req PageRequest) ([]model.SettlementBatch, *model.Page, error) {
+	var batches []model.SettlementBatch
+	page, err := paginate(ctx, c.BatchCollection, query, req, settlementBatchSortKeys, &batches)
+	if err != nil {
+		return nil, nil, err
+	}
+	return batches, page, nil
+}
+
+// ReviewBatch moves a pending settlement batch to status, approved or rejected, and returns it as reviewed. A batch
+// cannot be approved by whoever created it
+func (c CommissionDAL) ReviewBatch(ctx context.Context, ID, status, reviewer, note string, at time.Time) (*model.SettlementBatch, error) {
+	var batch model.SettlementBatch
+	query := bson.D{{"_id", ID}, {"status", model.SettlementPending}}
+	if status == model.SettlementApproved {
+		query = append(query, bson.E{Key: "created_by", Value: bson.D{{"$ne", reviewer}}})
+	}
+	update := bson.D{{"$set", bson.D{
+		{"status", status},
+		{"reviewed_by", reviewer},
+		{"review_note", note},
+		{"reviewed_at", at},
+	}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := c.BatchCollection.FindOneAndUpdate(ctx, query, update, opts).Decode(&batch)
+	if err == nil {
+		return &batch, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		logrus.Errorf("[Mongo]: error reviewing settlement batch %s: %s", ID, err.Error())
+		return nil, err
+	}
+
+	err = c.BatchCollection.FindOne(ctx, bson.D{{"_id", ID}}).Decode(&batch)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrSettlementBatchNotFound
+	}
+	if err != nil {
+		logrus.Errorf("[Mongo]: error fetching settlement batch %s: %s", ID, err.Error())
+		return nil, err
+	}
+	if batch.Status != model.SettlementPending {
+		return nil, ErrSettlementBatchReviewed
+	}
+	return nil, ErrSettlementBatchOwnApproval
+}
+
+// CloseBatch marks the commissions of a reviewed settlement batch paid, or returns them to accrued for the next run
+// when the batch was not paid
+func (c CommissionDAL) CloseBatch(ctx context.Context, ID string, paid bool, at time.Time) error {
+	update := bson.D{
+		{"$set", bson.D{{"status", model.CommissionAccrued}}},
+		{"$unset", bson.D{{"batch_id", ""}}},
+	}
+	if paid {
+		update = bson.D{{"$set", bson.D{{"status", model.CommissionPaid}, {"paid_at", at}}}}
+	}
+	query := bson.D{{"batch_id", ID}, {"status", model.CommissionBatched}}
+	if _, err := c.CommissionCollection.UpdateMany(ctx, query, update); err != nil {
+		logrus.Errorf("[Mongo]: error closing settlement batch %s: %s", ID, err.Error())
+		return err
+	}
+	return nil
+}
@@ -3,15 +3,29 @@ package dal
 import (
 	"context"
 	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/money"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var (
+	// ErrCurrencyNotFound is returned when a currency is not in the registry
+	ErrCurrencyNotFound = errors.New("currency is not supported")
+	// ErrCurrencyExists is returned when a currency is added to the registry twice
+	ErrCurrencyExists = errors.New("currency already exists")
+)
+
+// ICurrencyDAL is the currency registry. Every currency read from it is registered with money, so amounts in it are
+// rounded to its minor units
 type ICurrencyDAL interface {
 	Add(ctx context.Context, currency *model.Currency) error
-	FindAll(ctx context.Context) (*[]model.Currency, error)
+	Find(ctx context.Context, code string) (*model.Currency, error)
+	FindAll(ctx context.Context, query bson.D) ([]model.Currency, error)
+	Update(ctx context.Context, code string, update bson.D) (*model.Currency, error)
+	Load(ctx context.Context) (int, error)
 }
 
 type CurrencyDAL struct {
@@ -30,30 +44,74 @@ func (c CurrencyDAL) Add(ctx context.Context, currency *model.Currency) error {
 	_, err := c.Collection.InsertOne(ctx, currency)
 	if err != nil {
 		if mongo.IsDuplicateKeyError(err) {
-			return errors.New("BaseCurrency already exists")
-		} else {
-			return err
+			return ErrCurrencyExists
 		}
+		logrus.Errorf("[Mongo]: error adding currency %s: %s", currency.Code, err.Error())
+		return err
 	}
+	register(*currency)
 	return nil
 }
 
-func (c CurrencyDAL) FindAll(ctx context.Context) (*[]model.Currency, error) {
-	var currency *[]model.Currency
-	cursor, err := c.Collection.Find(ctx, bson.D{})
+// Find returns the currency of a code
+func (c CurrencyDAL) Find(ctx context.Context, code string) (*model.Currency, error) {
+	var currency model.Currency
+	err := c.Collection.FindOne(ctx, bson.D{{"_id", code}}).Decode(&currency)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return &[]model.Currency{}, nil
-		} else {
-			logrus.Fatalf("[Mongo]: error fetching currencies: %s", err.Error())
-			return nil, err
+			return nil, ErrCurrencyNotFound
 		}
+		logrus.Errorf("[Mongo]: error fetching currency %s: %s", code, err.Error())
+		return nil, err
+	}
+	register(currency)
+	return &currency, nil
+}
+
+// FindAll returns the currencies matching query by code
+func (c CurrencyDAL) FindAll(ctx context.Context, query bson.D) ([]model.Currency, error) {
+	cursor, err := c.Collection.Find(ctx, query, options.Find().SetSort(bson.D{{"_id", 1}}))
+	if err != nil {
+		logrus.Errorf("[Mongo]: error fetching currencies: %s", err.Error())
+		return nil, err
+	}
+	currencies := []model.Currency{}
+	if err = cursor.All(ctx, &currencies); err != nil {
+		logrus.Errorf("[Mongo]: error parsing mongo document to currency model: %s", err.Error())
+		return nil, err
 	}
+	for _, currency := range currencies {
+		register(currency)
+	}
+	return currencies, nil
+}
 
-	if err = cursor.All(ctx, &currency); err != nil {
-		logrus.Fatalf("[Mongo]: error parsing mongo document to currency model: %s", err.Error())
+// Update applies update to the currency of a code and returns it updated
+func (c CurrencyDAL) Update(ctx context.Context, code string, update bson.D) (*model.Currency, error) {
+	var currency model.Currency
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := c.Collection.FindOneAndUpdate(ctx, bson.D{{"_id", code}}, update, opts).Decode(&currency)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrCurrencyNotFound
+		}
+		logrus.Errorf("[Mongo]: error updating currency %s: %s", code, err.Error())
 		return nil, err
 	}
+	register(currency)
+	return &currency, nil
+}
+
+// Load registers every currency of the registry with money. Run it once the migrations are applied
+func (c CurrencyDAL) Load(ctx context.Context) (int, error) {
+	currencies, err := c.FindAll(ctx, bson.D{})
+	if err != nil {
+		return 0, err
+	}
+	return len(currencies), nil
+}
 
-	return currency, nil
+// register keeps money in step with the registry
+func register(currency model.Currency) {
+	money.RegisterCurrency(currency.Code, currency.MinorUnits, currency.IsCrypto())
 }
@@ -17,28 +17,66 @@ type DAL struct {
 	TransactionDAL  ITransactionDAL
 	AgentDAL        IAgentDAL
 	NotificationDAL INotificationDAL
+	LedgerDAL       ILedgerDAL
+	JobDAL          IJobDAL
+	IdempotencyDAL  IIdempotencyDAL
+	IdentityDAL     IIdentityDAL
+	OTPDAL          IOTPDAL
+	AuditDAL        IAuditDAL
+	RollupDAL       IRollupDAL
+	RateDAL         IRateDAL
+	QuoteDAL        IQuoteDAL
+	RateProposalDAL IRateProposalDAL
+	FeeDAL          IFeeDAL
+	CommissionDAL   ICommissionDAL
 }
 
-func (d *DAL) setupDALObjects(cfg *config.Config) error {
-	// set up database
+// connect opens a connection to the database of the environment of cfg
+func connect(cfg *config.Config) (*mongo.Client, *mongo.Database, error) {
 	clientOptions := options.Client().ApplyURI(cfg.MongoURI)
 	client, err := mongo.Connect(context.TODO(), clientOptions)
 	if err != nil {
-		return errors.Wrapf(err, "[Mongo]: unable to open intial connection")
+		return nil, nil, errors.Wrapf(err, "[Mongo]: unable to open intial connection")
 	}
+	var db *mongo.Database
 	if cfg.Environment == "development" {
-		d.DB = client.Database("onepurse-dev")
+		db = client.Database("onepurse-dev")
 	} else if cfg.Environment == "production" {
-		d.DB = client.Database("onepurse")
+		db = client.Database("onepurse")
 	}
+	return client, db, nil
+}
+
+func (d *DAL) setupDALObjects(cfg *config.Config) error {
+	// set up database
+	client, db, err := connect(cfg)
+	if err != nil {
+		return err
+	}
+	d.DB = db
 
 	d.Client = client
 	d.AdminDAL = NewAdminDAL(d.DB)
+	if err := d.AdminDAL.EnsureSuperAdminRole(context.TODO()); err != nil {
+		return errors.Wrapf(err, "[Mongo]: unable to create the super admin role")
+	}
 	d.UserDAL = NewUserDAL(d.DB)
 	d.CurrencyDAL = NewCurrencyDAL(d.DB)
 	d.TransactionDAL = NewTransactionDAL(d.DB)
 	d.AgentDAL = NewAgentDAL(d.DB)
 	d.NotificationDAL = NewNotificationDAL(d.DB)
+	d.LedgerDAL = NewLedgerDAL(d.DB)
+	d.JobDAL = NewJobDAL(d.DB)
+	d.IdempotencyDAL = NewIdempotencyDAL(d.DB)
+	d.IdentityDAL = NewIdentityDAL(d.DB)
+	d.OTPDAL = NewOTPDAL(d.DB)
+	d.AuditDAL = NewAuditDAL(d.DB)
+	d.RollupDAL = NewRollupDAL(d.DB)
+	d.RateDAL = NewRateDAL(d.DB)
+	d.QuoteDAL = NewQuoteDAL(d.DB)
+	d.RateProposalDAL = NewRateProposalDAL(d.DB)
+	d.FeeDAL = NewFeeDAL(d.DB)
+	d.CommissionDAL = NewCommissionDAL(d.DB)
 	return nil
 }
 
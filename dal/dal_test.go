@@ -0,0 +1,39 @@
+package dal
+
+import (
+	"context"
+	"github.com/lucsky/cuid"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"os"
+	"testing"
+	"time"
+)
+
+// testDatabase returns an empty database with every migration applied, on the mongo server of MONGO_TEST_URI. The
+// database is dropped when the test ends. Tests that need mongo are skipped when MONGO_TEST_URI is not set
+func testDatabase(t *testing.T) *mongo.Database {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("unable to connect to %s: %v", uri, err)
+	}
+	db := client.Database("onepurse-test-" + cuid.New())
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		_ = db.Drop(ctx)
+		_ = client.Disconnect(ctx)
+	})
+	if _, err := NewMigrator(db, false).Run(ctx); err != nil {
+		t.Fatalf("unable to migrate the test database: %v", err)
+	}
+	return db
+}
@@ -0,0 +1,127 @@
+package dal
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrFeeRuleNotFound is returned when there is no fee rule with an ID, or none matching a transaction
+	ErrFeeRuleNotFound = errors.New("fee rule not found")
+	// ErrFeeRuleExists is returned when a second rule is added for the same transaction type and currencies
+	ErrFeeRuleExists = errors.New("a fee rule already exists for this transaction type and currencies, update it instead")
+)
+
+// IFeeDAL is the fee schedule
+type IFeeDAL interface {
+	Create(ctx context.Context, rule *model.FeeRule) error
+	Find(ctx context.Context, ID string) (*model.FeeRule, error)
+	FindAll(ctx context.Context, query bson.D) ([]model.FeeRule, error)
+	Update(ctx context.Context, ID string, update bson.D) (*model.FeeRule, error)
+	Match(ctx context.Context, transactionType, currency, toCurrency string) (*model.FeeRule, error)
+}
+
+type FeeDAL struct {
+	DB                *mongo.Database
+	FeeRuleCollection *mongo.Collection
+}
+
+func NewFeeDAL(db *mongo.Database) *FeeDAL {
+	return &FeeDAL{
+		DB:                db,
+		FeeRuleCollection: db.Collection("fee-rule"),
+	}
+}
+
+// feeRuleIndexes keep a single rule per transaction type and currencies, which is also how rules are matched
+var feeRuleIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{"transaction_type", 1}, {"currency", 1}, {"to_currency", 1}}, Options: options.Index().SetUnique(true)},
+}
+
+func (f FeeDAL) Create(ctx context.Context, rule *model.FeeRule) error {
+	_, err := f.FeeRuleCollection.InsertOne(ctx, rule)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrFeeRuleExists
+		}
+		logrus.Errorf("[Mongo]: error creating fee rule %s: %s", rule.ID, err.Error())
+		return err
+	}
+	return nil
+}
+
+// Find returns the fee rule with an ID
+func (f FeeDAL) Find(ctx context.Context, ID string) (*model.FeeRule, error) {
+	var rule model.FeeRule
+	err := f.FeeRuleCollection.FindOne(ctx, bson.D{{"_id", ID}}).Decode(&rule)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrFeeRuleNotFound
+		}
+		logrus.Errorf("[Mongo]: error fetching fee rule %s: %s", ID, err.Error())
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// FindAll returns the fee rules matching query by transaction type and currencies
+func (f FeeDAL) FindAll(ctx context.Context, query bson.D) ([]model.FeeRule, error) {
+	opts := options.Find().SetSort(bson.D{{"transaction_type", 1}, {"currency", 1}, {"to_currency", 1}})
+	cursor, err := f.FeeRuleCollection.Find(ctx, query, opts)
+	if err != nil {
+		logrus.Errorf("[Mongo]: error fetching fee rules: %s", err.Error())
+		return nil, err
+	}
+	rules := []model.FeeRule{}
+	if err = cursor.All(ctx, &rules); err != nil {
+		logrus.Errorf("[Mongo]: error parsing mongo document to fee rule model: %s", err.Error())
+		return nil, err
+	}
+	return rules, nil
+}
+
+// Update applies update to the fee rule with an ID and returns it updated
+func (f FeeDAL) Update(ctx context.Context, ID string, update bson.D) (*model.FeeRule, error) {
+	var rule model.FeeRule
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := f.FeeRuleCollection.FindOneAndUpdate(ctx, bson.D{{"_id", ID}}, update, opts).Decode(&rule)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrFeeRuleNotFound
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrFeeRuleExists
+		}
+		logrus.Errorf("[Mongo]: error updating fee rule %s: %s", ID, err.Error())
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// Match returns the most specific enabled rule of a transaction of a type converting currency to toCurrency
+func (f FeeDAL) Match(ctx context.Context, transactionType, currency, toCurrency string) (*model.FeeRule, error) {
+	rules, err := f.FindAll(ctx, bson.D{
+		{"transaction_type", transactionType},
+		{"currency", bson.D{{"$in", bson.A{currency, ""}}}},
+		{"to_currency", bson.D{{"$in", bson.A{toCurrency, ""}}}},
+		{"enabled", true},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var match *model.FeeRule
+	for i, rule := range rules {
+		if rule.Matches(transactionType, currency, toCurrency) && (match == nil || rule.Specificity() > match.Specificity()) {
+			match = &rules[i]
+		}
+	}
+	if match == nil {
+		return nil, ErrFeeRuleNotFound
+	}
+	return match, nil
+}
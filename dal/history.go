@@ -0,0 +1,231 @@
+package dal
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"sort"
+)
+
+// historySortKeys are the keys a history can be sorted by. Every type has them, so the types can be merged
+var historySortKeys = []string{"-created_at"}
+
+// FetchHistory returns the page req asks for of the transactions of every type matching filter. Each type is read in
+// order from its own collection and the results are merged. The total is always counted
+func (t TransactionDAL) FetchHistory(ctx context.Context, filter model.HistoryFilter, req PageRequest) ([]model.HistoryEntry, *model.Page, error) {
+	sortKey, field, direction, err := pageSort(req, historySortKeys)
+	if err != nil {
+		return nil, nil, err
+	}
+	var after bson.D
+	if req.Cursor != "" {
+		c, err := parsePageCursor(req.Cursor, sortKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		after = afterCursor(field, direction, c)
+	}
+	transactionTypes := filter.Types
+	if len(transactionTypes) == 0 {
+		transactionTypes = TransactionTypes
+	}
+
+	page := &model.Page{Limit: pageLimit(req.Limit), Sort: sortKey}
+	var total int64
+	entries := []model.HistoryEntry{}
+	for _, transactionType := range transactionTypes {
+		fields, err := t.fieldsOf(transactionType)
+		if err != nil {
+			return nil, nil, err
+		}
+		query, ok := historyQuery(fields, filter)
+		if !ok {
+			continue // the filter cannot match this type, e.g. an agent on withdrawals
+		}
+
+		count, err := fields.collection.CountDocuments(ctx, query)
+		if err != nil {
+			logrus.Errorf("[Mongo]: error counting %s history: %s", transactionType, err.Error())
+			return nil, nil, err
+		}
+		total += count
+
+		if after != nil {
+			query = bson.D{{"$and", bson.A{query, after}}}
+		}
+		// one more than a page tells whether there is a next page
+		opts := options.Find().SetSort(bson.D{{field, direction}, {"_id", direction}}).SetLimit(page.Limit + 1)
+		typeEntries, err := t.fetchHistoryEntries(ctx, transactionType, fields, query, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries = append(entries, typeEntries...)
+	}
+	page.Total = &total
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if direction < 0 {
+			a, b = b, a
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+	if int64(len(entries)) > page.Limit {
+		entries = entries[:page.Limit]
+		last := entries[page.Limit-1]
+		c, err := newPageCursor(sortKey, last.CreatedAt, last.ID)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "unable to encode history cursor")
+		}
+		page.HasMore = true
+		page.NextCursor = c.String()
+	}
+	return entries, page, nil
+}
+
+// historyQuery translates filter to the fields of a transaction type. It reports false when no transaction of the
+// type can match
+func historyQuery(fields transactionFields, filter model.HistoryFilter) (bson.D, bool) {
+	query := bson.D{}
+	if len(filter.Statuses) > 0 {
+		query = append(query, bson.E{Key: "status", Value: bson.D{{"$in", filter.Statuses}}})
+	}
+	if filter.Currency != "" {
+		query = append(query, bson.E{Key: fields.currency, Value: filter.Currency})
+	}
+	if filter.AgentID != "" {
+		if fields.agent == "" {
+			return nil, false
+		}
+		query = append(query, bson.E{Key: fields.agent, Value: filter.AgentID})
+	}
+
+	var and bson.A
+	if filter.ParticipantID != "" {
+		and = append(and, anyOf(append([]string{fields.user}, fields.counterparties...), filter.ParticipantID))
+	}
+	if filter.CounterpartyID != "" {
+		if len(fields.counterparties) == 0 {
+			return nil, false
+		}
+		and = append(and, anyOf(fields.counterparties, filter.CounterpartyID))
+	}
+	if len(and) > 0 {
+		query = append(query, bson.E{Key: "$and", Value: and})
+	}
+
+	createdAt := bson.D{}
+	if !filter.From.IsZero() {
+		createdAt = append(createdAt, bson.E{Key: "$gte", Value: filter.From})
+	}
+	if !filter.To.IsZero() {
+		createdAt = append(createdAt, bson.E{Key: "$lt", Value: filter.To})
+	}
+	if len(createdAt) > 0 {
+		query = append(query, bson.E{Key: "created_at", Value: createdAt})
+	}
+	return query, true
+}
+
+// anyOf matches documents where any of fields is value
+func anyOf(fields []string, value string) bson.D {
+	or := make(bson.A, 0, len(fields))
+	for _, field := range fields {
+		or = append(or, bson.D{{field, value}})
+	}
+	return bson.D{{"$or", or}}
+}
+
+// fetchHistoryEntries reads the transactions of a type matching query as history entries
+func (t TransactionDAL) fetchHistoryEntries(ctx context.Context, transactionType string, fields transactionFields, query bson.D, opts *options.FindOptions) ([]model.HistoryEntry, error) {
+	cursor, err := fields.collection.Find(ctx, query, opts)
+	if err != nil {
+		logrus.Errorf("[Mongo]: error fetching %s history: %s", transactionType, err.Error())
+		return nil, err
+	}
+
+	var entries []model.HistoryEntry
+	switch transactionType {
+	case types.TRANSFER:
+		var transfers []model.Transfer
+		err = cursor.All(ctx, &transfers)
+		for i := range transfers {
+			tr := &transfers[i]
+			entries = append(entries, model.HistoryEntry{
+				ID: tr.ID, Status: tr.Status, Amount: tr.BaseAmount, Currency: tr.BaseCurrency,
+				UserID: tr.UserID, AgentID: tr.AgentID, CounterpartyID: tr.AgentID,
+				CreatedAt: tr.CreatedAt, UpdatedAt: tr.UpdatedAt, Transaction: tr,
+			})
+		}
+	case types.WITHDRAW:
+		var withdrawals []model.Withdrawal
+		err = cursor.All(ctx, &withdrawals)
+		for i := range withdrawals {
+			w := &withdrawals[i]
+			entries = append(entries, model.HistoryEntry{
+				ID: w.ID, Status: w.Status, Amount: w.BaseAmount, Currency: w.BaseCurrency,
+				UserID:    w.UserID,
+				CreatedAt: w.CreatedAt, UpdatedAt: w.UpdatedAt, Transaction: w,
+			})
+		}
+	case types.DEPOSIT:
+		var deposits []model.Deposit
+		err = cursor.All(ctx, &deposits)
+		for i := range deposits {
+			d := &deposits[i]
+			entries = append(entries, model.HistoryEntry{
+				ID: d.ID, Status: d.Status, Amount: d.BaseAmount, Currency: d.BaseCurrency,
+				UserID: d.UserID, AgentID: d.AgentID, CounterpartyID: d.AgentID,
+				CreatedAt: d.CreatedAt, UpdatedAt: d.UpdatedAt, Transaction: d,
+			})
+		}
+	case types.EXCHANGE:
+		var exchanges []model.Exchange
+		err = cursor.All(ctx, &exchanges)
+		for i := range exchanges {
+			e := &exchanges[i]
+			counterparty := e.AgentID
+			if e.MatchedUserID != "" {
+				counterparty = e.MatchedUserID
+			}
+			entries = append(entries, model.HistoryEntry{
+				ID: e.ID, Status: e.Status, Amount: e.BaseAmount, Currency: e.BaseCurrency,
+				UserID: e.UserID, AgentID: e.AgentID, CounterpartyID: counterparty,
+				CreatedAt: e.CreatedAt, UpdatedAt: e.UpdatedAt, Transaction: e,
+			})
+		}
+	case types.ONE_PURSE_TRANSACTION:
+		var transactions []model.OnePurseTransaction
+		err = cursor.All(ctx, &transactions)
+		for i := range transactions {
+			o := &transactions[i]
+			entry := model.HistoryEntry{
+				ID: o.ID, Status: o.Status, Amount: o.Amount, Currency: o.Currency,
+				CreatedAt: o.CreatedAt, UpdatedAt: o.UpdatedAt, Transaction: o,
+			}
+			if o.FromUser != nil {
+				entry.UserID = o.FromUser.ID
+			}
+			if o.ToUser != nil {
+				entry.CounterpartyID = o.ToUser.ID
+			}
+			entries = append(entries, entry)
+		}
+	}
+	if err != nil {
+		logrus.Errorf("[Mongo]: error decoding %s history: %s", transactionType, err.Error())
+		return nil, err
+	}
+
+	for i := range entries {
+		entries[i].Type = transactionType
+	}
+	return entries, nil
+}
@@ -0,0 +1,81 @@
+package dal
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type IIdempotencyDAL interface {
+	Reserve(ctx context.Context, record *model.IdempotencyRecord) (*model.IdempotencyRecord, error)
+	Complete(ctx context.Context, ID string, statusCode int, body []byte) error
+	Release(ctx context.Context, ID string) error
+}
+
+type IdempotencyDAL struct {
+	DB                    *mongo.Database
+	IdempotencyCollection *mongo.Collection
+}
+
+func NewIdempotencyDAL(db *mongo.Database) *IdempotencyDAL {
+	return &IdempotencyDAL{
+		DB:                    db,
+		IdempotencyCollection: db.Collection("idempotency-key"),
+	}
+}
+
+// idempotencyIndexes let mongo remove idempotency records once they expire
+var idempotencyIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{"expires_at", 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+}
+
+// Reserve stores record as in progress. When a record already exists for the same scope and key it is returned
+// instead and nothing is stored
+func (i IdempotencyDAL) Reserve(ctx context.Context, record *model.IdempotencyRecord) (*model.IdempotencyRecord, error) {
+	_, err := i.IdempotencyCollection.InsertOne(ctx, record)
+	if err == nil {
+		return nil, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		logrus.Errorf("[Mongo]: error reserving idempotency key %s: %s", record.ID, err.Error())
+		return nil, err
+	}
+
+	var existing model.IdempotencyRecord
+	if err := i.IdempotencyCollection.FindOne(ctx, bson.D{{"_id", record.ID}}).Decode(&existing); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, errors.New("idempotency key expired while it was being reserved")
+		}
+		return nil, err
+	}
+	return &existing, nil
+}
+
+// Complete stores the response to the request that reserved a key
+func (i IdempotencyDAL) Complete(ctx context.Context, ID string, statusCode int, body []byte) error {
+	update := bson.D{{"$set", bson.D{
+		{"state", model.IdempotencyCompleted},
+		{"status_code", statusCode},
+		{"body", body},
+	}}}
+	_, err := i.IdempotencyCollection.UpdateByID(ctx, ID, update)
+	if err != nil {
+		logrus.Errorf("[Mongo]: error completing idempotency key %s: %s", ID, err.Error())
+		return err
+	}
+	return nil
+}
+
+// Release removes a reservation so the request can be retried with the same key
+func (i IdempotencyDAL) Release(ctx context.Context, ID string) error {
+	_, err := i.IdempotencyCollection.DeleteOne(ctx, bson.D{{"_id", ID}})
+	if err != nil {
+		logrus.Errorf("[Mongo]: error releasing idempotency key %s: %s", ID, err.Error())
+		return err
+	}
+	return nil
+}
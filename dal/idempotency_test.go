@@ -0,0 +1,58 @@
+package dal
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyReserve(t *testing.T) {
+	i := NewIdempotencyDAL(testDatabase(t))
+	ctx := context.Background()
+	now := time.Now()
+	record := &model.IdempotencyRecord{
+		ID:          "scope:key",
+		Scope:       "scope",
+		Key:         "key",
+		RequestHash: "first",
+		State:       model.IdempotencyInProgress,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(time.Hour),
+	}
+
+	existing, err := i.Reserve(ctx, record)
+	if err != nil || existing != nil {
+		t.Fatalf("first Reserve = %v, %v, want the key reserved", existing, err)
+	}
+
+	retry := *record
+	retry.RequestHash = "second"
+	existing, err = i.Reserve(ctx, &retry)
+	if err != nil {
+		t.Fatalf("Reserve of an in flight key error = %v", err)
+	}
+	if existing == nil || existing.State != model.IdempotencyInProgress || existing.RequestHash != "first" {
+		t.Fatalf("Reserve of an in flight key = %+v, want the in progress record of the first request", existing)
+	}
+
+	if err := i.Complete(ctx, record.ID, http.StatusCreated, []byte(`{"id":"1"}`)); err != nil {
+		t.Fatalf("Complete error = %v", err)
+	}
+	existing, err = i.Reserve(ctx, record)
+	if err != nil {
+		t.Fatalf("Reserve of a completed key error = %v", err)
+	}
+	if existing == nil || existing.State != model.IdempotencyCompleted || existing.StatusCode != http.StatusCreated || string(existing.Body) != `{"id":"1"}` {
+		t.Fatalf("Reserve of a completed key = %+v, want the stored response", existing)
+	}
+
+	if err := i.Release(ctx, record.ID); err != nil {
+		t.Fatalf("Release error = %v", err)
+	}
+	existing, err = i.Reserve(ctx, &retry)
+	if err != nil || existing != nil {
+		t.Fatalf("Reserve of a released key = %v, %v, want the key reserved again", existing, err)
+	}
+}
@@ -0,0 +1,81 @@
+package dal
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// ErrIdentityNotFound is returned when no local identity matches a query
+	ErrIdentityNotFound = errors.New("identity record does not exist")
+	// ErrIdentityExists is returned when adding a local identity with a username that is taken
+	ErrIdentityExists = errors.New("identity record already exists")
+)
+
+type IIdentityDAL interface {
+	Add(ctx context.Context, identity *model.LocalIdentity) error
+	FindOne(ctx context.Context, query bson.D) (*model.LocalIdentity, error)
+	Update(ctx context.Context, ID string, updateParam bson.D) error
+}
+
+type IdentityDAL struct {
+	DB                 *mongo.Database
+	IdentityCollection *mongo.Collection
+}
+
+func NewIdentityDAL(db *mongo.Database) *IdentityDAL {
+	return &IdentityDAL{
+		DB:                 db,
+		IdentityCollection: db.Collection("local-identity"),
+	}
+}
+
+// identityIndexes keep usernames unique and let refresh tokens be looked up
+var identityIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{"username", 1}}, Options: options.Index().SetUnique(true)},
+	{Keys: bson.D{{"refresh_tokens.hash", 1}}},
+}
+
+// Add ...
+func (i IdentityDAL) Add(ctx context.Context, identity *model.LocalIdentity) error {
+	_, err := i.IdentityCollection.InsertOne(ctx, identity)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrIdentityExists
+		}
+		logrus.Errorf("[Mongo]: error adding identity %s: %s", identity.Username, err.Error())
+		return err
+	}
+	return nil
+}
+
+// FindOne ...
+func (i IdentityDAL) FindOne(ctx context.Context, query bson.D) (*model.LocalIdentity, error) {
+	var identity model.LocalIdentity
+	err := i.IdentityCollection.FindOne(ctx, query).Decode(&identity)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrIdentityNotFound
+		}
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// Update ...
+func (i IdentityDAL) Update(ctx context.Context, ID string, updateParam bson.D) error {
+	result, err := i.IdentityCollection.UpdateByID(ctx, ID, updateParam)
+	if err != nil {
+		logrus.Errorf("[Mongo]: error updating identity %s: %s", ID, err.Error())
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrIdentityNotFound
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package dal
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// ErrJobLeaseLost is returned when the lease of a job expired and may have been taken over by another replica
+var ErrJobLeaseLost = errors.New("job lease was lost before the run finished")
+
+type IJobDAL interface {
+	Register(ctx context.Context, job *model.Job) error
+	Create(ctx context.Context, job *model.Job) error
+	FetchDue(ctx context.Context, now time.Time) (*[]model.Job, error)
+	FetchJobs(ctx context.Context, query bson.D) (*[]model.Job, error)
+	FetchPage(ctx context.Context, query bson.D, req PageRequest) ([]model.Job, *model.Page, error)
+	Acquire(ctx context.Context, jobID, owner string, now, leaseUntil time.Time) (*model.Job, error)
+	Renew(ctx context.Context, jobID, owner string, now, leaseUntil time.Time) error
+	Release(ctx context.Context, jobID, owner string, update bson.D) error
+}
+
+type JobDAL struct {
+	DB            *mongo.Database
+	JobCollection *mongo.Collection
+}
+
+func NewJobDAL(db *mongo.Database) *JobDAL {
+	return &JobDAL{
+		DB:            db,
+		JobCollection: db.Collection("job"),
+	}
+}
+
+// Register creates a cron job, or updates the schedule of an existing one while keeping its run state
+func (j JobDAL) Register(ctx context.Context, job *model.Job) error {
+	update := bson.D{
+		{"$set", bson.D{
+			{"handler", job.Handler},
+			{"kind", job.Kind},
+			{"schedule", job.Schedule},
+			{"updated_at", job.UpdatedAt},
+		}},
+		{"$setOnInsert", bson.D{
+			{"status", job.Status},
+			{"next_run_at", job.NextRunAt},
+			{"created_at", job.CreatedAt},
+		}},
+	}
+	_, err := j.JobCollection.UpdateByID(ctx, job.ID, update, options.Update().SetUpsert(true))
+	if err != nil {
+		logrus.Errorf("[Mongo]: error registering job %s: %s", job.ID, err.Error())
+		return err
+	}
+	return nil
+}
+
+func (j JobDAL) Create(ctx context.Context, job *model.Job) error {
+	_, err := j.JobCollection.InsertOne(ctx, job)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return errors.New("job already exists")
+		}
+		return err
+	}
+	return nil
+}
+
+// FetchDue returns the jobs that are due to run and are not leased by a replica
+func (j JobDAL) FetchDue(ctx context.Context, now time.Time) (*[]model.Job, error) {
+	return j.FetchJobs(ctx, bson.D{
+		{"status", bson.D{{"$in", bson.A{model.JobStatusScheduled, model.JobStatusRunning}}}},
+		{"next_run_at", bson.D{{"$lte", now}}},
+		{"lease_until", bson.D{{"$not", bson.D{{"$gt", now}}}}},
+	})
+}
+
+func (j JobDAL) FetchJobs(ctx context.Context, query bson.D) (*[]model.Job, error) {
+	var jobs []model.Job
+
+	cursor, err := j.JobCollection.Find(ctx, query, options.Find().SetSort(bson.D{{"next_run_at", 1}}))
+	if err != nil {
+		logrus.Errorf("[Mongo]: error fetching jobs: %s", err.Error())
+		return nil, err
+	}
+	if err = cursor.All(ctx, &jobs); err != nil {
+		logrus.Errorf("[Mongo]: error decoding job results: %s", err.Error())
+		return nil, err
+	}
+	return &jobs, nil
+}
+
+// Acquire leases a due job to owner until leaseUntil. It returns nil without an error when the job is not due or
+// another replica holds its lease, so only one replica ever runs a job at a time
+func (j JobDAL) Acquire(ctx context.Context, jobID, owner string, now, leaseUntil time.Time) (*model.Job, error) {
+	filter := bson.D{
+		{"_id", jobID},
+		{"status", bson.D{{"$in", bson.A{model.JobStatusScheduled, model.JobStatusRunning}}}},
+		{"next_run_at", bson.D{{"$lte", now}}},
+		{"lease_until", bson.D{{"$not", bson.D{{"$gt", now}}}}},
+	}
+	update := bson.D{{"$set", bson.D{
+		{"status", model.JobStatusRunning},
+		{"lease_owner", owner},
+		{"lease_until", leaseUntil},
+		{"updated_at", now},
+	}}}
+
+	var job model.Job
+	err := j.JobCollection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&job)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		logrus.Errorf("[Mongo]: error acquiring job %s: %s", jobID, err.Error())
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Renew extends the lease owner holds on a job to leaseUntil. It fails with ErrJobLeaseLost once the lease has expired,
+// even if no other replica has taken the job yet, since one may be about to
+func (j JobDAL) Renew(ctx context.Context, jobID, owner string, now, leaseUntil time.Time) error {
+	filter := bson.D{{"_id", jobID}, {"lease_owner", owner}, {"lease_until", bson.D{{"$gt", now}}}}
+	result, err := j.JobCollection.UpdateOne(ctx, filter, bson.D{{"$set", bson.D{{"lease_until", leaseUntil}, {"updated_at", now}}}})
+	if err != nil {
+		logrus.Errorf("[Mongo]: error renewing the lease of job %s: %s", jobID, err.Error())
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrJobLeaseLost
+	}
+	return nil
+}
+
+// Release applies the outcome of a run to a job and gives up its lease, as long as owner still holds it
+func (j JobDAL) Release(ctx context.Context, jobID, owner string, update bson.D) error {
+	update = append(update, bson.E{Key: "$unset", Value: bson.D{{"lease_owner", ""}, {"lease_until", ""}}})
+	result, err := j.JobCollection.UpdateOne(ctx, bson.D{{"_id", jobID}, {"lease_owner", owner}}, update)
+	if err != nil {
+		logrus.Errorf("[Mongo]: error releasing job %s: %s", jobID, err.Error())
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrJobLeaseLost
+	}
+	return nil
+}
+
+// jobSortKeys are the keys jobs can be listed by, those due soonest first by default
+var jobSortKeys = []string{"next_run_at", "created_at", "last_run_at"}
+
+// FetchPage returns the page req asks for of the jobs matching query
+func (j JobDAL) FetchPage(ctx context.Context, query bson.D, req PageRequest) ([]model.Job, *model.Page, error) {
+	var jobs []model.Job
+	page, err := paginate(ctx, j.JobCollection, query, req, jobSortKeys, &jobs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return jobs, page, nil
+}
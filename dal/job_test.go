@@ -0,0 +1,79 @@
+package dal
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+	"time"
+)
+
+func TestJobLease(t *testing.T) {
+	j := NewJobDAL(testDatabase(t))
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	lease := 5 * time.Minute
+	if err := j.Create(ctx, &model.Job{
+		ID:          "job",
+		Handler:     "handler",
+		Kind:        model.JobKindOnce,
+		Status:      model.JobStatusScheduled,
+		NextRunAt:   now,
+		MaxAttempts: 3,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}); err != nil {
+		t.Fatalf("Create error = %v", err)
+	}
+
+	job, err := j.Acquire(ctx, "job", "first", now, now.Add(lease))
+	if err != nil || job == nil {
+		t.Fatalf("Acquire of a due job = %v, %v, want the job leased", job, err)
+	}
+	if job.Status != model.JobStatusRunning || job.LeaseOwner != "first" || !job.LeaseUntil.Equal(now.Add(lease)) {
+		t.Errorf("acquired job = %+v, want it running under the lease of first", job)
+	}
+	if due, err := j.FetchDue(ctx, now); err != nil || len(*due) != 0 {
+		t.Errorf("FetchDue of a leased job = %v, %v, want nothing due", due, err)
+	}
+	if job, err := j.Acquire(ctx, "job", "second", now.Add(lease-time.Second), now.Add(2*lease)); err != nil || job != nil {
+		t.Fatalf("Acquire of a leased job = %v, %v, want nil", job, err)
+	}
+
+	// renewing keeps the job leased past the first expiry
+	renewed := now.Add(lease - time.Second)
+	if err := j.Renew(ctx, "job", "first", renewed, renewed.Add(lease)); err != nil {
+		t.Fatalf("Renew by the owner error = %v", err)
+	}
+	if err := j.Renew(ctx, "job", "second", renewed, renewed.Add(lease)); err != ErrJobLeaseLost {
+		t.Errorf("Renew by another replica error = %v, want %v", err, ErrJobLeaseLost)
+	}
+	if job, err := j.Acquire(ctx, "job", "second", now.Add(lease+time.Second), now.Add(2*lease)); err != nil || job != nil {
+		t.Fatalf("Acquire of a renewed job = %v, %v, want nil", job, err)
+	}
+
+	// once the lease expires another replica takes the job over and the first can neither renew nor release it
+	expired := renewed.Add(lease + time.Second)
+	if err := j.Renew(ctx, "job", "first", expired, expired.Add(lease)); err != ErrJobLeaseLost {
+		t.Errorf("Renew of an expired lease error = %v, want %v", err, ErrJobLeaseLost)
+	}
+	job, err = j.Acquire(ctx, "job", "second", expired, expired.Add(lease))
+	if err != nil || job == nil || job.LeaseOwner != "second" {
+		t.Fatalf("Acquire of an expired lease = %v, %v, want the job leased to second", job, err)
+	}
+	done := bson.D{{"$set", bson.D{{"status", model.JobStatusCompleted}}}}
+	if err := j.Release(ctx, "job", "first", done); err != ErrJobLeaseLost {
+		t.Errorf("Release by the previous owner error = %v, want %v", err, ErrJobLeaseLost)
+	}
+	if err := j.Release(ctx, "job", "second", done); err != nil {
+		t.Fatalf("Release by the owner error = %v", err)
+	}
+
+	jobs, err := j.FetchJobs(ctx, bson.D{{"_id", "job"}})
+	if err != nil || len(*jobs) != 1 {
+		t.Fatalf("FetchJobs = %v, %v", jobs, err)
+	}
+	if released := (*jobs)[0]; released.Status != model.JobStatusCompleted || released.LeaseOwner != "" || !released.LeaseUntil.IsZero() {
+		t.Errorf("released job = %+v, want it completed without a lease", released)
+	}
+}
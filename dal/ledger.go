@@ -0,0 +1,309 @@
+package dal
+
+import (
+	"context"
+	"fmt"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"github.com/lucsky/cuid"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+// ErrAgentCurrency is returned when a posting moves an agent's balance in a currency other than that of their wallet.
+// An agent trades a single currency, so their one wallet cannot hold any other
+var ErrAgentCurrency = errors.New("agent does not trade this currency")
+
+type ILedgerDAL interface {
+	Post(ctx context.Context, entry *model.JournalEntry) error
+	FetchJournalEntries(ctx context.Context, query bson.D) (*[]model.JournalEntry, error)
+	FetchPostings(ctx context.Context, query bson.D) (*[]model.Posting, error)
+	FetchPostingsPage(ctx context.Context, query bson.D, req PageRequest) ([]model.Posting, *model.Page, error)
+	Balances(ctx context.Context, owner, ownerID string) (*[]model.LedgerBalance, error)
+	RevenueHours(ctx context.Context, start, end time.Time, query bson.D) ([]model.RevenueRollup, error)
+}
+
+type LedgerDAL struct {
+	DB                *mongo.Database
+	JournalCollection *mongo.Collection
+	PostingCollection *mongo.Collection
+	UserCollection    *mongo.Collection
+	AgentCollection   *mongo.Collection
+}
+
+func NewLedgerDAL(db *mongo.Database) *LedgerDAL {
+	return &LedgerDAL{
+		DB:                db,
+		JournalCollection: db.Collection("journal-entry"),
+		PostingCollection: db.Collection("posting"),
+		UserCollection:    db.Collection("user"),
+		AgentCollection:   db.Collection("agent"),
+	}
+}
+
+// Post records a balanced journal entry and applies its postings to the affected wallets. When ctx is not already
+// part of a mongo session, the entry is posted in its own transaction so wallets and postings never diverge
+func (l LedgerDAL) Post(ctx context.Context, entry *model.JournalEntry) error {
+	if err := validateJournalEntry(entry); err != nil {
+		return err
+	}
+
+	if mongo.SessionFromContext(ctx) != nil {
+		return l.post(ctx, entry)
+	}
+
+	ses, err := l.DB.Client().StartSession()
+	if err != nil {
+		logrus.Errorf("[Mongo]: unable to create a session: %s", err.Error())
+		return err
+	}
+	defer ses.EndSession(ctx)
+
+	_, err = ses.WithTransaction(ctx, func(sesCtx mongo.SessionContext) (interface{}, error) {
+		return nil, l.post(sesCtx, entry)
+	})
+	return err
+}
+
+func (l LedgerDAL) post(ctx context.Context, entry *model.JournalEntry) error {
+	if entry.ID == "" {
+		entry.ID = cuid.New()
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+
+	postings := make([]interface{}, 0, len(entry.Postings))
+	for i := range entry.Postings {
+		p := &entry.Postings[i]
+		p.ID = cuid.New()
+		p.JournalID = entry.ID
+		p.TransactionID = entry.TransactionID
+		p.TransactionType = entry.TransactionType
+		p.AccountCode = p.Account.Code()
+		p.CreatedAt = entry.CreatedAt
+		postings = append(postings, p)
+
+		if p.Account.IsWallet() {
+			if err := l.applyToWallet(ctx, p); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := l.JournalCollection.InsertOne(ctx, entry); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return errors.New("journal entry already exists. You might be repeating a transaction")
+		}
+		return err
+	}
+	if _, err := l.PostingCollection.InsertMany(ctx, postings); err != nil {
+		logrus.Errorf("[Mongo]: error recording postings for journal entry %s: %s", entry.ID, err.Error())
+		return err
+	}
+	return nil
+}
+
+// applyToWallet moves the wallet balance backing a posting. Debits are guarded so a wallet bucket can never go negative,
+// and agent postings must be in the currency of the agent's single wallet
+func (l LedgerDAL) applyToWallet(ctx context.Context, p *model.Posting) error {
+	var collection *mongo.Collection
+	var field string
+	filter := bson.D{{"_id", p.Account.OwnerID}}
+	switch p.Account.Owner {
+	case model.LedgerOwnerUser:
+		collection = l.UserCollection
+		field = fmt.Sprintf("wallet.%s.%s_balance", p.Account.Currency, p.Account.Bucket)
+	case model.LedgerOwnerAgent:
+		collection = l.AgentCollection
+		field = fmt.Sprintf("wallet.%s_balance", p.Account.Bucket)
+		filter = append(filter, bson.E{Key: "wallet.currency", Value: p.Account.Currency})
+	default:
+		return nil
+	}
+
+	amount := p.Amount
+	if p.Direction == model.Debit {
+		amount = -amount
+		filter = append(filter, bson.E{Key: field, Value: bson.D{{"$gte", p.Amount}}})
+	}
+
+	result, err := collection.UpdateOne(ctx, filter, bson.D{{"$inc", bson.D{{field, amount}}}})
+	if err != nil {
+		logrus.Errorf("[Mongo]: error applying posting to %s: %s", p.Account.Code(), err.Error())
+		return err
+	}
+	if result.MatchedCount == 0 {
+		if p.Account.Owner == model.LedgerOwnerAgent {
+			count, err := collection.CountDocuments(ctx, filter[:2])
+			if err != nil {
+				logrus.Errorf("[Mongo]: error fetching wallet of %s: %s", p.Account.Code(), err.Error())
+				return err
+			}
+			if count == 0 {
+				return errors.Wrapf(ErrAgentCurrency, "agent %s has no %s wallet", p.Account.OwnerID, p.Account.Currency)
+			}
+		}
+		return fmt.Errorf("insufficient balance in %s %s wallet", p.Account.Currency, p.Account.Bucket)
+	}
+	return nil
+}
+
+// validateJournalEntry checks that an entry has postings and that debits equal credits for every currency
+func validateJournalEntry(entry *model.JournalEntry) error {
+	if entry == nil || len(entry.Postings) < 2 {
+		return errors.New("journal entry requires at least two postings")
+	}
+
+	totals := make(map[string]money.Amount)
+	for _, p := range entry.Postings {
+		if p.Amount <= money.Zero {
+			return errors.New("posting amount must be greater than zero")
+		}
+		amount := p.Amount
+		switch p.Direction {
+		case model.Debit:
+			amount = amount.Neg()
+		case model.Credit:
+		default:
+			return fmt.Errorf("invalid posting direction %q", p.Direction)
+		}
+		if err := money.AddTo(totals, p.Account.Currency, amount); err != nil {
+			return err
+		}
+	}
+
+	for currency, total := range totals {
+		if total != 0 {
+			return fmt.Errorf("journal entry is not balanced for %s", currency)
+		}
+	}
+	return nil
+}
+
+func (l LedgerDAL) FetchJournalEntries(ctx context.Context, query bson.D) (*[]model.JournalEntry, error) {
+	var entries []model.JournalEntry
+
+	cursor, err := l.JournalCollection.Find(ctx, query)
+	if err != nil {
+		logrus.Errorf("[Mongo]: error fetching journal entries: %s", err.Error())
+		return nil, err
+	}
+	if err = cursor.All(ctx, &entries); err != nil {
+		logrus.Errorf("[Mongo]: error decoding journal entry results: %s", err.Error())
+		return nil, err
+	}
+	return &entries, nil
+}
+
+func (l LedgerDAL) FetchPostings(ctx context.Context, query bson.D) (*[]model.Posting, error) {
+	var postings []model.Posting
+
+	cursor, err := l.PostingCollection.Find(ctx, query)
+	if err != nil {
+		logrus.Errorf("[Mongo]: error fetching postings: %s", err.Error())
+		return nil, err
+	}
+	if err = cursor.All(ctx, &postings); err != nil {
+		logrus.Errorf("[Mongo]: error decoding posting results: %s", err.Error())
+		return nil, err
+	}
+	return &postings, nil
+}
+
+// Balances computes the balance of every ledger account belonging to an owner from its postings
+func (l LedgerDAL) Balances(ctx context.Context, owner, ownerID string) (*[]model.LedgerBalance, error) {
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.D{{"account.owner", owner}, {"account.owner_id", ownerID}}}},
+		{{"$group", bson.D{
+			{"_id", "$account_code"},
+			{"credits", bson.D{{"$sum", bson.D{{"$cond", bson.A{bson.D{{"$eq", bson.A{"$direction", model.Credit}}}, "$amount", 0}}}}}},
+			{"debits", bson.D{{"$sum", bson.D{{"$cond", bson.A{bson.D{{"$eq", bson.A{"$direction", model.Debit}}}, "$amount", 0}}}}}},
+		}}},
+		{{"$addFields", bson.D{{"balance", bson.D{{"$subtract", bson.A{"$credits", "$debits"}}}}}}},
+	}
+
+	var balances []model.LedgerBalance
+	cursor, err := l.PostingCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		logrus.Errorf("[Mongo]: error aggregating ledger balances: %s", err.Error())
+		return nil, err
+	}
+	if err = cursor.All(ctx, &balances); err != nil {
+		logrus.Errorf("[Mongo]: error decoding ledger balances: %s", err.Error())
+		return nil, err
+	}
+	return &balances, nil
+}
+
+// RevenueHours totals the fees paid into the platform revenue accounts from start up to end, per UTC hour, currency
+// and transaction type. query narrows the postings further
+func (l LedgerDAL) RevenueHours(ctx context.Context, start, end time.Time, query bson.D) ([]model.RevenueRollup, error) {
+	match := append(bson.D{
+		{"account.owner", model.LedgerOwnerPlatform},
+		{"account.owner_id", model.LedgerRevenue},
+		{"created_at", bson.D{{"$gte", start}, {"$lt", end}}},
+	}, query...)
+	credit := bson.D{{"$eq", bson.A{"$direction", model.Credit}}}
+	hour := bson.D{{"$dateToString", bson.D{{"format", "%Y-%m-%dT%H:00:00Z"}, {"date", "$created_at"}}}}
+	pipeline := mongo.Pipeline{
+		{{"$match", match}},
+		{{"$group", bson.D{
+			{"_id", bson.D{{"hour", hour}, {"currency", "$account.currency"}, {"type", "$transaction_type"}}},
+			{"credits", bson.D{{"$sum", bson.D{{"$cond", bson.A{credit, "$amount", 0}}}}}},
+			{"debits", bson.D{{"$sum", bson.D{{"$cond", bson.A{credit, 0, "$amount"}}}}}},
+			{"count", bson.D{{"$sum", bson.D{{"$cond", bson.A{credit, 1, 0}}}}}},
+		}}},
+	}
+	var rows []struct {
+		ID struct {
+			Hour     string `bson:"hour"`
+			Currency string `bson:"currency"`
+			Type     string `bson:"type"`
+		} `bson:"_id"`
+		Credits money.Amount `bson:"credits"`
+		Debits  money.Amount `bson:"debits"`
+		Count   int64        `bson:"count"`
+	}
+	cursor, err := l.PostingCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		logrus.Errorf("[Mongo]: error aggregating revenue: %s", err.Error())
+		return nil, err
+	}
+	if err = cursor.All(ctx, &rows); err != nil {
+		logrus.Errorf("[Mongo]: error decoding revenue: %s", err.Error())
+		return nil, err
+	}
+
+	rollups := make([]model.RevenueRollup, 0, len(rows))
+	for _, row := range rows {
+		at, err := time.Parse(time.RFC3339, row.ID.Hour)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse hour %s", row.ID.Hour)
+		}
+		rollups = append(rollups, model.RevenueRollup{
+			Hour:            at,
+			Currency:        row.ID.Currency,
+			TransactionType: row.ID.Type,
+			Count:           row.Count,
+			Amount:          row.Credits - row.Debits,
+		})
+	}
+	return rollups, nil
+}
+
+// postingSortKeys are the keys postings can be listed by, newest first by default
+var postingSortKeys = []string{"-created_at"}
+
+// FetchPostingsPage returns the page req asks for of the postings matching query
+func (l LedgerDAL) FetchPostingsPage(ctx context.Context, query bson.D, req PageRequest) ([]model.Posting, *model.Page, error) {
+	var postings []model.Posting
+	page, err := paginate(ctx, l.PostingCollection, query, req, postingSortKeys, &postings)
+	if err != nil {
+		return nil, nil, err
+	}
+	return postings, page, nil
+}
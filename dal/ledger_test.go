@@ -0,0 +1,79 @@
+package dal
+
+import (
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"math"
+	"testing"
+)
+
+func testPosting(direction, currency string, amount money.Amount) model.Posting {
+	return model.Posting{
+		Account:   model.LedgerAccount{Owner: model.LedgerOwnerUser, OwnerID: "user", Currency: currency, Bucket: model.LedgerBucketAvailable},
+		Direction: direction,
+		Amount:    amount,
+	}
+}
+
+func TestValidateJournalEntry(t *testing.T) {
+	tests := []struct {
+		name     string
+		postings []model.Posting
+		wantErr  bool
+	}{
+		{name: "balanced", postings: []model.Posting{
+			testPosting(model.Debit, "NGN", money.New(100)),
+			testPosting(model.Credit, "NGN", money.New(100)),
+		}},
+		{name: "split credit", postings: []model.Posting{
+			testPosting(model.Debit, "NGN", money.New(100)),
+			testPosting(model.Credit, "NGN", money.New(99)),
+			testPosting(model.Credit, "NGN", money.New(1)),
+		}},
+		{name: "balanced per currency", postings: []model.Posting{
+			testPosting(model.Debit, "NGN", money.New(415)),
+			testPosting(model.Credit, "NGN", money.New(415)),
+			testPosting(model.Debit, "USD", money.New(1)),
+			testPosting(model.Credit, "USD", money.New(1)),
+		}},
+		{name: "no postings", wantErr: true},
+		{name: "single posting", postings: []model.Posting{
+			testPosting(model.Credit, "NGN", money.New(100)),
+		}, wantErr: true},
+		{name: "unbalanced", postings: []model.Posting{
+			testPosting(model.Debit, "NGN", money.New(100)),
+			testPosting(model.Credit, "NGN", money.New(90)),
+		}, wantErr: true},
+		{name: "balanced across currencies only", postings: []model.Posting{
+			testPosting(model.Debit, "NGN", money.New(100)),
+			testPosting(model.Credit, "USD", money.New(100)),
+		}, wantErr: true},
+		{name: "zero amount", postings: []model.Posting{
+			testPosting(model.Debit, "NGN", money.Zero),
+			testPosting(model.Credit, "NGN", money.Zero),
+		}, wantErr: true},
+		{name: "negative amount", postings: []model.Posting{
+			testPosting(model.Debit, "NGN", money.New(-100)),
+			testPosting(model.Credit, "NGN", money.New(-100)),
+		}, wantErr: true},
+		{name: "unknown direction", postings: []model.Posting{
+			testPosting("transfer", "NGN", money.New(100)),
+			testPosting(model.Credit, "NGN", money.New(100)),
+		}, wantErr: true},
+		{name: "overflowing total", postings: []model.Posting{
+			testPosting(model.Credit, "NGN", math.MaxInt64),
+			testPosting(model.Credit, "NGN", 1),
+			testPosting(model.Debit, "NGN", math.MaxInt64),
+			testPosting(model.Debit, "NGN", 1),
+		}, wantErr: true},
+	}
+	for _, tt := range tests {
+		err := validateJournalEntry(&model.JournalEntry{Postings: tt.postings})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateJournalEntry() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+	if err := validateJournalEntry(nil); err == nil {
+		t.Error("validateJournalEntry(nil) did not fail")
+	}
+}
@@ -0,0 +1,171 @@
+package dal
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"github.com/isongjosiah/work/onepurse-api/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+// TransactionTypes is every kind of transaction, each kept in its own collection
+var TransactionTypes = []string{types.TRANSFER, types.WITHDRAW, types.DEPOSIT, types.EXCHANGE, types.ONE_PURSE_TRANSACTION}
+
+// transactionFields are the collection of a transaction type and the fields its amount, currency, status and parties
+// are kept in, which differ between collections
+type transactionFields struct {
+	collection     *mongo.Collection
+	amount         string
+	currency       string
+	status         string
+	user           string   // user who created the transaction
+	agent          string   // agent the transaction was matched to, empty when it cannot have one
+	counterparties []string // agent or user on the other side of the transaction
+}
+
+func (t TransactionDAL) fieldsOf(transactionType string) (transactionFields, error) {
+	switch transactionType {
+	case types.TRANSFER:
+		return transactionFields{
+			collection: t.TransferCollection, amount: "base_amount", currency: "base_currency", status: "status",
+			user: "user_id", agent: "agent_id", counterparties: []string{"agent_id"},
+		}, nil
+	case types.WITHDRAW:
+		return transactionFields{
+			collection: t.WithdrawalCollection, amount: "amount", currency: "currency", status: "status",
+			user: "user_id",
+		}, nil
+	case types.DEPOSIT:
+		return transactionFields{
+			collection: t.DepositCollection, amount: "amount", currency: "base_currency", status: "status",
+			user: "user_id", agent: "agent_id", counterparties: []string{"agent_id"},
+		}, nil
+	case types.EXCHANGE:
+		return transactionFields{
+			collection: t.ExchangeCollection, amount: "base_amount", currency: "base_currency", status: "status",
+			user: "user", agent: "agent_id", counterparties: []string{"agent_id", "matched_user_id"},
+		}, nil
+	case types.ONE_PURSE_TRANSACTION:
+		// one purse payments settle as they are made, so whether it is a payment or a request is what matters
+		return transactionFields{
+			collection: t.OnePurseTransactionCollection, amount: "amount", currency: "currency", status: "type",
+			user: "from_user._id", counterparties: []string{"to_user._id"},
+		}, nil
+	}
+	return transactionFields{}, errors.Errorf("unknown transaction type %s", transactionType)
+}
+
+// transactionIndexes index the times the metrics rollups look for changed transactions by, and the parties of
+// transactions for their history
+func transactionIndexes(fields transactionFields) []mongo.IndexModel {
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{"created_at", -1}, {"_id", -1}}},
+		{Keys: bson.D{{"updated_at", 1}}},
+		{Keys: bson.D{{fields.user, 1}, {"created_at", -1}}},
+	}
+	for _, counterparty := range fields.counterparties {
+		indexes = append(indexes, mongo.IndexModel{Keys: bson.D{{counterparty, 1}, {"created_at", -1}}})
+	}
+	return indexes
+}
+
+// ChangedDays returns the UTC days in which the transactions of a type that were created or updated since since were
+// created. A zero since returns every day with a transaction
+func (t TransactionDAL) ChangedDays(ctx context.Context, transactionType string, since time.Time) ([]time.Time, error) {
+	fields, err := t.fieldsOf(transactionType)
+	if err != nil {
+		return nil, err
+	}
+	query := bson.D{{"created_at", bson.D{{"$gt", time.Time{}}}}}
+	if !since.IsZero() {
+		query = bson.D{{"$or", bson.A{
+			bson.D{{"created_at", bson.D{{"$gte", since}}}},
+			bson.D{{"updated_at", bson.D{{"$gte", since}}}},
+		}}}
+	}
+	pipeline := mongo.Pipeline{
+		{{"$match", query}},
+		{{"$group", bson.D{{"_id", bson.D{{"$dateToString", bson.D{{"format", "%Y-%m-%d"}, {"date", "$created_at"}}}}}}}},
+	}
+	var rows []struct {
+		Day string `bson:"_id"`
+	}
+	if err := t.aggregate(ctx, fields.collection, pipeline, &rows); err != nil {
+		return nil, err
+	}
+
+	days := make([]time.Time, 0, len(rows))
+	for _, row := range rows {
+		day, err := time.Parse("2006-01-02", row.Day)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse day %s", row.Day)
+		}
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+// RollupHours counts and totals the transactions of a type created from start up to end, per UTC hour, currency and
+// status
+func (t TransactionDAL) RollupHours(ctx context.Context, transactionType string, start, end time.Time) ([]model.MetricsRollup, error) {
+	fields, err := t.fieldsOf(transactionType)
+	if err != nil {
+		return nil, err
+	}
+	hour := bson.D{{"$dateToString", bson.D{{"format", "%Y-%m-%dT%H:00:00Z"}, {"date", "$created_at"}}}}
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.D{{"created_at", bson.D{{"$gte", start}, {"$lt", end}}}}}},
+		{{"$group", bson.D{
+			{"_id", bson.D{{"hour", hour}, {"currency", "$" + fields.currency}, {"status", "$" + fields.status}}},
+			{"volume", bson.D{{"$sum", "$" + fields.amount}}},
+			{"count", bson.D{{"$sum", 1}}},
+		}}},
+	}
+	var rows []struct {
+		ID struct {
+			Hour     string `bson:"hour"`
+			Currency string `bson:"currency"`
+			Status   string `bson:"status"`
+		} `bson:"_id"`
+		Volume money.Amount `bson:"volume"`
+		Count  int64        `bson:"count"`
+	}
+	if err := t.aggregate(ctx, fields.collection, pipeline, &rows); err != nil {
+		return nil, err
+	}
+
+	rollups := make([]model.MetricsRollup, 0, len(rows))
+	for _, row := range rows {
+		periodStart, err := time.Parse(time.RFC3339, row.ID.Hour)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse hour %s", row.ID.Hour)
+		}
+		rollups = append(rollups, model.MetricsRollup{
+			Granularity: model.RollupHour,
+			PeriodStart: periodStart,
+			Type:        transactionType,
+			Currency:    row.ID.Currency,
+			Status:      row.ID.Status,
+			Count:       row.Count,
+			Volume:      row.Volume,
+		})
+	}
+	return rollups, nil
+}
+
+func (t TransactionDAL) aggregate(ctx context.Context, collection *mongo.Collection, pipeline mongo.Pipeline, results interface{}) error {
+	cursor, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		logrus.Errorf("[Mongo]: error aggregating %s: %s", collection.Name(), err.Error())
+		return err
+	}
+	if err := cursor.All(ctx, results); err != nil {
+		logrus.Errorf("[Mongo]: error decoding %s aggregation: %s", collection.Name(), err.Error())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package dal
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/config"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// Migration is a versioned change to the database: indexes, validators or a transformation of stored documents.
+// Migrations are applied once each in order of version. A migration that fails part way, or replicas starting
+// together, can apply it again, so it must be safe to run more than once
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, m *Migrator) error
+}
+
+// Migrator applies the migrations not yet recorded in the schema_migrations collection. In a dry run it logs what
+// each pending migration would change without changing anything
+type Migrator struct {
+	DB         *mongo.Database
+	DryRun     bool
+	Migrations []Migration
+	collection *mongo.Collection
+}
+
+// NewMigrator returns a Migrator of the migrations of this version of the API
+func NewMigrator(db *mongo.Database, dryRun bool) *Migrator {
+	return &Migrator{
+		DB:         db,
+		DryRun:     dryRun,
+		Migrations: migrations,
+		collection: db.Collection("schema_migrations"),
+	}
+}
+
+// OpenMigrator connects to the database of cfg without setting up the rest of the DAL, for the migrate command
+func OpenMigrator(cfg *config.Config, dryRun bool) (*Migrator, error) {
+	_, db, err := connect(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewMigrator(db, dryRun), nil
+}
+
+// Applied returns the migrations recorded as applied, by version
+func (m *Migrator) Applied(ctx context.Context) (map[int]model.SchemaMigration, error) {
+	cursor, err := m.collection.Find(ctx, bson.D{})
+	if err != nil {
+		logrus.Errorf("[Mongo]: error fetching schema migrations: %s", err.Error())
+		return nil, err
+	}
+	var records []model.SchemaMigration
+	if err := cursor.All(ctx, &records); err != nil {
+		logrus.Errorf("[Mongo]: error decoding schema migrations: %s", err.Error())
+		return nil, err
+	}
+	applied := make(map[int]model.SchemaMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// Pending returns the migrations not yet applied, in order
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	for i := 1; i < len(m.Migrations); i++ {
+		if m.Migrations[i].Version <= m.Migrations[i-1].Version {
+			return nil, errors.Errorf("migration %d %s is out of order", m.Migrations[i].Version, m.Migrations[i].Name)
+		}
+	}
+	applied, err := m.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	known := map[int]bool{}
+	var pending []Migration
+	for _, migration := range m.Migrations {
+		known[migration.Version] = true
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+	for version, record := range applied {
+		if !known[version] {
+			logrus.Warnf("[Migration]: %d %s was applied by a newer version of the API", version, record.Name)
+		}
+	}
+	return pending, nil
+}
+
+// Run applies the pending migrations in order and returns how many it applied. It stops at the first that fails
+func (m *Migrator) Run(ctx context.Context) (int, error) {
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for i, migration := range pending {
+		if m.DryRun {
+			logrus.Infof("[Migration]: dry run of %d %s", migration.Version, migration.Name)
+		} else {
+			logrus.Infof("[Migration]: applying %d %s", migration.Version, migration.Name)
+		}
+		start := time.Now()
+		if err := migration.Up(ctx, m); err != nil {
+			return i, errors.Wrapf(err, "migration %d %s failed", migration.Version, migration.Name)
+		}
+		if m.DryRun {
+			continue
+		}
+
+		record := model.SchemaMigration{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			AppliedAt: time.Now(),
+			Duration:  time.Since(start).String(),
+		}
+		if _, err := m.collection.InsertOne(ctx, record); err != nil && !mongo.IsDuplicateKeyError(err) {
+			logrus.Errorf("[Mongo]: error recording schema migration %d: %s", migration.Version, err.Error())
+			return i, err
+		}
+		logrus.Infof("[Migration]: applied %d %s in %s", migration.Version, migration.Name, record.Duration)
+	}
+	return len(pending), nil
+}
+
+// CreateIndexes creates indexes on collection. A dry run reports the unique indexes existing documents would violate
+func (m *Migrator) CreateIndexes(ctx context.Context, collection string, indexes []mongo.IndexModel) error {
+	if !m.DryRun {
+		_, err := m.DB.Collection(collection).Indexes().CreateMany(ctx, indexes)
+		return errors.Wrapf(err, "unable to index %s", collection)
+	}
+
+	for _, index := range indexes {
+		logrus.Infof("[Migration]: would index %s on %v", collection, index.Keys)
+		if index.Options == nil || index.Options.Unique == nil || !*index.Options.Unique {
+			continue
+		}
+		duplicates, err := m.countDuplicates(ctx, collection, index)
+		if err != nil {
+			return err
+		}
+		if duplicates > 0 {
+			logrus.Warnf("[Migration]: %d values are duplicated in %s on %v, the unique index cannot be created", duplicates, collection, index.Keys)
+		}
+	}
+	return nil
+}
+
+// countDuplicates counts the values of the keys of a unique index that more than one document has
+func (m *Migrator) countDuplicates(ctx context.Context, collection string, index mongo.IndexModel) (int, error) {
+	keys, ok := index.Keys.(bson.D)
+	if !ok {
+		return 0, errors.Errorf("index keys of %s must be a bson.D", collection)
+	}
+	group := bson.D{}
+	for _, key := range keys {
+		group = append(group, bson.E{Key: key.Key, Value: "$" + key.Key})
+	}
+	// a partial index only holds the documents matching its filter
+	var match interface{} = bson.D{}
+	if index.Options.PartialFilterExpression != nil {
+		match = index.Options.PartialFilterExpression
+	}
+	pipeline := mongo.Pipeline{
+		{{"$match", match}},
+		{{"$group", bson.D{{"_id", group}, {"count", bson.D{{"$sum", 1}}}}}},
+		{{"$match", bson.D{{"count", bson.D{{"$gt", 1}}}}}},
+		{{"$count", "duplicates"}},
+	}
+	cursor, err := m.DB.Collection(collection).Aggregate(ctx, pipeline)
+	if err != nil {
+		logrus.Errorf("[Mongo]: error counting duplicates in %s: %s", collection, err.Error())
+		return 0, err
+	}
+	var result []struct {
+		Duplicates int `bson:"duplicates"`
+	}
+	if err := cursor.All(ctx, &result); err != nil {
+		return 0, err
+	}
+	if len(result) == 0 {
+		return 0, nil
+	}
+	return result[0].Duplicates, nil
+}
+
+// SetValidator makes collection reject inserts, and updates of valid documents, that do not match a JSON schema. A
+// dry run reports how many existing documents do not match it
+func (m *Migrator) SetValidator(ctx context.Context, collection string, schema bson.D) error {
+	validator := bson.D{{"$jsonSchema", schema}}
+	if m.DryRun {
+		invalid, err := m.DB.Collection(collection).CountDocuments(ctx, bson.D{{"$nor", bson.A{validator}}})
+		if err != nil {
+			logrus.Errorf("[Mongo]: error counting invalid documents in %s: %s", collection, err.Error())
+			return err
+		}
+		logrus.Infof("[Migration]: would validate %s, %d existing documents do not match", collection, invalid)
+		return nil
+	}
+
+	command := bson.D{
+		{"collMod", collection},
+		{"validator", validator},
+		{"validationLevel", "moderate"},
+		{"validationAction", "error"},
+	}
+	err := m.DB.RunCommand(ctx, command).Err()
+	var commandErr mongo.CommandError
+	if errors.As(err, &commandErr) && commandErr.Name == "NamespaceNotFound" {
+		opts := options.CreateCollection().SetValidator(validator).SetValidationLevel("moderate").SetValidationAction("error")
+		err = m.DB.CreateCollection(ctx, collection, opts)
+	}
+	return errors.Wrapf(err, "unable to validate %s", collection)
+}
+
+// Transform rewrites the documents of collection matching filter. transform returns the update of a document, or
+// nil to leave it as it is. A dry run counts the documents that would be updated
+func (m *Migrator) Transform(ctx context.Context, collection string, filter bson.D, transform func(doc bson.Raw) (bson.D, error)) error {
+	coll := m.DB.Collection(collection)
+	cursor, err := coll.Find(ctx, filter)
+	if err != nil {
+		logrus.Errorf("[Mongo]: error fetching %s: %s", collection, err.Error())
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	updated := 0
+	for cursor.Next(ctx) {
+		update, err := transform(cursor.Current)
+		if err != nil {
+			return errors.Wrapf(err, "unable to transform %s in %s", cursor.Current.Lookup("_id"), collection)
+		}
+		if update == nil {
+			continue
+		}
+		updated++
+		if m.DryRun {
+			continue
+		}
+		if _, err := coll.UpdateOne(ctx, bson.D{{"_id", cursor.Current.Lookup("_id")}}, update); err != nil {
+			logrus.Errorf("[Mongo]: error transforming %s: %s", collection, err.Error())
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	if m.DryRun {
+		logrus.Infof("[Migration]: would update %d documents in %s", updated, collection)
+	} else {
+		logrus.Infof("[Migration]: updated %d documents in %s", updated, collection)
+	}
+	return nil
+}
+
+// DropIndex drops the index of collection called name, if it exists
+func (m *Migrator) DropIndex(ctx context.Context, collection, name string) error {
+	if m.DryRun {
+		logrus.Infof("[Migration]: would drop index %s of %s", name, collection)
+		return nil
+	}
+	_, err := m.DB.Collection(collection).Indexes().DropOne(ctx, name)
+	var commandErr mongo.CommandError
+	if errors.As(err, &commandErr) && (commandErr.Name == "IndexNotFound" || commandErr.Name == "NamespaceNotFound") {
+		return nil
+	}
+	return errors.Wrapf(err, "unable to drop index %s of %s", name, collection)
+}
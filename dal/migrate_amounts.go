@@ -0,0 +1,101 @@
+package dal
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// amountCollections are the collections whose float fields hold amounts or wallet balances
+var amountCollections = []string{
+	"transfer",
+	"withdraw",
+	"deposit",
+	"exchange",
+	"one-purse-transaction",
+	"admin-payments",
+	"user",
+	"agent",
+}
+
+// rateCollections are the collections whose float fields hold exchange rates
+var rateCollections = []string{
+	"rate",
+}
+
+// migrateFloatAmounts rewrites the float amounts, balances and rates written by the float32 models as Decimal128.
+// Documents are read through the same conversion the models use, so a migrated document decodes to exactly the
+// value it decoded to before. The migration only touches double fields and is safe to run more than once
+func migrateFloatAmounts(ctx context.Context, m *Migrator) error {
+	for _, name := range amountCollections {
+		if err := m.Transform(ctx, name, bson.D{}, floatFieldsUpdate(toAmount)); err != nil {
+			return errors.Wrapf(err, "unable to migrate amounts in %s", name)
+		}
+	}
+	for _, name := range rateCollections {
+		if err := m.Transform(ctx, name, bson.D{}, floatFieldsUpdate(toRate)); err != nil {
+			return errors.Wrapf(err, "unable to migrate rates in %s", name)
+		}
+	}
+	return nil
+}
+
+func toAmount(v bson.RawValue) (interface{}, error) {
+	var a money.Amount
+	err := v.Unmarshal(&a)
+	return a, err
+}
+
+func toRate(v bson.RawValue) (interface{}, error) {
+	var r money.Rate
+	err := v.Unmarshal(&r)
+	return r, err
+}
+
+// floatFieldsUpdate returns the transform that sets every double of a document to its conversion
+func floatFieldsUpdate(convert func(bson.RawValue) (interface{}, error)) func(doc bson.Raw) (bson.D, error) {
+	return func(doc bson.Raw) (bson.D, error) {
+		update := bson.D{}
+		if err := collectFloatFields(doc, "", convert, &update); err != nil {
+			return nil, err
+		}
+		if len(update) == 0 {
+			return nil, nil
+		}
+		return bson.D{{"$set", update}}, nil
+	}
+}
+
+// collectFloatFields walks a document and adds a $set for every double it contains, keyed by its dotted path
+func collectFloatFields(doc bson.Raw, prefix string, convert func(bson.RawValue) (interface{}, error), update *bson.D) error {
+	elements, err := doc.Elements()
+	if err != nil {
+		return err
+	}
+	for _, element := range elements {
+		path := element.Key()
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+		value := element.Value()
+		switch value.Type {
+		case bsontype.Double:
+			converted, err := convert(value)
+			if err != nil {
+				return errors.Wrapf(err, "unable to convert %s", path)
+			}
+			*update = append(*update, bson.E{Key: path, Value: converted})
+		case bsontype.EmbeddedDocument:
+			if err := collectFloatFields(value.Document(), path, convert, update); err != nil {
+				return err
+			}
+		case bsontype.Array:
+			if err := collectFloatFields(bson.Raw(value.Array()), path, convert, update); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+package dal
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"strings"
+	"time"
+)
+
+// migrations are the migrations of the database, in the order they are applied. Append new migrations with the next
+// version and never change one that has been released
+var migrations = []Migration{
+	{Version: 1, Name: "index_existing_collections", Up: indexExistingCollections},
+	{Version: 2, Name: "migrate_float_amounts", Up: migrateFloatAmounts},
+	{Version: 3, Name: "unique_contacts_and_lookup_indexes", Up: uniqueContactsAndLookupIndexes},
+	{Version: 4, Name: "validate_profiles_and_ledger", Up: validateProfilesAndLedger},
+	{Version: 5, Name: "version_exchange_rates", Up: versionExchangeRates},
+	{Version: 6, Name: "index_rate_proposals", Up: indexRateProposals},
+	{Version: 7, Name: "currency_registry", Up: currencyRegistry},
+	{Version: 8, Name: "index_fee_rules", Up: indexFeeRules},
+	{Version: 9, Name: "index_commissions", Up: indexCommissions},
+}
+
+// indexExistingCollections creates the indexes the DAL used to ensure when it was set up
+func indexExistingCollections(ctx context.Context, m *Migrator) error {
+	transactionDAL := NewTransactionDAL(m.DB)
+	for _, transactionType := range TransactionTypes {
+		fields, err := transactionDAL.fieldsOf(transactionType)
+		if err != nil {
+			return err
+		}
+		if err := m.CreateIndexes(ctx, fields.collection.Name(), transactionIndexes(fields)); err != nil {
+			return err
+		}
+	}
+
+	indexes := map[string][]mongo.IndexModel{
+		"user":            userIndexes,
+		"agent":           agentIndexes,
+		"idempotency-key": idempotencyIndexes,
+		"local-identity":  identityIndexes,
+		"otp-challenge":   otpIndexes,
+		"audit-log":       auditIndexes,
+		"metrics-rollup":  rollupIndexes,
+	}
+	for collection, models := range indexes {
+		if err := m.CreateIndexes(ctx, collection, models); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uniqueUnlessEmpty makes a unique index ignore documents where the indexed string is empty, such as users who have
+// not picked a username yet
+func uniqueUnlessEmpty(field string) mongo.IndexModel {
+	return mongo.IndexModel{
+		Keys:    bson.D{{field, 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.D{{field, bson.D{{"$gt", ""}}}}),
+	}
+}
+
+// uniqueContactsAndLookupIndexes makes the usernames and emails of users and agents unique, replacing the plain
+// indexes searches used, and indexes the owner lookups of notifications, accounts, the ledger and jobs
+func uniqueContactsAndLookupIndexes(ctx context.Context, m *Migrator) error {
+	for _, collection := range []string{"user", "agent"} {
+		for _, field := range []string{"username", "email"} {
+			if err := m.DropIndex(ctx, collection, field+"_1"); err != nil {
+				return err
+			}
+			if err := m.CreateIndexes(ctx, collection, []mongo.IndexModel{uniqueUnlessEmpty(field)}); err != nil {
+				return err
+			}
+		}
+	}
+
+	indexes := map[string][]mongo.IndexModel{
+		"user-notification": {
+			{Keys: bson.D{{"user_id", 1}, {"created_at", -1}}},
+		},
+		"account": {
+			{Keys: bson.D{{"agent_id", 1}}},
+		},
+		"posting": {
+			{Keys: bson.D{{"account.owner", 1}, {"account.owner_id", 1}, {"created_at", -1}}},
+			{Keys: bson.D{{"transaction_id", 1}}},
+		},
+		"journal-entry": {
+			{Keys: bson.D{{"transaction_id", 1}}},
+		},
+		"job": {
+			{Keys: bson.D{{"status", 1}, {"next_run_at", 1}}},
+		},
+	}
+	for collection, models := range indexes {
+		if err := m.CreateIndexes(ctx, collection, models); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateProfilesAndLedger makes mongo reject users, agents and ledger entries missing the fields the API relies on,
+// or holding amounts that are not decimals
+func validateProfilesAndLedger(ctx context.Context, m *Migrator) error {
+	schemas := map[string]bson.D{
+		"user": {
+			{"bsonType", "object"},
+			{"required", bson.A{"_id", "email", "approved", "created_at"}},
+			{"properties", bson.D{
+				{"_id", bson.D{{"bsonType", "string"}}},
+				{"email", bson.D{{"bsonType", "string"}}},
+				{"username", bson.D{{"bsonType", "string"}}},
+				{"approved", bson.D{{"bsonType", "bool"}}},
+				{"wallet", bson.D{{"bsonType", bson.A{"object", "null"}}}},
+				{"created_at", bson.D{{"bsonType", "date"}}},
+			}},
+		},
+		"agent": {
+			{"bsonType", "object"},
+			{"required", bson.A{"_id", "username", "email", "approved"}},
+			{"properties", bson.D{
+				{"_id", bson.D{{"bsonType", "string"}}},
+				{"username", bson.D{{"bsonType", "string"}}},
+				{"email", bson.D{{"bsonType", "string"}}},
+				{"approved", bson.D{{"bsonType", "bool"}}},
+				{"wallet", bson.D{{"bsonType", "object"}}},
+			}},
+		},
+		"journal-entry": {
+			{"bsonType", "object"},
+			{"required", bson.A{"_id", "transaction_id", "transaction_type", "created_at"}},
+			{"properties", bson.D{
+				{"transaction_id", bson.D{{"bsonType", "string"}}},
+				{"created_at", bson.D{{"bsonType", "date"}}},
+			}},
+		},
+		"posting": {
+			{"bsonType", "object"},
+			{"required", bson.A{"_id", "journal_id", "transaction_id", "account", "direction", "amount", "created_at"}},
+			{"properties", bson.D{
+				{"account", bson.D{
+					{"bsonType", "object"},
+					{"required", bson.A{"owner", "owner_id", "currency"}},
+				}},
+				{"direction", bson.D{{"enum", bson.A{model.Debit, model.Credit}}}},
+				{"amount", bson.D{{"bsonType", "decimal"}}},
+				{"created_at", bson.D{{"bsonType", "date"}}},
+			}},
+		},
+	}
+	for collection, schema := range schemas {
+		if err := m.SetValidator(ctx, collection, schema); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// versionExchangeRates indexes rate versions and quotes, and turns the single document of rates maps the admin
+// console used to edit into a first version of each pair it held, with no spread or margin
+func versionExchangeRates(ctx context.Context, m *Migrator) error {
+	if err := m.CreateIndexes(ctx, "exchange-rate", rateIndexes); err != nil {
+		return err
+	}
+	if err := m.CreateIndexes(ctx, "quote", quoteIndexes); err != nil {
+		return err
+	}
+
+	var legacy map[string]interface{}
+	err := m.DB.Collection("rate").FindOne(ctx, bson.D{}).Decode(&legacy)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to read the legacy rates")
+	}
+
+	rateDAL := NewRateDAL(m.DB)
+	now := time.Now()
+	for base, quotes := range legacy {
+		if base == "_id" {
+			continue
+		}
+		var rates map[string]money.Rate
+		raw, err := bson.Marshal(quotes)
+		if err != nil {
+			return err
+		}
+		if err := bson.Unmarshal(raw, &rates); err != nil {
+			return errors.Wrapf(err, "unable to read the legacy %s rates", base)
+		}
+
+		for quote, mid := range rates {
+			rate := model.ExchangeRate{
+				Base:        strings.ToUpper(base),
+				Quote:       strings.ToUpper(quote),
+				Mid:         mid,
+				Source:      "legacy",
+				EffectiveAt: now,
+				CreatedAt:   now,
+			}
+			if rate.Validate() != nil {
+				continue // a zero or same currency entry of the old maps
+			}
+			_, err := rateDAL.Current(ctx, rate.Base, rate.Quote, now)
+			if err == nil {
+				continue // versioned by an earlier run
+			}
+			if err != ErrRateNotFound {
+				return err
+			}
+			if m.DryRun {
+				logrus.Infof("[Migration]: would version %s at %s", rate.Pair(), rate.Mid)
+				continue
+			}
+			if err := rateDAL.Add(ctx, &rate); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// indexRateProposals indexes the rates fetched from a rate provider that wait for an admin to approve them
+func indexRateProposals(ctx context.Context, m *Migrator) error {
+	return m.CreateIndexes(ctx, "exchange-rate-proposal", rateProposalIndexes)
+}
+
+// registeredCurrencies are the currencies the platform held wallets and rates in before the registry, registered
+// and enabled so existing wallets keep working
+var registeredCurrencies = []model.Currency{
+	{Code: "NGN", Label: "Nigerian Naira", Type: model.CurrencyFiat, MinorUnits: 2, Symbol: "₦"},
+	{Code: "USD", Label: "US Dollar", Type: model.CurrencyFiat, MinorUnits: 2, Symbol: "$"},
+	{Code: "BSD", Label: "Bahamian Dollar", Type: model.CurrencyFiat, MinorUnits: 2, Symbol: "B$"},
+	{Code: "BTC", Label: "Bitcoin", Type: model.CurrencyCrypto, MinorUnits: 8, Symbol: "₿", BlockchainNetworks: []string{"bitcoin"}},
+}
+
+// currencyRegistry turns the label, slug and icon documents the admin console created into registry currencies
+// keyed by code, and registers the currencies wallets were already held in. Legacy documents whose slug is not a
+// currency code are moved to currency-legacy for an admin to register by hand
+func currencyRegistry(ctx context.Context, m *Migrator) error {
+	collection := m.DB.Collection("currency")
+	cursor, err := collection.Find(ctx, bson.D{{"slug", bson.D{{"$exists", true}}}})
+	if err != nil {
+		return errors.Wrap(err, "unable to read the legacy currencies")
+	}
+	var legacy []bson.M
+	if err := cursor.All(ctx, &legacy); err != nil {
+		return errors.Wrap(err, "unable to read the legacy currencies")
+	}
+
+	now := time.Now()
+	currencies := map[string]model.Currency{}
+	for _, currency := range registeredCurrencies {
+		currencies[currency.Code] = currency
+	}
+	for _, doc := range legacy {
+		label, _ := doc["label"].(string)
+		slug, _ := doc["slug"].(string)
+		icon, _ := doc["icon"].(string)
+		code := strings.ToUpper(strings.TrimSpace(slug))
+
+		currency, ok := currencies[code]
+		if !ok {
+			currency = model.Currency{Code: code, Type: model.CurrencyFiat, MinorUnits: money.MinorUnits(code)}
+			if money.IsCrypto(code) {
+				currency.Type = model.CurrencyCrypto
+			}
+		}
+		if label != "" {
+			currency.Label = label
+		}
+		currency.Icon = icon
+		if err := currency.Validate(); err != nil {
+			logrus.Warnf("[Migration]: moving currency %q to currency-legacy: %s", slug, err.Error())
+			if m.DryRun {
+				continue
+			}
+			if _, err := m.DB.Collection("currency-legacy").InsertOne(ctx, doc); err != nil && !mongo.IsDuplicateKeyError(err) {
+				return err
+			}
+			if _, err := collection.DeleteOne(ctx, bson.D{{"_id", doc["_id"]}}); err != nil {
+				return err
+			}
+			continue
+		}
+		currencies[code] = currency
+	}
+
+	for code, currency := range currencies {
+		currency.Enabled = true
+		currency.CreatedAt, currency.UpdatedAt = now, now
+		if m.DryRun {
+			logrus.Infof("[Migration]: would register %s (%s) if not registered", code, currency.Label)
+			continue
+		}
+		_, err := collection.InsertOne(ctx, currency)
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return errors.Wrapf(err, "unable to register %s", code)
+		}
+	}
+	if m.DryRun || len(legacy) == 0 {
+		return nil
+	}
+	_, err = collection.DeleteMany(ctx, bson.D{{"slug", bson.D{{"$exists", true}}}})
+	return err
+}
+
+func indexFeeRules(ctx context.Context, m *Migrator) error {
+	return m.CreateIndexes(ctx, "fee-rule", feeRuleIndexes)
+}
+
+func indexCommissions(ctx context.Context, m *Migrator) error {
+	for collection, indexes := range commissionIndexes {
+		if err := m.CreateIndexes(ctx, collection, indexes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,5 +1,10 @@
 package model
 
+import (
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"time"
+)
+
 // Admin is the struct that defines an admin
 type Admin struct {
 	ID       string `bson:"_id"`
@@ -8,7 +13,14 @@ type Admin struct {
 	Email    string `bson:"email" json:"email"`
 	Phone    string `bson:"phone" json:"phone"`
 	Avatar   string `bson:"avatar" json:"avatar"`
-	Role     *Role  `bson:"role" json:"role"`
+	RoleID   string `bson:"role_id" json:"role_id"` // slug of the role that grants the admin access
+	Role     *Role  `bson:"role" json:"role"`       // copy of the role at the time it was assigned
+
+	// Authenticator used for step-up codes on sensitive actions
+	TOTPEnabled       bool   `bson:"totp_enabled" json:"totp_enabled"`
+	TOTPSecret        string `bson:"totp_secret" json:"-"`
+	TOTPPendingSecret string `bson:"totp_pending_secret" json:"-"` // secret being enrolled until a code from it is confirmed
+	TOTPLastStep      int64  `bson:"totp_last_step" json:"-"`      // time step of the last code accepted, so a code cannot be replayed
 }
 
 type NewPasswordChallengeInput struct {
@@ -18,20 +30,27 @@ type NewPasswordChallengeInput struct {
 	Session  string `json:"session"`
 }
 
-// Currency is the struct that defines currencies
-type Currency struct {
-	Label string `bson:"label" json:"label"`
-	Slug  string `bson:"slug" json:"slug"`
-	Icon  string `bson:"icon" json:"icon"`
-}
-
 // Role defines the admin roles and access
 type Role struct {
+	ID     string   `bson:"_id" json:"id"` // same as the slug
 	Name   string   `bson:"name" json:"name"`
 	Slug   string   `bson:"slug" json:"slug"`
 	Access []Access `bson:"access" json:"access"`
 }
 
+// HasAccess reports whether the role grants the access with the given name e.g. rates
+func (r *Role) HasAccess(name string) bool {
+	if r == nil {
+		return false
+	}
+	for _, access := range r.Access {
+		if access.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 type Access struct {
 	Name        string `json:"name"`
 	Slug        string `json:"slug"`
@@ -45,15 +64,29 @@ type NumberMetrics struct {
 	NumberOfTransaction int32 `json:"number_of_transaction"`
 }
 
-// TransactionVolumeMetrics defines the model for transaction metrics
+// TransactionVolumeMetrics defines the model for transaction metrics. Values totals each currency over the whole
+// range and Buckets splits it by interval
 type TransactionVolumeMetrics struct {
-	Values map[string]float32 `json:"values"`
+	Interval string                  `json:"interval"` // day, week or month
+	Timezone string                  `json:"timezone"`
+	Start    time.Time               `json:"start"`
+	End      time.Time               `json:"end"` // exclusive
+	Values   map[string]money.Amount `json:"values"`
+	Buckets  []VolumeBucket          `json:"buckets"`
+}
+
+// VolumeBucket is the volume of settled transactions in one interval
+type VolumeBucket struct {
+	Period string                  `json:"period"` // e.g. 2021-12-01, 2021-W48 or 2021-12
+	Start  time.Time               `json:"start"`
+	Count  int64                   `json:"count"`
+	Values map[string]money.Amount `json:"values"`
 }
 
-// CurrencyVolume defines the model for the cu
+// CurrencyVolume defines the model for the volume of each currency, split by fiat and crypto
 type CurrencyVolume struct {
-	Fiat   map[string]float32 `json:"fiat"`
-	Crypto map[string]float32 `json:"crypto"`
+	Fiat   map[string]money.Amount `json:"fiat"`
+	Crypto map[string]money.Amount `json:"crypto"`
 }
 
 type CurrencyMetrics struct {
@@ -80,6 +113,9 @@ const ACTIVITY_LOGS = "activity-logs"
 const MANAGE_PERSONEL = "manage-admins"
 const TWO_FACTOR_AUTH = "2fa"
 
+// SUPER_ADMIN is the slug of the role that is granted every access
+const SUPER_ADMIN = "super-admin"
+
 // Defined Role
 var dashboardRole = &Access{
 	Name:        DASHBOARD,
@@ -108,7 +144,7 @@ var rateRole = &Access{
 var revenueRole = &Access{
 	Name:        REVENUE,
 	Slug:        REVENUE + "-role",
-	Description: "can view revenues",
+	Description: "can view revenues and manage the fee schedule",
 }
 
 var paymentRole = &Access{
@@ -138,5 +174,38 @@ var deactivateRole = &Access{
 var twoFARole = &Access{
 	Name:        TWO_FACTOR_AUTH,
 	Slug:        TWO_FACTOR_AUTH + "-role",
-	Description: "can generate 2fa security code",
+	Description: "can reset the two factor authenticator of other admins",
+}
+
+// Accesses is every access a role can grant
+var Accesses = []*Access{
+	dashboardRole,
+	verificationRole,
+	transactionRole,
+	rateRole,
+	revenueRole,
+	paymentRole,
+	activityLogRole,
+	managePersonelRole,
+	deactivateRole,
+	twoFARole,
+}
+
+// FindAccess returns the access with the given name, or nil when there is none
+func FindAccess(name string) *Access {
+	for _, access := range Accesses {
+		if access.Name == name {
+			return access
+		}
+	}
+	return nil
+}
+
+// SuperAdminRole returns the role that is granted every access
+func SuperAdminRole() *Role {
+	role := &Role{ID: SUPER_ADMIN, Name: "Super Admin", Slug: SUPER_ADMIN}
+	for _, access := range Accesses {
+		role.Access = append(role.Access, *access)
+	}
+	return role
 }
@@ -0,0 +1,59 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// AuditLog records something an admin did. Entries are chained: each one carries the hash of the entry before it,
+// so editing or removing an entry breaks the chain from that point on
+type AuditLog struct {
+	ID        string        `bson:"_id" json:"id"`
+	Seq       int64         `bson:"seq" json:"seq"`           // position in the chain, starting at 1
+	ActorID   string        `bson:"actor_id" json:"actor_id"` // _id of the admin
+	Actor     string        `bson:"actor" json:"actor"`       // kind:id of the caller
+	Role      string        `bson:"role" json:"role"`         // slug of the admin's role at the time
+	Action    string        `bson:"action" json:"action"`     // e.g. PATCH /admin/user/action or step_up.verified
+	Target    string        `bson:"target" json:"target"`     // what the action was performed on e.g. user:<id>
+	Status    int           `bson:"status" json:"status"`     // response status of the request, 0 for entries not tied to a response
+	Changes   []AuditChange `bson:"changes" json:"changes"`
+	RequestID string        `bson:"request_id" json:"request_id"`
+	IP        string        `bson:"ip" json:"ip"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+	PrevHash  string        `bson:"prev_hash" json:"prev_hash"`
+	Hash      string        `bson:"hash" json:"hash"`
+}
+
+// AuditChange is a field that an audited action changed. Values are JSON encoded, and empty when the field did not
+// exist before or after the change
+type AuditChange struct {
+	Field  string `bson:"field" json:"field"`
+	Before string `bson:"before" json:"before"`
+	After  string `bson:"after" json:"after"`
+}
+
+// ComputeHash hashes every field of the entry except Hash itself
+func (l *AuditLog) ComputeHash() string {
+	content, _ := json.Marshal(struct {
+		ID        string
+		Seq       int64
+		ActorID   string
+		Actor     string
+		Role      string
+		Action    string
+		Target    string
+		Status    int
+		Changes   []AuditChange
+		RequestID string
+		IP        string
+		CreatedAt string
+		PrevHash  string
+	}{
+		l.ID, l.Seq, l.ActorID, l.Actor, l.Role, l.Action, l.Target, l.Status, l.Changes, l.RequestID, l.IP,
+		l.CreatedAt.UTC().Format(time.RFC3339Nano), l.PrevHash,
+	})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,165 @@
+package model
+
+import (
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// CommissionRule is an entry of the commission schedule. The commission an agent earns on completing a transaction is
+// set by the most specific enabled rule of its type, in the currency the agent traded, which for an exchange is the
+// currency the user was paid
+type CommissionRule struct {
+	ID              string       `bson:"_id" json:"id"`
+	AgentID         string       `bson:"agent_id" json:"agent_id"`                 // every agent when empty
+	TransactionType string       `bson:"transaction_type" json:"transaction_type"` // transfer, exchange or deposit
+	Currency        string       `bson:"currency" json:"currency"`                 // any when empty
+	Flat            money.Amount `bson:"flat" json:"flat"`
+	Percent         money.Rate   `bson:"percent" json:"percent"`
+	MinAmount       money.Amount `bson:"min_amount" json:"min_amount"` // no minimum when zero
+	MaxAmount       money.Amount `bson:"max_amount" json:"max_amount"` // no cap when zero
+	Enabled         bool         `bson:"enabled" json:"enabled"`
+	CreatedAt       time.Time    `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time    `bson:"updated_at" json:"updated_at"`
+}
+
+// Validate checks the rule can price commissions. Flat commissions and caps are amounts of a currency, so a rule with
+// any of them must be of a single currency
+func (c CommissionRule) Validate() error {
+	if c.Flat == money.Zero && c.Percent.IsZero() {
+		return errors.New("a commission has a flat amount, a percent or both")
+	}
+	if c.Percent.Cmp(money.Rate{}) < 0 || c.Percent.Cmp(maxPercent) >= 0 {
+		return errors.New("percent must be at least 0 and less than 100")
+	}
+	for _, amount := range []money.Amount{c.Flat, c.MinAmount, c.MaxAmount} {
+		if amount < money.Zero {
+			return errors.New("amounts cannot be negative")
+		}
+		if amount == money.Zero {
+			continue
+		}
+		if c.Currency == "" {
+			return errors.New("a rule with a flat amount or caps must be of a single currency")
+		}
+		if !amount.Valid(c.Currency) {
+			return errors.Errorf("%s amounts cannot have more decimal places than the currency", c.Currency)
+		}
+	}
+	if c.MaxAmount > money.Zero && c.MinAmount > c.MaxAmount {
+		return errors.New("min amount cannot be more than max amount")
+	}
+	return nil
+}
+
+// Matches reports whether the rule applies to an agent completing a transaction of a type in currency
+func (c CommissionRule) Matches(agentID, transactionType, currency string) bool {
+	return c.TransactionType == transactionType &&
+		(c.AgentID == "" || c.AgentID == agentID) &&
+		(c.Currency == "" || c.Currency == currency)
+}
+
+// Specificity ranks the rules matching a transaction. A rule of the agent beats one of every agent, and then a rule of
+// the currency beats one of any currency
+func (c CommissionRule) Specificity() int {
+	specificity := 0
+	if c.AgentID != "" {
+		specificity += 2
+	}
+	if c.Currency != "" {
+		specificity++
+	}
+	return specificity
+}
+
+// Commission returns the commission the rule pays on an amount of currency, within the caps of the rule and rounded to
+// the minor units of currency. It fails when the commission does not fit in an Amount
+func (c CommissionRule) Commission(amount money.Amount, currency string) (money.Amount, error) {
+	percentage, err := amount.Percent(c.Percent, currency)
+	if err != nil {
+		return money.Zero, err
+	}
+	commission, err := c.Flat.Add(percentage)
+	if err != nil {
+		return money.Zero, err
+	}
+	if commission < c.MinAmount {
+		commission = c.MinAmount
+	}
+	if c.MaxAmount > money.Zero && commission > c.MaxAmount {
+		commission = c.MaxAmount
+	}
+	return commission.Round(currency), nil
+}
+
+// Commission statuses
+const (
+	CommissionAccrued = "accrued" // earned and waiting for the next settlement run
+	CommissionBatched = "batched" // in a settlement batch waiting for an admin to approve it
+	CommissionPaid    = "paid"
+)
+
+// Commission is what an agent earned on completing a transaction. It is owed to the agent until the settlement batch
+// it is in is approved
+type Commission struct {
+	ID              string       `bson:"_id" json:"id"`
+	AgentID         string       `bson:"agent_id" json:"agent_id"`
+	TransactionID   string       `bson:"transaction_id" json:"transaction_id"`
+	TransactionType string       `bson:"transaction_type" json:"transaction_type"`
+	Currency        string       `bson:"currency" json:"currency"`
+	BaseAmount      money.Amount `bson:"base_amount" json:"base_amount"` // amount of the transaction the commission was priced on
+	Amount          money.Amount `bson:"amount" json:"amount"`
+	RuleID          string       `bson:"rule_id" json:"rule_id"`
+	Status          string       `bson:"status" json:"status"`
+	BatchID         string       `bson:"batch_id,omitempty" json:"batch_id,omitempty"`
+	CreatedAt       time.Time    `bson:"created_at" json:"created_at"`
+	PaidAt          *time.Time   `bson:"paid_at,omitempty" json:"paid_at,omitempty"`
+}
+
+// EarningsTotal is what an agent earned in a currency, by how far it is through settlement
+type EarningsTotal struct {
+	Accrued money.Amount `bson:"accrued" json:"accrued"`
+	Batched money.Amount `bson:"batched" json:"batched"`
+	Paid    money.Amount `bson:"paid" json:"paid"`
+	Count   int64        `bson:"count" json:"count"`
+}
+
+// EarningsStatement is what an agent earned from From up to To. Totals are of the whole range and Commissions is a page
+// of it
+type EarningsStatement struct {
+	AgentID     string                   `json:"agent_id"`
+	From        *time.Time               `json:"from,omitempty"`
+	To          *time.Time               `json:"to,omitempty"` // exclusive
+	Totals      map[string]EarningsTotal `json:"totals"`       // by currency
+	Commissions []Commission             `json:"commissions"`
+}
+
+// Settlement batch statuses
+const (
+	SettlementPending  = "pending"
+	SettlementApproved = "approved"
+	SettlementRejected = "rejected" // its commissions go back to accrued for the next run
+)
+
+// AgentPayout is what a settlement batch pays an agent in a currency
+type AgentPayout struct {
+	AgentID  string       `bson:"agent_id" json:"agent_id"`
+	Currency string       `bson:"currency" json:"currency"`
+	Amount   money.Amount `bson:"amount" json:"amount"`
+	Count    int64        `bson:"count" json:"count"` // commissions paid
+}
+
+// SettlementBatch pays agents the commissions accrued before its cutoff. The agents are paid into their wallets once
+// an admin approves it
+type SettlementBatch struct {
+	ID         string                  `bson:"_id" json:"id"`
+	Cutoff     time.Time               `bson:"cutoff" json:"cutoff"` // commissions accrued before it are in the batch
+	Payouts    []AgentPayout           `bson:"payouts" json:"payouts"`
+	Totals     map[string]money.Amount `bson:"totals" json:"totals"` // by currency
+	Status     string                  `bson:"status" json:"status"`
+	CreatedBy  string                  `bson:"created_by" json:"created_by"` // admin who ran the settlement, or the settlement job
+	ReviewedBy string                  `bson:"reviewed_by,omitempty" json:"reviewed_by,omitempty"`
+	ReviewNote string                  `bson:"review_note,omitempty" json:"review_note,omitempty"`
+	ReviewedAt *time.Time              `bson:"reviewed_at,omitempty" json:"reviewed_at,omitempty"`
+	CreatedAt  time.Time               `bson:"created_at" json:"created_at"`
+}
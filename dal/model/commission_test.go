@@ -0,0 +1,62 @@
+package model
+
+import (
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"testing"
+)
+
+func TestCommissionRuleCommission(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   CommissionRule
+		amount string
+		want   string
+	}{
+		{name: "flat", rule: CommissionRule{Flat: money.New(5)}, amount: "1000", want: "5"},
+		{name: "flat and percent", rule: CommissionRule{Flat: money.New(5), Percent: money.MustParseRate("1")}, amount: "1000", want: "15"},
+		{name: "percent rounded", rule: CommissionRule{Percent: money.MustParseRate("0.5")}, amount: "333.33", want: "1.67"},
+		{name: "min amount", rule: CommissionRule{Percent: money.MustParseRate("1"), MinAmount: money.New(20)}, amount: "1000", want: "20"},
+		{name: "max amount", rule: CommissionRule{Flat: money.New(5), Percent: money.MustParseRate("1"), MaxAmount: money.New(12)}, amount: "1000", want: "12"},
+	}
+	for _, tt := range tests {
+		got, err := tt.rule.Commission(money.MustParse(tt.amount), "NGN")
+		if err != nil {
+			t.Errorf("%s: Commission(%s) error = %v", tt.name, tt.amount, err)
+			continue
+		}
+		if got != money.MustParse(tt.want) {
+			t.Errorf("%s: Commission(%s) = %s, want %s", tt.name, tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestCommissionRuleCommissionOverflow(t *testing.T) {
+	rule := CommissionRule{Flat: money.MustParse("92233720368"), Percent: money.MustParseRate("50")}
+	if _, err := rule.Commission(money.New(1000), "NGN"); err == nil {
+		t.Error("Commission did not fail on overflow")
+	}
+}
+
+func TestCommissionRuleValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    CommissionRule
+		wantErr bool
+	}{
+		{name: "percent of any currency", rule: CommissionRule{Percent: money.MustParseRate("1")}},
+		{name: "flat", rule: CommissionRule{Currency: "NGN", Flat: money.New(5)}},
+		{name: "capped", rule: CommissionRule{Currency: "NGN", Percent: money.MustParseRate("1"), MinAmount: money.New(5), MaxAmount: money.New(50)}},
+		{name: "empty", rule: CommissionRule{Currency: "NGN"}, wantErr: true},
+		{name: "percent of 100", rule: CommissionRule{Percent: money.MustParseRate("100")}, wantErr: true},
+		{name: "negative percent", rule: CommissionRule{Percent: money.MustParseRate("-1")}, wantErr: true},
+		{name: "flat of any currency", rule: CommissionRule{Flat: money.New(5)}, wantErr: true},
+		{name: "negative min amount", rule: CommissionRule{Currency: "NGN", Percent: money.MustParseRate("1"), MinAmount: money.New(-5)}, wantErr: true},
+		{name: "too many decimal places", rule: CommissionRule{Currency: "NGN", Flat: money.MustParse("0.001")}, wantErr: true},
+		{name: "min above max", rule: CommissionRule{Currency: "NGN", Percent: money.MustParseRate("1"), MinAmount: money.New(50), MaxAmount: money.New(5)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := tt.rule.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package model
+
+import (
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"github.com/pkg/errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Currency types
+const (
+	CurrencyFiat   = "fiat"
+	CurrencyCrypto = "crypto"
+)
+
+// currencyCode matches an ISO 4217 code or the ticker of a cryptocurrency
+var currencyCode = regexp.MustCompile(`^[A-Z0-9]{3,10}$`)
+
+// Currency is a currency of the registry. Wallets, rates and transactions are only accepted in registered currencies,
+// and new ones only in enabled currencies
+type Currency struct {
+	Code               string       `bson:"_id" json:"code"` // ISO 4217 code, or the ticker of a cryptocurrency
+	Label              string       `bson:"label" json:"label"`
+	Type               string       `bson:"type" json:"type"`               // fiat or crypto
+	MinorUnits         int          `bson:"minor_units" json:"minor_units"` // decimal places amounts are kept to, fixed once created
+	Symbol             string       `bson:"symbol" json:"symbol"`
+	Icon               string       `bson:"icon" json:"icon"`
+	Enabled            bool         `bson:"enabled" json:"enabled"`
+	MinAmount          money.Amount `bson:"min_amount" json:"min_amount"`                   // smallest transaction amount, no minimum when zero
+	MaxAmount          money.Amount `bson:"max_amount" json:"max_amount"`                   // largest transaction amount, no maximum when zero
+	PaymentChannels    []string     `bson:"payment_channels" json:"payment_channels"`       // channels it is paid in and out through, any when empty
+	BlockchainNetworks []string     `bson:"blockchain_networks" json:"blockchain_networks"` // networks a cryptocurrency is sent over, any when empty
+	CreatedAt          time.Time    `bson:"created_at" json:"created_at"`
+	UpdatedAt          time.Time    `bson:"updated_at" json:"updated_at"`
+}
+
+// Validate checks the currency can be registered
+func (c Currency) Validate() error {
+	if !currencyCode.MatchString(c.Code) {
+		return errors.New("code must be 3 to 10 upper case letters or digits")
+	}
+	if c.Label == "" {
+		return errors.New("label is required")
+	}
+	if c.Type != CurrencyFiat && c.Type != CurrencyCrypto {
+		return errors.Errorf("type must be %s or %s", CurrencyFiat, CurrencyCrypto)
+	}
+	if c.MinorUnits < 0 || c.MinorUnits > money.Scale {
+		return errors.Errorf("minor units must be between 0 and %d", money.Scale)
+	}
+	if c.MinAmount < money.Zero || c.MaxAmount < money.Zero {
+		return errors.New("min and max amount cannot be negative")
+	}
+	if c.MaxAmount > money.Zero && c.MinAmount > c.MaxAmount {
+		return errors.New("min amount cannot be more than max amount")
+	}
+	if c.Type != CurrencyCrypto && len(c.BlockchainNetworks) > 0 {
+		return errors.New("only crypto currencies have blockchain networks")
+	}
+	return nil
+}
+
+// IsCrypto reports whether the currency is a cryptocurrency
+func (c Currency) IsCrypto() bool {
+	return c.Type == CurrencyCrypto
+}
+
+// CheckAmount checks a transaction amount is positive, has no more decimal places than the currency and is within
+// its limits
+func (c Currency) CheckAmount(amount money.Amount) error {
+	if amount <= money.Zero {
+		return errors.New("amount must be greater than zero")
+	}
+	if !amount.Valid(c.Code) {
+		return errors.Errorf("%s amounts cannot have more than %d decimal places", c.Code, c.MinorUnits)
+	}
+	if c.MinAmount > money.Zero && amount < c.MinAmount {
+		return errors.Errorf("%s amounts must be at least %s", c.Code, c.MinAmount.StringFixed(c.Code))
+	}
+	if c.MaxAmount > money.Zero && amount > c.MaxAmount {
+		return errors.Errorf("%s amounts cannot be more than %s", c.Code, c.MaxAmount.StringFixed(c.Code))
+	}
+	return nil
+}
+
+// CheckPaymentChannel checks the currency can be paid through channel
+func (c Currency) CheckPaymentChannel(channel string) error {
+	if !contains(c.PaymentChannels, channel) {
+		return errors.Errorf("%s cannot be paid through %s, use one of %s", c.Code, channel, strings.Join(c.PaymentChannels, ", "))
+	}
+	return nil
+}
+
+// CheckBlockchainNetwork checks the currency can be sent over network
+func (c Currency) CheckBlockchainNetwork(network string) error {
+	if !c.IsCrypto() {
+		return errors.Errorf("%s is not a crypto currency", c.Code)
+	}
+	if !contains(c.BlockchainNetworks, network) {
+		return errors.Errorf("%s cannot be sent over %s, use one of %s", c.Code, network, strings.Join(c.BlockchainNetworks, ", "))
+	}
+	return nil
+}
+
+// contains reports whether value is one of values, ignoring case. An empty list contains every value
+func contains(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
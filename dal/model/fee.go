@@ -0,0 +1,186 @@
+package model
+
+import (
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// Fee kinds
+const (
+	FeeFlat       = "flat"
+	FeePercentage = "percentage"
+	FeeTiered     = "tiered"
+)
+
+// FeeTier is a band of a tiered fee. An amount is charged the flat fee and percentage of the first tier it is no
+// more than UpTo of
+type FeeTier struct {
+	UpTo    money.Amount `bson:"up_to" json:"up_to"` // zero on the last tier, which has no upper bound
+	Flat    money.Amount `bson:"flat" json:"flat"`
+	Percent money.Rate   `bson:"percent" json:"percent"`
+}
+
+// FeeRule is an entry of the fee schedule. The fee of a transaction is set by the most specific enabled rule of its
+// type, and is charged in the currency debited from the user on top of the amount
+type FeeRule struct {
+	ID              string       `bson:"_id" json:"id"`
+	TransactionType string       `bson:"transaction_type" json:"transaction_type"` // transfer, exchange or withdraw
+	Currency        string       `bson:"currency" json:"currency"`                 // currency debited and charged in, any when empty
+	ToCurrency      string       `bson:"to_currency" json:"to_currency"`           // currency converted to, any when empty
+	Kind            string       `bson:"kind" json:"kind"`                         // flat, percentage or tiered
+	Flat            money.Amount `bson:"flat" json:"flat"`
+	Percent         money.Rate   `bson:"percent" json:"percent"`
+	Tiers           []FeeTier    `bson:"tiers" json:"tiers"`     // ascending by UpTo
+	MinFee          money.Amount `bson:"min_fee" json:"min_fee"` // no minimum when zero
+	MaxFee          money.Amount `bson:"max_fee" json:"max_fee"` // no cap when zero
+	Enabled         bool         `bson:"enabled" json:"enabled"`
+	CreatedAt       time.Time    `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time    `bson:"updated_at" json:"updated_at"`
+}
+
+// Validate checks the rule can price fees. Flat fees, tier bounds and caps are amounts of a currency, so a rule with
+// any of them must be of a single currency
+func (f FeeRule) Validate() error {
+	if f.ToCurrency != "" && f.Currency == "" {
+		return errors.New("a rule of a currency converted to must also be of the currency converted from")
+	}
+	amounts := []money.Amount{f.Flat, f.MinFee, f.MaxFee}
+	percents := []money.Rate{f.Percent}
+	switch f.Kind {
+	case FeeFlat:
+		if f.Flat <= money.Zero || !f.Percent.IsZero() || len(f.Tiers) > 0 {
+			return errors.New("a flat fee has a flat amount only")
+		}
+	case FeePercentage:
+		if f.Percent.Cmp(money.Rate{}) <= 0 || f.Flat != money.Zero || len(f.Tiers) > 0 {
+			return errors.New("a percentage fee has a percent only")
+		}
+	case FeeTiered:
+		if len(f.Tiers) == 0 || f.Flat != money.Zero || !f.Percent.IsZero() {
+			return errors.New("a tiered fee has tiers only")
+		}
+		for i, tier := range f.Tiers {
+			if tier.UpTo == money.Zero && i < len(f.Tiers)-1 {
+				return errors.New("only the last tier can be without an up_to amount")
+			}
+			if i > 0 && tier.UpTo != money.Zero && tier.UpTo <= f.Tiers[i-1].UpTo {
+				return errors.New("tiers must be in ascending order of up_to")
+			}
+			amounts = append(amounts, tier.UpTo, tier.Flat)
+			percents = append(percents, tier.Percent)
+		}
+	default:
+		return errors.Errorf("kind must be %s, %s or %s", FeeFlat, FeePercentage, FeeTiered)
+	}
+
+	for _, percent := range percents {
+		if percent.Cmp(money.Rate{}) < 0 || percent.Cmp(maxPercent) >= 0 {
+			return errors.New("percent must be at least 0 and less than 100")
+		}
+	}
+	for _, amount := range amounts {
+		if amount < money.Zero {
+			return errors.New("amounts cannot be negative")
+		}
+		if amount == money.Zero {
+			continue
+		}
+		if f.Currency == "" {
+			return errors.New("a rule with flat fees, tiers or caps must be of a single currency")
+		}
+		if !amount.Valid(f.Currency) {
+			return errors.Errorf("%s amounts cannot have more decimal places than the currency", f.Currency)
+		}
+	}
+	if f.MaxFee > money.Zero && f.MinFee > f.MaxFee {
+		return errors.New("min fee cannot be more than max fee")
+	}
+	return nil
+}
+
+// Matches reports whether the rule applies to a transaction of a type converting currency to toCurrency
+func (f FeeRule) Matches(transactionType, currency, toCurrency string) bool {
+	return f.TransactionType == transactionType &&
+		(f.Currency == "" || f.Currency == currency) &&
+		(f.ToCurrency == "" || f.ToCurrency == toCurrency)
+}
+
+// Specificity ranks the rules matching a transaction. A rule of a currency pair beats one of its currency, which beats
+// one of any currency
+func (f FeeRule) Specificity() int {
+	specificity := 0
+	if f.Currency != "" {
+		specificity += 2
+	}
+	if f.ToCurrency != "" {
+		specificity++
+	}
+	return specificity
+}
+
+// Fee returns the fee the rule charges on an amount of currency, within the caps of the rule and rounded to the minor
+// units of currency. It fails when the fee does not fit in an Amount
+func (f FeeRule) Fee(amount money.Amount, currency string) (money.Amount, error) {
+	flat, percent := f.Flat, f.Percent
+	if f.Kind == FeeTiered {
+		tier := f.tierOf(amount)
+		flat, percent = tier.Flat, tier.Percent
+	}
+
+	percentage, err := amount.Percent(percent, currency)
+	if err != nil {
+		return money.Zero, err
+	}
+	fee, err := flat.Add(percentage)
+	if err != nil {
+		return money.Zero, err
+	}
+	if fee < f.MinFee {
+		fee = f.MinFee
+	}
+	if f.MaxFee > money.Zero && fee > f.MaxFee {
+		fee = f.MaxFee
+	}
+	return fee.Round(currency), nil
+}
+
+// tierOf returns the tier an amount falls in, the last tier when it is above every bound
+func (f FeeRule) tierOf(amount money.Amount) FeeTier {
+	for _, tier := range f.Tiers {
+		if tier.UpTo == money.Zero || amount <= tier.UpTo {
+			return tier
+		}
+	}
+	return f.Tiers[len(f.Tiers)-1]
+}
+
+// RevenueRollup is the fee revenue of a UTC hour in a currency from a type of transaction
+type RevenueRollup struct {
+	Hour            time.Time
+	Currency        string
+	TransactionType string
+	Count           int64 // fees charged
+	Amount          money.Amount
+}
+
+// RevenueReport is the fee revenue of the platform. Values totals each currency over the whole range, Types splits the
+// totals by transaction type and Buckets splits both by interval
+type RevenueReport struct {
+	Interval string                             `json:"interval"` // day, week or month
+	Timezone string                             `json:"timezone"`
+	Start    time.Time                          `json:"start"`
+	End      time.Time                          `json:"end"` // exclusive
+	Values   map[string]money.Amount            `json:"values"`
+	Types    map[string]map[string]money.Amount `json:"types"` // by transaction type, then currency
+	Buckets  []RevenueBucket                    `json:"buckets"`
+}
+
+// RevenueBucket is the fee revenue of one interval
+type RevenueBucket struct {
+	Period string                             `json:"period"` // e.g. 2021-12-01, 2021-W48 or 2021-12
+	Start  time.Time                          `json:"start"`
+	Count  int64                              `json:"count"`
+	Values map[string]money.Amount            `json:"values"`
+	Types  map[string]map[string]money.Amount `json:"types"`
+}
@@ -0,0 +1,73 @@
+package model
+
+import (
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"testing"
+)
+
+var testTiers = []FeeTier{
+	{UpTo: money.New(1000), Flat: money.New(10)},
+	{UpTo: money.New(10000), Percent: money.MustParseRate("1")},
+	{Percent: money.MustParseRate("0.5")},
+}
+
+func TestFeeRuleFee(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   FeeRule
+		amount string
+		want   string
+	}{
+		{name: "flat", rule: FeeRule{Kind: FeeFlat, Flat: money.New(50)}, amount: "1000", want: "50"},
+		{name: "percentage", rule: FeeRule{Kind: FeePercentage, Percent: money.MustParseRate("1.5")}, amount: "1000", want: "15"},
+		{name: "percentage rounded", rule: FeeRule{Kind: FeePercentage, Percent: money.MustParseRate("1.5")}, amount: "0.33", want: "0"},
+		{name: "min fee", rule: FeeRule{Kind: FeePercentage, Percent: money.MustParseRate("1.5"), MinFee: money.New(20)}, amount: "1000", want: "20"},
+		{name: "max fee", rule: FeeRule{Kind: FeePercentage, Percent: money.MustParseRate("1.5"), MaxFee: money.New(10)}, amount: "1000", want: "10"},
+		{name: "first tier", rule: FeeRule{Kind: FeeTiered, Tiers: testTiers}, amount: "500", want: "10"},
+		{name: "tier bound is inclusive", rule: FeeRule{Kind: FeeTiered, Tiers: testTiers}, amount: "1000", want: "10"},
+		{name: "second tier", rule: FeeRule{Kind: FeeTiered, Tiers: testTiers}, amount: "5000", want: "50"},
+		{name: "unbounded tier", rule: FeeRule{Kind: FeeTiered, Tiers: testTiers}, amount: "20000", want: "100"},
+		{name: "tier with max fee", rule: FeeRule{Kind: FeeTiered, Tiers: testTiers, MaxFee: money.New(75)}, amount: "20000", want: "75"},
+	}
+	for _, tt := range tests {
+		got, err := tt.rule.Fee(money.MustParse(tt.amount), "NGN")
+		if err != nil {
+			t.Errorf("%s: Fee(%s) error = %v", tt.name, tt.amount, err)
+			continue
+		}
+		if got != money.MustParse(tt.want) {
+			t.Errorf("%s: Fee(%s) = %s, want %s", tt.name, tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestFeeRuleValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    FeeRule
+		wantErr bool
+	}{
+		{name: "flat", rule: FeeRule{Currency: "NGN", Kind: FeeFlat, Flat: money.New(50)}},
+		{name: "percentage of any currency", rule: FeeRule{Kind: FeePercentage, Percent: money.MustParseRate("1.5")}},
+		{name: "tiered", rule: FeeRule{Currency: "NGN", Kind: FeeTiered, Tiers: testTiers}},
+		{name: "capped", rule: FeeRule{Currency: "NGN", Kind: FeePercentage, Percent: money.MustParseRate("1"), MinFee: money.New(5), MaxFee: money.New(50)}},
+		{name: "unknown kind", rule: FeeRule{Currency: "NGN", Kind: "fixed", Flat: money.New(50)}, wantErr: true},
+		{name: "flat without amount", rule: FeeRule{Currency: "NGN", Kind: FeeFlat}, wantErr: true},
+		{name: "flat with percent", rule: FeeRule{Currency: "NGN", Kind: FeeFlat, Flat: money.New(50), Percent: money.MustParseRate("1")}, wantErr: true},
+		{name: "percentage with flat", rule: FeeRule{Currency: "NGN", Kind: FeePercentage, Flat: money.New(50), Percent: money.MustParseRate("1")}, wantErr: true},
+		{name: "percent of 100", rule: FeeRule{Kind: FeePercentage, Percent: money.MustParseRate("100")}, wantErr: true},
+		{name: "flat of any currency", rule: FeeRule{Kind: FeeFlat, Flat: money.New(50)}, wantErr: true},
+		{name: "to currency only", rule: FeeRule{ToCurrency: "USD", Kind: FeePercentage, Percent: money.MustParseRate("1")}, wantErr: true},
+		{name: "too many decimal places", rule: FeeRule{Currency: "NGN", Kind: FeeFlat, Flat: money.MustParse("0.001")}, wantErr: true},
+		{name: "negative min fee", rule: FeeRule{Currency: "NGN", Kind: FeePercentage, Percent: money.MustParseRate("1"), MinFee: money.New(-1)}, wantErr: true},
+		{name: "min above max", rule: FeeRule{Currency: "NGN", Kind: FeePercentage, Percent: money.MustParseRate("1"), MinFee: money.New(50), MaxFee: money.New(5)}, wantErr: true},
+		{name: "no tiers", rule: FeeRule{Currency: "NGN", Kind: FeeTiered}, wantErr: true},
+		{name: "unbounded middle tier", rule: FeeRule{Currency: "NGN", Kind: FeeTiered, Tiers: []FeeTier{{Flat: money.New(10)}, {UpTo: money.New(1000), Flat: money.New(20)}}}, wantErr: true},
+		{name: "descending tiers", rule: FeeRule{Currency: "NGN", Kind: FeeTiered, Tiers: []FeeTier{{UpTo: money.New(1000), Flat: money.New(10)}, {UpTo: money.New(500), Flat: money.New(20)}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := tt.rule.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"time"
+)
+
+// HistoryEntry is a transaction of any type in the shape shared by every type, with the full transaction attached
+type HistoryEntry struct {
+	ID             string       `json:"id"`
+	Type           string       `json:"type"` // transfer, withdraw, deposit, exchange or one-purse
+	Status         string       `json:"status"`
+	Amount         money.Amount `json:"amount"`
+	Currency       string       `json:"currency"`
+	UserID         string       `json:"user_id"`                   // user who created the transaction
+	AgentID        string       `json:"agent_id,omitempty"`        // agent the transaction was matched to
+	CounterpartyID string       `json:"counterparty_id,omitempty"` // agent, matched user or the other one purse user
+	CreatedAt      time.Time    `json:"created_at"`
+	UpdatedAt      time.Time    `json:"updated_at"`
+	Transaction    interface{}  `json:"transaction"`
+}
+
+// HistoryFilter narrows a transaction history. Empty fields do not filter
+type HistoryFilter struct {
+	Types          []string
+	Statuses       []string
+	Currency       string
+	ParticipantID  string // user or agent who created the transaction or is its counterparty
+	AgentID        string
+	CounterpartyID string
+	From           time.Time // inclusive
+	To             time.Time // exclusive
+}
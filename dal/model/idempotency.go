@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+// Idempotency record states
+const (
+	IdempotencyInProgress = "in-progress"
+	IdempotencyCompleted  = "completed"
+)
+
+// IdempotencyRecord stores the response to the first request made with an Idempotency-Key so retries of the same
+// request can be answered without repeating it
+type IdempotencyRecord struct {
+	ID          string    `bson:"_id" json:"id"`      // scope and key
+	Scope       string    `bson:"scope" json:"scope"` // the caller the key belongs to
+	Key         string    `bson:"key" json:"key"`
+	RequestHash string    `bson:"request_hash" json:"request_hash"` // hash of the method, path and body of the first request
+	State       string    `bson:"state" json:"state"`               // in-progress or completed
+	StatusCode  int       `bson:"status_code" json:"status_code"`
+	Body        []byte    `bson:"body" json:"body"`
+	CreatedAt   time.Time `bson:"created_at" json:"created_at"`
+	ExpiresAt   time.Time `bson:"expires_at" json:"expires_at"`
+}
@@ -0,0 +1,41 @@
+package model
+
+import "time"
+
+// LocalIdentity is an account of the local identity provider, which stands in for cognito during development
+type LocalIdentity struct {
+	ID                string              `bson:"_id" json:"id"`            // the sub claim of the tokens of the account
+	Username          string              `bson:"username" json:"username"` // the email for accounts that sign up
+	Email             string              `bson:"email" json:"email"`
+	Phone             string              `bson:"phone" json:"phone"`
+	Name              string              `bson:"name" json:"name"`
+	PreferredUsername string              `bson:"preferred_username" json:"preferred_username"`
+	PasswordHash      string              `bson:"password_hash" json:"-"`
+	Status            string              `bson:"status" json:"status"` // UNCONFIRMED, CONFIRMED or FORCE_CHANGE_PASSWORD as in cognito
+	Groups            []string            `bson:"groups" json:"groups"`
+	ConfirmationCode  *LocalCode          `bson:"confirmation_code" json:"-"` // sent on sign up
+	ResetCode         *LocalCode          `bson:"reset_code" json:"-"`        // sent when the password is forgotten
+	Session           *LocalCode          `bson:"session" json:"-"`           // issued with the new password challenge
+	RefreshTokens     []LocalRefreshToken `bson:"refresh_tokens" json:"-"`
+	CreatedAt         time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt         time.Time           `bson:"updated_at" json:"updated_at"`
+}
+
+// LocalCode is a hashed one time code of the local identity provider
+type LocalCode struct {
+	Hash      string    `bson:"hash"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// LocalRefreshToken is a hashed refresh token of the local identity provider
+type LocalRefreshToken struct {
+	Hash      string    `bson:"hash"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// Local identity statuses, named after their cognito equivalent
+const (
+	IdentityUnconfirmed         = "UNCONFIRMED"
+	IdentityConfirmed           = "CONFIRMED"
+	IdentityForceChangePassword = "FORCE_CHANGE_PASSWORD"
+)
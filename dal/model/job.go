@@ -0,0 +1,37 @@
+package model
+
+import "time"
+
+// Job kinds
+const (
+	JobKindCron = "cron" // runs repeatedly on a schedule
+	JobKindOnce = "once" // runs a single time at RunAt, retrying on failure
+)
+
+// Job statuses
+const (
+	JobStatusScheduled = "scheduled"
+	JobStatusRunning   = "running"
+	JobStatusCompleted = "completed" // one-off job that ran successfully
+	JobStatusFailed    = "failed"    // one-off job that used up its attempts
+)
+
+// Job is a unit of background work persisted so it survives restarts. A replica must hold the lease of a job to run it
+type Job struct {
+	ID          string                 `bson:"_id" json:"id"`            // the handler name for cron jobs
+	Handler     string                 `bson:"handler" json:"handler"`   // name the job function is registered with
+	Kind        string                 `bson:"kind" json:"kind"`         // cron or once
+	Schedule    string                 `bson:"schedule" json:"schedule"` // cron expression or @every duration for cron jobs
+	Payload     map[string]interface{} `bson:"payload" json:"payload"`   // arguments of a one-off job
+	Status      string                 `bson:"status" json:"status"`     // scheduled, running, completed or failed
+	NextRunAt   time.Time              `bson:"next_run_at" json:"next_run_at"`
+	LastRunAt   time.Time              `bson:"last_run_at" json:"last_run_at"`
+	LastError   string                 `bson:"last_error" json:"last_error"`
+	Attempts    int                    `bson:"attempts" json:"attempts"`         // failed attempts of a one-off job
+	MaxAttempts int                    `bson:"max_attempts" json:"max_attempts"` // attempts a one-off job gets before it is marked failed
+	Runs        int64                  `bson:"runs" json:"runs"`
+	LeaseOwner  string                 `bson:"lease_owner" json:"lease_owner"` // replica currently running the job
+	LeaseUntil  time.Time              `bson:"lease_until" json:"lease_until"`
+	CreatedAt   time.Time              `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time              `bson:"updated_at" json:"updated_at"`
+}
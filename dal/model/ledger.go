@@ -0,0 +1,109 @@
+package model
+
+import (
+	"fmt"
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"time"
+)
+
+// Ledger account owners
+const (
+	LedgerOwnerUser     = "user"
+	LedgerOwnerAgent    = "agent"
+	LedgerOwnerPlatform = "platform"
+	LedgerOwnerExternal = "external"
+)
+
+// Ledger account buckets. Wallet buckets map directly onto the wallet balance fields
+const (
+	LedgerBucketAvailable = "available"
+	LedgerBucketPending   = "pending"
+)
+
+// Platform ledger accounts
+const (
+	LedgerRevenue           = "revenue"            // fees charged
+	LedgerCommissionExpense = "commission-expense" // commissions agents earned
+	LedgerCommissionPayable = "commission-payable" // commissions owed to agents until they are settled
+	LedgerFX                = "fx"                 // currencies the platform buys and sells settling exchanges filled by agents
+)
+
+// Posting directions. A credit increases the balance of a wallet account and a debit decreases it
+const (
+	Debit  = "debit"
+	Credit = "credit"
+)
+
+// LedgerAccount identifies a single balance in the ledger e.g. the available NGN balance of a user
+type LedgerAccount struct {
+	Owner    string `bson:"owner" json:"owner"`       // user, agent, platform or external
+	OwnerID  string `bson:"owner_id" json:"owner_id"` // user or agent id, or a name for platform and external accounts
+	Currency string `bson:"currency" json:"currency"`
+	Bucket   string `bson:"bucket" json:"bucket"` // available or pending for wallet accounts
+}
+
+// Code returns a flat representation of the account used for querying postings
+func (l LedgerAccount) Code() string {
+	return fmt.Sprintf("%s:%s:%s:%s", l.Owner, l.OwnerID, l.Currency, l.Bucket)
+}
+
+// IsWallet reports whether the account is backed by a user or agent wallet
+func (l LedgerAccount) IsWallet() bool {
+	return l.Owner == LedgerOwnerUser || l.Owner == LedgerOwnerAgent
+}
+
+// UserWalletAccount returns the ledger account for a bucket of a user's wallet
+func UserWalletAccount(userID, currency, bucket string) LedgerAccount {
+	return LedgerAccount{Owner: LedgerOwnerUser, OwnerID: userID, Currency: currency, Bucket: bucket}
+}
+
+// AgentWalletAccount returns the ledger account for a bucket of an agent's wallet
+func AgentWalletAccount(agentID, currency, bucket string) LedgerAccount {
+	return LedgerAccount{Owner: LedgerOwnerAgent, OwnerID: agentID, Currency: currency, Bucket: bucket}
+}
+
+// PlatformAccount returns a platform owned ledger account e.g. fx clearing or operating expenses
+func PlatformAccount(name, currency string) LedgerAccount {
+	return LedgerAccount{Owner: LedgerOwnerPlatform, OwnerID: name, Currency: currency, Bucket: LedgerBucketAvailable}
+}
+
+// RevenueAccount returns the platform account the fees charged in a currency are paid into
+func RevenueAccount(currency string) LedgerAccount {
+	return PlatformAccount(LedgerRevenue, currency)
+}
+
+// ExternalAccount returns a ledger account representing money outside the platform e.g. bank settlements
+func ExternalAccount(name, currency string) LedgerAccount {
+	return LedgerAccount{Owner: LedgerOwnerExternal, OwnerID: name, Currency: currency, Bucket: LedgerBucketAvailable}
+}
+
+// JournalEntry groups the postings of a single money movement. The postings of an entry must balance per currency
+type JournalEntry struct {
+	ID              string    `bson:"_id" json:"id"`
+	TransactionID   string    `bson:"transaction_id" json:"transaction_id"`
+	TransactionType string    `bson:"transaction_type" json:"transaction_type"`
+	Description     string    `bson:"description" json:"description"`
+	Postings        []Posting `bson:"-" json:"postings"`
+	CreatedAt       time.Time `bson:"created_at" json:"created_at"`
+}
+
+// Posting is one side of a journal entry against a single ledger account
+type Posting struct {
+	ID              string        `bson:"_id" json:"id"`
+	JournalID       string        `bson:"journal_id" json:"journal_id"`
+	TransactionID   string        `bson:"transaction_id" json:"transaction_id"`
+	TransactionType string        `bson:"transaction_type" json:"transaction_type"`
+	Account         LedgerAccount `bson:"account" json:"account"`
+	AccountCode     string        `bson:"account_code" json:"account_code"`
+	Direction       string        `bson:"direction" json:"direction"` // debit or credit
+	Amount          money.Amount  `bson:"amount" json:"amount"`
+	CreatedAt       time.Time     `bson:"created_at" json:"created_at"`
+}
+
+// LedgerBalance is the balance of a ledger account computed from its postings
+type LedgerBalance struct {
+	AccountCode string       `bson:"_id" json:"account_code"`
+	Credits     money.Amount `bson:"credits" json:"credits"`
+	Debits      money.Amount `bson:"debits" json:"debits"`
+	Balance     money.Amount `bson:"balance" json:"balance"`
+}
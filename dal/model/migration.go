@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+// SchemaMigration records a migration applied to the database, so it is not applied again
+type SchemaMigration struct {
+	Version   int       `bson:"_id" json:"version"`
+	Name      string    `bson:"name" json:"name"`
+	AppliedAt time.Time `bson:"applied_at" json:"applied_at"`
+	Duration  string    `bson:"duration" json:"duration"` // how long the migration took
+}
@@ -0,0 +1,34 @@
+package model
+
+import "time"
+
+// OTP purposes. A code issued for one purpose cannot be used for another
+const (
+	OTPPurposeChangePin   = "change-pin"
+	OTPPurposeWithdraw    = "withdraw"
+	OTPPurposeNewDevice   = "new-device"   // replacing the device push notifications are sent to
+	OTPPurposeAdminAction = "admin-action" // sensitive admin actions, such as enrolling an authenticator
+)
+
+// Channels an OTP can be delivered on
+const (
+	OTPChannelSMS   = "sms"
+	OTPChannelEmail = "email"
+)
+
+// OTPChallenge is a one time code sent to a user, agent or admin to confirm an action. Only a hash of the code is
+// kept, and the challenge can be used once
+type OTPChallenge struct {
+	ID          string    `bson:"_id" json:"id"`
+	OwnerID     string    `bson:"owner_id" json:"owner_id"` // the user, agent or admin the code was sent to
+	Purpose     string    `bson:"purpose" json:"purpose"`
+	Channel     string    `bson:"channel" json:"channel"`
+	Destination string    `bson:"destination" json:"destination"` // phone number or email address the code was sent to
+	CodeHash    string    `bson:"code_hash" json:"-"`
+	Attempts    int       `bson:"attempts" json:"attempts"` // wrong codes entered so far
+	MaxAttempts int       `bson:"max_attempts" json:"max_attempts"`
+	Consumed    bool      `bson:"consumed" json:"consumed"` // set once the code is used or a newer one is sent
+	ConsumedAt  time.Time `bson:"consumed_at,omitempty" json:"consumed_at,omitempty"`
+	CreatedAt   time.Time `bson:"created_at" json:"created_at"`
+	ExpiresAt   time.Time `bson:"expires_at" json:"expires_at"`
+}
@@ -0,0 +1,10 @@
+package model
+
+// Page describes a page of a list endpoint. It is sent with the page as pagination metadata
+type Page struct {
+	Limit      int64  `json:"limit"`
+	Sort       string `json:"sort"`                  // sort key, descending when prefixed with -
+	NextCursor string `json:"next_cursor,omitempty"` // send back as cursor for the next page, empty on the last page
+	HasMore    bool   `json:"has_more"`
+	Total      *int64 `json:"total,omitempty"` // items across every page, only when asked for
+}
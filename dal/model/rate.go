@@ -0,0 +1,139 @@
+package model
+
+import (
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+var (
+	one        = money.MustParseRate("1")
+	hundredth  = money.MustParseRate("0.01")
+	maxPercent = money.MustParseRate("100")
+)
+
+// ExchangeRate is a version of the rate of a currency pair. It is in effect from EffectiveAt until a later version
+// takes effect, and prices conversions both ways. Spreads and the margin are percentages of the mid rate
+type ExchangeRate struct {
+	ID          string     `bson:"_id" json:"id"`
+	Base        string     `bson:"base" json:"base"`
+	Quote       string     `bson:"quote" json:"quote"`
+	Version     int64      `bson:"version" json:"version"`
+	Mid         money.Rate `bson:"mid" json:"mid"`                 // units of Quote a unit of Base is worth
+	BuySpread   money.Rate `bson:"buy_spread" json:"buy_spread"`   // below mid when the platform buys Base, i.e. Base is converted to Quote
+	SellSpread  money.Rate `bson:"sell_spread" json:"sell_spread"` // above mid when the platform sells Base, i.e. Quote is converted to Base
+	Margin      money.Rate `bson:"margin" json:"margin"`           // the platform's share, charged on top of either spread
+	Source      string     `bson:"source" json:"source"`           // admin who set the rate, or the feed it came from
+	EffectiveAt time.Time  `bson:"effective_at" json:"effective_at"`
+	CreatedAt   time.Time  `bson:"created_at" json:"created_at"`
+}
+
+// Pair returns the currency pair of the rate as BASE/QUOTE
+func (e ExchangeRate) Pair() string {
+	return e.Base + "/" + e.Quote
+}
+
+// Validate checks the rate can price conversions: a positive mid rate and spreads and a margin below 100 percent
+func (e ExchangeRate) Validate() error {
+	if e.Base == "" || e.Quote == "" {
+		return errors.New("base and quote currency are required")
+	}
+	if strings.EqualFold(e.Base, e.Quote) {
+		return errors.New("base and quote currency must differ")
+	}
+	if e.Mid.Cmp(money.Rate{}) <= 0 {
+		return errors.New("mid rate must be greater than zero")
+	}
+	for name, percent := range map[string]money.Rate{"buy spread": e.BuySpread, "sell spread": e.SellSpread, "margin": e.Margin} {
+		if percent.Cmp(money.Rate{}) < 0 {
+			return errors.Errorf("%s cannot be negative", name)
+		}
+	}
+	if e.BuySpread.Add(e.Margin).Cmp(maxPercent) >= 0 {
+		return errors.New("buy spread and margin must add up to less than 100 percent")
+	}
+	return nil
+}
+
+// Convert returns the rate a customer converting from one currency of the pair to the other gets, and the mid rate
+// of the same conversion
+func (e ExchangeRate) Convert(from, to string) (money.Rate, money.Rate, error) {
+	switch {
+	case from == e.Base && to == e.Quote:
+		discount := one.Sub(e.BuySpread.Add(e.Margin).Mul(hundredth))
+		return e.Mid.Mul(discount), e.Mid, nil
+	case from == e.Quote && to == e.Base:
+		markup := one.Add(e.SellSpread.Add(e.Margin).Mul(hundredth))
+		rate, err := e.Mid.Mul(markup).Inverse()
+		if err != nil {
+			return money.Rate{}, money.Rate{}, err
+		}
+		mid, err := e.Mid.Inverse()
+		return rate, mid, err
+	}
+	return money.Rate{}, money.Rate{}, errors.Errorf("%s does not price %s to %s", e.Pair(), from, to)
+}
+
+// PeerRate returns the rate a peer filling a conversion from one currency of the pair to the other pays out at, which
+// is that of Convert before the margin. The difference is the platform's
+func (e ExchangeRate) PeerRate(from, to string) (money.Rate, error) {
+	e.Margin = money.Rate{}
+	rate, _, err := e.Convert(from, to)
+	return rate, err
+}
+
+// Quote is the price of a conversion and the fee charged on it, locked for a user until it expires. A quote is used by
+// at most one transaction, of the type it was requested for
+type Quote struct {
+	ID              string       `bson:"_id" json:"id"`
+	UserID          string       `bson:"user_id" json:"user_id"`
+	TransactionType string       `bson:"transaction_type" json:"transaction_type"` // transfer, exchange or withdraw, the type it can be used for
+	FromCurrency    string       `bson:"from_currency" json:"from_currency"`
+	FromAmount      money.Amount `bson:"from_amount" json:"from_amount"`
+	ToCurrency      string       `bson:"to_currency" json:"to_currency"`
+	ToAmount        money.Amount `bson:"to_amount" json:"to_amount"`
+	Rate            money.Rate   `bson:"rate" json:"rate"`                                   // rate applied, after the spread and margin
+	MidRate         money.Rate   `bson:"mid_rate" json:"mid_rate"`                           // rate before the spread and margin
+	RateID          string       `bson:"rate_id" json:"rate_id"`                             // version of the pair rate quoted, empty for a single currency
+	Fee             money.Amount `bson:"fee" json:"fee"`                                     // of FromCurrency, charged on top of FromAmount
+	FeeRuleID       string       `bson:"fee_rule_id,omitempty" json:"fee_rule_id,omitempty"` // rule of the fee schedule that set the fee
+	Total           money.Amount `bson:"total" json:"total"`                                 // FromAmount and Fee, debited from the user's wallet
+	Margin          money.Amount `bson:"margin" json:"margin"`                               // of ToCurrency, the platform's share of an exchange, paid by the peer on top of ToAmount
+	ExpiresAt       time.Time    `bson:"expires_at" json:"expires_at"`
+	CreatedAt       time.Time    `bson:"created_at" json:"created_at"`
+	TransactionID   string       `bson:"transaction_id,omitempty" json:"transaction_id,omitempty"` // transaction the quote was used for
+	UsedAt          *time.Time   `bson:"used_at,omitempty" json:"used_at,omitempty"`
+}
+
+// QuoteRequest asks for the price, and fee, of converting an amount of one currency to another for a transaction of a
+// type. Withdrawals are quoted in a single currency
+type QuoteRequest struct {
+	TransactionType string       `json:"transaction_type"` // transfer, exchange or withdraw
+	FromCurrency    string       `json:"from_currency"`
+	ToCurrency      string       `json:"to_currency"`
+	Amount          money.Amount `json:"amount"` // of FromCurrency
+}
+
+// Rate proposal statuses
+const (
+	RateProposalPending    = "pending"
+	RateProposalApproved   = "approved"
+	RateProposalRejected   = "rejected"
+	RateProposalSuperseded = "superseded" // a later rate of the pair was fetched before an admin reviewed it
+)
+
+// RateProposal is a rate fetched from a rate provider that moved too far from the rate in effect, or is the first
+// rate of its pair, so it is only added once an admin approves it
+type RateProposal struct {
+	ID          string       `bson:"_id" json:"id"`
+	Rate        ExchangeRate `bson:"rate" json:"rate"`                 // version added when approved, taking effect then
+	PreviousMid money.Rate   `bson:"previous_mid" json:"previous_mid"` // mid rate in effect when fetched, zero for a new pair
+	Move        money.Rate   `bson:"move" json:"move"`                 // percent the mid rate moved from PreviousMid
+	Status      string       `bson:"status" json:"status"`
+	ReviewedBy  string       `bson:"reviewed_by,omitempty" json:"reviewed_by,omitempty"`
+	ReviewNote  string       `bson:"review_note,omitempty" json:"review_note,omitempty"`
+	ReviewedAt  *time.Time   `bson:"reviewed_at,omitempty" json:"reviewed_at,omitempty"`
+	CreatedAt   time.Time    `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time    `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"fmt"
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"time"
+)
+
+// Rollup granularities
+const (
+	RollupHour  = "hour"
+	RollupDay   = "day"
+	RollupTotal = "total" // every transaction ever created, PeriodStart is zero
+)
+
+// MetricsRollup counts the transactions of one type, currency and status created in an hour or day, in UTC. Status is
+// the lifecycle status of the transactions, or pay or request for one purse transactions which settle as they are made
+type MetricsRollup struct {
+	ID          string       `bson:"_id" json:"id"`
+	Granularity string       `bson:"granularity" json:"granularity"` // hour, day or total
+	PeriodStart time.Time    `bson:"period_start" json:"period_start"`
+	Type        string       `bson:"type" json:"type"`
+	Currency    string       `bson:"currency" json:"currency"`
+	Status      string       `bson:"status" json:"status"`
+	Count       int64        `bson:"count" json:"count"`
+	Volume      money.Amount `bson:"volume" json:"volume"`
+	UpdatedAt   time.Time    `bson:"updated_at" json:"updated_at"`
+}
+
+// Key identifies the rollup, so rolling the same period up again replaces it
+func (r MetricsRollup) Key() string {
+	return fmt.Sprintf("%s:%s:%s:%s:%s", r.Granularity, r.PeriodStart.UTC().Format(time.RFC3339), r.Type, r.Currency, r.Status)
+}
@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// KYC statuses a user can be searched by
+const (
+	KYCVerified = "verified" // the ID has been verified
+	KYCPending  = "pending"  // an ID number was submitted but has not been verified
+	KYCNone     = "none"     // no ID number was submitted
+)
+
+// ProfileFilter narrows a search of users or agents. Empty fields do not filter. Agents have no ID, KYC status, active
+// flag or creation time, so IDNumber, KYCStatus and Active only apply to users
+type ProfileFilter struct {
+	Text      string // words of the full name, username, email or phone
+	Name      string // start of the full name, ignoring case
+	Username  string // start of the username, ignoring case
+	Email     string // start of the email, ignoring case
+	Phone     string
+	IDNumber  string
+	KYCStatus string // verified, pending or none
+	Active    *bool
+	Approved  *bool
+	Currency  string    // currency of a wallet
+	From      time.Time // inclusive
+	To        time.Time // exclusive
+}
@@ -1,92 +1,117 @@
 package model
 
-import "time"
+import (
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"time"
+)
 
 type Withdrawal struct {
-	ID           string       `bson:"_id" json:"id"`
-	BaseAmount   float32      `bson:"amount" json:"amount"`
-	BaseCurrency string       `bson:"currency" json:"currency"` // USD, NGN, BS
-	UserAccount  *UserAccount `bson:"user_account" json:"user_account"`
-	Status       string       `bson:"status" json:"status"`
-	CreatedAt    time.Time    `bson:"created_at" json:"created_at"`
-	UpdatedAt    time.Time    `bson:"updated_at" json:"updated_at"`
+	ID           string         `bson:"_id" json:"id"`
+	UserID       string         `bson:"user_id" json:"user_id"`
+	BaseAmount   money.Amount   `bson:"amount" json:"amount"`
+	BaseCurrency string         `bson:"currency" json:"currency"` // USD, NGN, BS
+	Fee          money.Amount   `bson:"fee" json:"fee"`           // charged on top of the amount, in the same currency
+	QuoteID      string         `bson:"quote_id" json:"quote_id"` // quote the fee was locked with, if any
+	UserAccount  *UserAccount   `bson:"user_account" json:"user_account"`
+	Status       string         `bson:"status" json:"status"`
+	History      []StatusChange `bson:"history" json:"history"`
+	CreatedAt    time.Time      `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time      `bson:"updated_at" json:"updated_at"`
 }
 
 type Transfer struct {
-	ID             string    `bson:"_id" json:"id"`
-	UserID         string    `bson:"user_id" json:"user_id"`
-	AgentID        string    `bson:"agent_id" json:"agent_id"`
-	BaseAmount     float32   `bson:"base_amount" json:"base_amount"`
-	BaseCurrency   string    `bson:"base_currency" json:"base_currency"`
-	AmountSent     float32   `bson:"amount_sent" json:"amount_sent"`
-	ConvCurrency   string    `bson:"conv_currency" json:"conv_currency"`
-	PaymentChannel string    `bson:"payment_channel" json:"payment_channel"`
-	AgentAccount   *Account  `bson:"agent_account" json:"agent_account"`
-	UserReceipt    string    `bson:"user_receipt" json:"user_receipt"`
-	AgentReceipt   string    `bson:"agent_receipt" json:"agent_receipt"`
-	CreatedAt      time.Time `bson:"created_at" json:"created_at"`
-	UpdatedAt      time.Time `bson:"updated_at" json:"updated_at"`
-	Status         string    `bson:"status" json:"status"` // pending, completed, cancelled
+	ID             string         `bson:"_id" json:"id"`
+	UserID         string         `bson:"user_id" json:"user_id"`
+	AgentID        string         `bson:"agent_id" json:"agent_id"`
+	BaseAmount     money.Amount   `bson:"base_amount" json:"base_amount"`
+	BaseCurrency   string         `bson:"base_currency" json:"base_currency"`
+	AmountSent     money.Amount   `bson:"amount_sent" json:"amount_sent"`
+	ConvCurrency   string         `bson:"conv_currency" json:"conv_currency"`
+	PaymentChannel string         `bson:"payment_channel" json:"payment_channel"`
+	AgentAccount   *Account       `bson:"agent_account" json:"agent_account"`
+	UserReceipt    string         `bson:"user_receipt" json:"user_receipt"`
+	AgentReceipt   string         `bson:"agent_receipt" json:"agent_receipt"`
+	CreatedAt      time.Time      `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time      `bson:"updated_at" json:"updated_at"`
+	Status         string         `bson:"status" json:"status"` // see txstate for the lifecycle of a transfer
+	History        []StatusChange `bson:"history" json:"history"`
+	QuoteID        string         `bson:"quote_id" json:"quote_id"` // quote the base amount was converted with
+	Fee            money.Amount   `bson:"fee" json:"fee"`           // of the base currency, charged on top of the base amount
 }
 
 //OnePurseTransaction refers to transfer between one purse users
 type OnePurseTransaction struct {
-	ID        string    `bson:"_id" json:"id"`
-	FromUser  *User     `bson:"from_user" json:"from_user"` // user initiating the one purse transaction
-	ToUser    *User     `bson:"to_user" json:"to_user"`
-	Amount    float32   `bson:"amount" json:"amount"`
-	Currency  string    `bson:"currency" json:"currency"`
-	CreatedAt time.Time `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
-	Status    string    `bson:"status" json:"status"`
-	Type      string    `bson:"type" json:"type"` // can either be pay or request
+	ID        string       `bson:"_id" json:"id"`
+	FromUser  *User        `bson:"from_user" json:"from_user"` // user initiating the one purse transaction
+	ToUser    *User        `bson:"to_user" json:"to_user"`
+	Amount    money.Amount `bson:"amount" json:"amount"`
+	Currency  string       `bson:"currency" json:"currency"`
+	CreatedAt time.Time    `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time    `bson:"updated_at" json:"updated_at"`
+	Status    string       `bson:"status" json:"status"`
+	Type      string       `bson:"type" json:"type"` // can either be pay or request
 }
 
 type Deposit struct {
-	ID             string    `bson:"_id" json:"id"`
-	UserID         string    `bson:"user_id" json:"user_id"`
-	AgentID        string    `bson:"agent_id" json:"agent_id"`
-	BaseCurrency   string    `bson:"base_currency" json:"base_currency"`
-	BaseAmount     float32   `bson:"amount" json:"amount"`
-	PaymentChannel string    `bson:"payment_channel" json:"payment_channel"`
-	UserReceipt    string    `bson:"user_receipt" json:"user_receipt"`
-	AgentAccount   *Account  `bson:"agent_account" json:"agent_account"`
-	CreatedAt      time.Time `bson:"created_at" json:"created_at"`
-	UpdatedAt      time.Time `bson:"updated_at" json:"updated_at"`
-	Status         string    `bson:"status" json:"status"`
+	ID             string         `bson:"_id" json:"id"`
+	UserID         string         `bson:"user_id" json:"user_id"`
+	AgentID        string         `bson:"agent_id" json:"agent_id"`
+	BaseCurrency   string         `bson:"base_currency" json:"base_currency"`
+	BaseAmount     money.Amount   `bson:"amount" json:"amount"`
+	PaymentChannel string         `bson:"payment_channel" json:"payment_channel"`
+	UserReceipt    string         `bson:"user_receipt" json:"user_receipt"`
+	AgentAccount   *Account       `bson:"agent_account" json:"agent_account"`
+	CreatedAt      time.Time      `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time      `bson:"updated_at" json:"updated_at"`
+	Status         string         `bson:"status" json:"status"`
+	History        []StatusChange `bson:"history" json:"history"`
 }
 
 type Exchange struct {
-	ID                       string    `bson:"_id" json:"id"`
-	UserID                   string    `bson:"user" json:"user"`
-	AgentID                  string    `bson:"agent_id" json:"agent_id"`
-	BaseCurrency             string    `bson:"base_currency" json:"base_currency"`
-	BaseAmount               float32   `bson:"base_amount" json:"base_amount"`
-	ExchangeCurrency         string    `bson:"exchange_currency" json:"exchange_currency"`
-	ExchangeAmount           float32   `bson:"exchange_amount" json:"exchange_amount"`
-	IsCryptoExchange         bool      `bson:"is_crypto_exchange" json:"is_crypto_exchange"`
-	BlockchainChannel        string    `bson:"blockchain_channel" json:"blockchain_channel"`
-	CryptoWalletAddress      string    `bson:"crypto_wallet_address" json:"crypto_wallet_address"`
-	PaymentChannel           string    `bson:"payment_channel" json:"payment_channel"`
-	AgentAccount             *Account  `bson:"agent_account" json:"agent_account"`
-	UserReceipt              string    `bson:"user_receipt" json:"user_receipt"`
-	AgentReceipt             string    `bson:"agent_receipt" json:"agent_receipt"`
-	UserReasonForCancelling  string    `bson:"reason_for_cancelling" json:"reason_for_cancelling"`
-	AgentReasonForCancelling string    `bson:"agent_reason_for_cancelling" json:"agent_reason_for_cancelling"`
-	ReasonForDispute         string    `bson:"reason_for_dispute" json:"reason_for_dispute"`
-	Status                   string    `bson:"status" json:"status"`
-	CreatedAt                time.Time `bson:"created_at" json:"created_at"`
-	UpdatedAt                time.Time `bson:"updated_at" json:"updated_at"`
+	ID                       string         `bson:"_id" json:"id"`
+	UserID                   string         `bson:"user" json:"user"`
+	AgentID                  string         `bson:"agent_id" json:"agent_id"`
+	MatchedUserID            string         `bson:"matched_user_id" json:"matched_user_id"` // set when the exchange is matched to another user instead of an agent
+	BaseCurrency             string         `bson:"base_currency" json:"base_currency"`
+	BaseAmount               money.Amount   `bson:"base_amount" json:"base_amount"`
+	ExchangeCurrency         string         `bson:"exchange_currency" json:"exchange_currency"`
+	ExchangeAmount           money.Amount   `bson:"exchange_amount" json:"exchange_amount"`
+	QuoteID                  string         `bson:"quote_id" json:"quote_id"` // quote the base amount was converted with
+	Fee                      money.Amount   `bson:"fee" json:"fee"`           // of the base currency, charged on top of the base amount
+	Margin                   money.Amount   `bson:"margin" json:"margin"`     // of the exchange currency, paid by the peer to the platform on completion
+	IsCryptoExchange         bool           `bson:"is_crypto_exchange" json:"is_crypto_exchange"`
+	BlockchainChannel        string         `bson:"blockchain_channel" json:"blockchain_channel"`
+	CryptoWalletAddress      string         `bson:"crypto_wallet_address" json:"crypto_wallet_address"`
+	PaymentChannel           string         `bson:"payment_channel" json:"payment_channel"`
+	AgentAccount             *Account       `bson:"agent_account" json:"agent_account"`
+	UserReceipt              string         `bson:"user_receipt" json:"user_receipt"`
+	AgentReceipt             string         `bson:"agent_receipt" json:"agent_receipt"`
+	UserReasonForCancelling  string         `bson:"reason_for_cancelling" json:"reason_for_cancelling"`
+	AgentReasonForCancelling string         `bson:"agent_reason_for_cancelling" json:"agent_reason_for_cancelling"`
+	ReasonForDispute         string         `bson:"reason_for_dispute" json:"reason_for_dispute"`
+	Status                   string         `bson:"status" json:"status"`
+	History                  []StatusChange `bson:"history" json:"history"`
+	CreatedAt                time.Time      `bson:"created_at" json:"created_at"`
+	UpdatedAt                time.Time      `bson:"updated_at" json:"updated_at"`
+}
+
+// StatusChange records a single transition of a transaction through its lifecycle
+type StatusChange struct {
+	From   string    `bson:"from" json:"from"`
+	To     string    `bson:"to" json:"to"`
+	Actor  string    `bson:"actor" json:"actor"` // id of the user, agent or admin, or the name of the system process, that made the change
+	Reason string    `bson:"reason,omitempty" json:"reason,omitempty"`
+	At     time.Time `bson:"at" json:"at"`
 }
 
 type Wallet struct {
-	Currency         string    `bson:"currency" json:"currency"`                   // NGN, USD BSD
-	AvailableBalance float32   `bson:"available_balance" json:"available_balance"` // BaseAmount that can be withdrawn
-	PendingBalance   float32   `bson:"pending_balance" json:"pending_balance"`     // BaseAmount tied up in transactions
-	TotalVolume      float32   `bson:"total_volume" json:"total_volume"`           // Total BaseAmount transacted with this wallet. Might not be necessary
-	IsActive         bool      `bson:"is_active" json:"is_active"`
-	CreatedAt        time.Time `bson:"created_at" json:"created_at"` // Date the wallet was created
-	UpdatedAt        time.Time `bson:"updated_at" json:"updated_at"`
+	Currency         string       `bson:"currency" json:"currency"`                   // NGN, USD BSD
+	AvailableBalance money.Amount `bson:"available_balance" json:"available_balance"` // BaseAmount that can be withdrawn
+	PendingBalance   money.Amount `bson:"pending_balance" json:"pending_balance"`     // BaseAmount tied up in transactions
+	TotalVolume      money.Amount `bson:"total_volume" json:"total_volume"`           // Total BaseAmount transacted with this wallet. Might not be necessary
+	IsActive         bool         `bson:"is_active" json:"is_active"`
+	CreatedAt        time.Time    `bson:"created_at" json:"created_at"` // Date the wallet was created
+	UpdatedAt        time.Time    `bson:"updated_at" json:"updated_at"`
 }
 
 type Account struct {
@@ -99,21 +124,16 @@ type Account struct {
 	IsUser        bool   `bson:"is_user" json:"is_user"`
 }
 
-type Rate struct {
-	NGN map[string]float32 `json:"ngn" bson:"ngn"`
-	USD map[string]float32 `json:"usd" bson:"usd"`
-	BSD map[string]float32 `json:"bsd" bson:"bsd"`
-	BTC map[string]float32 `json:"btc" bson:"btc"`
-}
-
 type AdminPayment struct {
-	RecipientName string  `json:"recipient_name" bson:"recipient_name"`
-	Category      string  `json:"category" bson:"category"`
-	BaseCurrency  string  `json:"base_currency" bson:"base_currency"`
-	BaseAmount    float32 `json:"base_amount" bson:"base_amount"`
-	ConvCurrency  string  `json:"conv_currency" bson:"conv_currency"`
-	Description   string  `json:"description" bson:"description"`
-	Receipt       string  `json:"receipt" bson:"receipt"`
+	ID            string       `json:"id" bson:"_id"`
+	RecipientName string       `json:"recipient_name" bson:"recipient_name"`
+	Category      string       `json:"category" bson:"category"`
+	BaseCurrency  string       `json:"base_currency" bson:"base_currency"`
+	BaseAmount    money.Amount `json:"base_amount" bson:"base_amount"`
+	ConvCurrency  string       `json:"conv_currency" bson:"conv_currency"`
+	Description   string       `json:"description" bson:"description"`
+	Receipt       string       `json:"receipt" bson:"receipt"`
+	CreatedAt     time.Time    `json:"created_at" bson:"created_at"`
 }
 
 type PaymentCategory struct {
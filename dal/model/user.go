@@ -31,6 +31,8 @@ type User struct {
 	DeviceToken            string              `bson:"device_token, omitempty" json:"device_token,omitempty"`
 	Active                 bool                `bson:"active, omitempty" json:"active,omitempty"`
 	Approved               bool                `bson:"approved" json:"approved"`
+	PinAttempts            int                 `bson:"pin_attempts" json:"-"`                              // wrong transaction pins since the last correct one
+	PinLockedUntil         time.Time           `bson:"pin_locked_until" json:"pin_locked_until,omitempty"` // debits are refused until then
 }
 
 type UserAuthResp struct {
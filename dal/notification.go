@@ -13,7 +13,7 @@ import (
 type INotificationDAL interface {
 	CreateUserNotification(ctx context.Context, notification *model.UserNotification) error
 	FetchUserNotification(ctx context.Context, query bson.D) (*model.UserNotification, error)
-	FetchUserNotifications(ctx context.Context, query bson.D) (*[]model.UserNotification, error)
+	FetchUserNotifications(ctx context.Context, query bson.D, req PageRequest) ([]model.UserNotification, *model.Page, error)
 	UpdateUserNotification(ctx context.Context, ID string, query bson.D) error
 	DeleteUserNotification(ctx context.Context, ID string) error
 }
@@ -53,19 +53,17 @@ func (n NotificationDAL) FetchUserNotification(ctx context.Context, query bson.D
 	return &notification, nil
 }
 
-func (n NotificationDAL) FetchUserNotifications(ctx context.Context, query bson.D) (*[]model.UserNotification, error) {
+// notificationSortKeys are the keys notifications can be listed by, newest first by default
+var notificationSortKeys = []string{"-created_at"}
+
+// FetchUserNotifications returns the page req asks for of the notifications matching query
+func (n NotificationDAL) FetchUserNotifications(ctx context.Context, query bson.D, req PageRequest) ([]model.UserNotification, *model.Page, error) {
 	var notifications []model.UserNotification
-	cursor, err := n.UserNotificationCollection.Find(ctx, query)
+	page, err := paginate(ctx, n.UserNotificationCollection, query, req, notificationSortKeys, &notifications)
 	if err != nil {
-		logrus.Errorf("[Mongo]: error fetching notifications: %s", err.Error())
-		return nil, err
-	}
-
-	if err = cursor.All(ctx, &notifications); err != nil {
-		logrus.Errorf("[Mongo]: error decoding notification results: %s", err.Error())
-		return nil, err
+		return nil, nil, err
 	}
-	return &notifications, nil
+	return notifications, page, nil
 }
 
 func (n NotificationDAL) UpdateUserNotification(ctx context.Context, ID string, updateParam bson.D) error {
@@ -0,0 +1,138 @@
+package dal
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// otpRetention is how long challenges are kept after they expire, so recent sends can still be counted for throttling
+const otpRetention = 24 * time.Hour
+
+var (
+	ErrOTPNotFound = errors.New("otp challenge not found")
+	// ErrOTPAttempts is returned when an attempt is claimed on a challenge that has used up its attempts
+	ErrOTPAttempts = errors.New("otp challenge has no attempts left")
+)
+
+type IOTPDAL interface {
+	Create(ctx context.Context, challenge *model.OTPChallenge) error
+	FindLatest(ctx context.Context, ownerID, purpose string) (*model.OTPChallenge, error)
+	CountSince(ctx context.Context, ownerID, purpose string, since time.Time) (int64, error)
+	ClaimAttempt(ctx context.Context, ID string) (*model.OTPChallenge, error)
+	Consume(ctx context.Context, ID string) (bool, error)
+}
+
+type OTPDAL struct {
+	DB            *mongo.Database
+	OTPCollection *mongo.Collection
+}
+
+func NewOTPDAL(db *mongo.Database) *OTPDAL {
+	return &OTPDAL{
+		DB:            db,
+		OTPCollection: db.Collection("otp-challenge"),
+	}
+}
+
+// otpIndexes index challenges by owner and purpose and let mongo remove them a while after they expire
+var otpIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{"owner_id", 1}, {"purpose", 1}, {"created_at", -1}}},
+	{Keys: bson.D{{"expires_at", 1}}, Options: options.Index().SetExpireAfterSeconds(int32(otpRetention.Seconds()))},
+}
+
+// Create stores a new challenge. Earlier challenges for the same owner and purpose can no longer be used
+func (o OTPDAL) Create(ctx context.Context, challenge *model.OTPChallenge) error {
+	filter := bson.D{{"owner_id", challenge.OwnerID}, {"purpose", challenge.Purpose}, {"consumed", false}}
+	_, err := o.OTPCollection.UpdateMany(ctx, filter, bson.D{{"$set", bson.D{{"consumed", true}, {"consumed_at", challenge.CreatedAt}}}})
+	if err != nil {
+		logrus.Errorf("[Mongo]: error superseding otp challenges of %s: %s", challenge.OwnerID, err.Error())
+		return err
+	}
+	if _, err := o.OTPCollection.InsertOne(ctx, challenge); err != nil {
+		logrus.Errorf("[Mongo]: error creating otp challenge for %s: %s", challenge.OwnerID, err.Error())
+		return err
+	}
+	return nil
+}
+
+// FindLatest returns the most recent challenge sent to ownerID for purpose
+func (o OTPDAL) FindLatest(ctx context.Context, ownerID, purpose string) (*model.OTPChallenge, error) {
+	var challenge model.OTPChallenge
+	opts := options.FindOne().SetSort(bson.D{{"created_at", -1}})
+	err := o.OTPCollection.FindOne(ctx, bson.D{{"owner_id", ownerID}, {"purpose", purpose}}, opts).Decode(&challenge)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrOTPNotFound
+		}
+		logrus.Errorf("[Mongo]: error fetching otp challenge of %s: %s", ownerID, err.Error())
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// CountSince counts the challenges sent to ownerID for purpose since a point in time
+func (o OTPDAL) CountSince(ctx context.Context, ownerID, purpose string, since time.Time) (int64, error) {
+	count, err := o.OTPCollection.CountDocuments(ctx, bson.D{
+		{"owner_id", ownerID},
+		{"purpose", purpose},
+		{"created_at", bson.D{{"$gte", since}}},
+	})
+	if err != nil {
+		logrus.Errorf("[Mongo]: error counting otp challenges of %s: %s", ownerID, err.Error())
+		return 0, err
+	}
+	return count, nil
+}
+
+// ClaimAttempt counts an attempt against an unused challenge before its code is compared and returns the updated
+// challenge. The claim is refused with ErrOTPAttempts once max_attempts are claimed
+func (o OTPDAL) ClaimAttempt(ctx context.Context, ID string) (*model.OTPChallenge, error) {
+	var challenge model.OTPChallenge
+	query := bson.D{
+		{"_id", ID},
+		{"consumed", false},
+		{"$expr", bson.D{{"$lt", bson.A{"$attempts", "$max_attempts"}}}},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := o.OTPCollection.FindOneAndUpdate(ctx, query, bson.D{{"$inc", bson.D{{"attempts", 1}}}}, opts).Decode(&challenge)
+	if err == nil {
+		return &challenge, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		logrus.Errorf("[Mongo]: error claiming attempt on otp challenge %s: %s", ID, err.Error())
+		return nil, err
+	}
+
+	count, err := o.OTPCollection.CountDocuments(ctx, bson.D{{"_id", ID}, {"consumed", false}})
+	if err != nil {
+		logrus.Errorf("[Mongo]: error fetching otp challenge %s: %s", ID, err.Error())
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrOTPNotFound
+	}
+	return nil, ErrOTPAttempts
+}
+
+// Consume marks a challenge as used. It reports false when the challenge was already used or more than max_attempts
+// codes were entered for it, so a code can only be redeemed once even by concurrent requests
+func (o OTPDAL) Consume(ctx context.Context, ID string) (bool, error) {
+	query := bson.D{
+		{"_id", ID},
+		{"consumed", false},
+		{"$expr", bson.D{{"$lte", bson.A{"$attempts", "$max_attempts"}}}},
+	}
+	update := bson.D{{"$set", bson.D{{"consumed", true}, {"consumed_at", time.Now()}}}}
+	result, err := o.OTPCollection.UpdateOne(ctx, query, update)
+	if err != nil {
+		logrus.Errorf("[Mongo]: error consuming otp challenge %s: %s", ID, err.Error())
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
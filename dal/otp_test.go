@@ -0,0 +1,126 @@
+package dal
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestChallenge(t *testing.T, o *OTPDAL, ID string, maxAttempts int) *model.OTPChallenge {
+	t.Helper()
+	now := time.Now()
+	challenge := &model.OTPChallenge{
+		ID:          ID,
+		OwnerID:     "user",
+		Purpose:     model.OTPPurposeWithdraw,
+		Channel:     model.OTPChannelSMS,
+		CodeHash:    "hash",
+		MaxAttempts: maxAttempts,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(5 * time.Minute),
+	}
+	if err := o.Create(context.Background(), challenge); err != nil {
+		t.Fatalf("Create error = %v", err)
+	}
+	return challenge
+}
+
+func TestOTPClaimAttempt(t *testing.T) {
+	o := NewOTPDAL(testDatabase(t))
+	ctx := context.Background()
+	newTestChallenge(t, o, "challenge", 3)
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		challenge, err := o.ClaimAttempt(ctx, "challenge")
+		if err != nil || challenge.Attempts != attempt {
+			t.Fatalf("claim %d = %+v, %v, want %d attempts", attempt, challenge, err, attempt)
+		}
+	}
+	if _, err := o.ClaimAttempt(ctx, "challenge"); err != ErrOTPAttempts {
+		t.Fatalf("claim past max_attempts error = %v, want %v", err, ErrOTPAttempts)
+	}
+	// a code that matched on the last claimed attempt can still be used, once
+	if consumed, err := o.Consume(ctx, "challenge"); err != nil || !consumed {
+		t.Fatalf("Consume on the last attempt = %v, %v, want it consumed", consumed, err)
+	}
+	if consumed, err := o.Consume(ctx, "challenge"); err != nil || consumed {
+		t.Errorf("second Consume = %v, %v, want false", consumed, err)
+	}
+	if _, err := o.ClaimAttempt(ctx, "challenge"); err != ErrOTPNotFound {
+		t.Errorf("claim on a used challenge error = %v, want %v", err, ErrOTPNotFound)
+	}
+	if _, err := o.ClaimAttempt(ctx, "missing"); err != ErrOTPNotFound {
+		t.Errorf("claim on a missing challenge error = %v, want %v", err, ErrOTPNotFound)
+	}
+
+	// a new challenge supersedes the earlier ones of the same owner and purpose
+	newTestChallenge(t, o, "first", 3)
+	newTestChallenge(t, o, "second", 3)
+	if _, err := o.ClaimAttempt(ctx, "first"); err != ErrOTPNotFound {
+		t.Errorf("claim on a superseded challenge error = %v, want %v", err, ErrOTPNotFound)
+	}
+	if latest, err := o.FindLatest(ctx, "user", model.OTPPurposeWithdraw); err != nil || latest.ID != "second" {
+		t.Errorf("FindLatest = %+v, %v, want the second challenge", latest, err)
+	}
+}
+
+func TestOTPConcurrentClaims(t *testing.T) {
+	o := NewOTPDAL(testDatabase(t))
+	ctx := context.Background()
+	newTestChallenge(t, o, "challenge", 1)
+
+	const requests = 8
+	var wg sync.WaitGroup
+	claimed := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := o.ClaimAttempt(ctx, "challenge")
+			claimed <- err
+		}()
+	}
+	wg.Wait()
+	close(claimed)
+
+	granted := 0
+	for err := range claimed {
+		switch err {
+		case nil:
+			granted++
+		case ErrOTPAttempts:
+		default:
+			t.Errorf("concurrent claim error = %v", err)
+		}
+	}
+	if granted != 1 {
+		t.Errorf("%d concurrent claims were granted on a challenge allowing 1", granted)
+	}
+
+	var consumes sync.WaitGroup
+	results := make(chan bool, requests)
+	for i := 0; i < requests; i++ {
+		consumes.Add(1)
+		go func() {
+			defer consumes.Done()
+			consumed, err := o.Consume(ctx, "challenge")
+			if err != nil {
+				t.Errorf("concurrent Consume error = %v", err)
+			}
+			results <- consumed
+		}()
+	}
+	consumes.Wait()
+	close(results)
+	used := 0
+	for consumed := range results {
+		if consumed {
+			used++
+		}
+	}
+	if used != 1 {
+		t.Errorf("challenge was consumed %d times, want once", used)
+	}
+}
@@ -0,0 +1,213 @@
+package dal
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+	"strings"
+)
+
+// Page size limits of list endpoints
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+// PageRequest asks for a page of a list
+type PageRequest struct {
+	Limit      int64  // capped at MaxPageLimit, DefaultPageLimit when not set
+	Cursor     string // NextCursor of the previous page, empty for the first page
+	Sort       string // one of the sort keys of the list, descending when prefixed with -. The list default when empty
+	CountTotal bool
+}
+
+// InvalidPageError is returned when a page request cannot be served, e.g. for an unknown sort key. Its message can be
+// shown to the client
+type InvalidPageError string
+
+func (e InvalidPageError) Error() string {
+	return string(e)
+}
+
+// pageCursor is the position of the last item of a page: its sort key value and ID, which breaks ties
+type pageCursor struct {
+	Sort  string        `bson:"s"`
+	Value bson.RawValue `bson:"v"`
+	ID    bson.RawValue `bson:"id"`
+}
+
+func (c pageCursor) String() string {
+	content, err := bson.Marshal(c)
+	if err != nil {
+		logrus.Errorf("[Mongo]: unable to encode page cursor: %s", err.Error())
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(content)
+}
+
+func parsePageCursor(value, sort string) (*pageCursor, error) {
+	content, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, InvalidPageError("invalid cursor")
+	}
+	var c pageCursor
+	if err := bson.Unmarshal(content, &c); err != nil {
+		return nil, InvalidPageError("invalid cursor")
+	}
+	if c.Sort != sort {
+		return nil, InvalidPageError("cursor was issued for a different sort, start again from the first page")
+	}
+	return &c, nil
+}
+
+// pageSort checks the sort of req against the sort keys a list allows. The first key is the default, given with its
+// direction
+func pageSort(req PageRequest, keys []string) (string, string, int, error) {
+	sort := req.Sort
+	if sort == "" {
+		sort = keys[0]
+	}
+	field, direction := strings.TrimPrefix(sort, "-"), 1
+	if strings.HasPrefix(sort, "-") {
+		direction = -1
+	}
+	for _, key := range keys {
+		if strings.TrimPrefix(key, "-") == field {
+			return sort, field, direction, nil
+		}
+	}
+	allowed := make([]string, len(keys))
+	for i, key := range keys {
+		allowed[i] = strings.TrimPrefix(key, "-")
+	}
+	return "", "", 0, InvalidPageError(fmt.Sprintf("sort must be one of %s, prefixed with - for descending", strings.Join(allowed, ", ")))
+}
+
+// pageLimit applies the default and cap to a requested limit
+func pageLimit(limit int64) int64 {
+	if limit <= 0 {
+		return DefaultPageLimit
+	}
+	if limit > MaxPageLimit {
+		return MaxPageLimit
+	}
+	return limit
+}
+
+// paginate finds the page req asks for of the documents in collection matching query, sorted by one of sortKeys and
+// then _id so the order is stable. results must point to a slice the documents are decoded into
+func paginate(ctx context.Context, collection *mongo.Collection, query bson.D, req PageRequest, sortKeys []string, results interface{}) (*model.Page, error) {
+	sort, field, direction, err := pageSort(req, sortKeys)
+	if err != nil {
+		return nil, err
+	}
+	page := &model.Page{Limit: pageLimit(req.Limit), Sort: sort}
+
+	if req.CountTotal {
+		total, err := collection.CountDocuments(ctx, query)
+		if err != nil {
+			logrus.Errorf("[Mongo]: error counting %s: %s", collection.Name(), err.Error())
+			return nil, err
+		}
+		page.Total = &total
+	}
+
+	find := query
+	if req.Cursor != "" {
+		c, err := parsePageCursor(req.Cursor, sort)
+		if err != nil {
+			return nil, err
+		}
+		find = bson.D{{"$and", bson.A{query, afterCursor(field, direction, c)}}}
+	}
+	order := bson.D{{field, direction}}
+	if field != "_id" {
+		order = append(order, bson.E{Key: "_id", Value: direction})
+	}
+	// one more than a page tells whether there is a next page
+	opts := options.Find().SetSort(order).SetLimit(page.Limit + 1)
+	cursor, err := collection.Find(ctx, find, opts)
+	if err != nil {
+		logrus.Errorf("[Mongo]: error fetching %s: %s", collection.Name(), err.Error())
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	slice := reflect.ValueOf(results).Elem()
+	slice.Set(reflect.MakeSlice(slice.Type(), 0, int(page.Limit)))
+	var last bson.Raw
+	for cursor.Next(ctx) {
+		if int64(slice.Len()) == page.Limit {
+			page.HasMore = true
+			break
+		}
+		item := reflect.New(slice.Type().Elem())
+		if err := cursor.Decode(item.Interface()); err != nil {
+			logrus.Errorf("[Mongo]: error decoding %s: %s", collection.Name(), err.Error())
+			return nil, err
+		}
+		slice.Set(reflect.Append(slice, item.Elem()))
+		last = append(bson.Raw{}, cursor.Current...)
+	}
+	if err := cursor.Err(); err != nil {
+		logrus.Errorf("[Mongo]: error fetching %s: %s", collection.Name(), err.Error())
+		return nil, err
+	}
+
+	if page.HasMore {
+		page.NextCursor = pageCursor{Sort: sort, Value: orNull(last.Lookup(field)), ID: last.Lookup("_id")}.String()
+	}
+	return page, nil
+}
+
+// newPageCursor returns the cursor of an item whose sort key is value
+func newPageCursor(sort string, value, id interface{}) (pageCursor, error) {
+	c := pageCursor{Sort: sort}
+	t, data, err := bson.MarshalValue(value)
+	if err != nil {
+		return c, err
+	}
+	c.Value = bson.RawValue{Type: t, Value: data}
+	if t, data, err = bson.MarshalValue(id); err != nil {
+		return c, err
+	}
+	c.ID = bson.RawValue{Type: t, Value: data}
+	return c, nil
+}
+
+// orNull stands in null for a sort key missing from a document, which is how mongo sorts it
+func orNull(v bson.RawValue) bson.RawValue {
+	if v.Type == 0 {
+		return bson.RawValue{Type: bsontype.Null}
+	}
+	return v
+}
+
+// afterCursor matches the documents after c in the order of field and direction
+func afterCursor(field string, direction int, c *pageCursor) bson.D {
+	operator := "$gt"
+	if direction < 0 {
+		operator = "$lt"
+	}
+	if field == "_id" {
+		return bson.D{{"_id", bson.D{{operator, c.ID}}}}
+	}
+	return bson.D{{"$or", bson.A{
+		bson.D{{field, bson.D{{operator, c.Value}}}},
+		bson.D{{field, c.Value}, {"_id", bson.D{{operator, c.ID}}}},
+	}}}
+}
+
+// IsInvalidPage reports whether err is an InvalidPageError
+func IsInvalidPage(err error) bool {
+	var invalid InvalidPageError
+	return errors.As(err, &invalid)
+}
@@ -0,0 +1,95 @@
+package dal
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"time"
+)
+
+var (
+	// ErrQuoteNotFound is returned when a user has no quote with an ID
+	ErrQuoteNotFound = errors.New("quote not found")
+	// ErrQuoteExpired is returned when a quote is used after it expired
+	ErrQuoteExpired = errors.New("quote has expired, request a new one")
+	// ErrQuoteUsed is returned when a quote is used by a second transaction
+	ErrQuoteUsed = errors.New("quote has already been used")
+)
+
+type IQuoteDAL interface {
+	Create(ctx context.Context, quote *model.Quote) error
+	Find(ctx context.Context, ID, userID string) (*model.Quote, error)
+	Use(ctx context.Context, ID, userID, transactionID string, at time.Time) error
+}
+
+type QuoteDAL struct {
+	DB              *mongo.Database
+	QuoteCollection *mongo.Collection
+}
+
+func NewQuoteDAL(db *mongo.Database) *QuoteDAL {
+	return &QuoteDAL{
+		DB:              db,
+		QuoteCollection: db.Collection("quote"),
+	}
+}
+
+// quoteIndexes let the quotes of a user be looked up. Quotes are kept, used or not, as the record of the price given
+var quoteIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{"user_id", 1}, {"created_at", -1}}},
+	{Keys: bson.D{{"transaction_id", 1}}},
+}
+
+func (q QuoteDAL) Create(ctx context.Context, quote *model.Quote) error {
+	_, err := q.QuoteCollection.InsertOne(ctx, quote)
+	if err != nil {
+		logrus.Errorf("[Mongo]: error creating quote %s: %s", quote.ID, err.Error())
+		return err
+	}
+	return nil
+}
+
+// Find returns the quote of userID with an ID
+func (q QuoteDAL) Find(ctx context.Context, ID, userID string) (*model.Quote, error) {
+	var quote model.Quote
+	err := q.QuoteCollection.FindOne(ctx, bson.D{{"_id", ID}, {"user_id", userID}}).Decode(&quote)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrQuoteNotFound
+		}
+		logrus.Errorf("[Mongo]: error fetching quote %s: %s", ID, err.Error())
+		return nil, err
+	}
+	return &quote, nil
+}
+
+// Use marks the quote of userID as used by a transaction, unless it has expired by at or was already used
+func (q QuoteDAL) Use(ctx context.Context, ID, userID, transactionID string, at time.Time) error {
+	filter := bson.D{
+		{"_id", ID},
+		{"user_id", userID},
+		{"used_at", nil},
+		{"expires_at", bson.D{{"$gt", at}}},
+	}
+	update := bson.D{{"$set", bson.D{{"transaction_id", transactionID}, {"used_at", at}}}}
+	result, err := q.QuoteCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		logrus.Errorf("[Mongo]: error using quote %s: %s", ID, err.Error())
+		return err
+	}
+	if result.MatchedCount == 1 {
+		return nil
+	}
+
+	quote, err := q.Find(ctx, ID, userID)
+	if err != nil {
+		return err
+	}
+	if quote.UsedAt != nil {
+		return ErrQuoteUsed
+	}
+	return ErrQuoteExpired
+}
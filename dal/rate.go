@@ -0,0 +1,121 @@
+package dal
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/lucsky/cuid"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// rateAddRetries is how many times a rate is retried when another version of the pair is added at the same time
+const rateAddRetries = 5
+
+// ErrRateNotFound is returned when no rate of a currency pair is in effect
+var ErrRateNotFound = errors.New("no exchange rate is in effect for the currency pair")
+
+type IRateDAL interface {
+	Add(ctx context.Context, rate *model.ExchangeRate) error
+	Current(ctx context.Context, base, quote string, at time.Time) (*model.ExchangeRate, error)
+	FetchCurrent(ctx context.Context, at time.Time) ([]model.ExchangeRate, error)
+	FetchHistory(ctx context.Context, query bson.D, req PageRequest) ([]model.ExchangeRate, *model.Page, error)
+}
+
+type RateDAL struct {
+	DB             *mongo.Database
+	RateCollection *mongo.Collection
+}
+
+func NewRateDAL(db *mongo.Database) *RateDAL {
+	return &RateDAL{
+		DB:             db,
+		RateCollection: db.Collection("exchange-rate"),
+	}
+}
+
+// rateIndexes make versions unique per pair and find the version in effect at a time
+var rateIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{"base", 1}, {"quote", 1}, {"version", -1}}, Options: options.Index().SetUnique(true)},
+	{Keys: bson.D{{"base", 1}, {"quote", 1}, {"effective_at", -1}, {"version", -1}}},
+	{Keys: bson.D{{"effective_at", -1}}},
+}
+
+// Add records rate as the next version of its pair, setting its ID and version. Versions are never updated
+func (r RateDAL) Add(ctx context.Context, rate *model.ExchangeRate) error {
+	for attempt := 0; attempt < rateAddRetries; attempt++ {
+		var last model.ExchangeRate
+		opts := options.FindOne().SetSort(bson.D{{"version", -1}})
+		err := r.RateCollection.FindOne(ctx, bson.D{{"base", rate.Base}, {"quote", rate.Quote}}, opts).Decode(&last)
+		if err != nil && err != mongo.ErrNoDocuments {
+			logrus.Errorf("[Mongo]: error fetching the last %s rate: %s", rate.Pair(), err.Error())
+			return err
+		}
+		rate.ID = cuid.New()
+		rate.Version = last.Version + 1
+
+		_, err = r.RateCollection.InsertOne(ctx, rate)
+		if err == nil {
+			return nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			logrus.Errorf("[Mongo]: error adding %s rate: %s", rate.Pair(), err.Error())
+			return err
+		}
+	}
+	return errors.Errorf("unable to add %s rate after %d attempts", rate.Pair(), rateAddRetries)
+}
+
+// Current returns the version of the rate of base to quote in effect at a time, the latest to take effect by then
+func (r RateDAL) Current(ctx context.Context, base, quote string, at time.Time) (*model.ExchangeRate, error) {
+	var rate model.ExchangeRate
+	query := bson.D{{"base", base}, {"quote", quote}, {"effective_at", bson.D{{"$lte", at}}}}
+	opts := options.FindOne().SetSort(bson.D{{"effective_at", -1}, {"version", -1}})
+	err := r.RateCollection.FindOne(ctx, query, opts).Decode(&rate)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrRateNotFound
+		}
+		logrus.Errorf("[Mongo]: error fetching %s/%s rate: %s", base, quote, err.Error())
+		return nil, err
+	}
+	return &rate, nil
+}
+
+// FetchCurrent returns the version of every pair in effect at a time
+func (r RateDAL) FetchCurrent(ctx context.Context, at time.Time) ([]model.ExchangeRate, error) {
+	pipeline := mongo.Pipeline{
+		{{"$match", bson.D{{"effective_at", bson.D{{"$lte", at}}}}}},
+		{{"$sort", bson.D{{"effective_at", -1}, {"version", -1}}}},
+		{{"$group", bson.D{{"_id", bson.D{{"base", "$base"}, {"quote", "$quote"}}}, {"rate", bson.D{{"$first", "$$ROOT"}}}}}},
+		{{"$replaceRoot", bson.D{{"newRoot", "$rate"}}}},
+		{{"$sort", bson.D{{"base", 1}, {"quote", 1}}}},
+	}
+	cursor, err := r.RateCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		logrus.Errorf("[Mongo]: error fetching current rates: %s", err.Error())
+		return nil, err
+	}
+	rates := []model.ExchangeRate{}
+	if err := cursor.All(ctx, &rates); err != nil {
+		logrus.Errorf("[Mongo]: error decoding current rates: %s", err.Error())
+		return nil, err
+	}
+	return rates, nil
+}
+
+// rateSortKeys are the keys rate versions can be listed by, latest to take effect first by default
+var rateSortKeys = []string{"-effective_at", "created_at"}
+
+// FetchHistory returns the page req asks for of the rate versions matching query
+func (r RateDAL) FetchHistory(ctx context.Context, query bson.D, req PageRequest) ([]model.ExchangeRate, *model.Page, error) {
+	var rates []model.ExchangeRate
+	page, err := paginate(ctx, r.RateCollection, query, req, rateSortKeys, &rates)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rates, page, nil
+}
@@ -0,0 +1,135 @@
+package dal
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+var (
+	// ErrRateProposalNotFound is returned when there is no rate proposal with an ID, or no pending one of a pair
+	ErrRateProposalNotFound = errors.New("rate proposal not found")
+	// ErrRateProposalReviewed is returned when a rate proposal that is no longer pending is reviewed
+	ErrRateProposalReviewed = errors.New("rate proposal has already been reviewed or superseded")
+)
+
+type IRateProposalDAL interface {
+	Propose(ctx context.Context, proposal *model.RateProposal) error
+	Pending(ctx context.Context, base, quote string) (*model.RateProposal, error)
+	Supersede(ctx context.Context, base, quote string, at time.Time) error
+	Review(ctx context.Context, ID, status, reviewer, note string, at time.Time) (*model.RateProposal, error)
+	Fetch(ctx context.Context, query bson.D, req PageRequest) ([]model.RateProposal, *model.Page, error)
+}
+
+type RateProposalDAL struct {
+	DB                     *mongo.Database
+	RateProposalCollection *mongo.Collection
+}
+
+func NewRateProposalDAL(db *mongo.Database) *RateProposalDAL {
+	return &RateProposalDAL{
+		DB:                     db,
+		RateProposalCollection: db.Collection("exchange-rate-proposal"),
+	}
+}
+
+// rateProposalIndexes keep a single pending proposal per pair and list proposals for review
+var rateProposalIndexes = []mongo.IndexModel{
+	{
+		Keys: bson.D{{"rate.base", 1}, {"rate.quote", 1}},
+		Options: options.Index().
+			SetName("rate.base_1_rate.quote_1_pending").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.D{{"status", model.RateProposalPending}}),
+	},
+	{Keys: bson.D{{"status", 1}, {"created_at", -1}}},
+}
+
+// Propose records proposal as the pending proposal of its pair, superseding the one pending before
+func (r RateProposalDAL) Propose(ctx context.Context, proposal *model.RateProposal) error {
+	if err := r.Supersede(ctx, proposal.Rate.Base, proposal.Rate.Quote, proposal.CreatedAt); err != nil {
+		return err
+	}
+	_, err := r.RateProposalCollection.InsertOne(ctx, proposal)
+	if err != nil {
+		logrus.Errorf("[Mongo]: error creating rate proposal %s: %s", proposal.ID, err.Error())
+		return err
+	}
+	return nil
+}
+
+// Pending returns the proposal of base to quote waiting for an admin
+func (r RateProposalDAL) Pending(ctx context.Context, base, quote string) (*model.RateProposal, error) {
+	var proposal model.RateProposal
+	query := bson.D{{"rate.base", base}, {"rate.quote", quote}, {"status", model.RateProposalPending}}
+	err := r.RateProposalCollection.FindOne(ctx, query).Decode(&proposal)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrRateProposalNotFound
+		}
+		logrus.Errorf("[Mongo]: error fetching pending %s/%s rate proposal: %s", base, quote, err.Error())
+		return nil, err
+	}
+	return &proposal, nil
+}
+
+// Supersede marks the pending proposal of base to quote, if any, as superseded by a later rate
+func (r RateProposalDAL) Supersede(ctx context.Context, base, quote string, at time.Time) error {
+	query := bson.D{{"rate.base", base}, {"rate.quote", quote}, {"status", model.RateProposalPending}}
+	update := bson.D{{"$set", bson.D{{"status", model.RateProposalSuperseded}, {"updated_at", at}}}}
+	if _, err := r.RateProposalCollection.UpdateMany(ctx, query, update); err != nil {
+		logrus.Errorf("[Mongo]: error superseding %s/%s rate proposals: %s", base, quote, err.Error())
+		return err
+	}
+	return nil
+}
+
+// Review moves a pending proposal to status, approved or rejected, and returns it as reviewed
+func (r RateProposalDAL) Review(ctx context.Context, ID, status, reviewer, note string, at time.Time) (*model.RateProposal, error) {
+	var proposal model.RateProposal
+	query := bson.D{{"_id", ID}, {"status", model.RateProposalPending}}
+	update := bson.D{{"$set", bson.D{
+		{"status", status},
+		{"reviewed_by", reviewer},
+		{"review_note", note},
+		{"reviewed_at", at},
+		{"updated_at", at},
+	}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := r.RateProposalCollection.FindOneAndUpdate(ctx, query, update, opts).Decode(&proposal)
+	if err == nil {
+		return &proposal, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		logrus.Errorf("[Mongo]: error reviewing rate proposal %s: %s", ID, err.Error())
+		return nil, err
+	}
+
+	count, err := r.RateProposalCollection.CountDocuments(ctx, bson.D{{"_id", ID}})
+	if err != nil {
+		logrus.Errorf("[Mongo]: error fetching rate proposal %s: %s", ID, err.Error())
+		return nil, err
+	}
+	if count == 0 {
+		return nil, ErrRateProposalNotFound
+	}
+	return nil, ErrRateProposalReviewed
+}
+
+// rateProposalSortKeys are the keys rate proposals can be listed by, latest first by default
+var rateProposalSortKeys = []string{"-created_at", "updated_at"}
+
+// Fetch returns the page req asks for of the rate proposals matching query
+func (r RateProposalDAL) Fetch(ctx context.Context, query bson.D, req PageRequest) ([]model.RateProposal, *model.Page, error) {
+	var proposals []model.RateProposal
+	page, err := paginate(ctx, r.RateProposalCollection, query, req, rateProposalSortKeys, &proposals)
+	if err != nil {
+		return nil, nil, err
+	}
+	return proposals, page, nil
+}
@@ -0,0 +1,120 @@
+package dal
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+type IRollupDAL interface {
+	Replace(ctx context.Context, scope bson.D, rollups []model.MetricsRollup) error
+	Fetch(ctx context.Context, query bson.D) ([]model.MetricsRollup, error)
+	Sum(ctx context.Context, query bson.D) ([]model.MetricsRollup, error)
+}
+
+type RollupDAL struct {
+	DB               *mongo.Database
+	RollupCollection *mongo.Collection
+}
+
+func NewRollupDAL(db *mongo.Database) *RollupDAL {
+	return &RollupDAL{
+		DB:               db,
+		RollupCollection: db.Collection("metrics-rollup"),
+	}
+}
+
+// rollupIndexes index rollups by the period the dashboard reads them for
+var rollupIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{"granularity", 1}, {"period_start", 1}, {"type", 1}, {"status", 1}}},
+}
+
+// Replace makes rollups the only rollups matching scope. Each rollup is written in place, so readers never see a
+// period half rolled up, and rollups in scope that are no longer produced are removed afterwards
+func (r RollupDAL) Replace(ctx context.Context, scope bson.D, rollups []model.MetricsRollup) error {
+	now := time.Now()
+	keys := make(bson.A, 0, len(rollups))
+	writes := make([]mongo.WriteModel, 0, len(rollups))
+	for i := range rollups {
+		rollup := rollups[i]
+		rollup.ID = rollup.Key()
+		rollup.UpdatedAt = now
+		keys = append(keys, rollup.ID)
+		writes = append(writes, mongo.NewReplaceOneModel().SetFilter(bson.D{{"_id", rollup.ID}}).SetReplacement(rollup).SetUpsert(true))
+	}
+
+	if len(writes) > 0 {
+		if _, err := r.RollupCollection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+			logrus.Errorf("[Mongo]: error writing metrics rollups: %s", err.Error())
+			return err
+		}
+	}
+	stale := append(bson.D{{"_id", bson.D{{"$nin", keys}}}}, scope...)
+	if _, err := r.RollupCollection.DeleteMany(ctx, stale); err != nil {
+		logrus.Errorf("[Mongo]: error removing stale metrics rollups: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// Fetch returns the rollups matching query, oldest period first
+func (r RollupDAL) Fetch(ctx context.Context, query bson.D) ([]model.MetricsRollup, error) {
+	cursor, err := r.RollupCollection.Find(ctx, query, options.Find().SetSort(bson.D{{"period_start", 1}}))
+	if err != nil {
+		logrus.Errorf("[Mongo]: error fetching metrics rollups: %s", err.Error())
+		return nil, err
+	}
+	rollups := []model.MetricsRollup{}
+	if err := cursor.All(ctx, &rollups); err != nil {
+		logrus.Errorf("[Mongo]: error decoding metrics rollups: %s", err.Error())
+		return nil, err
+	}
+	return rollups, nil
+}
+
+// Sum adds up the rollups matching query per type, currency and status. The sums have no granularity or period
+func (r RollupDAL) Sum(ctx context.Context, query bson.D) ([]model.MetricsRollup, error) {
+	pipeline := mongo.Pipeline{
+		{{"$match", query}},
+		{{"$group", bson.D{
+			{"_id", bson.D{{"type", "$type"}, {"currency", "$currency"}, {"status", "$status"}}},
+			{"volume", bson.D{{"$sum", "$volume"}}},
+			{"count", bson.D{{"$sum", "$count"}}},
+		}}},
+	}
+	cursor, err := r.RollupCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		logrus.Errorf("[Mongo]: error summing metrics rollups: %s", err.Error())
+		return nil, err
+	}
+	var rows []struct {
+		ID struct {
+			Type     string `bson:"type"`
+			Currency string `bson:"currency"`
+			Status   string `bson:"status"`
+		} `bson:"_id"`
+		Volume money.Amount `bson:"volume"`
+		Count  int64        `bson:"count"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		logrus.Errorf("[Mongo]: error decoding metrics rollup sums: %s", err.Error())
+		return nil, err
+	}
+
+	sums := make([]model.MetricsRollup, 0, len(rows))
+	for _, row := range rows {
+		sums = append(sums, model.MetricsRollup{
+			Type:     row.ID.Type,
+			Currency: row.ID.Currency,
+			Status:   row.ID.Status,
+			Count:    row.Count,
+			Volume:   row.Volume,
+		})
+	}
+	return sums, nil
+}
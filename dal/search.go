@@ -0,0 +1,98 @@
+package dal
+
+import (
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// profileFields are the names a collection of users or agents gives the fields a ProfileFilter matches
+type profileFields struct {
+	phone     string
+	currency  func(currency string) bson.E
+	created   string                        // field ordered by creation time
+	createdAt func(t time.Time) interface{} // value of the created field at t
+}
+
+var (
+	userProfileFields = profileFields{
+		phone: "phone_number",
+		// a user has a wallet per currency, keyed by it
+		currency: func(currency string) bson.E {
+			return bson.E{Key: "wallet." + currency + ".currency", Value: currency}
+		},
+		created:   "created_at",
+		createdAt: func(t time.Time) interface{} { return t },
+	}
+	agentProfileFields = profileFields{
+		phone: "phone",
+		currency: func(currency string) bson.E {
+			return bson.E{Key: "wallet.currency", Value: currency}
+		},
+		// agent ids are cuids, which start with c and their creation time in base 36 milliseconds
+		created: "_id",
+		createdAt: func(t time.Time) interface{} {
+			return "c" + strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 36)
+		},
+	}
+)
+
+// profileQuery translates filter to a query of the users or agents collection
+func profileQuery(fields profileFields, filter model.ProfileFilter) bson.D {
+	query := bson.D{}
+	if filter.Text != "" {
+		query = append(query, bson.E{Key: "$text", Value: bson.D{{"$search", filter.Text}}})
+	}
+	if filter.Name != "" {
+		query = append(query, bson.E{Key: "full_name", Value: startsWith(filter.Name)})
+	}
+	if filter.Username != "" {
+		query = append(query, bson.E{Key: "username", Value: startsWith(filter.Username)})
+	}
+	if filter.Email != "" {
+		query = append(query, bson.E{Key: "email", Value: startsWith(filter.Email)})
+	}
+	if filter.Phone != "" {
+		query = append(query, bson.E{Key: fields.phone, Value: filter.Phone})
+	}
+	if filter.IDNumber != "" {
+		query = append(query, bson.E{Key: "id_number", Value: filter.IDNumber})
+	}
+	switch filter.KYCStatus {
+	case model.KYCVerified:
+		query = append(query, bson.E{Key: "is_id_verified", Value: true})
+	case model.KYCPending:
+		query = append(query, bson.E{Key: "is_id_verified", Value: bson.D{{"$ne", true}}}, bson.E{Key: "id_number", Value: bson.D{{"$nin", bson.A{"", nil}}}})
+	case model.KYCNone:
+		query = append(query, bson.E{Key: "id_number", Value: bson.D{{"$in", bson.A{"", nil}}}})
+	}
+	if filter.Active != nil {
+		query = append(query, bson.E{Key: "active", Value: *filter.Active})
+	}
+	if filter.Approved != nil {
+		query = append(query, bson.E{Key: "approved", Value: *filter.Approved})
+	}
+	if filter.Currency != "" {
+		query = append(query, fields.currency(strings.ToUpper(filter.Currency)))
+	}
+	if !filter.From.IsZero() || !filter.To.IsZero() {
+		created := bson.D{}
+		if !filter.From.IsZero() {
+			created = append(created, bson.E{Key: "$gte", Value: fields.createdAt(filter.From)})
+		}
+		if !filter.To.IsZero() {
+			created = append(created, bson.E{Key: "$lt", Value: fields.createdAt(filter.To)})
+		}
+		query = append(query, bson.E{Key: fields.created, Value: created})
+	}
+	return query
+}
+
+// startsWith matches strings starting with prefix, ignoring case
+func startsWith(prefix string) primitive.Regex {
+	return primitive.Regex{Pattern: "^" + regexp.QuoteMeta(prefix), Options: "i"}
+}
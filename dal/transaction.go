@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/types"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
@@ -19,7 +20,6 @@ type ITransactionDAL interface {
 	CreateDeposit(ctx context.Context, deposit *model.Deposit) error
 	CreateExchange(ctx context.Context, exchange *model.Exchange) error
 	CreateOnePurseTransaction(ctx context.Context, transaction *model.OnePurseTransaction) error
-	CreateRate(ctx context.Context, transaction *model.Rate) error
 	CreateAdminPayment(ctx context.Context, payments *model.AdminPayment) error
 
 	GetAccount(ctx context.Context, query bson.D) (*model.Account, error)
@@ -28,7 +28,6 @@ type ITransactionDAL interface {
 	GetDepositByID(ctx context.Context, depositID string) (*model.Deposit, error)
 	GetExchangeByID(ctx context.Context, exchangeID string) (*model.Exchange, error)
 	GetOnePurseTransactionByID(ctx context.Context, transactionID string) (*model.OnePurseTransaction, error)
-	GetRate(ctx context.Context) (*model.Rate, error)
 	GetAdminPayment(ctx context.Context, query bson.D) (*model.AdminPayment, error)
 
 	UpdateAccount(ctx context.Context, accountID string, updateParam bson.D) error
@@ -37,8 +36,8 @@ type ITransactionDAL interface {
 	UpdateDeposit(ctx context.Context, depositID string, updateParam bson.D) error
 	UpdateExchange(ctx context.Context, exchangeID string, updateParam bson.D) error
 	UpdateOnePurseTransaction(ctx context.Context, transactionID string, updateParam bson.D) error
-	UpdateRate(ctx context.Context, updateParam bson.D) error
 	UpdateAdminPayment(ctx context.Context, ID string, updateParam bson.D) error
+	TransitionStatus(ctx context.Context, transactionType, transactionID string, change model.StatusChange, fields bson.D) error
 
 	FetchAccounts(ctx context.Context, query bson.D) (*[]model.Account, error)
 	FetchTransfers(ctx context.Context, query bson.D) (*[]model.Transfer, error)
@@ -47,9 +46,12 @@ type ITransactionDAL interface {
 	FetchExchanges(ctx context.Context, query bson.D) (*[]model.Exchange, error)
 	FetchOnePurseTransactions(ctx context.Context, query bson.D) (*[]model.OnePurseTransaction, error)
 	FetchAdminPayments(ctx context.Context, query bson.D) (*[]model.AdminPayment, error)
+	FetchAdminPaymentsPage(ctx context.Context, query bson.D, req PageRequest) ([]model.AdminPayment, *model.Page, error)
+	FetchHistory(ctx context.Context, filter model.HistoryFilter, req PageRequest) ([]model.HistoryEntry, *model.Page, error)
 
 	CountAll(ctx context.Context) (int32, error)
-	CheckTimeLimit() error
+	ChangedDays(ctx context.Context, transactionType string, since time.Time) ([]time.Time, error)
+	RollupHours(ctx context.Context, transactionType string, start, end time.Time) ([]model.MetricsRollup, error)
 }
 type TransactionDAL struct {
 	DB                            *mongo.Database
@@ -59,7 +61,6 @@ type TransactionDAL struct {
 	ExchangeCollection            *mongo.Collection
 	OnePurseTransactionCollection *mongo.Collection
 	AccountCollection             *mongo.Collection
-	RateCollection                *mongo.Collection
 	AdminPaymentCollection        *mongo.Collection
 }
 
@@ -72,7 +73,6 @@ func NewTransactionDAL(db *mongo.Database) *TransactionDAL {
 		ExchangeCollection:            db.Collection("exchange"),
 		OnePurseTransactionCollection: db.Collection("one-purse-transaction"),
 		AccountCollection:             db.Collection("account"),
-		RateCollection:                db.Collection("rate"),
 		AdminPaymentCollection:        db.Collection("admin-payments"),
 	}
 }
@@ -155,21 +155,10 @@ func (t TransactionDAL) CreateAdminPayment(ctx context.Context, payment *model.A
 	return nil
 }
 
-func (t TransactionDAL) CreateRate(ctx context.Context, rate *model.Rate) error {
-	_, err := t.RateCollection.InsertOne(ctx, rate)
-	if err != nil {
-		if mongo.IsDuplicateKeyError(err) {
-			return errors.New("rate record already exist. You might be duplicating a rate")
-		}
-		return err
-	}
-	return nil
-}
-
 // GetAccount fetches bank account information based on the specified query parameters ...
 func (t TransactionDAL) GetAccount(ctx context.Context, query bson.D) (*model.Account, error) {
 	var account *model.Account
-	err := t.AccountCollection.FindOne(ctx, query).Decode(account)
+	err := t.AccountCollection.FindOne(ctx, query).Decode(&account)
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -183,7 +172,7 @@ func (t TransactionDAL) GetAccount(ctx context.Context, query bson.D) (*model.Ac
 
 func (t TransactionDAL) GetTransferByID(ctx context.Context, transferID string) (*model.Transfer, error) {
 	var transfer *model.Transfer
-	err := t.TransferCollection.FindOne(ctx, bson.D{{"_id", transferID}}).Decode(transfer)
+	err := t.TransferCollection.FindOne(ctx, bson.D{{"_id", transferID}}).Decode(&transfer)
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -197,7 +186,7 @@ func (t TransactionDAL) GetTransferByID(ctx context.Context, transferID string)
 
 func (t TransactionDAL) GetWithdrawalByID(ctx context.Context, withdrawalID string) (*model.Withdrawal, error) {
 	var withdrawal *model.Withdrawal
-	err := t.WithdrawalCollection.FindOne(ctx, bson.D{{"_id", withdrawalID}}).Decode(withdrawal)
+	err := t.WithdrawalCollection.FindOne(ctx, bson.D{{"_id", withdrawalID}}).Decode(&withdrawal)
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -211,7 +200,7 @@ func (t TransactionDAL) GetWithdrawalByID(ctx context.Context, withdrawalID stri
 
 func (t TransactionDAL) GetDepositByID(ctx context.Context, depositID string) (*model.Deposit, error) {
 	var deposit *model.Deposit
-	err := t.DepositCollection.FindOne(ctx, bson.D{{"_id", depositID}}).Decode(deposit)
+	err := t.DepositCollection.FindOne(ctx, bson.D{{"_id", depositID}}).Decode(&deposit)
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -225,7 +214,7 @@ func (t TransactionDAL) GetDepositByID(ctx context.Context, depositID string) (*
 
 func (t TransactionDAL) GetExchangeByID(ctx context.Context, exchangeID string) (*model.Exchange, error) {
 	var exchange *model.Exchange
-	err := t.ExchangeCollection.FindOne(ctx, bson.D{{"_id", exchangeID}}).Decode(exchange)
+	err := t.ExchangeCollection.FindOne(ctx, bson.D{{"_id", exchangeID}}).Decode(&exchange)
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -239,7 +228,7 @@ func (t TransactionDAL) GetExchangeByID(ctx context.Context, exchangeID string)
 
 func (t TransactionDAL) GetOnePurseTransactionByID(ctx context.Context, transactionID string) (*model.OnePurseTransaction, error) {
 	var transaction *model.OnePurseTransaction
-	err := t.OnePurseTransactionCollection.FindOne(ctx, bson.D{{"_id", transactionID}}).Decode(transaction)
+	err := t.OnePurseTransactionCollection.FindOne(ctx, bson.D{{"_id", transactionID}}).Decode(&transaction)
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -264,18 +253,6 @@ func (t TransactionDAL) GetAdminPayment(ctx context.Context, query bson.D) (*mod
 	return payment, nil
 }
 
-func (t TransactionDAL) GetRate(ctx context.Context) (*model.Rate, error) {
-	var rate *model.Rate
-	err := t.RateCollection.FindOne(ctx, bson.D{}).Decode(&rate)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, errors.New("record for rate not found")
-		}
-		return nil, err
-	}
-	return rate, nil
-}
-
 // FetchAccounts fetches a list of account based on the specified query ...
 func (t TransactionDAL) FetchAccounts(ctx context.Context, query bson.D) (*[]model.Account, error) {
 	var account []model.Account
@@ -484,42 +461,40 @@ func (t TransactionDAL) UpdateAdminPayment(ctx context.Context, ID string, updat
 	return nil
 }
 
-func (t TransactionDAL) UpdateRate(ctx context.Context, updateParam bson.D) error {
-	result, err := t.RateCollection.UpdateOne(ctx, bson.D{}, updateParam)
+// TransitionStatus moves a transaction from change.From to change.To, records the change in its history and sets any
+// fields that come with the transition. The update only applies while the transaction is still in change.From so
+// two concurrent transitions of the same transaction cannot both succeed
+func (t TransactionDAL) TransitionStatus(ctx context.Context, transactionType, transactionID string, change model.StatusChange, fields bson.D) error {
+	collection := t.collectionFor(transactionType)
+	if collection == nil {
+		return fmt.Errorf("transaction type %s not supported", transactionType)
+	}
+
+	set := append(bson.D{{"status", change.To}, {"updated_at", change.At}}, fields...)
+	filter := bson.D{{"_id", transactionID}, {"status", change.From}}
+	update := bson.D{{"$set", set}, {"$push", bson.D{{"history", change}}}}
+	result, err := collection.UpdateOne(ctx, filter, update)
 	if err != nil {
-		logrus.Errorf("[Mongo]: error updating rate: %s", err.Error())
+		logrus.Errorf("[Mongo]: error moving %s %s to %s: %s", transactionType, transactionID, change.To, err.Error())
 		return err
 	}
-
 	if result.MatchedCount == 0 {
-		logrus.Errorf("[Mongo]: error updating rate: record not found")
-		return errors.New("rate record not found")
+		return fmt.Errorf("%s is no longer %s. Please refresh and try again", transactionType, change.From)
 	}
 	return nil
 }
 
-func (t TransactionDAL) CheckTimeLimit() error {
-	fmt.Println("here one")
-	logrus.Info("Running Transaction Time Limit Cron")
-	// first fetch all transactions created today
-	var transfers *[]model.Transfer
-	fmt.Println("here again")
-	cursor, err := t.TransferCollection.Find(context.TODO(), bson.D{{"created_at", bson.D{{"$eq", time.Now().Add(-30 * time.Minute)}}}})
-	if err != nil {
-		logrus.Fatalf("[Mongo]: error fetching transactions: %s", err.Error())
-		return err
+func (t TransactionDAL) collectionFor(transactionType string) *mongo.Collection {
+	switch transactionType {
+	case types.TRANSFER:
+		return t.TransferCollection
+	case types.WITHDRAW:
+		return t.WithdrawalCollection
+	case types.DEPOSIT:
+		return t.DepositCollection
+	case types.EXCHANGE:
+		return t.ExchangeCollection
 	}
-	if err = cursor.All(context.TODO(), &transfers); err != nil {
-		logrus.Fatalf("[Mongo]: error parsing mongo document to transfer model: %s", err.Error())
-		return err
-	}
-
-	// update fetched transactions
-	for _, t := range *transfers {
-		if t.Status == "pending" {
-		}
-	}
-	logrus.Info("Completed Transaction Time Limit Cron")
 	return nil
 }
 
@@ -552,3 +527,16 @@ func (t TransactionDAL) CountAll(ctx context.Context) (int32, error) {
 	total := nOT + nE + nT + nW + nD
 	return int32(total), nil
 }
+
+// adminPaymentSortKeys are the keys admin payments can be listed by, newest first by default
+var adminPaymentSortKeys = []string{"-created_at", "recipient_name", "category"}
+
+// FetchAdminPaymentsPage returns the page req asks for of the admin payments matching query
+func (t TransactionDAL) FetchAdminPaymentsPage(ctx context.Context, query bson.D, req PageRequest) ([]model.AdminPayment, *model.Page, error) {
+	var payments []model.AdminPayment
+	page, err := paginate(ctx, t.AdminPaymentCollection, query, req, adminPaymentSortKeys, &payments)
+	if err != nil {
+		return nil, nil, err
+	}
+	return payments, page, nil
+}
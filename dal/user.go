@@ -8,15 +8,23 @@ import (
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
 )
 
+// ErrPinLocked is returned when a transaction pin attempt is claimed while the user's pin is locked or out of attempts
+var ErrPinLocked = errors.New("transaction pin is locked")
+
 type IUserDAL interface {
 	Add(ctx context.Context, user *model.User) error
 	FindByID(ctx context.Context, userID string) (*model.User, error)
 	FindAll(ctx context.Context, query bson.D) (*[]model.User, error)
+	FindPage(ctx context.Context, query bson.D, req PageRequest) ([]model.User, *model.Page, error)
+	Search(ctx context.Context, filter model.ProfileFilter, req PageRequest) ([]model.User, *model.Page, error)
 	FindOne(ctx context.Context, query bson.D) (*model.User, error)
 	FindByUsername(ctx context.Context, username string) (*model.User, error)
 	UpdateUser(ctx context.Context, userID string, updateParam bson.D) error
+	ClaimPinAttempt(ctx context.Context, userID string, maxAttempts int, now time.Time) (*model.User, error)
 	DeleteUser(ctx context.Context, userID string) error
 	Count(ctx context.Context) (int32, error)
 }
@@ -33,6 +41,24 @@ func NewUserDAL(db *mongo.Database) *UserDAL {
 	}
 }
 
+// userIndexes back the default sort and the searches of the admin console, including a text index over names and
+// contact details. Wallets are keyed by currency, so they are covered by a wildcard index
+var userIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{"created_at", -1}}},
+	{Keys: bson.D{{"full_name", 1}}},
+	{Keys: bson.D{{"username", 1}}},
+	{Keys: bson.D{{"email", 1}}},
+	{Keys: bson.D{{"phone_number", 1}}},
+	{Keys: bson.D{{"id_number", 1}}},
+	{Keys: bson.D{{"approved", 1}, {"created_at", -1}}},
+	{Keys: bson.D{{"is_id_verified", 1}, {"created_at", -1}}},
+	{Keys: bson.D{{"wallet.$**", 1}}},
+	{
+		Keys:    bson.D{{"full_name", "text"}, {"username", "text"}, {"email", "text"}, {"phone_number", "text"}},
+		Options: options.Index().SetName("user_search"),
+	},
+}
+
 func (u UserDAL) Add(ctx context.Context, user *model.User) error {
 	_, err := u.Collection.InsertOne(ctx, user)
 	if err != nil {
@@ -116,6 +142,28 @@ func (u UserDAL) FindByUsername(ctx context.Context, username string) (*model.Us
 	return user, nil
 }
 
+// ClaimPinAttempt counts a transaction pin attempt against the user before the pin is checked, and returns the updated
+// user. The claim is refused with ErrPinLocked while the pin is locked or maxAttempts are already claimed, so
+// concurrent attempts can never check more pins than allowed
+func (u UserDAL) ClaimPinAttempt(ctx context.Context, userID string, maxAttempts int, now time.Time) (*model.User, error) {
+	var user *model.User
+	query := bson.D{
+		{"_id", userID},
+		{"pin_locked_until", bson.D{{"$not", bson.D{{"$gt", now}}}}},
+		{"pin_attempts", bson.D{{"$not", bson.D{{"$gte", maxAttempts}}}}},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := u.Collection.FindOneAndUpdate(ctx, query, bson.D{{"$inc", bson.D{{"pin_attempts", 1}}}}, opts).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrPinLocked
+		}
+		logrus.Errorf("[Mongo]: error claiming pin attempt of user %s : %s", userID, err.Error())
+		return nil, err
+	}
+	return user, nil
+}
+
 func (u UserDAL) UpdateUser(ctx context.Context, userID string, updateParam bson.D) error {
 	result, err := u.Collection.UpdateByID(ctx, userID, updateParam)
 	if err != nil {
@@ -144,9 +192,27 @@ func (u UserDAL) DeleteUser(ctx context.Context, userID string) error {
 }
 
 func (u UserDAL) Count(ctx context.Context) (int32, error) {
-	num, err := u.Collection.CountDocuments(ctx, bson.D{})
+	num, err := u.Collection.EstimatedDocumentCount(ctx)
 	if err != nil {
 		return 0, err
 	}
 	return int32(num), err
 }
+
+// userSortKeys are the keys users can be listed by, newest first by default
+var userSortKeys = []string{"-created_at", "full_name", "username"}
+
+// FindPage returns the page req asks for of the users matching query
+func (u UserDAL) FindPage(ctx context.Context, query bson.D, req PageRequest) ([]model.User, *model.Page, error) {
+	var users []model.User
+	page, err := paginate(ctx, u.Collection, query, req, userSortKeys, &users)
+	if err != nil {
+		return nil, nil, err
+	}
+	return users, page, nil
+}
+
+// Search returns the page req asks for of the users matching filter
+func (u UserDAL) Search(ctx context.Context, filter model.ProfileFilter, req PageRequest) ([]model.User, *model.Page, error) {
+	return u.FindPage(ctx, profileQuery(userProfileFields, filter), req)
+}
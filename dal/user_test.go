@@ -0,0 +1,80 @@
+package dal
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClaimPinAttempt(t *testing.T) {
+	u := NewUserDAL(testDatabase(t))
+	ctx := context.Background()
+	now := time.Now()
+	const maxAttempts = 3
+	if err := u.Add(ctx, &model.User{ID: "user", CreatedAt: now}); err != nil {
+		t.Fatalf("Add error = %v", err)
+	}
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		user, err := u.ClaimPinAttempt(ctx, "user", maxAttempts, now)
+		if err != nil || user.PinAttempts != attempt {
+			t.Fatalf("claim %d = %+v, %v, want %d attempts", attempt, user, err, attempt)
+		}
+	}
+	if _, err := u.ClaimPinAttempt(ctx, "user", maxAttempts, now); err != ErrPinLocked {
+		t.Fatalf("claim past the limit error = %v, want %v", err, ErrPinLocked)
+	}
+
+	// a lockout refuses attempts until it expires
+	lockedUntil := now.Add(30 * time.Minute)
+	if err := u.UpdateUser(ctx, "user", bson.D{{"$set", bson.D{{"pin_attempts", 0}, {"pin_locked_until", lockedUntil}}}}); err != nil {
+		t.Fatalf("UpdateUser error = %v", err)
+	}
+	if _, err := u.ClaimPinAttempt(ctx, "user", maxAttempts, lockedUntil.Add(-time.Second)); err != ErrPinLocked {
+		t.Errorf("claim while locked error = %v, want %v", err, ErrPinLocked)
+	}
+	if user, err := u.ClaimPinAttempt(ctx, "user", maxAttempts, lockedUntil.Add(time.Second)); err != nil || user.PinAttempts != 1 {
+		t.Errorf("claim after the lockout = %+v, %v, want the first attempt", user, err)
+	}
+}
+
+func TestClaimPinAttemptConcurrently(t *testing.T) {
+	u := NewUserDAL(testDatabase(t))
+	ctx := context.Background()
+	now := time.Now()
+	const maxAttempts = 3
+	if err := u.Add(ctx, &model.User{ID: "user", PinAttempts: maxAttempts - 1, CreatedAt: now}); err != nil {
+		t.Fatalf("Add error = %v", err)
+	}
+
+	const requests = 8
+	var wg sync.WaitGroup
+	claimed := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := u.ClaimPinAttempt(ctx, "user", maxAttempts, now)
+			claimed <- err
+		}()
+	}
+	wg.Wait()
+	close(claimed)
+
+	granted := 0
+	for err := range claimed {
+		switch err {
+		case nil:
+			granted++
+		case ErrPinLocked:
+		default:
+			t.Errorf("concurrent claim error = %v", err)
+		}
+	}
+	if granted != 1 {
+		t.Errorf("%d concurrent claims were granted with one attempt left", granted)
+	}
+}
@@ -1,8 +1,13 @@
 package deps
 
 import (
+	"context"
+	"fmt"
+	"github.com/isongjosiah/work/onepurse-api/auth"
 	"github.com/isongjosiah/work/onepurse-api/config"
 	userdal "github.com/isongjosiah/work/onepurse-api/dal"
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"github.com/isongjosiah/work/onepurse-api/scheduler"
 	"github.com/isongjosiah/work/onepurse-api/services"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -13,9 +18,19 @@ type Dependencies struct {
 	AWS    *services.AWS
 	PLAID  *services.PLAID
 	TWILIO *services.Twilio
+	EMAIL  services.IEmailService
 
 	// DAL
 	DAL *userdal.DAL
+
+	// Background jobs
+	Scheduler *scheduler.Scheduler
+	RateFeed  *services.RateFeed // nil when no rate provider is configured
+
+	// Authentication
+	Identity  services.IIdentityProvider
+	Verifier  *auth.Verifier
+	LocalKeys *auth.LocalKeys // set when tokens are signed locally
 }
 
 func New(cfg *config.Config) (*Dependencies, error) {
@@ -40,12 +55,99 @@ func New(cfg *config.Config) (*Dependencies, error) {
 		return nil, errors.Wrapf(err, "[TWILIO]: unable to set up TWILIO service")
 	}
 
+	identity, verifier, localKeys, err := newIdentity(cfg, dal)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[AUTH]: unable to set up authentication")
+	}
+
+	rateFeed, err := newRateFeed(cfg, dal)
+	if err != nil {
+		return nil, errors.Wrapf(err, "[RATES]: unable to set up the rate provider")
+	}
+
 	deps := &Dependencies{
-		AWS:    aws,
-		PLAID:  plaid,
-		DAL:    dal,
-		TWILIO: twilio,
+		AWS:       aws,
+		PLAID:     plaid,
+		DAL:       dal,
+		TWILIO:    twilio,
+		EMAIL:     services.NewEmailService(cfg),
+		Scheduler: scheduler.New(dal.JobDAL, cfg.SchedulerPollInterval, cfg.JobLease),
+		RateFeed:  rateFeed,
+		Identity:  identity,
+		Verifier:  verifier,
+		LocalKeys: localKeys,
 	}
 
 	return deps, nil
 }
+
+// newRateFeed sets up fetching rates from the configured rate provider, if any
+func newRateFeed(cfg *config.Config, dal *userdal.DAL) (*services.RateFeed, error) {
+	provider, err := services.NewRateProvider(cfg)
+	if err != nil || provider == nil {
+		return nil, err
+	}
+	maxMove, err := money.ParseRate(cfg.RateMaxMove)
+	if err != nil || maxMove.Cmp(money.Rate{}) < 0 {
+		return nil, errors.Errorf("RATE_MAX_MOVE must be a percentage, got %q", cfg.RateMaxMove)
+	}
+	logrus.Infof("[RATES]: fetching rates from %s every %s", provider.Name(), cfg.RateFetchInterval)
+	return services.NewRateFeed(provider, dal.RateDAL, dal.RateProposalDAL, dal.CurrencyDAL, maxMove), nil
+}
+
+// newIdentity sets up the identity provider and the verification of the tokens it issues
+func newIdentity(cfg *config.Config, dal *userdal.DAL) (services.IIdentityProvider, *auth.Verifier, *auth.LocalKeys, error) {
+	local := cfg.IdentityProvider == config.IdentityProviderLocal
+	issuer := cfg.TokenIssuer
+	clientID := cfg.CognitoAppClientID
+	switch {
+	case local:
+		if issuer == "" {
+			issuer = fmt.Sprintf("http://localhost:%d/auth", cfg.Port)
+		}
+		if clientID == "" {
+			clientID = config.AppSrvName
+		}
+	case cfg.IdentityProvider != config.IdentityProviderCognito:
+		return nil, nil, nil, errors.Errorf("unknown IDENTITY_PROVIDER %s", cfg.IdentityProvider)
+	case issuer == "":
+		issuer = auth.CognitoIssuer(cfg.AWSRegion, cfg.CognitoUserPoolID)
+	}
+
+	var source auth.KeySource
+	var localKeys *auth.LocalKeys
+	switch {
+	case local || cfg.AuthKeySource == config.KeySourceLocal:
+		keys, err := auth.NewLocalKeys()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		source, localKeys = keys, keys
+	case cfg.AuthKeySource == config.KeySourceCognito:
+		source = auth.NewRemoteKeySource(auth.CognitoJWKSURL(cfg.AWSRegion, cfg.CognitoUserPoolID))
+	case cfg.AuthKeySource == config.KeySourceFile:
+		if cfg.JWKSFile == "" {
+			return nil, nil, nil, errors.New("JWKS_FILE is required when AUTH_KEY_SOURCE is file")
+		}
+		source = &auth.FileKeySource{Path: cfg.JWKSFile}
+	default:
+		return nil, nil, nil, errors.Errorf("unknown AUTH_KEY_SOURCE %s", cfg.AuthKeySource)
+	}
+
+	keys := auth.NewKeyCache(source, cfg.JWKSRefreshInterval)
+	// requests get a 503 until the keys are fetched, so an unreachable key source should not stop the API from starting
+	if err := keys.Refresh(context.Background()); err != nil {
+		logrus.Warnf("[AUTH]: signing keys are not available yet: %s", err.Error())
+	}
+	verifier := auth.NewVerifier(keys, issuer, clientID)
+
+	if local {
+		logrus.Warn("[AUTH]: using the local identity provider, do not use it in production")
+		return services.NewLocalIdentityProvider(dal.IdentityDAL, localKeys, verifier, issuer, clientID), verifier, localKeys, nil
+	}
+	cognito, err := services.NewCognitoService(cfg)
+	if err != nil {
+		return nil, nil, nil, errors.Wrapf(err, "%v Cognito", "Failed to setup service:")
+	}
+	return cognito, verifier, localKeys, nil
+}
@@ -0,0 +1,200 @@
+package deps
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/config"
+	userdal "github.com/isongjosiah/work/onepurse-api/dal"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/services"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"go.mongodb.org/mongo-driver/bson"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memoryIdentityDAL keeps local identities in memory as the documents mongo would store. It supports the queries and
+// the $set and $unset updates of the local identity provider
+type memoryIdentityDAL struct {
+	mu   sync.Mutex
+	docs map[string]bson.M
+}
+
+func toDocument(v interface{}) (bson.M, error) {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	return doc, bson.Unmarshal(data, &doc)
+}
+
+func (m *memoryIdentityDAL) Add(ctx context.Context, identity *model.LocalIdentity) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, doc := range m.docs {
+		if doc["username"] == identity.Username {
+			return userdal.ErrIdentityExists
+		}
+	}
+	doc, err := toDocument(identity)
+	if err != nil {
+		return err
+	}
+	m.docs[identity.ID] = doc
+	return nil
+}
+
+func (m *memoryIdentityDAL) FindOne(ctx context.Context, query bson.D) (*model.LocalIdentity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, doc := range m.docs {
+		if matches(doc, query) {
+			data, err := bson.Marshal(doc)
+			if err != nil {
+				return nil, err
+			}
+			var identity model.LocalIdentity
+			return &identity, bson.Unmarshal(data, &identity)
+		}
+	}
+	return nil, userdal.ErrIdentityNotFound
+}
+
+func (m *memoryIdentityDAL) Update(ctx context.Context, ID string, updateParam bson.D) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	doc, ok := m.docs[ID]
+	if !ok {
+		return userdal.ErrIdentityNotFound
+	}
+	for _, op := range updateParam {
+		for _, field := range op.Value.(bson.D) {
+			switch op.Key {
+			case "$set":
+				doc[field.Key] = field.Value
+			case "$unset":
+				delete(doc, field.Key)
+			}
+		}
+	}
+	// round trip the document so set values are stored the way mongo returns them
+	doc, err := toDocument(doc)
+	if err != nil {
+		return err
+	}
+	m.docs[ID] = doc
+	return nil
+}
+
+// matches supports equality on fields, on a field of the documents of an array, and $or
+func matches(doc bson.M, query bson.D) bool {
+	for _, cond := range query {
+		switch {
+		case cond.Key == "$or":
+			matched := false
+			for _, alternative := range cond.Value.([]bson.M) {
+				for key, value := range alternative {
+					if matches(doc, bson.D{{key, value}}) {
+						matched = true
+					}
+				}
+			}
+			if !matched {
+				return false
+			}
+		case strings.Contains(cond.Key, "."):
+			path := strings.SplitN(cond.Key, ".", 2)
+			items, _ := doc[path[0]].(bson.A)
+			found := false
+			for _, item := range items {
+				if sub, ok := item.(bson.M); ok && matches(sub, bson.D{{path[1], cond.Value}}) {
+					found = true
+				}
+			}
+			if !found {
+				return false
+			}
+		case !reflect.DeepEqual(doc[cond.Key], cond.Value):
+			return false
+		}
+	}
+	return true
+}
+
+var confirmationCode = regexp.MustCompile(`confirmation code for \S+ is (\d{6})`)
+
+func TestLocalIdentityWithoutAWS(t *testing.T) {
+	cfg := &config.Config{
+		Port:             8080,
+		OTPSecret:        "secret",
+		IdentityProvider: config.IdentityProviderLocal,
+		AuthKeySource:    config.KeySourceCognito,
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v, want the local provider to need no AWS settings", err)
+	}
+	aws, err := services.NewAWS(cfg)
+	if err != nil || aws.S3 == nil || aws.SNS == nil {
+		t.Fatalf("NewAWS() = %v, %v, want stand-ins without AWS_REGION", aws, err)
+	}
+
+	dal := &userdal.DAL{IdentityDAL: &memoryIdentityDAL{docs: make(map[string]bson.M)}}
+	identity, verifier, localKeys, err := newIdentity(cfg, dal)
+	if err != nil {
+		t.Fatalf("newIdentity() error = %v", err)
+	}
+	if localKeys == nil {
+		t.Fatalf("newIdentity() did not sign tokens locally")
+	}
+
+	logs := logtest.NewGlobal()
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	const email, password = "ada@onepurse.test", "Correct-Horse-1"
+	if _, err := identity.SignUp(&model.RegistrationRequest{Email: email, Password: password, FullName: "Ada"}); err != nil {
+		t.Fatalf("SignUp() error = %v", err)
+	}
+	var code string
+	for _, entry := range logs.AllEntries() {
+		if match := confirmationCode.FindStringSubmatch(entry.Message); match != nil {
+			code = match[1]
+		}
+	}
+	if code == "" {
+		t.Fatalf("SignUp() did not log a confirmation code")
+	}
+
+	if _, err := identity.Login(&model.LoginRequest{Username: email, Password: password}); err == nil {
+		t.Errorf("Login() of an unconfirmed account succeeded")
+	}
+	if confirmed, err := identity.ConfirmSignUp(&model.VerificationRequest{Email: email, Code: code}); err != nil || !confirmed {
+		t.Fatalf("ConfirmSignUp() = %v, %v", confirmed, err)
+	}
+	if _, err := identity.Login(&model.LoginRequest{Username: email, Password: "Wrong-Horse-1"}); err == nil {
+		t.Errorf("Login() with a wrong password succeeded")
+	}
+	tokens, err := identity.Login(&model.LoginRequest{Username: email, Password: password})
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+
+	token, err := verifier.Verify(context.Background(), tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("Verify() of the access token error = %v", err)
+	}
+	account, err := dal.IdentityDAL.FindOne(context.Background(), bson.D{{"_id", token.Subject()}})
+	if err != nil || account.Email != email {
+		t.Errorf("access token subject %s = %v, %v, want the account of %s", token.Subject(), account, err, email)
+	}
+
+	refreshed, err := identity.RefreshAccessToken(&model.RefreshTokenRequest{RefreshToken: tokens.RefreshToken})
+	if err != nil {
+		t.Fatalf("RefreshAccessToken() error = %v", err)
+	}
+	if _, err := verifier.Verify(context.Background(), refreshed.AccessToken); err != nil {
+		t.Errorf("Verify() of the refreshed access token error = %v", err)
+	}
+}
@@ -1,14 +1,12 @@
 package helpers
 
 import (
-	"encoding/base32"
+	"fmt"
 	"github.com/isongjosiah/work/onepurse-api/dal/model"
-	"github.com/pquerna/otp"
-	"github.com/pquerna/otp/totp"
-	"github.com/sirupsen/logrus"
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"golang.org/x/crypto/bcrypt"
-	"time"
 )
 
 func HashPassword(password string) (string, error) {
@@ -22,13 +20,24 @@ func CheckPasswordHash(password, hash string) bool {
 }
 
 //DoSufficientFundsCheck checks that the user has sufficient funds in wallet to carry out the transaction
-func DoSufficientFundsCheck(user *model.User, amount float32, currency string) bool {
+func DoSufficientFundsCheck(user *model.User, amount money.Amount, currency string) bool {
 	if user.Wallet[currency].AvailableBalance < amount {
 		return false
 	}
 	return true
 }
 
+//CheckAmount checks that an amount is positive and has no more decimal places than the currency allows
+func CheckAmount(amount money.Amount, currency string) error {
+	if amount <= money.Zero {
+		return errors.New("amount must be greater than zero")
+	}
+	if !amount.Valid(currency) {
+		return fmt.Errorf("%s amounts cannot have more than %d decimal places", currency, money.MinorUnits(currency))
+	}
+	return nil
+}
+
 //MarshalStructToBSONDoc marshals a struct to a mongo document
 func MarshalStructToBSONDoc(structure interface{}) (bson.D, error) {
 	var doc bson.D
@@ -45,69 +54,6 @@ func MarshalStructToBSONDoc(structure interface{}) (bson.D, error) {
 	return doc, nil
 }
 
-//CreateOTP creates an otp code using the userID as secret
-func CreateOTP(user *model.User) (*otp.Key, error) {
-	token, err := totp.Generate(totp.GenerateOpts{
-		Issuer:      "onepurse",
-		AccountName: "hello@onepurse.co",
-		Period:      300,
-		SecretSize:  0,
-		Secret:      []byte(user.ID),
-		Digits:      0,
-		Algorithm:   0,
-		Rand:        nil,
-	})
-	if err != nil {
-		logrus.Errorf("[OTP]: error generating otp: %s", err.Error())
-		return nil, err
-	}
-	return token, err
-}
-
-//CreateOTPCode creates an otp code using the userID as secret
-func CreateOTPCode(userID string) (string, error) {
-	s := generateSecret(userID)
-
-	opts := totp.ValidateOpts{
-		Period:    30,
-		Skew:      0,
-		Digits:    otp.DigitsSix,
-		Algorithm: otp.AlgorithmSHA512,
-	}
-	passcode, err := totp.GenerateCodeCustom(s, time.Now(), opts)
-	if err != nil {
-		logrus.Errorf("[OTP]: error generating otp code: %s", err.Error())
-		return "", err
-	}
-	return passcode, err
-}
-
-//ValidateOTPCode validates a passcode using the userID as secret
-func ValidateOTPCode(userID string, passcode string) bool {
-	s := generateSecret(userID)
-
-	opts := totp.ValidateOpts{
-		Period:    30,
-		Skew:      0,
-		Digits:    otp.DigitsSix,
-		Algorithm: otp.AlgorithmSHA512,
-	}
-	valid, err := totp.ValidateCustom(passcode, s, time.Now(), opts)
-	if err != nil {
-		logrus.Errorf("[OTP]: unable to validate otp: %s", err.Error())
-		return false
-	}
-
-	return valid
-}
-
-//generateSecret generates secret for generating and validating OTP
-func generateSecret(key string) string {
-	secret := []byte(key)
-	s := base32.StdEncoding.EncodeToString(secret)
-	return s
-}
-
 //DoUserWalletCheck checks to see if a user has activated the specified wallet
 func DoUserWalletCheck(user *model.User, walletType string) bool {
 	if _, found := user.Wallet[walletType]; found {
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"github.com/isongjosiah/work/onepurse-api/api"
 	"github.com/isongjosiah/work/onepurse-api/config"
+	"github.com/isongjosiah/work/onepurse-api/dal"
 	"github.com/isongjosiah/work/onepurse-api/deps"
+	"github.com/isongjosiah/work/onepurse-api/services"
 	"github.com/sirupsen/logrus"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -32,26 +39,75 @@ func main() {
 		logrus.SetLevel(logrus.InfoLevel)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(cfg, os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "serve-rates" {
+		serveRates(os.Args[2:])
+		return
+	}
+
 	deps, err := deps.New(cfg)
 	if err != nil {
 		logrus.Fatalf("Unable to setup dependencies : %s", err.Error())
 	}
 	logrus.Info("[DEPS]: OK")
 
+	if cfg.MigrateOnStartup {
+		applied, err := dal.NewMigrator(deps.DAL.DB, false).Run(context.Background())
+		if err != nil {
+			logrus.Fatalf("Unable to apply schema migrations : %s", err.Error())
+		}
+		logrus.Infof("[Migration]: %d applied OK", applied)
+	}
+	currencies, err := deps.DAL.CurrencyDAL.Load(context.Background())
+	if err != nil {
+		logrus.Fatalf("Unable to load the currency registry : %s", err.Error())
+	}
+	logrus.Infof("[Currency]: %d registered OK", currencies)
+
 	a := &api.API{
 		Config: cfg,
 		Deps:   deps,
 	}
 
+	if cfg.BackfillMetrics {
+		if err := a.BackfillMetrics(context.Background()); err != nil {
+			logrus.Fatalf("Unable to backfill metrics rollups : %s", err.Error())
+		}
+		logrus.Info("[Metrics]: backfill OK")
+	}
+
 	go func() {
 		log.Fatal(a.Serve())
 	}()
 
+	// background jobs
+	if err := a.RegisterJobs(context.Background()); err != nil {
+		logrus.Fatalf("Unable to register background jobs : %s", err.Error())
+	}
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	workers.Add(2)
+	go func() {
+		defer workers.Done()
+		deps.Scheduler.Run(workerCtx)
+	}()
+	go func() {
+		defer workers.Done()
+		deps.Verifier.Keys.Run(workerCtx)
+	}()
+
 	// graceful shutdown
-	stopChan := make(chan os.Signal)
+	stopChan := make(chan os.Signal, 1)
 	signal.Notify(stopChan, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
 	<-stopChan
 
+	logrus.Info("[API]: Stopping background workers ...")
+	stopWorkers()
+	workers.Wait()
+
 	logrus.Infof("[API]: Request to shutdown server. Doing nothing for %v", allowConnectionsAfterShutdown)
 	waitTimer := time.NewTimer(allowConnectionsAfterShutdown)
 	<-waitTimer.C
@@ -59,3 +115,57 @@ func main() {
 	logrus.Info("[API]: Shutting down server ...")
 	logrus.Fatal(a.Shutdown())
 }
+
+// migrate applies the pending schema migrations, or with -dry-run logs what they would change. -status lists every
+// migration and when it was applied instead
+func migrate(cfg *config.Config, args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "log what the pending migrations would change without changing anything")
+	status := flags.Bool("status", false, "list the migrations and when they were applied")
+	_ = flags.Parse(args)
+
+	migrator, err := dal.OpenMigrator(cfg, *dryRun)
+	if err != nil {
+		logrus.Fatalf("Unable to connect to the database : %s", err.Error())
+	}
+	ctx := context.Background()
+
+	if *status {
+		applied, err := migrator.Applied(ctx)
+		if err != nil {
+			logrus.Fatalf("Unable to fetch schema migrations : %s", err.Error())
+		}
+		for _, migration := range migrator.Migrations {
+			state := "pending"
+			if record, ok := applied[migration.Version]; ok {
+				state = "applied " + record.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-40s %s\n", migration.Version, migration.Name, state)
+		}
+		return
+	}
+
+	count, err := migrator.Run(ctx)
+	if err != nil {
+		logrus.Fatalf("Unable to apply schema migrations : %s", err.Error())
+	}
+	if *dryRun {
+		logrus.Infof("[Migration]: dry run of %d pending OK", count)
+	} else {
+		logrus.Infof("[Migration]: %d applied OK", count)
+	}
+}
+
+// serveRates runs a stand-in rate provider serving a rate fixture, for the fixture rate provider to fetch from with
+// RATE_FIXTURE set to its URL. Edit the fixture while it runs to move the rates
+func serveRates(args []string) {
+	flags := flag.NewFlagSet("serve-rates", flag.ExitOnError)
+	file := flags.String("file", "fixtures/rates.json", "rate fixture to serve")
+	addr := flags.String("addr", ":8090", "address to listen on")
+	_ = flags.Parse(args)
+
+	mux := http.NewServeMux()
+	mux.Handle("/rates", services.FixtureRateHandler(*file))
+	logrus.Infof("[Rates]: serving %s at http://localhost%s/rates", *file, *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
@@ -0,0 +1,54 @@
+package money
+
+import (
+	"strings"
+	"sync"
+)
+
+// defaultMinorUnits is the number of decimal places used when a currency has not been registered
+const defaultMinorUnits = 2
+
+var (
+	minorUnitsMu sync.RWMutex
+	minorUnits   = map[string]int{
+		"NGN": 2,
+		"USD": 2,
+		"BSD": 2,
+		"BTC": 8,
+	}
+	crypto = map[string]bool{
+		"BTC": true,
+	}
+)
+
+// MinorUnits returns the number of decimal places amounts in currency are kept to
+func MinorUnits(currency string) int {
+	minorUnitsMu.RLock()
+	defer minorUnitsMu.RUnlock()
+	if units, ok := minorUnits[strings.ToUpper(currency)]; ok {
+		return units
+	}
+	return defaultMinorUnits
+}
+
+// RegisterCurrency sets the number of decimal places amounts in currency are kept to, and whether it is a
+// cryptocurrency
+func RegisterCurrency(currency string, units int, isCrypto bool) {
+	if units < 0 {
+		units = 0
+	}
+	if units > Scale {
+		units = Scale
+	}
+	minorUnitsMu.Lock()
+	defer minorUnitsMu.Unlock()
+	minorUnits[strings.ToUpper(currency)] = units
+	crypto[strings.ToUpper(currency)] = isCrypto
+}
+
+// IsCrypto reports whether currency is a cryptocurrency
+func IsCrypto(currency string) bool {
+	minorUnitsMu.RLock()
+	defer minorUnitsMu.RUnlock()
+	return crypto[strings.ToUpper(currency)]
+}
@@ -0,0 +1,358 @@
+package money
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Scale is the number of decimal places an Amount is stored with. It covers the smallest minor unit of every
+// supported currency (satoshis for BTC)
+const Scale = 8
+
+const unit = 100000000 // 10^Scale
+
+// Amount is an exact decimal amount of money held as an integer number of 10^-Scale units. It is stored in mongo as
+// Decimal128 and written to JSON as a plain decimal number so that no amount ever passes through a float
+type Amount int64
+
+// Zero is the zero Amount
+const Zero Amount = 0
+
+// ErrOverflow is returned by arithmetic whose result does not fit in an Amount
+var ErrOverflow = errors.New("amount is out of range")
+
+var pow10 = [...]int64{1, 10, 100, 1000, 10000, 100000, 1000000, 10000000, 100000000}
+
+// New returns the Amount of whole units of a currency e.g. New(5) is 5 NGN
+func New(whole int64) Amount {
+	return Amount(whole * unit)
+}
+
+// FromMinor returns the Amount of minor units of a currency e.g. FromMinor(150, "NGN") is 1.50 NGN
+func FromMinor(minor int64, currency string) Amount {
+	return Amount(minor * pow10[Scale-MinorUnits(currency)])
+}
+
+// Parse parses a decimal string such as "1050.25" into an Amount
+func Parse(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Zero, errors.New("empty amount")
+	}
+
+	negative := false
+	switch s[0] {
+	case '-':
+		negative = true
+		s = s[1:]
+	case '+':
+		s = s[1:]
+	}
+
+	whole, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, fraction = s[:i], s[i+1:]
+	}
+	if whole == "" && fraction == "" {
+		return Zero, fmt.Errorf("invalid amount %q", s)
+	}
+	if len(fraction) > Scale {
+		return Zero, fmt.Errorf("amount %q has more than %d decimal places", s, Scale)
+	}
+
+	var w, f int64
+	var err error
+	if whole != "" {
+		if w, err = strconv.ParseInt(whole, 10, 64); err != nil || w < 0 {
+			return Zero, fmt.Errorf("invalid amount %q", s)
+		}
+	}
+	if fraction != "" {
+		if f, err = strconv.ParseInt(fraction, 10, 64); err != nil || f < 0 {
+			return Zero, fmt.Errorf("invalid amount %q", s)
+		}
+		f *= pow10[Scale-len(fraction)]
+	}
+	if w > (math.MaxInt64-f)/unit {
+		return Zero, fmt.Errorf("amount %q is out of range", s)
+	}
+
+	units := w*unit + f
+	if negative {
+		units = -units
+	}
+	return Amount(units), nil
+}
+
+// MustParse is like Parse but panics on invalid input. It is meant for constants
+func MustParse(s string) Amount {
+	a, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// String returns the amount as a decimal string without trailing zeros
+func (a Amount) String() string {
+	units := int64(a)
+	sign := ""
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+	whole, fraction := units/unit, units%unit
+	if fraction == 0 {
+		return fmt.Sprintf("%s%d", sign, whole)
+	}
+	return strings.TrimRight(fmt.Sprintf("%s%d.%08d", sign, whole, fraction), "0")
+}
+
+// StringFixed returns the amount with exactly the number of decimal places of currency
+func (a Amount) StringFixed(currency string) string {
+	units := int64(a.Round(currency))
+	sign := ""
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+	places := MinorUnits(currency)
+	if places == 0 {
+		return fmt.Sprintf("%s%d", sign, units/unit)
+	}
+	fraction := (units % unit) / pow10[Scale-places]
+	return fmt.Sprintf("%s%d.%0*d", sign, units/unit, places, fraction)
+}
+
+// Minor returns the amount as an integer number of the minor units of currency, rounding if necessary
+func (a Amount) Minor(currency string) int64 {
+	return int64(a.Round(currency)) / pow10[Scale-MinorUnits(currency)]
+}
+
+// Round rounds the amount half away from zero to the minor units of currency
+func (a Amount) Round(currency string) Amount {
+	return a.roundTo(MinorUnits(currency))
+}
+
+func (a Amount) roundTo(places int) Amount {
+	step := pow10[Scale-places]
+	if step == 1 {
+		return a
+	}
+	units := int64(a)
+	remainder := units % step
+	units -= remainder
+	if remainder >= step/2 {
+		units += step
+	} else if remainder <= -step/2 {
+		units -= step
+	}
+	return Amount(units)
+}
+
+// Valid reports whether the amount can be represented exactly in the minor units of currency
+func (a Amount) Valid(currency string) bool {
+	return a.Round(currency) == a
+}
+
+// IsZero reports whether the amount is zero
+func (a Amount) IsZero() bool {
+	return a == Zero
+}
+
+// Neg returns the amount with its sign flipped
+func (a Amount) Neg() Amount {
+	return -a
+}
+
+// Abs returns the absolute value of the amount
+func (a Amount) Abs() Amount {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+// Add returns the sum of a and b, or ErrOverflow when it does not fit in an Amount
+func (a Amount) Add(b Amount) (Amount, error) {
+	sum := a + b
+	if (b > 0 && sum < a) || (b < 0 && sum > a) {
+		return Zero, ErrOverflow
+	}
+	return sum, nil
+}
+
+// Sub returns a less b, or ErrOverflow when it does not fit in an Amount
+func (a Amount) Sub(b Amount) (Amount, error) {
+	if b == math.MinInt64 {
+		return Zero, ErrOverflow
+	}
+	return a.Add(-b)
+}
+
+// AddTo adds a to the total of currency in totals, leaving totals unchanged when the sum overflows
+func AddTo(totals map[string]Amount, currency string, a Amount) error {
+	sum, err := totals[currency].Add(a)
+	if err != nil {
+		return errors.Wrapf(err, "unable to total %s", currency)
+	}
+	totals[currency] = sum
+	return nil
+}
+
+// Percent returns p percent of the amount rounded to the minor units of currency
+func (a Amount) Percent(p Rate, currency string) (Amount, error) {
+	r := new(big.Rat).Mul(a.rat(), p.rat())
+	r.Quo(r, big.NewRat(100, 1))
+	return fromRat(roundRat(r, MinorUnits(currency)))
+}
+
+func (a Amount) rat() *big.Rat {
+	return big.NewRat(int64(a), unit)
+}
+
+// Float64 returns an approximation of the amount. It must only be used for display and metrics, never for arithmetic
+func (a Amount) Float64() float64 {
+	return float64(a) / unit
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON accepts both JSON numbers and strings so clients can send amounts as "1050.25" or 1050.25
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		*a = Zero
+		return nil
+	}
+	s = strings.Trim(s, `"`)
+	if s == "" {
+		*a = Zero
+		return nil
+	}
+	if strings.ContainsAny(s, "eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		s = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = v
+	return nil
+}
+
+func (a Amount) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	d, err := primitive.ParseDecimal128(a.String())
+	if err != nil {
+		return 0, nil, err
+	}
+	return bsontype.Decimal128, bsoncore.AppendDecimal128(nil, d), nil
+}
+
+// UnmarshalBSONValue reads Decimal128 amounts as well as the float and integer amounts of documents written before
+// amounts were stored as decimals
+func (a *Amount) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	v := bsoncore.Value{Type: t, Data: data}
+	switch t {
+	case bsontype.Null, bsontype.Undefined:
+		*a = Zero
+		return nil
+	case bsontype.Decimal128:
+		r, err := ratFromDecimal128(v.Decimal128())
+		if err != nil {
+			return err
+		}
+		parsed, err := fromRat(r)
+		if err != nil {
+			return err
+		}
+		*a = parsed
+		return nil
+	case bsontype.Double:
+		return a.setLegacyFloat(v.Double())
+	case bsontype.Int32:
+		*a = New(int64(v.Int32()))
+		return nil
+	case bsontype.Int64:
+		*a = New(v.Int64())
+		return nil
+	case bsontype.String:
+		parsed, err := Parse(v.StringValue())
+		if err != nil {
+			return err
+		}
+		*a = parsed
+		return nil
+	default:
+		return fmt.Errorf("cannot decode %v into an amount", t)
+	}
+}
+
+// setLegacyFloat converts a float amount written by the float32 models. Formatting with 32 bit precision recovers the
+// decimal the client originally sent instead of the binary approximation stored in the document
+func (a *Amount) setLegacyFloat(f float64) error {
+	s := strconv.FormatFloat(f, 'f', -1, 32)
+	if i := strings.IndexByte(s, '.'); i >= 0 && len(s)-i-1 > Scale {
+		s = s[:i+1+Scale]
+	}
+	parsed, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// fromRat converts r to an Amount, rounding half away from zero to Scale decimal places. It returns ErrOverflow when
+// r does not fit in an Amount
+func fromRat(r *big.Rat) (Amount, error) {
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt64(unit))
+	num, den := scaled.Num(), scaled.Denom()
+	q, m := new(big.Int).QuoRem(num, den, new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(m), big.NewInt(2)).Cmp(den) >= 0 {
+		if num.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	if !q.IsInt64() {
+		return Zero, ErrOverflow
+	}
+	return Amount(q.Int64()), nil
+}
+
+func ratFromDecimal128(d primitive.Decimal128) (*big.Rat, error) {
+	coefficient, exponent, err := d.BigInt()
+	if err != nil {
+		return nil, err
+	}
+	r := new(big.Rat).SetInt(coefficient)
+	p := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(exponent))), nil))
+	if exponent >= 0 {
+		r.Mul(r, p)
+	} else {
+		r.Quo(r, p)
+	}
+	return r, nil
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
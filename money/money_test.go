@@ -0,0 +1,125 @@
+package money
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Amount
+		wantErr bool
+	}{
+		{in: "1050.25", want: 105025000000},
+		{in: " 12 ", want: 1200000000},
+		{in: "0.00000001", want: 1},
+		{in: "-3.5", want: -350000000},
+		{in: "+2", want: 200000000},
+		{in: ".5", want: 50000000},
+		{in: "5.", want: 500000000},
+		{in: "92233720368.54775807", want: math.MaxInt64},
+		{in: "92233720368.54775808", wantErr: true},
+		{in: "1.123456789", wantErr: true},
+		{in: "", wantErr: true},
+		{in: ".", wantErr: true},
+		{in: "abc", wantErr: true},
+		{in: "1.-5", wantErr: true},
+		{in: "--1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		in       string
+		currency string
+		want     string
+	}{
+		{in: "1.005", currency: "NGN", want: "1.01"},
+		{in: "1.004", currency: "NGN", want: "1"},
+		{in: "-1.005", currency: "NGN", want: "-1.01"},
+		{in: "-1.004", currency: "NGN", want: "-1"},
+		{in: "2.5", currency: "NGN", want: "2.5"},
+		{in: "1.00000001", currency: "BTC", want: "1.00000001"},
+		{in: "0.125", currency: "XYZ", want: "0.13"},
+	}
+	for _, tt := range tests {
+		if got := MustParse(tt.in).Round(tt.currency); got != MustParse(tt.want) {
+			t.Errorf("%s.Round(%s) = %s, want %s", tt.in, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestPercent(t *testing.T) {
+	tests := []struct {
+		amount   string
+		percent  string
+		currency string
+		want     string
+		wantErr  error
+	}{
+		{amount: "1000", percent: "1.5", currency: "NGN", want: "15"},
+		{amount: "333.33", percent: "1", currency: "NGN", want: "3.33"},
+		{amount: "1", percent: "0.5", currency: "NGN", want: "0.01"},
+		{amount: "1", percent: "0.4", currency: "NGN", want: "0"},
+		{amount: "0.5", percent: "0.1", currency: "BTC", want: "0.0005"},
+		{amount: "1000", percent: "0", currency: "NGN", want: "0"},
+		{amount: "92233720368", percent: "200", currency: "NGN", wantErr: ErrOverflow},
+	}
+	for _, tt := range tests {
+		got, err := MustParse(tt.amount).Percent(MustParseRate(tt.percent), tt.currency)
+		if err != tt.wantErr {
+			t.Errorf("%s.Percent(%s) error = %v, want %v", tt.amount, tt.percent, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != MustParse(tt.want) {
+			t.Errorf("%s.Percent(%s) = %s, want %s", tt.amount, tt.percent, got, tt.want)
+		}
+	}
+}
+
+func TestAdd(t *testing.T) {
+	tests := []struct {
+		a, b    Amount
+		want    Amount
+		wantErr error
+	}{
+		{a: New(1), b: New(2), want: New(3)},
+		{a: New(1), b: New(-2), want: New(-1)},
+		{a: math.MaxInt64, b: 1, wantErr: ErrOverflow},
+		{a: math.MinInt64, b: -1, wantErr: ErrOverflow},
+		{a: math.MaxInt64, b: math.MinInt64, want: -1},
+	}
+	for _, tt := range tests {
+		got, err := tt.a.Add(tt.b)
+		if err != tt.wantErr || got != tt.want {
+			t.Errorf("%d.Add(%d) = %d, %v, want %d, %v", tt.a, tt.b, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestAddTo(t *testing.T) {
+	totals := map[string]Amount{"NGN": New(5)}
+	if err := AddTo(totals, "NGN", New(2)); err != nil || totals["NGN"] != New(7) {
+		t.Fatalf("AddTo = %v, total %s, want total 7", err, totals["NGN"])
+	}
+	if err := AddTo(totals, "USD", New(1)); err != nil || totals["USD"] != New(1) {
+		t.Fatalf("AddTo = %v, total %s, want total 1", err, totals["USD"])
+	}
+	if err := AddTo(totals, "NGN", math.MaxInt64); err == nil {
+		t.Fatal("AddTo did not fail on overflow")
+	}
+	if totals["NGN"] != New(7) {
+		t.Errorf("AddTo changed the total to %s on overflow", totals["NGN"])
+	}
+}
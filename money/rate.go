@@ -0,0 +1,195 @@
+package money
+
+import (
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// maxRatePlaces is the number of decimal places a rate is kept to. Rates between currencies of very different value
+// (NGN to BTC) need far more precision than an Amount has
+const maxRatePlaces = 18
+
+// Rate is an exact decimal multiplier such as an exchange rate or a percentage. The zero value is a rate of 0
+type Rate struct {
+	value *big.Rat
+}
+
+// ParseRate parses a decimal string such as "415.25" into a Rate
+func ParseRate(s string) (Rate, error) {
+	s = strings.TrimSpace(s)
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return Rate{}, fmt.Errorf("invalid rate %q", s)
+	}
+	return Rate{value: roundRat(r, maxRatePlaces)}, nil
+}
+
+// MustParseRate is like ParseRate but panics on invalid input. It is meant for constants
+func MustParseRate(s string) Rate {
+	r, err := ParseRate(s)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// RateOf returns the rate that converts from into to e.g. RateOf(New(1), MustParse("415")) is 415
+func RateOf(from, to Amount) (Rate, error) {
+	if from.IsZero() {
+		return Rate{}, fmt.Errorf("cannot compute a rate from a zero amount")
+	}
+	r := new(big.Rat).Quo(to.rat(), from.rat())
+	return Rate{value: roundRat(r, maxRatePlaces)}, nil
+}
+
+func (r Rate) rat() *big.Rat {
+	if r.value == nil {
+		return new(big.Rat)
+	}
+	return r.value
+}
+
+// IsZero reports whether the rate is zero
+func (r Rate) IsZero() bool {
+	return r.rat().Sign() == 0
+}
+
+// Cmp compares r and o and returns -1, 0 or +1
+func (r Rate) Cmp(o Rate) int {
+	return r.rat().Cmp(o.rat())
+}
+
+// Convert multiplies a by the rate and rounds the result to the minor units of currency. It returns ErrOverflow when
+// the result does not fit in an Amount
+func (r Rate) Convert(a Amount, currency string) (Amount, error) {
+	return fromRat(roundRat(new(big.Rat).Mul(a.rat(), r.rat()), MinorUnits(currency)))
+}
+
+// Add returns the sum of two rates e.g. two percentages charged together
+func (r Rate) Add(o Rate) Rate {
+	return Rate{value: new(big.Rat).Add(r.rat(), o.rat())}
+}
+
+// Sub returns r less o
+func (r Rate) Sub(o Rate) Rate {
+	return Rate{value: new(big.Rat).Sub(r.rat(), o.rat())}
+}
+
+// Mul returns the product of two rates e.g. a rate with a spread applied
+func (r Rate) Mul(o Rate) Rate {
+	return Rate{value: roundRat(new(big.Rat).Mul(r.rat(), o.rat()), maxRatePlaces)}
+}
+
+// Inverse returns 1/r
+func (r Rate) Inverse() (Rate, error) {
+	if r.IsZero() {
+		return Rate{}, fmt.Errorf("cannot invert a zero rate")
+	}
+	return Rate{value: roundRat(new(big.Rat).Inv(r.rat()), maxRatePlaces)}, nil
+}
+
+// ChangePercent returns how far o has moved from r as an absolute percentage
+func (r Rate) ChangePercent(o Rate) Rate {
+	if r.IsZero() {
+		return Rate{}
+	}
+	change := new(big.Rat).Sub(o.rat(), r.rat())
+	change.Abs(change)
+	change.Quo(change, r.rat())
+	change.Mul(change, big.NewRat(100, 1))
+	return Rate{value: roundRat(change, maxRatePlaces)}
+}
+
+// String returns the rate as a decimal string without trailing zeros
+func (r Rate) String() string {
+	s := r.rat().FloatString(maxRatePlaces)
+	if strings.IndexByte(s, '.') >= 0 {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	if s == "null" || s == "" {
+		*r = Rate{}
+		return nil
+	}
+	parsed, err := ParseRate(s)
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+func (r Rate) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	d, err := primitive.ParseDecimal128(r.String())
+	if err != nil {
+		return 0, nil, err
+	}
+	return bsontype.Decimal128, bsoncore.AppendDecimal128(nil, d), nil
+}
+
+func (r *Rate) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	v := bsoncore.Value{Type: t, Data: data}
+	switch t {
+	case bsontype.Null, bsontype.Undefined:
+		*r = Rate{}
+		return nil
+	case bsontype.Decimal128:
+		parsed, err := ratFromDecimal128(v.Decimal128())
+		if err != nil {
+			return err
+		}
+		*r = Rate{value: parsed}
+		return nil
+	case bsontype.Double:
+		parsed, err := ParseRate(strconv.FormatFloat(v.Double(), 'f', -1, 32))
+		if err != nil {
+			return err
+		}
+		*r = parsed
+		return nil
+	case bsontype.Int32:
+		*r = Rate{value: new(big.Rat).SetInt64(int64(v.Int32()))}
+		return nil
+	case bsontype.Int64:
+		*r = Rate{value: new(big.Rat).SetInt64(v.Int64())}
+		return nil
+	case bsontype.String:
+		parsed, err := ParseRate(v.StringValue())
+		if err != nil {
+			return err
+		}
+		*r = parsed
+		return nil
+	default:
+		return fmt.Errorf("cannot decode %v into a rate", t)
+	}
+}
+
+// roundRat rounds r half away from zero to the given number of decimal places
+func roundRat(r *big.Rat, places int) *big.Rat {
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(places)), nil)
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(scale))
+	num, den := scaled.Num(), scaled.Denom()
+	q, m := new(big.Int).QuoRem(num, den, new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(m), big.NewInt(2)).Cmp(den) >= 0 {
+		if num.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return new(big.Rat).SetFrac(q, scale)
+}
@@ -0,0 +1,31 @@
+package money
+
+import "testing"
+
+func TestRateConvert(t *testing.T) {
+	tests := []struct {
+		rate     string
+		amount   string
+		currency string
+		want     string
+		wantErr  error
+	}{
+		{rate: "415.25", amount: "10", currency: "NGN", want: "4152.5"},
+		{rate: "0.0000025", amount: "1000", currency: "BTC", want: "0.0025"},
+		{rate: "0.333333333333333333", amount: "1", currency: "USD", want: "0.33"},
+		{rate: "0.335", amount: "2", currency: "USD", want: "0.67"},
+		{rate: "0.0025", amount: "1", currency: "USD", want: "0"},
+		{rate: "0", amount: "100", currency: "USD", want: "0"},
+		{rate: "1000000000", amount: "1000", currency: "USD", wantErr: ErrOverflow},
+	}
+	for _, tt := range tests {
+		got, err := MustParseRate(tt.rate).Convert(MustParse(tt.amount), tt.currency)
+		if err != tt.wantErr {
+			t.Errorf("%s.Convert(%s) error = %v, want %v", tt.rate, tt.amount, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != MustParse(tt.want) {
+			t.Errorf("%s.Convert(%s) = %s, want %s", tt.rate, tt.amount, got, tt.want)
+		}
+	}
+}
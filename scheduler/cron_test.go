@@ -0,0 +1,88 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{spec: "@every 30s"},
+		{spec: " @every 1h30m "},
+		{spec: "@hourly"},
+		{spec: "@daily"},
+		{spec: "@weekly"},
+		{spec: "@monthly"},
+		{spec: "* * * * *"},
+		{spec: "*/15 * * * *"},
+		{spec: "0 2 * * 1-5"},
+		{spec: "0,30 8-18/2 1,15 1-12 0"},
+		{spec: "5/10 * * * *"},
+		{spec: "@every 500ms", wantErr: true},
+		{spec: "@every soon", wantErr: true},
+		{spec: "@yearly", wantErr: true},
+		{spec: "", wantErr: true},
+		{spec: "* * * *", wantErr: true},
+		{spec: "* * * * * *", wantErr: true},
+		{spec: "60 * * * *", wantErr: true},
+		{spec: "* 24 * * *", wantErr: true},
+		{spec: "* * 0 * *", wantErr: true},
+		{spec: "* * * 13 *", wantErr: true},
+		{spec: "* * * * 7", wantErr: true},
+		{spec: "5-1 * * * *", wantErr: true},
+		{spec: "*/0 * * * *", wantErr: true},
+		{spec: "a * * * *", wantErr: true},
+		{spec: "1-b * * * *", wantErr: true},
+	}
+	for _, tt := range tests {
+		if _, err := Parse(tt.spec); (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	at := func(value string) time.Time {
+		parsed, err := time.Parse("2006-01-02 15:04:05", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		spec  string
+		after string
+		want  string
+	}{
+		{spec: "@every 90s", after: "2021-03-10 10:00:20", want: "2021-03-10 10:01:50"},
+		{spec: "* * * * *", after: "2021-03-10 10:00:20", want: "2021-03-10 10:01:00"},
+		{spec: "*/15 * * * *", after: "2021-03-10 10:00:00", want: "2021-03-10 10:15:00"},
+		{spec: "*/15 * * * *", after: "2021-03-10 10:59:59", want: "2021-03-10 11:00:00"},
+		{spec: "5/20 * * * *", after: "2021-03-10 10:26:00", want: "2021-03-10 10:45:00"},
+		{spec: "@hourly", after: "2021-03-10 23:30:00", want: "2021-03-11 00:00:00"},
+		{spec: "@daily", after: "2021-12-31 12:00:00", want: "2022-01-01 00:00:00"},
+		{spec: "@monthly", after: "2021-01-31 00:00:00", want: "2021-02-01 00:00:00"},
+		// the 10th of March 2021 is a Wednesday
+		{spec: "@weekly", after: "2021-03-10 00:00:00", want: "2021-03-14 00:00:00"},
+		{spec: "0 2 * * 1-5", after: "2021-03-12 03:00:00", want: "2021-03-15 02:00:00"},
+		{spec: "30 9 31 * *", after: "2021-04-01 00:00:00", want: "2021-05-31 09:30:00"},
+		{spec: "0 0 29 2 *", after: "2021-03-01 00:00:00", want: "2024-02-29 00:00:00"},
+		// with both day fields restricted either one matches
+		{spec: "0 0 13 * 5", after: "2021-03-10 00:00:00", want: "2021-03-12 00:00:00"},
+		{spec: "0 0 11 * 5", after: "2021-03-10 00:00:00", want: "2021-03-11 00:00:00"},
+	}
+	for _, tt := range tests {
+		schedule, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q) error = %v", tt.spec, err)
+			continue
+		}
+		if got := schedule.Next(at(tt.after)); !got.Equal(at(tt.want)) {
+			t.Errorf("Parse(%q).Next(%s) = %s, want %s", tt.spec, tt.after, got, tt.want)
+		}
+	}
+}
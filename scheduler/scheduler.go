@@ -0,0 +1,248 @@
+// Package scheduler runs periodic and deferred background work. Jobs are persisted in mongo so they survive restarts,
+// and a replica has to lease a job before running it so every run happens on exactly one replica
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"github.com/isongjosiah/work/onepurse-api/dal"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/lucsky/cuid"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPollInterval = 10 * time.Second
+	defaultLease        = 5 * time.Minute
+	defaultMaxAttempts  = 3
+	retryBackoff        = time.Minute
+)
+
+// Func is the work of a job. The payload of one-off jobs is available on job
+type Func func(ctx context.Context, job *model.Job) error
+
+type Scheduler struct {
+	jobs         dal.IJobDAL
+	owner        string
+	pollInterval time.Duration
+	lease        time.Duration
+
+	mu       sync.RWMutex
+	handlers map[string]Func
+	running  sync.WaitGroup
+}
+
+// New returns a scheduler storing its jobs through jobs. Handlers and cron jobs must be registered before Run
+func New(jobs dal.IJobDAL, pollInterval, lease time.Duration) *Scheduler {
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+	if lease <= 0 {
+		lease = defaultLease
+	}
+	host, _ := os.Hostname()
+	return &Scheduler{
+		jobs:         jobs,
+		owner:        fmt.Sprintf("%s-%s", host, cuid.New()),
+		pollInterval: pollInterval,
+		lease:        lease,
+		handlers:     make(map[string]Func),
+	}
+}
+
+// Handle registers the function run for jobs with the given handler name
+func (s *Scheduler) Handle(name string, fn Func) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[name] = fn
+}
+
+// Cron registers fn under name and schedules it to run on spec. Registering an existing cron job updates its schedule
+// without resetting its run state
+func (s *Scheduler) Cron(ctx context.Context, name, spec string, fn Func) error {
+	schedule, err := Parse(spec)
+	if err != nil {
+		return err
+	}
+	s.Handle(name, fn)
+
+	now := time.Now()
+	return s.jobs.Register(ctx, &model.Job{
+		ID:        name,
+		Handler:   name,
+		Kind:      model.JobKindCron,
+		Schedule:  spec,
+		Status:    model.JobStatusScheduled,
+		NextRunAt: schedule.Next(now),
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+}
+
+// Once schedules the function registered as handler to run a single time at runAt with payload
+func (s *Scheduler) Once(ctx context.Context, handler string, runAt time.Time, payload map[string]interface{}) (*model.Job, error) {
+	now := time.Now()
+	job := &model.Job{
+		ID:          cuid.New(),
+		Handler:     handler,
+		Kind:        model.JobKindOnce,
+		Payload:     payload,
+		Status:      model.JobStatusScheduled,
+		NextRunAt:   runAt,
+		MaxAttempts: defaultMaxAttempts,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := s.jobs.Create(ctx, job); err != nil {
+		return nil, errors.Wrapf(err, "unable to schedule %s", handler)
+	}
+	return job, nil
+}
+
+// Run polls for due jobs until ctx is cancelled, then waits for the jobs it started to finish
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	logrus.Infof("[Scheduler]: started as %s", s.owner)
+	for {
+		s.runDue(ctx)
+		select {
+		case <-ctx.Done():
+			s.running.Wait()
+			logrus.Info("[Scheduler]: stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) runDue(ctx context.Context) {
+	now := time.Now()
+	due, err := s.jobs.FetchDue(ctx, now)
+	if err != nil {
+		logrus.Errorf("[Scheduler]: unable to fetch due jobs: %s", err.Error())
+		return
+	}
+
+	for _, candidate := range *due {
+		fn := s.handler(candidate.Handler)
+		if fn == nil {
+			// another replica may be running a version that knows this handler
+			continue
+		}
+		job, err := s.jobs.Acquire(ctx, candidate.ID, s.owner, now, now.Add(s.lease))
+		if err != nil || job == nil {
+			continue
+		}
+
+		s.running.Add(1)
+		go func() {
+			defer s.running.Done()
+			s.run(job, fn)
+		}()
+	}
+}
+
+func (s *Scheduler) handler(name string) Func {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.handlers[name]
+}
+
+// run executes a leased job and records its outcome. Jobs run with their own context so a shutdown waits for them
+// instead of leaving them half done. The lease is renewed while the job runs and the context is cancelled if it is
+// lost, since another replica may then start the job again
+func (s *Scheduler) run(job *model.Job, fn Func) {
+	runCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	defer close(done)
+	go s.renew(job.ID, cancel, done)
+
+	started := time.Now()
+	err := safeRun(runCtx, job, fn)
+	finished := time.Now()
+
+	lastError := ""
+	if err != nil {
+		logrus.Errorf("[Scheduler]: job %s failed: %s", job.ID, err.Error())
+		lastError = err.Error()
+	}
+
+	set := bson.D{{"last_run_at", started}, {"updated_at", finished}}
+	switch job.Kind {
+	case model.JobKindCron:
+		schedule, parseErr := Parse(job.Schedule)
+		if parseErr != nil {
+			lastError = parseErr.Error()
+			set = append(set, bson.E{Key: "status", Value: model.JobStatusFailed})
+			break
+		}
+		set = append(set, bson.E{Key: "status", Value: model.JobStatusScheduled}, bson.E{Key: "next_run_at", Value: schedule.Next(finished)})
+	default:
+		switch {
+		case err == nil:
+			set = append(set, bson.E{Key: "status", Value: model.JobStatusCompleted})
+		case job.Attempts+1 >= job.MaxAttempts:
+			set = append(set, bson.E{Key: "status", Value: model.JobStatusFailed}, bson.E{Key: "attempts", Value: job.Attempts + 1})
+		default:
+			backoff := time.Duration(job.Attempts+1) * retryBackoff
+			set = append(set,
+				bson.E{Key: "status", Value: model.JobStatusScheduled},
+				bson.E{Key: "attempts", Value: job.Attempts + 1},
+				bson.E{Key: "next_run_at", Value: finished.Add(backoff)},
+			)
+		}
+	}
+	set = append(set, bson.E{Key: "last_error", Value: lastError})
+
+	update := bson.D{{"$set", set}, {"$inc", bson.D{{"runs", 1}}}}
+	if err := s.jobs.Release(context.Background(), job.ID, s.owner, update); err != nil {
+		logrus.Errorf("[Scheduler]: unable to record run of job %s: %s", job.ID, err.Error())
+	}
+}
+
+// renew extends the lease of a running job every third of the lease until done is closed. It calls cancel when the
+// lease could not be renewed before it expired
+func (s *Scheduler) renew(jobID string, cancel context.CancelFunc, done <-chan struct{}) {
+	ticker := time.NewTicker(s.lease / 3)
+	defer ticker.Stop()
+
+	expires := time.Now().Add(s.lease)
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		err := s.jobs.Renew(context.Background(), jobID, s.owner, now, now.Add(s.lease))
+		switch {
+		case err == nil:
+			expires = now.Add(s.lease)
+		case err == dal.ErrJobLeaseLost || !now.Before(expires):
+			logrus.Errorf("[Scheduler]: lost the lease of job %s, cancelling it", jobID)
+			cancel()
+			return
+		default:
+			// the lease has not expired yet, try again on the next tick
+			logrus.Errorf("[Scheduler]: unable to renew the lease of job %s: %s", jobID, err.Error())
+		}
+	}
+}
+
+// safeRun keeps a panicking job from taking the whole API down
+func safeRun(ctx context.Context, job *model.Job, fn Func) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return fn(ctx, job)
+}
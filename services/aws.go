@@ -2,25 +2,33 @@ package services
 
 import (
 	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
 	"github.com/isongjosiah/work/onepurse-api/config"
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"mime/multipart"
 )
 
+// ErrAWSUnavailable is returned by the stand-ins used when AWS is not configured
+var ErrAWSUnavailable = errors.New("AWS is not configured, set AWS_REGION to use it")
+
 type AWS struct {
-	Cognito ICognitoService
-	S3      IS3Service
-	SNS     ISNSService
+	S3  IS3Service
+	SNS ISNSService
 }
 
+// NewAWS sets up the AWS services. Without AWS_REGION, which only the local identity provider allows, uploads fail and
+// push notifications are written to the log so the API still runs offline
 func NewAWS(cfg *config.Config) (*AWS, error) {
-	s3, err := NewS3Service(cfg)
-	if err != nil {
-		return nil, errors.Wrapf(err, fmt.Sprintf("%v S3", "Failed to setup service:"))
+	if cfg.AWSRegion == "" {
+		logrus.Warn("[AWS]: AWS_REGION is not set, uploads are unavailable and push notifications will be logged")
+		return &AWS{S3: UnavailableS3Service{}, SNS: LogSNSService{}}, nil
 	}
 
-	cognito, err := NewCognitoService(cfg)
+	s3, err := NewS3Service(cfg)
 	if err != nil {
-		return nil, errors.Wrapf(err, fmt.Sprintf("%v Cognito", "Failed to setup service:"))
+		return nil, errors.Wrapf(err, fmt.Sprintf("%v S3", "Failed to setup service:"))
 	}
 
 	sns, err := NewSNSService(cfg)
@@ -29,10 +37,28 @@ func NewAWS(cfg *config.Config) (*AWS, error) {
 	}
 
 	aws := &AWS{
-		Cognito: cognito,
-		S3:      s3,
-		SNS:     sns,
+		S3:  s3,
+		SNS: sns,
 	}
 
 	return aws, nil
 }
+
+// UnavailableS3Service refuses uploads when AWS is not configured
+type UnavailableS3Service struct{}
+
+func (UnavailableS3Service) Upload(filename string, file multipart.File) (string, error) {
+	return "", ErrAWSUnavailable
+}
+
+// LogSNSService writes push notifications to the log instead of sending them
+type LogSNSService struct{}
+
+func (LogSNSService) CreatePlatformEndpoint(token string) (*sns.CreatePlatformEndpointOutput, error) {
+	return &sns.CreatePlatformEndpointOutput{EndpointArn: aws.String("local:" + token)}, nil
+}
+
+func (LogSNSService) SendPushNotification(endpoint, message, subject string) (*sns.PublishOutput, error) {
+	logrus.Infof("[SNS]: to %s, subject %q: %s", endpoint, subject, message)
+	return &sns.PublishOutput{}, nil
+}
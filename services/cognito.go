@@ -18,26 +18,13 @@ import (
 	"time"
 )
 
-type ICognitoService interface {
-	Login(l *model.LoginRequest) (*model.AuthResponse, error)
-	RefreshAccessToken(rt *model.RefreshTokenRequest) (*model.AuthResponse, error)
-	InvitedUserChangePassword(l *model.NewPasswordChallengeInput) (*model.AuthResponse, error)
-	SignUp(r *model.RegistrationRequest) (*model.SignupResponse, error)
-	ConfirmSignUp(v *model.VerificationRequest) (bool, error)
-	ResendCode(email string) (*cognito.ResendConfirmationCodeOutput, error)
-	ForgetPassword(email string) (*cognito.ForgotPasswordOutput, error)
-	ConfirmForgotPassword(p *model.ConfirmForgotPasswordRequest) (bool, error)
-	ChangePassword(p *model.ChangePassword) (bool, error)
-	UpdateUsername(ua *model.UpdateUsername) error
-	CreateUser(r *model.CreateUserRequest) (*model.CreateUserResponse, error)
-}
-
 type CognitoService struct {
 	config        *config.Config
 	cognitoClient *cognito.Client
 }
 
-func NewCognitoService(cfg *config.Config) (ICognitoService, error) {
+// NewCognitoService returns the identity provider backed by the configured cognito user pool
+func NewCognitoService(cfg *config.Config) (IIdentityProvider, error) {
 	conf, err := awsConfig.LoadDefaultConfig(context.TODO(), awsConfig.WithRegion(cfg.AWSRegion))
 	if err != nil {
 		logrus.Fatalf("[COGNITO]: unable to load SDK config")
@@ -249,31 +236,25 @@ func (c CognitoService) ConfirmSignUp(v *model.VerificationRequest) (bool, error
 	return true, nil
 }
 
-func (c CognitoService) ResendCode(email string) (*cognito.ResendConfirmationCodeOutput, error) {
+func (c CognitoService) ResendCode(email string) error {
 	params := &cognito.ResendConfirmationCodeInput{
 		ClientId:   aws.String(c.config.CognitoAppClientID),
 		Username:   aws.String(email),
 		SecretHash: aws.String(c.generateCognitoSecretHash(email)),
 	}
 
-	resp, err := c.cognitoClient.ResendConfirmationCode(context.TODO(), params)
-	if err != nil {
-		return nil, err
-	}
-	return resp, nil
+	_, err := c.cognitoClient.ResendConfirmationCode(context.TODO(), params)
+	return err
 }
 
-func (c CognitoService) ForgetPassword(email string) (*cognito.ForgotPasswordOutput, error) {
+func (c CognitoService) ForgetPassword(email string) error {
 	params := &cognito.ForgotPasswordInput{
 		ClientId:   aws.String(c.config.CognitoAppClientID),
 		Username:   aws.String(email),
 		SecretHash: aws.String(c.generateCognitoSecretHash(email)),
 	}
-	resp, err := c.cognitoClient.ForgotPassword(context.TODO(), params)
-	if err != nil {
-		return nil, err
-	}
-	return resp, nil
+	_, err := c.cognitoClient.ForgotPassword(context.TODO(), params)
+	return err
 }
 
 func (c CognitoService) ConfirmForgotPassword(p *model.ConfirmForgotPasswordRequest) (bool, error) {
@@ -0,0 +1,58 @@
+package services
+
+import (
+	"fmt"
+	"github.com/isongjosiah/work/onepurse-api/config"
+	"github.com/sirupsen/logrus"
+	"net/smtp"
+	"strings"
+)
+
+type IEmailService interface {
+	SendEmail(to, subject, body string) error
+}
+
+// NewEmailService sends email through the configured SMTP server. Without one, emails are written to the log so
+// flows that send email still work in development
+func NewEmailService(cfg *config.Config) IEmailService {
+	if cfg.SMTPHost == "" {
+		logrus.Warn("[Email]: SMTP_HOST is not set, emails will be logged instead of sent")
+		return LogEmailService{}
+	}
+	return SMTPEmailService{config: cfg}
+}
+
+type SMTPEmailService struct {
+	config *config.Config
+}
+
+func (s SMTPEmailService) SendEmail(to, subject, body string) error {
+	addr := fmt.Sprintf("%s:%d", s.config.SMTPHost, s.config.SMTPPort)
+	var auth smtp.Auth
+	if s.config.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", s.config.SMTPUsername, s.config.SMTPPassword, s.config.SMTPHost)
+	}
+
+	headers := []string{
+		fmt.Sprintf("From: %s", s.config.EmailFrom),
+		fmt.Sprintf("To: %s", to),
+		fmt.Sprintf("Subject: %s", subject),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+	}
+	message := strings.Join(headers, "\r\n") + "\r\n\r\n" + body
+
+	if err := smtp.SendMail(addr, auth, s.config.EmailFrom, []string{to}, []byte(message)); err != nil {
+		logrus.Errorf("[Email]: error sending email: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// LogEmailService writes emails to the log instead of sending them
+type LogEmailService struct{}
+
+func (LogEmailService) SendEmail(to, subject, body string) error {
+	logrus.Infof("[Email]: to %s, subject %q: %s", to, subject, body)
+	return nil
+}
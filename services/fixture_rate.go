@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/isongjosiah/work/onepurse-api/config"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// RateFixture is the document a fixture rate provider reads, e.g. {"rates": [{"base": "USD", "quote": "NGN",
+// "mid": "415.25"}]}
+type RateFixture struct {
+	Rates []ProviderRate `json:"rates"`
+}
+
+// FixtureRateProvider reads rates from a fixture file, or from a URL serving one such as the stand-in server of
+// FixtureRateHandler, so rate updates can be developed and tested offline. Edit the fixture to move the rates
+type FixtureRateProvider struct {
+	source string
+	client *http.Client
+}
+
+// NewFixtureRateProvider returns a provider reading the fixture at source, a file path or an http(s) URL
+func NewFixtureRateProvider(source string) *FixtureRateProvider {
+	return &FixtureRateProvider{
+		source: source,
+		client: &http.Client{Timeout: config.HTTPClientTimout},
+	}
+}
+
+func (f *FixtureRateProvider) Name() string {
+	return config.RateProviderFixture
+}
+
+func (f *FixtureRateProvider) FetchRates(ctx context.Context) ([]ProviderRate, error) {
+	content, err := f.read(ctx)
+	if err != nil {
+		logrus.Errorf("[Rates]: unable to read rate fixture %s: %s", f.source, err.Error())
+		return nil, err
+	}
+	var fixture RateFixture
+	if err := json.Unmarshal(content, &fixture); err != nil {
+		return nil, errors.Wrapf(err, "invalid rate fixture %s", f.source)
+	}
+	return fixture.Rates, nil
+}
+
+func (f *FixtureRateProvider) read(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(f.source, "http://") && !strings.HasPrefix(f.source, "https://") {
+		return ioutil.ReadFile(f.source)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status %s", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// FixtureRateHandler serves the rate fixture at path, read again on every request so edits take effect on the next
+// fetch. It is the stand-in server of a rate provider for the fixture provider to read
+func FixtureRateHandler(path string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			logrus.Errorf("[Rates]: unable to read rate fixture %s: %s", path, err.Error())
+			http.Error(w, "unable to read rate fixture", http.StatusInternalServerError)
+			return
+		}
+		var fixture RateFixture
+		if err := json.Unmarshal(content, &fixture); err != nil {
+			http.Error(w, "invalid rate fixture: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(content)
+	})
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"github.com/aws/smithy-go"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+)
+
+// IIdentityProvider signs users up, authenticates them and manages their credentials. Providers report failures as
+// smithy.APIError values carrying the cognito error codes, so callers handle every provider the same way
+type IIdentityProvider interface {
+	Login(l *model.LoginRequest) (*model.AuthResponse, error)
+	RefreshAccessToken(rt *model.RefreshTokenRequest) (*model.AuthResponse, error)
+	InvitedUserChangePassword(l *model.NewPasswordChallengeInput) (*model.AuthResponse, error)
+	SignUp(r *model.RegistrationRequest) (*model.SignupResponse, error)
+	ConfirmSignUp(v *model.VerificationRequest) (bool, error)
+	ResendCode(email string) error
+	ForgetPassword(email string) error
+	ConfirmForgotPassword(p *model.ConfirmForgotPasswordRequest) (bool, error)
+	ChangePassword(p *model.ChangePassword) (bool, error)
+	UpdateUsername(ua *model.UpdateUsername) error
+	CreateUser(r *model.CreateUserRequest) (*model.CreateUserResponse, error)
+}
+
+// Identity provider error codes, as returned by cognito
+const (
+	ErrCodeInvalidParameter = "InvalidParameterException"
+	ErrCodeInvalidPassword  = "InvalidPasswordException"
+	ErrCodeUsernameExists   = "UsernameExistsException"
+	ErrCodeNotAuthorized    = "NotAuthorizedException"
+	ErrCodeUserNotConfirmed = "UserNotConfirmedException"
+	ErrCodeUserNotFound     = "UserNotFoundException"
+	ErrCodeCodeMismatch     = "CodeMismatchException"
+	ErrCodeExpiredCode      = "ExpiredCodeException"
+	ErrCodeInternalError    = "InternalErrorException"
+)
+
+// identityError reports a failure of an identity provider other than cognito
+func identityError(code, message string) error {
+	fault := smithy.FaultClient
+	if code == ErrCodeInternalError {
+		fault = smithy.FaultServer
+	}
+	return &smithy.GenericAPIError{Code: code, Message: message, Fault: fault}
+}
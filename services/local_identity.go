@@ -0,0 +1,477 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/isongjosiah/work/onepurse-api/auth"
+	"github.com/isongjosiah/work/onepurse-api/dal"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/lucsky/cuid"
+	"github.com/sethvargo/go-password/password"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/crypto/bcrypt"
+	"math/big"
+	"time"
+	"unicode"
+)
+
+const (
+	localAccessTokenTTL       = time.Hour
+	localRefreshTokenTTL      = 30 * 24 * time.Hour
+	localConfirmationCodeTTL  = 24 * time.Hour
+	localResetCodeTTL         = time.Hour
+	localSessionTTL           = 3 * time.Minute
+	localTemporaryPasswordTTL = 7 * 24 * time.Hour
+)
+
+// LocalIdentityProvider keeps accounts in mongo and signs its own tokens, so the auth flows work without AWS. Codes
+// and temporary passwords that cognito would email are written to the log instead
+type LocalIdentityProvider struct {
+	identities dal.IIdentityDAL
+	keys       *auth.LocalKeys
+	verifier   *auth.Verifier
+	issuer     string
+	clientID   string
+}
+
+// NewLocalIdentityProvider returns a provider signing tokens with keys for clientID. verifier must trust keys
+func NewLocalIdentityProvider(identities dal.IIdentityDAL, keys *auth.LocalKeys, verifier *auth.Verifier, issuer, clientID string) IIdentityProvider {
+	return &LocalIdentityProvider{
+		identities: identities,
+		keys:       keys,
+		verifier:   verifier,
+		issuer:     issuer,
+		clientID:   clientID,
+	}
+}
+
+func (l *LocalIdentityProvider) Login(req *model.LoginRequest) (*model.AuthResponse, error) {
+	identity, err := l.find(req.Username)
+	if err != nil {
+		return nil, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(identity.PasswordHash), []byte(req.Password)) != nil {
+		return nil, identityError(ErrCodeNotAuthorized, "Incorrect username or password.")
+	}
+
+	switch identity.Status {
+	case model.IdentityUnconfirmed:
+		return nil, identityError(ErrCodeUserNotConfirmed, "User is not confirmed.")
+	case model.IdentityForceChangePassword:
+		if time.Since(identity.UpdatedAt) > localTemporaryPasswordTTL {
+			return nil, identityError(ErrCodeNotAuthorized, "Temporary password has expired and must be reset by an administrator.")
+		}
+		session, code := newLocalSecret(localSessionTTL)
+		if err := l.identities.Update(context.TODO(), identity.ID, bson.D{{"$set", bson.D{{"session", code}}}}); err != nil {
+			return nil, identityError(ErrCodeInternalError, err.Error())
+		}
+		return &model.AuthResponse{
+			ChallengeName: string(types.ChallengeNameTypeNewPasswordRequired),
+			Session:       session,
+		}, nil
+	}
+	return l.issueTokens(identity)
+}
+
+func (l *LocalIdentityProvider) RefreshAccessToken(rt *model.RefreshTokenRequest) (*model.AuthResponse, error) {
+	hash := hashSecret(rt.RefreshToken)
+	identity, err := l.identities.FindOne(context.TODO(), bson.D{{"refresh_tokens.hash", hash}})
+	if err != nil {
+		return nil, identityError(ErrCodeNotAuthorized, "Invalid Refresh Token")
+	}
+	for _, token := range identity.RefreshTokens {
+		if token.Hash == hash && time.Now().Before(token.ExpiresAt) {
+			accessToken, expiresAt, err := l.accessToken(identity)
+			if err != nil {
+				return nil, err
+			}
+			return &model.AuthResponse{
+				AccessToken:  accessToken,
+				RefreshToken: rt.RefreshToken,
+				ExpiresAt:    expiresAt,
+			}, nil
+		}
+	}
+	return nil, identityError(ErrCodeNotAuthorized, "Refresh Token has expired")
+}
+
+func (l *LocalIdentityProvider) InvitedUserChangePassword(req *model.NewPasswordChallengeInput) (*model.AuthResponse, error) {
+	identity, err := l.find(req.Username)
+	if err != nil {
+		return nil, err
+	}
+	if identity.Status != model.IdentityForceChangePassword || !checkLocalCode(identity.Session, req.Session) {
+		return nil, identityError(ErrCodeNotAuthorized, "Invalid session for the user.")
+	}
+	hash, err := hashLocalPassword(req.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	update := bson.D{
+		{"$set", bson.D{{"password_hash", hash}, {"status", model.IdentityConfirmed}, {"updated_at", time.Now()}}},
+		{"$unset", bson.D{{"session", ""}}},
+	}
+	if err := l.identities.Update(context.TODO(), identity.ID, update); err != nil {
+		return nil, identityError(ErrCodeInternalError, err.Error())
+	}
+	return l.issueTokens(identity)
+}
+
+func (l *LocalIdentityProvider) SignUp(r *model.RegistrationRequest) (*model.SignupResponse, error) {
+	if r.Email == "" {
+		return nil, identityError(ErrCodeInvalidParameter, "email is required")
+	}
+	hash, err := hashLocalPassword(r.Password)
+	if err != nil {
+		return nil, err
+	}
+	code, stored := newLocalCode(localConfirmationCodeTTL)
+
+	now := time.Now()
+	identity := &model.LocalIdentity{
+		ID:               cuid.New(),
+		Username:         r.Email,
+		Email:            r.Email,
+		Phone:            r.Phone,
+		Name:             r.FullName,
+		PasswordHash:     hash,
+		Status:           model.IdentityUnconfirmed,
+		ConfirmationCode: stored,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	if err := l.add(identity); err != nil {
+		return nil, err
+	}
+	logrus.Infof("[LocalIdentity]: confirmation code for %s is %s", identity.Username, code)
+
+	return &model.SignupResponse{
+		IsConfirmed:    false,
+		DeliveryMedium: string(types.DeliveryMediumTypeEmail),
+		Destination:    r.Email,
+	}, nil
+}
+
+func (l *LocalIdentityProvider) ConfirmSignUp(v *model.VerificationRequest) (bool, error) {
+	identity, err := l.find(v.Email)
+	if err != nil {
+		return false, err
+	}
+	if identity.Status != model.IdentityUnconfirmed {
+		return false, identityError(ErrCodeNotAuthorized, "User cannot be confirmed. Current status is "+identity.Status)
+	}
+	if err := verifyLocalCode(identity.ConfirmationCode, v.Code); err != nil {
+		return false, err
+	}
+
+	update := bson.D{
+		{"$set", bson.D{{"status", model.IdentityConfirmed}, {"updated_at", time.Now()}}},
+		{"$unset", bson.D{{"confirmation_code", ""}}},
+	}
+	if err := l.identities.Update(context.TODO(), identity.ID, update); err != nil {
+		return false, identityError(ErrCodeInternalError, err.Error())
+	}
+	return true, nil
+}
+
+func (l *LocalIdentityProvider) ResendCode(email string) error {
+	identity, err := l.find(email)
+	if err != nil {
+		return err
+	}
+	if identity.Status != model.IdentityUnconfirmed {
+		return identityError(ErrCodeInvalidParameter, "User is already confirmed.")
+	}
+	code, stored := newLocalCode(localConfirmationCodeTTL)
+	if err := l.identities.Update(context.TODO(), identity.ID, bson.D{{"$set", bson.D{{"confirmation_code", stored}}}}); err != nil {
+		return identityError(ErrCodeInternalError, err.Error())
+	}
+	logrus.Infof("[LocalIdentity]: confirmation code for %s is %s", identity.Username, code)
+	return nil
+}
+
+func (l *LocalIdentityProvider) ForgetPassword(email string) error {
+	identity, err := l.find(email)
+	if err != nil {
+		return err
+	}
+	code, stored := newLocalCode(localResetCodeTTL)
+	if err := l.identities.Update(context.TODO(), identity.ID, bson.D{{"$set", bson.D{{"reset_code", stored}}}}); err != nil {
+		return identityError(ErrCodeInternalError, err.Error())
+	}
+	logrus.Infof("[LocalIdentity]: password reset code for %s is %s", identity.Username, code)
+	return nil
+}
+
+func (l *LocalIdentityProvider) ConfirmForgotPassword(p *model.ConfirmForgotPasswordRequest) (bool, error) {
+	identity, err := l.find(p.Username)
+	if err != nil {
+		return false, err
+	}
+	if err := verifyLocalCode(identity.ResetCode, p.Code); err != nil {
+		return false, err
+	}
+	hash, err := hashLocalPassword(p.ProposedPassword)
+	if err != nil {
+		return false, err
+	}
+
+	// a reset password signs the account out everywhere
+	update := bson.D{
+		{"$set", bson.D{{"password_hash", hash}, {"refresh_tokens", []model.LocalRefreshToken{}}, {"updated_at", time.Now()}}},
+		{"$unset", bson.D{{"reset_code", ""}}},
+	}
+	if err := l.identities.Update(context.TODO(), identity.ID, update); err != nil {
+		return false, identityError(ErrCodeInternalError, err.Error())
+	}
+	return true, nil
+}
+
+func (l *LocalIdentityProvider) ChangePassword(p *model.ChangePassword) (bool, error) {
+	identity, err := l.fromAccessToken(p.AccessToken)
+	if err != nil {
+		return false, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(identity.PasswordHash), []byte(p.PreviousPassword)) != nil {
+		return false, identityError(ErrCodeNotAuthorized, "Incorrect username or password.")
+	}
+	hash, err := hashLocalPassword(p.ProposedPassword)
+	if err != nil {
+		return false, err
+	}
+	if err := l.identities.Update(context.TODO(), identity.ID, bson.D{{"$set", bson.D{{"password_hash", hash}, {"updated_at", time.Now()}}}}); err != nil {
+		return false, identityError(ErrCodeInternalError, err.Error())
+	}
+	return true, nil
+}
+
+func (l *LocalIdentityProvider) UpdateUsername(ua *model.UpdateUsername) error {
+	identity, err := l.fromAccessToken(ua.AccessToken)
+	if err != nil {
+		return err
+	}
+	if err := l.identities.Update(context.TODO(), identity.ID, bson.D{{"$set", bson.D{{"preferred_username", ua.PreferredUsername}, {"updated_at", time.Now()}}}}); err != nil {
+		return identityError(ErrCodeInternalError, err.Error())
+	}
+	return nil
+}
+
+func (l *LocalIdentityProvider) CreateUser(r *model.CreateUserRequest) (*model.CreateUserResponse, error) {
+	if r.Email == "" {
+		return nil, identityError(ErrCodeInvalidParameter, "email is required")
+	}
+	temporary, err := password.Generate(10, 2, 3, false, false)
+	if err != nil {
+		return nil, identityError(ErrCodeInternalError, err.Error())
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(temporary), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, identityError(ErrCodeInternalError, err.Error())
+	}
+
+	now := time.Now()
+	identity := &model.LocalIdentity{
+		ID:                cuid.New(),
+		Username:          r.Email,
+		Email:             r.Email,
+		Phone:             r.Phone,
+		Name:              r.FullName,
+		PreferredUsername: r.UserName,
+		PasswordHash:      string(hash),
+		Status:            model.IdentityForceChangePassword,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+	if err := l.add(identity); err != nil {
+		return nil, err
+	}
+	logrus.Infof("[LocalIdentity]: temporary password for %s is %s", identity.Username, temporary)
+
+	return &model.CreateUserResponse{User: &types.UserType{
+		Username:             aws.String(identity.Username),
+		Enabled:              true,
+		UserStatus:           types.UserStatusTypeForceChangePassword,
+		UserCreateDate:       aws.Time(now),
+		UserLastModifiedDate: aws.Time(now),
+		Attributes: []types.AttributeType{
+			{Name: aws.String("sub"), Value: aws.String(identity.ID)},
+			{Name: aws.String("email"), Value: aws.String(identity.Email)},
+			{Name: aws.String("phone_number"), Value: aws.String(identity.Phone)},
+			{Name: aws.String("name"), Value: aws.String(identity.Name)},
+			{Name: aws.String("preferred_username"), Value: aws.String(identity.PreferredUsername)},
+		},
+	}}, nil
+}
+
+// find looks an account up by username or email, which is how cognito resolves the USERNAME of its flows
+func (l *LocalIdentityProvider) find(username string) (*model.LocalIdentity, error) {
+	identity, err := l.identities.FindOne(context.TODO(), bson.D{{"$or", []bson.M{{"username": username}, {"email": username}}}})
+	if err != nil {
+		if err == dal.ErrIdentityNotFound {
+			return nil, identityError(ErrCodeUserNotFound, "User does not exist.")
+		}
+		return nil, identityError(ErrCodeInternalError, err.Error())
+	}
+	return identity, nil
+}
+
+func (l *LocalIdentityProvider) add(identity *model.LocalIdentity) error {
+	if _, err := l.find(identity.Username); err == nil {
+		return identityError(ErrCodeUsernameExists, "An account with the given email already exists.")
+	}
+	if err := l.identities.Add(context.TODO(), identity); err != nil {
+		if err == dal.ErrIdentityExists {
+			return identityError(ErrCodeUsernameExists, "An account with the given email already exists.")
+		}
+		return identityError(ErrCodeInternalError, err.Error())
+	}
+	return nil
+}
+
+// fromAccessToken returns the account an access token was issued to
+func (l *LocalIdentityProvider) fromAccessToken(raw string) (*model.LocalIdentity, error) {
+	token, err := l.verifier.Verify(context.TODO(), raw)
+	if err != nil {
+		return nil, identityError(ErrCodeNotAuthorized, "Invalid Access Token")
+	}
+	identity, err := l.identities.FindOne(context.TODO(), bson.D{{"_id", token.Subject()}})
+	if err != nil {
+		return nil, identityError(ErrCodeNotAuthorized, "Invalid Access Token")
+	}
+	return identity, nil
+}
+
+// issueTokens signs an access token and stores a new refresh token for identity
+func (l *LocalIdentityProvider) issueTokens(identity *model.LocalIdentity) (*model.AuthResponse, error) {
+	accessToken, expiresAt, err := l.accessToken(identity)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, stored := newLocalSecret(localRefreshTokenTTL)
+
+	// expired refresh tokens are dropped whenever a new one is issued
+	now := time.Now()
+	var refreshTokens []model.LocalRefreshToken
+	for _, token := range identity.RefreshTokens {
+		if now.Before(token.ExpiresAt) {
+			refreshTokens = append(refreshTokens, token)
+		}
+	}
+	refreshTokens = append(refreshTokens, model.LocalRefreshToken{Hash: stored.Hash, ExpiresAt: stored.ExpiresAt})
+	if err := l.identities.Update(context.TODO(), identity.ID, bson.D{{"$set", bson.D{{"refresh_tokens", refreshTokens}}}}); err != nil {
+		return nil, identityError(ErrCodeInternalError, err.Error())
+	}
+
+	return &model.AuthResponse{
+		Email:        identity.Email,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresAt:    expiresAt,
+	}, nil
+}
+
+// accessToken signs an access token carrying the claims of a cognito access token
+func (l *LocalIdentityProvider) accessToken(identity *model.LocalIdentity) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(localAccessTokenTTL)
+
+	token := jwt.New()
+	claims := map[string]interface{}{
+		jwt.IssuerKey:     l.issuer,
+		jwt.SubjectKey:    identity.ID,
+		jwt.IssuedAtKey:   now,
+		jwt.ExpirationKey: expiresAt,
+		jwt.JwtIDKey:      cuid.New(),
+		"token_use":       auth.TokenUseAccess,
+		"client_id":       l.clientID,
+		"username":        identity.Username,
+	}
+	if len(identity.Groups) > 0 {
+		claims["cognito:groups"] = identity.Groups
+	}
+	for name, value := range claims {
+		if err := token.Set(name, value); err != nil {
+			return "", time.Time{}, identityError(ErrCodeInternalError, err.Error())
+		}
+	}
+
+	signed, err := l.keys.Sign(token)
+	if err != nil {
+		return "", time.Time{}, identityError(ErrCodeInternalError, err.Error())
+	}
+	return signed, expiresAt, nil
+}
+
+// hashLocalPassword applies the default password policy of cognito user pools before hashing password
+func hashLocalPassword(password string) (string, error) {
+	var upper, lower, digit, symbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsDigit(c):
+			digit = true
+		case unicode.IsPunct(c) || unicode.IsSymbol(c):
+			symbol = true
+		}
+	}
+	if len(password) < 8 || !upper || !lower || !digit || !symbol {
+		return "", identityError(ErrCodeInvalidPassword, "Password does not conform to policy: Password must be at least 8 characters long and have uppercase, lowercase, numeric and symbol characters")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", identityError(ErrCodeInternalError, err.Error())
+	}
+	return string(hash), nil
+}
+
+// newLocalCode returns a six digit code and the hash stored for it
+func newLocalCode(ttl time.Duration) (string, *model.LocalCode) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		panic(err)
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+	return code, &model.LocalCode{Hash: hashSecret(code), ExpiresAt: time.Now().Add(ttl)}
+}
+
+// newLocalSecret returns an opaque secret such as a refresh token and the hash stored for it
+func newLocalSecret(ttl time.Duration) (string, *model.LocalCode) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	secret := base64.RawURLEncoding.EncodeToString(b)
+	return secret, &model.LocalCode{Hash: hashSecret(secret), ExpiresAt: time.Now().Add(ttl)}
+}
+
+func checkLocalCode(stored *model.LocalCode, code string) bool {
+	return stored != nil && code != "" && stored.Hash == hashSecret(code) && time.Now().Before(stored.ExpiresAt)
+}
+
+func verifyLocalCode(stored *model.LocalCode, code string) error {
+	if stored == nil || stored.Hash != hashSecret(code) {
+		return identityError(ErrCodeCodeMismatch, "Invalid verification code provided, please try again.")
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return identityError(ErrCodeExpiredCode, "Invalid code provided, please request a code again.")
+	}
+	return nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/dal"
+	"github.com/isongjosiah/work/onepurse-api/dal/model"
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"github.com/lucsky/cuid"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+// RateFeedResult counts what a fetch from a rate provider did with each rate
+type RateFeedResult struct {
+	Applied   int `json:"applied"`   // added as the rate in effect
+	Proposed  int `json:"proposed"`  // left for an admin to approve
+	Unchanged int `json:"unchanged"` // same as the rate in effect or the proposal pending
+	Invalid   int `json:"invalid"`   // could not price conversions or are of unregistered currencies, and were ignored
+}
+
+// RateFeed adds the rates fetched from a rate provider as new versions of their pairs. A rate that moved more than
+// maxMove percent from the rate in effect, or is the first rate of its pair, is an outlier and is proposed for an
+// admin to approve instead. Rates of currencies that are not in the registry are ignored
+type RateFeed struct {
+	provider   IRateProvider
+	rates      dal.IRateDAL
+	proposals  dal.IRateProposalDAL
+	currencies dal.ICurrencyDAL
+	maxMove    money.Rate
+}
+
+// NewRateFeed returns a feed of the rates of provider
+func NewRateFeed(provider IRateProvider, rates dal.IRateDAL, proposals dal.IRateProposalDAL, currencies dal.ICurrencyDAL, maxMove money.Rate) *RateFeed {
+	return &RateFeed{
+		provider:   provider,
+		rates:      rates,
+		proposals:  proposals,
+		currencies: currencies,
+		maxMove:    maxMove,
+	}
+}
+
+// Provider returns the name of the provider rates are fetched from
+func (f *RateFeed) Provider() string {
+	return f.provider.Name()
+}
+
+// Fetch fetches the latest rates from the provider and applies or proposes each one
+func (f *RateFeed) Fetch(ctx context.Context) (*RateFeedResult, error) {
+	fetched, err := f.provider.FetchRates(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RateFeedResult{}
+	for _, p := range fetched {
+		now := time.Now()
+		rate := model.ExchangeRate{
+			Base:        strings.ToUpper(p.Base),
+			Quote:       strings.ToUpper(p.Quote),
+			Mid:         p.Mid,
+			Source:      "feed:" + f.provider.Name(),
+			EffectiveAt: now,
+			CreatedAt:   now,
+		}
+		registered, err := f.registered(ctx, rate.Base, rate.Quote)
+		if err != nil {
+			return result, err
+		}
+		if !registered {
+			logrus.Warnf("[Rates]: ignoring %s rate from %s: currency is not registered", rate.Pair(), f.provider.Name())
+			result.Invalid++
+			continue
+		}
+
+		current, err := f.rates.Current(ctx, rate.Base, rate.Quote, now)
+		if err != nil && err != dal.ErrRateNotFound {
+			return result, err
+		}
+		if current != nil {
+			// the platform's spreads and margin carry over to the new version
+			rate.BuySpread, rate.SellSpread, rate.Margin = current.BuySpread, current.SellSpread, current.Margin
+		}
+		if err := rate.Validate(); err != nil {
+			logrus.Warnf("[Rates]: ignoring %s rate %s from %s: %s", rate.Pair(), p.Mid.String(), f.provider.Name(), err.Error())
+			result.Invalid++
+			continue
+		}
+		if current != nil && current.Mid.Cmp(rate.Mid) == 0 {
+			result.Unchanged++
+			continue
+		}
+
+		if current != nil {
+			move := current.Mid.ChangePercent(rate.Mid)
+			if move.Cmp(f.maxMove) <= 0 {
+				if err := f.rates.Add(ctx, &rate); err != nil {
+					return result, err
+				}
+				if err := f.proposals.Supersede(ctx, rate.Base, rate.Quote, now); err != nil {
+					return result, err
+				}
+				result.Applied++
+				continue
+			}
+		}
+
+		proposed, err := f.propose(ctx, rate, current, now)
+		if err != nil {
+			return result, err
+		}
+		if proposed {
+			result.Proposed++
+		} else {
+			result.Unchanged++
+		}
+	}
+	return result, nil
+}
+
+// registered reports whether every one of codes is a currency of the registry
+func (f *RateFeed) registered(ctx context.Context, codes ...string) (bool, error) {
+	for _, code := range codes {
+		_, err := f.currencies.Find(ctx, code)
+		if err == dal.ErrCurrencyNotFound {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// propose leaves rate for an admin to approve, unless the same rate is already pending
+func (f *RateFeed) propose(ctx context.Context, rate model.ExchangeRate, current *model.ExchangeRate, now time.Time) (bool, error) {
+	pending, err := f.proposals.Pending(ctx, rate.Base, rate.Quote)
+	if err != nil && err != dal.ErrRateProposalNotFound {
+		return false, err
+	}
+	if pending != nil && pending.Rate.Mid.Cmp(rate.Mid) == 0 {
+		return false, nil
+	}
+
+	proposal := model.RateProposal{
+		ID:        cuid.New(),
+		Rate:      rate,
+		Status:    model.RateProposalPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if current != nil {
+		proposal.PreviousMid = current.Mid
+		proposal.Move = current.Mid.ChangePercent(rate.Mid)
+		logrus.Warnf("[Rates]: %s moved %s%% to %s, waiting for an admin to approve it", rate.Pair(), proposal.Move.String(), rate.Mid.String())
+	} else {
+		logrus.Warnf("[Rates]: %s is a new pair at %s, waiting for an admin to approve it", rate.Pair(), rate.Mid.String())
+	}
+	return true, f.proposals.Propose(ctx, &proposal)
+}
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"context"
+	"github.com/isongjosiah/work/onepurse-api/config"
+	"github.com/isongjosiah/work/onepurse-api/money"
+	"github.com/pkg/errors"
+)
+
+// ProviderRate is the mid rate of a currency pair as published by a rate provider
+type ProviderRate struct {
+	Base  string     `json:"base"`
+	Quote string     `json:"quote"`
+	Mid   money.Rate `json:"mid"` // units of Quote a unit of Base is worth
+}
+
+// IRateProvider fetches the latest mid rates of the currency pairs a provider publishes. Spreads and margins are the
+// platform's and are never taken from a provider
+type IRateProvider interface {
+	Name() string
+	FetchRates(ctx context.Context) ([]ProviderRate, error)
+}
+
+// NewRateProvider returns the rate provider configured with RATE_PROVIDER, or nil when rates are only set by hand
+func NewRateProvider(cfg *config.Config) (IRateProvider, error) {
+	switch cfg.RateProvider {
+	case "":
+		return nil, nil
+	case config.RateProviderFixture:
+		if cfg.RateFixture == "" {
+			return nil, errors.New("RATE_FIXTURE is required when RATE_PROVIDER is fixture")
+		}
+		return NewFixtureRateProvider(cfg.RateFixture), nil
+	}
+	return nil, errors.Errorf("unknown RATE_PROVIDER %s", cfg.RateProvider)
+}
@@ -0,0 +1,208 @@
+// Package txstate defines the lifecycle of transfers, withdrawals, deposits and exchanges. Every status change of a
+// transaction must be one of the transitions allowed for its type, and handlers apply the wallet and ledger side
+// effects of a transition in the same mongo transaction as the status change
+package txstate
+
+import (
+	"fmt"
+	"github.com/isongjosiah/work/onepurse-api/types"
+)
+
+// States a transaction can be in
+const (
+	Created   = "created"   // the user has created the transaction and their funds are held where needed
+	Matched   = "matched"   // an agent or peer user has been assigned and their funds are held where needed
+	Paid      = "paid"      // the off platform leg of the transaction has been paid and a receipt uploaded
+	Confirmed = "confirmed" // the receiving party has confirmed the off platform payment
+	Completed = "completed" // held funds have been settled
+	Cancelled = "cancelled" // held funds have been released
+	Disputed  = "disputed"  // a party disputes the off platform payment and an admin has to resolve it
+	Expired   = "expired"   // the transaction was not progressed in time and held funds have been released
+)
+
+// Parties that can move a transaction through its lifecycle
+const (
+	Owner        = "owner"        // the user who created the transaction
+	Counterparty = "counterparty" // the agent or peer user the transaction was matched to
+	Admin        = "admin"        // an admin whose role grants access to transactions
+	System       = "system"       // a background job of the API
+)
+
+// legacy maps statuses written before the state machine existed onto their current state
+var legacy = map[string]string{
+	"initiated": Created,
+	"pending":   Matched,
+}
+
+// matchedFlow is the lifecycle of transactions that are matched to an agent or peer user before being paid
+var matchedFlow = map[string][]string{
+	Created:   {Matched, Cancelled, Expired},
+	Matched:   {Paid, Cancelled, Expired},
+	Paid:      {Confirmed, Disputed},
+	Confirmed: {Completed, Disputed},
+	Disputed:  {Completed, Cancelled},
+}
+
+// withdrawalFlow is the lifecycle of withdrawals, which are paid out directly to the user's bank account
+var withdrawalFlow = map[string][]string{
+	Created:   {Paid, Cancelled, Expired},
+	Paid:      {Confirmed, Disputed},
+	Confirmed: {Completed, Disputed},
+	Disputed:  {Completed, Cancelled},
+}
+
+var transitions = map[string]map[string][]string{
+	types.TRANSFER: matchedFlow,
+	types.EXCHANGE: matchedFlow,
+	types.DEPOSIT:  matchedFlow,
+	types.WITHDRAW: withdrawalFlow,
+}
+
+// move is a transition from one state to another
+type move struct {
+	from, to string
+}
+
+// matchedActors are the parties that can make each move of the matched flow. payer pays the off platform leg of the
+// transaction and marks it paid, and receiver, whose funds are settled on completion, confirms and completes it
+func matchedActors(payer, receiver string) map[move][]string {
+	return map[move][]string{
+		{Created, Matched}:     {System}, // the API assigns a match when the owner asks for one
+		{Created, Cancelled}:   {Owner, Admin},
+		{Created, Expired}:     {System},
+		{Matched, Paid}:        {payer},
+		{Matched, Cancelled}:   {Owner, Counterparty, Admin},
+		{Matched, Expired}:     {System},
+		{Paid, Confirmed}:      {receiver},
+		{Paid, Disputed}:       {Owner, Counterparty},
+		{Confirmed, Completed}: {receiver},
+		{Confirmed, Disputed}:  {Owner, Counterparty},
+		{Disputed, Completed}:  {Admin},
+		{Disputed, Cancelled}:  {Admin},
+	}
+}
+
+// withdrawalActors are the parties that can make each move of a withdrawal, which the platform pays out
+var withdrawalActors = map[move][]string{
+	{Created, Paid}:        {Admin},
+	{Created, Cancelled}:   {Owner, Admin},
+	{Created, Expired}:     {System},
+	{Paid, Confirmed}:      {Owner},
+	{Paid, Disputed}:       {Owner},
+	{Confirmed, Completed}: {Owner},
+	{Confirmed, Disputed}:  {Owner},
+	{Disputed, Completed}:  {Admin},
+	{Disputed, Cancelled}:  {Admin},
+}
+
+// actors are the parties that can make each move, by transaction type. Agents pay out transfers and exchanges off
+// platform and are paid deposits off platform
+var actors = map[string]map[move][]string{
+	types.TRANSFER: matchedActors(Counterparty, Owner),
+	types.EXCHANGE: matchedActors(Counterparty, Owner),
+	types.DEPOSIT:  matchedActors(Owner, Counterparty),
+	types.WITHDRAW: withdrawalActors,
+}
+
+// Normalize returns the current name of a status, mapping statuses stored by older versions of the API
+func Normalize(status string) string {
+	if s, ok := legacy[status]; ok {
+		return s
+	}
+	return status
+}
+
+// IsFinal reports whether no further transition is possible from status
+func IsFinal(status string) bool {
+	switch Normalize(status) {
+	case Completed, Cancelled, Expired:
+		return true
+	}
+	return false
+}
+
+// Next returns the states a transaction of transactionType can move to from status
+func Next(transactionType, status string) []string {
+	return transitions[transactionType][Normalize(status)]
+}
+
+// Can reports whether a transaction of transactionType may move from one status to another
+func Can(transactionType, from, to string) bool {
+	for _, s := range Next(transactionType, from) {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Sources returns every stored status, including legacy names, from which a transaction of transactionType may move
+// to the state to. It is meant for querying the transactions a transition applies to
+func Sources(transactionType, to string) []string {
+	var sources []string
+	for from := range transitions[transactionType] {
+		if Can(transactionType, from, to) {
+			sources = append(sources, from)
+		}
+	}
+	for old, current := range legacy {
+		if Can(transactionType, current, to) {
+			sources = append(sources, old)
+		}
+	}
+	return sources
+}
+
+// Check returns an error describing why a transaction of transactionType cannot move from one status to another
+func Check(transactionType, from, to string) error {
+	if _, ok := transitions[transactionType]; !ok {
+		return fmt.Errorf("transaction type %s has no lifecycle", transactionType)
+	}
+	if Can(transactionType, from, to) {
+		return nil
+	}
+	if IsFinal(from) {
+		return fmt.Errorf("cannot update a %s transaction", Normalize(from))
+	}
+	return fmt.Errorf("a %s cannot move from %s to %s", transactionType, Normalize(from), to)
+}
+
+// Allowed reports whether party may move a transaction of transactionType from one status to another
+func Allowed(transactionType, from, to, party string) bool {
+	for _, p := range actors[transactionType][move{Normalize(from), to}] {
+		if p == party {
+			return true
+		}
+	}
+	return false
+}
+
+// SystemOnly reports whether only the API itself can move a transaction of transactionType to the state to, such as
+// matching it to an agent or peer user or expiring it. Clients can never request those states
+func SystemOnly(transactionType, to string) bool {
+	found := false
+	for m, parties := range actors[transactionType] {
+		if m.to != to {
+			continue
+		}
+		found = true
+		for _, p := range parties {
+			if p != System {
+				return false
+			}
+		}
+	}
+	return found
+}
+
+// Authorize returns an error describing why party cannot move a transaction of transactionType from one status to
+// another. It does not check the move is part of the lifecycle, which Check does
+func Authorize(transactionType, from, to, party string) error {
+	if Allowed(transactionType, from, to, party) {
+		return nil
+	}
+	if party == "" {
+		return fmt.Errorf("you are not a party to this %s", transactionType)
+	}
+	return fmt.Errorf("as the %s you cannot move this %s from %s to %s", party, transactionType, Normalize(from), to)
+}
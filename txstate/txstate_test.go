@@ -0,0 +1,127 @@
+package txstate
+
+import (
+	"github.com/isongjosiah/work/onepurse-api/types"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		transactionType string
+		from, to        string
+		wantErr         bool
+	}{
+		{transactionType: types.TRANSFER, from: Created, to: Matched},
+		{transactionType: types.TRANSFER, from: "initiated", to: Matched},
+		{transactionType: types.TRANSFER, from: "pending", to: Paid},
+		{transactionType: types.EXCHANGE, from: Disputed, to: Completed},
+		{transactionType: types.DEPOSIT, from: Matched, to: Expired},
+		{transactionType: types.WITHDRAW, from: Created, to: Paid},
+		{transactionType: types.WITHDRAW, from: Created, to: Matched, wantErr: true},
+		{transactionType: types.TRANSFER, from: Created, to: Completed, wantErr: true},
+		{transactionType: types.TRANSFER, from: Paid, to: Expired, wantErr: true},
+		{transactionType: types.TRANSFER, from: Completed, to: Cancelled, wantErr: true},
+		{transactionType: types.TRANSFER, from: Expired, to: Created, wantErr: true},
+		{transactionType: types.ONE_PURSE_TRANSACTION, from: Created, to: Completed, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := Check(tt.transactionType, tt.from, tt.to); (err != nil) != tt.wantErr {
+			t.Errorf("Check(%s, %s, %s) error = %v, wantErr %v", tt.transactionType, tt.from, tt.to, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSources(t *testing.T) {
+	tests := []struct {
+		transactionType string
+		to              string
+		want            []string
+	}{
+		{transactionType: types.TRANSFER, to: Expired, want: []string{Created, "initiated", Matched, "pending"}},
+		{transactionType: types.TRANSFER, to: Paid, want: []string{Matched, "pending"}},
+		{transactionType: types.TRANSFER, to: Confirmed, want: []string{Paid}},
+		{transactionType: types.TRANSFER, to: Completed, want: []string{Confirmed, Disputed}},
+		{transactionType: types.WITHDRAW, to: Expired, want: []string{Created, "initiated"}},
+		{transactionType: types.WITHDRAW, to: Matched},
+		{transactionType: types.ONE_PURSE_TRANSACTION, to: Completed},
+	}
+	for _, tt := range tests {
+		got := Sources(tt.transactionType, tt.to)
+		sort.Strings(got)
+		sort.Strings(tt.want)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Sources(%s, %s) = %v, want %v", tt.transactionType, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	tests := []struct {
+		transactionType string
+		from, to        string
+		party           string
+		want            bool
+	}{
+		{transactionType: types.TRANSFER, from: Created, to: Matched, party: System, want: true},
+		{transactionType: types.TRANSFER, from: Created, to: Matched, party: Owner},
+		{transactionType: types.DEPOSIT, from: Created, to: Matched, party: Owner},
+		{transactionType: types.TRANSFER, from: Created, to: Matched, party: Counterparty},
+		{transactionType: types.TRANSFER, from: Matched, to: Paid, party: Counterparty, want: true},
+		{transactionType: types.TRANSFER, from: "pending", to: Paid, party: Counterparty, want: true},
+		{transactionType: types.TRANSFER, from: Matched, to: Paid, party: Owner},
+		{transactionType: types.TRANSFER, from: Paid, to: Confirmed, party: Owner, want: true},
+		{transactionType: types.TRANSFER, from: Paid, to: Confirmed, party: Counterparty},
+		{transactionType: types.DEPOSIT, from: Matched, to: Paid, party: Owner, want: true},
+		{transactionType: types.DEPOSIT, from: Paid, to: Confirmed, party: Counterparty, want: true},
+		{transactionType: types.DEPOSIT, from: Paid, to: Confirmed, party: Owner},
+		{transactionType: types.EXCHANGE, from: Disputed, to: Completed, party: Admin, want: true},
+		{transactionType: types.EXCHANGE, from: Disputed, to: Completed, party: Owner},
+		{transactionType: types.EXCHANGE, from: Matched, to: Expired, party: System, want: true},
+		{transactionType: types.EXCHANGE, from: Matched, to: Expired, party: Admin},
+		{transactionType: types.WITHDRAW, from: Created, to: Paid, party: Admin, want: true},
+		{transactionType: types.WITHDRAW, from: Created, to: Paid, party: Owner},
+		{transactionType: types.WITHDRAW, from: Paid, to: Confirmed, party: Owner, want: true},
+		{transactionType: types.TRANSFER, from: Created, to: Cancelled, party: ""},
+	}
+	for _, tt := range tests {
+		if got := Allowed(tt.transactionType, tt.from, tt.to, tt.party); got != tt.want {
+			t.Errorf("Allowed(%s, %s, %s, %q) = %v, want %v", tt.transactionType, tt.from, tt.to, tt.party, got, tt.want)
+		}
+	}
+}
+
+func TestSystemOnly(t *testing.T) {
+	tests := []struct {
+		transactionType string
+		to              string
+		want            bool
+	}{
+		{transactionType: types.TRANSFER, to: Matched, want: true},
+		{transactionType: types.DEPOSIT, to: Matched, want: true},
+		{transactionType: types.EXCHANGE, to: Expired, want: true},
+		{transactionType: types.WITHDRAW, to: Expired, want: true},
+		{transactionType: types.TRANSFER, to: Paid},
+		{transactionType: types.TRANSFER, to: Cancelled},
+		{transactionType: types.WITHDRAW, to: Matched},
+		{transactionType: types.ONE_PURSE_TRANSACTION, to: Matched},
+	}
+	for _, tt := range tests {
+		if got := SystemOnly(tt.transactionType, tt.to); got != tt.want {
+			t.Errorf("SystemOnly(%s, %s) = %v, want %v", tt.transactionType, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	if err := Authorize(types.TRANSFER, Matched, Paid, Counterparty); err != nil {
+		t.Errorf("Authorize refused the counterparty: %v", err)
+	}
+	if err := Authorize(types.TRANSFER, Matched, Paid, ""); err == nil || err.Error() != "you are not a party to this transfer" {
+		t.Errorf("Authorize of a non party = %v", err)
+	}
+	if err := Authorize(types.TRANSFER, "pending", Paid, Owner); err == nil || err.Error() != "as the owner you cannot move this transfer from matched to paid" {
+		t.Errorf("Authorize of the owner = %v", err)
+	}
+}
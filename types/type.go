@@ -10,6 +10,7 @@ const PAY = "pay"
 const PAYMENT_REQUEST = "payment requested"
 const PAYMENT_RECEIVED = "payment received"
 const TRANSACTION_MATCH = "you have been matched to a transaction"
+const TRANSACTION_EXPIRED = "your transaction has expired"
 const DEACTIVATE = "deactivate"
 const ACTIVATE = "activate"
 const APPROVE = "approve"
@@ -21,3 +22,7 @@ const REQUIRE_NEW_PASSWORD = "require-new-password"
 const ADMIN_LOGIN = "admin-login"
 const USER_LOGIN = "user-login"
 const AGENT_LOGIN = "agent-login"
+const ADMIN_PAYMENT = "admin-payment"
+const COMMISSION_SETTLEMENT = "commission-settlement"
+const TRANSACTION_PIN = "transaction-pin"
+const TRANSACTION_PIN_LOCKED = "your transaction pin has been locked"